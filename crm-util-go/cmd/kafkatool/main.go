@@ -0,0 +1,340 @@
+/*
+kafkatool runs the Kafka operations of kafkautil from the command line.
+
+	go run ./cmd/kafkatool replay-dlq -bootstrap kkts01:9094 -group crm-order -topic order.dlq
+	go run ./cmd/kafkatool create-topic -bootstrap kkts01:9094 -topic order -partitions 6 -replication 3 -config retention.ms=604800000
+	go run ./cmd/kafkatool lag -bootstrap kkts01:9094 -group crm-order -topic order
+	go run ./cmd/kafkatool reset-offsets -bootstrap kkts01:9094 -group crm-order -topic order -to timestamp -timestamp 2026-01-01T00:00:00+07:00 -execute
+
+The connection flags are the security fields of kafkautil.KafkaConfig, see kafkautil.KafkaConfig.
+*/
+package main
+
+import (
+	"context"
+	"crm-util-go/common"
+	"crm-util-go/kafkautil"
+	"crm-util-go/logging"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type command struct {
+	name  string
+	usage string
+	// setup adds the flags of the command and returns its run function
+	setup func(flagSet *flag.FlagSet) func(kc kafkautil.KafkaConfig, transID string) error
+}
+
+var commandList = []command{
+	{name: "replay-dlq", usage: "publish the messages of a DLQ topic back to their source topic", setup: replayDLQCommand},
+	{name: "create-topic", usage: "create a topic with partitions, replication factor and configs", setup: createTopicCommand},
+	{name: "delete-topic", usage: "delete topics", setup: deleteTopicCommand},
+	{name: "describe-topic", usage: "describe the partitions and configs of topics, all topics when -topic is empty", setup: describeTopicCommand},
+	{name: "list-groups", usage: "list the consumer groups", setup: listGroupsCommand},
+	{name: "lag", usage: "show the lag of a consumer group on each partition of a topic", setup: lagCommand},
+	{name: "reset-offsets", usage: "reset the offsets of a stopped consumer group, dry run without -execute", setup: resetOffsetsCommand},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commandList {
+		if cmd.name != os.Args[1] {
+			continue
+		}
+
+		flagSet := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+		kc := addKafkaFlags(flagSet)
+		run := cmd.setup(flagSet)
+
+		flagSet.Parse(os.Args[2:])
+
+		if err := run(*kc, common.NewUUID()); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	printUsage()
+	os.Exit(2)
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: kafkatool <command> [flags]")
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	for _, cmd := range commandList {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+func addKafkaFlags(flagSet *flag.FlagSet) *kafkautil.KafkaConfig {
+	kc := &kafkautil.KafkaConfig{Logger: logging.InitUtilLogger("kafkatool", logging.CrmUtil)}
+
+	flagSet.StringVar(&kc.BootstrapServers, "bootstrap", "localhost:9092", "bootstrap servers")
+	flagSet.StringVar(&kc.GroupID, "group", "kafkatool", "consumer group")
+	flagSet.StringVar(&kc.SecurityProtocol, "security-protocol", "PLAINTEXT", "PLAINTEXT, SSL, SASL_PLAINTEXT or SASL_SSL")
+	flagSet.StringVar(&kc.SaslMechanism, "sasl-mechanism", "GSSAPI", "SASL mechanism")
+	flagSet.StringVar(&kc.KerberosPrincipalName, "kerberos-principal", "kafkaclient", "Kerberos principal")
+	flagSet.StringVar(&kc.KerberosServiceName, "kerberos-service", "kafka", "Kerberos service name")
+	flagSet.StringVar(&kc.KerberosKeytab, "kerberos-keytab", "", "Kerberos keytab")
+	flagSet.IntVar(&kc.KerberosReloginMS, "kerberos-relogin-ms", 60000, "Kerberos min time before relogin")
+	flagSet.StringVar(&kc.SslCALocation, "ssl-ca", "", "CA certificate file")
+	flagSet.StringVar(&kc.SslCipherSuites, "ssl-cipher-suites", "", "SSL cipher suites")
+
+	return kc
+}
+
+func replayDLQCommand(flagSet *flag.FlagSet) func(kc kafkautil.KafkaConfig, transID string) error {
+	topic := flagSet.String("topic", "", "DLQ topic")
+	maxMessages := flagSet.Int("max", 0, "max messages to replay, 0 is no limit")
+
+	return func(kc kafkautil.KafkaConfig, transID string) error {
+		if *topic == "" {
+			return errors.New("-topic is required")
+		}
+
+		count, err := kc.ReplayDLQ(transID, *topic, *maxMessages)
+		fmt.Printf("Replayed %d messages from %s\n", count, *topic)
+
+		return err
+	}
+}
+
+// runAdmin calls run with a KafkaAdmin, the context is cancelled by SIGINT or SIGTERM.
+func runAdmin(kc kafkautil.KafkaConfig, transID string, run func(ctx context.Context, admin *kafkautil.KafkaAdmin) error) error {
+	ctx, stop := kafkautil.SignalContext(context.Background())
+	defer stop()
+
+	admin, err := kafkautil.NewKafkaAdmin(transID, kc)
+
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	return run(ctx, admin)
+}
+
+func splitList(value string) []string {
+	var list []string
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+func createTopicCommand(flagSet *flag.FlagSet) func(kc kafkautil.KafkaConfig, transID string) error {
+	topic := flagSet.String("topic", "", "topic name")
+	partitions := flagSet.Int("partitions", 1, "number of partitions")
+	replication := flagSet.Int("replication", -1, "replication factor, -1 is default.replication.factor of the broker")
+	config := flagSet.String("config", "", "topic configs, e.g. retention.ms=604800000,cleanup.policy=compact")
+
+	return func(kc kafkautil.KafkaConfig, transID string) error {
+		if *topic == "" {
+			return errors.New("-topic is required")
+		}
+
+		spec := kafkautil.KafkaTopicSpec{Name: *topic, Partitions: *partitions, ReplicationFactor: *replication,
+			Config: make(map[string]string)}
+
+		for _, entry := range splitList(*config) {
+			name, value, found := strings.Cut(entry, "=")
+
+			if !found {
+				return fmt.Errorf("-config %q is not name=value", entry)
+			}
+
+			spec.Config[name] = value
+		}
+
+		return runAdmin(kc, transID, func(ctx context.Context, admin *kafkautil.KafkaAdmin) error {
+			if err := admin.CreateTopics(ctx, transID, spec); err != nil {
+				return err
+			}
+
+			fmt.Printf("Created topic %s with %d partitions\n", *topic, *partitions)
+			return nil
+		})
+	}
+}
+
+func deleteTopicCommand(flagSet *flag.FlagSet) func(kc kafkautil.KafkaConfig, transID string) error {
+	topics := flagSet.String("topic", "", "comma separated topic names")
+
+	return func(kc kafkautil.KafkaConfig, transID string) error {
+		topicNames := splitList(*topics)
+
+		if len(topicNames) == 0 {
+			return errors.New("-topic is required")
+		}
+
+		return runAdmin(kc, transID, func(ctx context.Context, admin *kafkautil.KafkaAdmin) error {
+			if err := admin.DeleteTopics(ctx, transID, topicNames...); err != nil {
+				return err
+			}
+
+			fmt.Println("Deleted topics", strings.Join(topicNames, ", "))
+			return nil
+		})
+	}
+}
+
+func describeTopicCommand(flagSet *flag.FlagSet) func(kc kafkautil.KafkaConfig, transID string) error {
+	topics := flagSet.String("topic", "", "comma separated topic names, all topics when it is empty")
+
+	return func(kc kafkautil.KafkaConfig, transID string) error {
+		return runAdmin(kc, transID, func(ctx context.Context, admin *kafkautil.KafkaAdmin) error {
+			descriptionList, err := admin.DescribeTopics(ctx, transID, splitList(*topics)...)
+
+			if err != nil {
+				return err
+			}
+
+			for _, description := range descriptionList {
+				fmt.Printf("Topic: %s\tPartitionCount: %d\n", description.Name, len(description.Partitions))
+
+				configNames := make([]string, 0, len(description.Config))
+				for name := range description.Config {
+					configNames = append(configNames, name)
+				}
+				sort.Strings(configNames)
+
+				for _, name := range configNames {
+					fmt.Printf("\tConfig: %s=%s\n", name, description.Config[name])
+				}
+
+				for _, partition := range description.Partitions {
+					fmt.Printf("\tPartition: %d\tLeader: %d\tReplicas: %v\tIsr: %v\n",
+						partition.Partition, partition.Leader, partition.Replicas, partition.Isrs)
+				}
+			}
+
+			return nil
+		})
+	}
+}
+
+func listGroupsCommand(flagSet *flag.FlagSet) func(kc kafkautil.KafkaConfig, transID string) error {
+	return func(kc kafkautil.KafkaConfig, transID string) error {
+		return runAdmin(kc, transID, func(ctx context.Context, admin *kafkautil.KafkaAdmin) error {
+			groupList, err := admin.ListConsumerGroups(ctx, transID)
+
+			if err != nil {
+				return err
+			}
+
+			writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(writer, "GROUP\tSTATE\tSIMPLE")
+
+			for _, group := range groupList {
+				fmt.Fprintf(writer, "%s\t%s\t%t\n", group.GroupID, group.State, group.IsSimple)
+			}
+
+			return writer.Flush()
+		})
+	}
+}
+
+func lagCommand(flagSet *flag.FlagSet) func(kc kafkautil.KafkaConfig, transID string) error {
+	topic := flagSet.String("topic", "", "topic name")
+
+	return func(kc kafkautil.KafkaConfig, transID string) error {
+		if *topic == "" {
+			return errors.New("-topic is required")
+		}
+
+		return runAdmin(kc, transID, func(ctx context.Context, admin *kafkautil.KafkaAdmin) error {
+			lagList, err := admin.GroupLag(ctx, transID, kc.GroupID, *topic)
+
+			if err != nil {
+				return err
+			}
+
+			var totalLag int64
+			writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(writer, "TOPIC\tPARTITION\tCOMMITTED-OFFSET\tLOG-START-OFFSET\tLOG-END-OFFSET\tLAG")
+
+			for _, lag := range lagList {
+				committedOffset := "-"
+				if lag.CommittedOffset >= 0 {
+					committedOffset = fmt.Sprint(lag.CommittedOffset)
+				}
+
+				fmt.Fprintf(writer, "%s\t%d\t%s\t%d\t%d\t%d\n", lag.Topic, lag.Partition, committedOffset,
+					lag.LogStartOffset, lag.LogEndOffset, lag.Lag)
+				totalLag += lag.Lag
+			}
+
+			fmt.Fprintf(writer, "TOTAL\t\t\t\t\t%d\n", totalLag)
+
+			return writer.Flush()
+		})
+	}
+}
+
+func resetOffsetsCommand(flagSet *flag.FlagSet) func(kc kafkautil.KafkaConfig, transID string) error {
+	topic := flagSet.String("topic", "", "topic name")
+	to := flagSet.String("to", "", "earliest, latest, timestamp or offset")
+	timestamp := flagSet.String("timestamp", "", "RFC3339 time of -to timestamp, e.g. 2026-01-01T00:00:00+07:00")
+	offset := flagSet.Int64("offset", 0, "offset of -to offset")
+	execute := flagSet.Bool("execute", false, "commit the new offsets, otherwise only print them")
+
+	return func(kc kafkautil.KafkaConfig, transID string) error {
+		if *topic == "" || *to == "" {
+			return errors.New("-topic and -to are required")
+		}
+
+		reset := kafkautil.KafkaOffsetReset{To: *to, Offset: *offset, DryRun: !*execute}
+
+		if *to == kafkautil.KafkaOffsetTimestamp {
+			var err error
+
+			if reset.Timestamp, err = time.Parse(time.RFC3339, *timestamp); err != nil {
+				return fmt.Errorf("-timestamp: %w", err)
+			}
+		}
+
+		return runAdmin(kc, transID, func(ctx context.Context, admin *kafkautil.KafkaAdmin) error {
+			offsetList, err := admin.ResetOffsets(ctx, transID, kc.GroupID, *topic, reset)
+
+			if err != nil {
+				return err
+			}
+
+			writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(writer, "TOPIC\tPARTITION\tOLD-OFFSET\tNEW-OFFSET")
+
+			for _, partitionOffset := range offsetList {
+				fmt.Fprintf(writer, "%s\t%d\t%d\t%d\n", partitionOffset.Topic, partitionOffset.Partition,
+					partitionOffset.OldOffset, partitionOffset.NewOffset)
+			}
+
+			if err = writer.Flush(); err != nil {
+				return err
+			}
+
+			if reset.DryRun {
+				fmt.Println("Dry run, add -execute to commit the new offsets of group", kc.GroupID)
+			}
+
+			return nil
+		})
+	}
+}
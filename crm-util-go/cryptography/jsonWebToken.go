@@ -122,8 +122,6 @@ func mapToJwtClaims(mapClaims jwt.MapClaims) JwtClaims {
 			jwtClaims.Gender = fmt.Sprintf("%v", val)
 		case "email":
 			jwtClaims.Email = fmt.Sprintf("%v", val)
-		default:
-			fmt.Sprintf("%v", val)
 		}
 	}
 
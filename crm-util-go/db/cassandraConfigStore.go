@@ -0,0 +1,344 @@
+package db
+
+import (
+	"crm-util-go/common"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	config "github.com/spf13/viper"
+)
+
+var ErrConfigStoreNotLoaded = errors.New("config store is not loaded")
+
+// configSnapshot is never changed after it is stored, a refresh stores a new snapshot.
+type configSnapshot struct {
+	configMap  map[string]string
+	mappingMap map[string][]MappingBean
+	loadedAt   time.Time
+}
+
+/*
+ConfigStore keeps the config and mapping tables of Cassandra in memory, the getters do not query Cassandra.
+The tables are reloaded every RefreshInterval, a failed reload keeps the last loaded values.
+
+	configStore := db.NewConfigStore(csdPool, db.ConfigStoreConfig{MappingTypes: []string{"TITLE", "IDTYPE"}})
+	err := configStore.Start(transID)
+	defer configStore.Stop()
+
+	configStore.OnChange(func(transID string, changeList []db.ConfigChange) {
+		...
+	})
+
+	siebelURL, err := configStore.GetConfigVal("siebel.url")
+	title, err := configStore.GetMappingValue("TITLE", "Mr.")
+*/
+type ConfigStore struct {
+	csdPool  CassandraPool
+	config   ConfigStoreConfig
+	load     func(transID string) (*configSnapshot, error)
+	snapshot atomic.Value
+
+	refreshMu    sync.Mutex
+	mu           sync.Mutex
+	onChangeList []func(transID string, changeList []ConfigChange)
+	lastErr      error
+	stop         chan struct{}
+	stopOnce     sync.Once
+}
+
+func NewConfigStore(csdPool CassandraPool, storeConfig ConfigStoreConfig) *ConfigStore {
+	if storeConfig.RefreshInterval <= 0 {
+		storeConfig.RefreshInterval = DefaultConfigRefreshInterval
+	}
+
+	store := &ConfigStore{csdPool: csdPool, config: storeConfig, stop: make(chan struct{})}
+	store.load = store.loadCassandra
+
+	return store
+}
+
+// Start loads the tables and refreshes them until Stop, the service should not start when Start fails.
+func (store *ConfigStore) Start(transID string) error {
+	if err := store.Refresh(transID); err != nil {
+		return err
+	}
+
+	// viper is not safe for concurrent use, so it is set once before the refresh goroutine starts
+	if store.config.SetViper {
+		for key, val := range store.getSnapshot().configMap {
+			config.Set(key, val)
+		}
+	}
+
+	go store.refreshLoop()
+
+	return nil
+}
+
+func (store *ConfigStore) Stop() {
+	store.stopOnce.Do(func() {
+		close(store.stop)
+	})
+}
+
+func (store *ConfigStore) refreshLoop() {
+	ticker := time.NewTicker(store.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-store.stop:
+			return
+		case <-ticker.C:
+			store.Refresh(common.NewUUID())
+		}
+	}
+}
+
+// OnChange adds fn which is called with the changes of every refresh, it is not called for the first load.
+func (store *ConfigStore) OnChange(fn func(transID string, changeList []ConfigChange)) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.onChangeList = append(store.onChangeList, fn)
+}
+
+// Refresh reloads the tables now, on error the last loaded values are kept.
+func (store *ConfigStore) Refresh(transID string) error {
+	store.refreshMu.Lock()
+	defer store.refreshMu.Unlock()
+
+	newSnapshot, err := store.load(transID)
+
+	store.mu.Lock()
+	store.lastErr = err
+	onChangeList := store.onChangeList
+	store.mu.Unlock()
+
+	if err != nil {
+		if oldSnapshot := store.getSnapshot(); oldSnapshot != nil {
+			store.csdPool.Logger.Error(transID, fmt.Sprintf("Refresh config store error, keep the config loaded at %s: %s",
+				oldSnapshot.loadedAt.Format(time.RFC3339), err.Error()), err)
+		} else {
+			store.csdPool.Logger.Error(transID, "Load config store error: "+err.Error(), err)
+		}
+
+		return err
+	}
+
+	oldSnapshot := store.getSnapshot()
+	store.snapshot.Store(newSnapshot)
+
+	if oldSnapshot == nil {
+		store.csdPool.Logger.Info(transID, fmt.Sprintf("Load config store success, Config: %d, Mapping Type: %d",
+			len(newSnapshot.configMap), len(newSnapshot.mappingMap)))
+		return nil
+	}
+
+	changeList := diffConfigSnapshot(oldSnapshot, newSnapshot)
+
+	if len(changeList) > 0 {
+		store.csdPool.Logger.Info(transID, fmt.Sprintf("Config store changed %d values", len(changeList)))
+
+		for _, onChange := range onChangeList {
+			onChange(transID, changeList)
+		}
+	}
+
+	return nil
+}
+
+func (store *ConfigStore) getSnapshot() *configSnapshot {
+	snapshot, _ := store.snapshot.Load().(*configSnapshot)
+	return snapshot
+}
+
+// LoadedAt returns the time of the last successful load.
+func (store *ConfigStore) LoadedAt() time.Time {
+	if snapshot := store.getSnapshot(); snapshot != nil {
+		return snapshot.loadedAt
+	}
+
+	return time.Time{}
+}
+
+// LastError returns the error of the last refresh, nil when it succeeded.
+func (store *ConfigStore) LastError() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.lastErr
+}
+
+func (store *ConfigStore) GetConfigVal(key string) (string, error) {
+	snapshot := store.getSnapshot()
+
+	if snapshot == nil {
+		return "", ErrConfigStoreNotLoaded
+	}
+
+	val, found := snapshot.configMap[key]
+
+	if !found {
+		return val, errors.New("Data config.key=" + key + " not found in Cassandra database")
+	}
+
+	return val, nil
+}
+
+func (store *ConfigStore) GetMappingByType(mappingType string) ([]MappingBean, error) {
+	snapshot := store.getSnapshot()
+
+	if snapshot == nil {
+		return nil, ErrConfigStoreNotLoaded
+	}
+
+	mappingBeanList, found := snapshot.mappingMap[mappingType]
+
+	if !found {
+		return nil, errors.New("Data mappingTypeList=" + mappingType + " not found in Cassandra database")
+	}
+
+	return append([]MappingBean{}, mappingBeanList...), nil
+}
+
+func (store *ConfigStore) GetMappingValue(mappingType string, fromValue string) (string, error) {
+	mappingBeanList, err := store.GetMappingByType(mappingType)
+
+	if err != nil {
+		return "", err
+	}
+
+	return store.csdPool.GetMappingValue(mappingBeanList, mappingType, fromValue)
+}
+
+func (store *ConfigStore) loadCassandra(transID string) (*configSnapshot, error) {
+	session, err := store.csdPool.GetSessionOne(transID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &configSnapshot{
+		configMap:  make(map[string]string),
+		mappingMap: make(map[string][]MappingBean),
+		loadedAt:   time.Now(),
+	}
+
+	configCQL := "SELECT key, value FROM config"
+	var configBind []interface{}
+
+	if len(store.config.ConfigKeys) > 0 {
+		configCQL += " WHERE key IN " + cqlBindList(len(store.config.ConfigKeys))
+		configBind = stringsToBind(store.config.ConfigKeys)
+	}
+
+	iterator := session.Query(configCQL, configBind...).Iter()
+
+	var key, val string
+	for iterator.Scan(&key, &val) {
+		snapshot.configMap[key] = val
+	}
+
+	if err = iterator.Close(); err != nil {
+		return nil, errors.New("Cassandra database error " + err.Error())
+	}
+
+	mappingCQL := "SELECT type, from_value, to_value FROM mapping"
+	var mappingBind []interface{}
+
+	if len(store.config.MappingTypes) > 0 {
+		mappingCQL += " WHERE type IN " + cqlBindList(len(store.config.MappingTypes)) + " ALLOW FILTERING"
+		mappingBind = stringsToBind(store.config.MappingTypes)
+	}
+
+	iterator = session.Query(mappingCQL, mappingBind...).Iter()
+
+	var mappingBean MappingBean
+	for iterator.Scan(&mappingBean.MappingType, &mappingBean.FromValue, &mappingBean.ToValue) {
+		snapshot.mappingMap[mappingBean.MappingType] = append(snapshot.mappingMap[mappingBean.MappingType], mappingBean)
+	}
+
+	if err = iterator.Close(); err != nil {
+		return nil, errors.New("Cassandra database error " + err.Error())
+	}
+
+	return snapshot, nil
+}
+
+func cqlBindList(size int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?,", size), ",") + ")"
+}
+
+func stringsToBind(valueList []string) []interface{} {
+	bindList := make([]interface{}, len(valueList))
+
+	for i, value := range valueList {
+		bindList[i] = value
+	}
+
+	return bindList
+}
+
+// diffConfigSnapshot returns the changes ordered by table, mapping type and key.
+func diffConfigSnapshot(oldSnapshot *configSnapshot, newSnapshot *configSnapshot) []ConfigChange {
+	var changeList []ConfigChange
+
+	changeList = append(changeList, diffValueMap(ConfigTable, "", oldSnapshot.configMap, newSnapshot.configMap)...)
+
+	mappingTypes := make(map[string]bool)
+	for mappingType := range oldSnapshot.mappingMap {
+		mappingTypes[mappingType] = true
+	}
+	for mappingType := range newSnapshot.mappingMap {
+		mappingTypes[mappingType] = true
+	}
+
+	for _, mappingType := range sortedKeys(mappingTypes) {
+		changeList = append(changeList, diffValueMap(MappingTable, mappingType,
+			mappingToMap(oldSnapshot.mappingMap[mappingType]), mappingToMap(newSnapshot.mappingMap[mappingType]))...)
+	}
+
+	return changeList
+}
+
+func diffValueMap(table string, mappingType string, oldMap map[string]string, newMap map[string]string) []ConfigChange {
+	var changeList []ConfigChange
+
+	for key, newValue := range newMap {
+		oldValue, found := oldMap[key]
+
+		if !found {
+			changeList = append(changeList, ConfigChange{Table: table, ChangeType: ConfigAdded, MappingType: mappingType, Key: key, NewValue: newValue})
+		} else if oldValue != newValue {
+			changeList = append(changeList, ConfigChange{Table: table, ChangeType: ConfigUpdated, MappingType: mappingType, Key: key, OldValue: oldValue, NewValue: newValue})
+		}
+	}
+
+	for key, oldValue := range oldMap {
+		if _, found := newMap[key]; !found {
+			changeList = append(changeList, ConfigChange{Table: table, ChangeType: ConfigRemoved, MappingType: mappingType, Key: key, OldValue: oldValue})
+		}
+	}
+
+	sort.Slice(changeList, func(i, j int) bool {
+		return changeList[i].Key < changeList[j].Key
+	})
+
+	return changeList
+}
+
+func mappingToMap(mappingBeanList []MappingBean) map[string]string {
+	valueMap := make(map[string]string, len(mappingBeanList))
+
+	for _, mappingBean := range mappingBeanList {
+		valueMap[mappingBean.FromValue] = mappingBean.ToValue
+	}
+
+	return valueMap
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"crm-util-go/logging"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	config "github.com/spf13/viper"
+)
+
+func newTestConfigStore(snapshotList ...*configSnapshot) *ConfigStore {
+	logger := logging.InitUtilLogger("crm-util-go", logging.CrmDatabase)
+	logger.Level = logging.LEVEL_OFF
+
+	store := NewConfigStore(CassandraPool{Logger: logger}, ConfigStoreConfig{RefreshInterval: time.Hour})
+	loadCount := 0
+
+	store.load = func(transID string) (*configSnapshot, error) {
+		if loadCount >= len(snapshotList) || snapshotList[loadCount] == nil {
+			loadCount++
+			return nil, errors.New("gocql: no hosts available in the pool")
+		}
+
+		loadCount++
+		return snapshotList[loadCount-1], nil
+	}
+
+	return store
+}
+
+func TestConfigStore(t *testing.T) {
+	store := newTestConfigStore(
+		&configSnapshot{
+			configMap:  map[string]string{"siebel.url": "http://siebel1", "siebel.header.user": "crm"},
+			mappingMap: map[string][]MappingBean{"TITLE": {{MappingType: "TITLE", FromValue: "Mr.", ToValue: "นาย"}}},
+		},
+		nil,
+		&configSnapshot{
+			configMap: map[string]string{"siebel.url": "http://siebel2", "siebel.header.pass": "secret"},
+			mappingMap: map[string][]MappingBean{
+				"TITLE":  {{MappingType: "TITLE", FromValue: "Mr.", ToValue: "นาย"}},
+				"IDTYPE": {{MappingType: "IDTYPE", FromValue: "I", ToValue: "ID Card"}},
+			},
+		},
+	)
+
+	if _, err := store.GetConfigVal("siebel.url"); !errors.Is(err, ErrConfigStoreNotLoaded) {
+		t.Errorf("expected ErrConfigStoreNotLoaded but got %v", err)
+	}
+
+	if err := store.Start("config-test"); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Stop()
+
+	var changeList []ConfigChange
+	store.OnChange(func(transID string, changes []ConfigChange) {
+		changeList = changes
+	})
+
+	if val, err := store.GetConfigVal("siebel.url"); err != nil || val != "http://siebel1" {
+		t.Errorf("unexpected config %s, %v", val, err)
+	}
+
+	if err := store.Refresh("config-test"); err == nil || store.LastError() == nil {
+		t.Error("expected refresh error")
+	}
+
+	if val, err := store.GetMappingValue("TITLE", "Mr."); err != nil || val != "นาย" {
+		t.Errorf("expected the last loaded mapping but got %s, %v", val, err)
+	}
+
+	if err := store.Refresh("config-test"); err != nil || store.LastError() != nil {
+		t.Fatal(err)
+	}
+
+	if val, err := store.GetMappingValue("IDTYPE", "I"); err != nil || val != "ID Card" {
+		t.Errorf("unexpected mapping %s, %v", val, err)
+	}
+
+	if _, err := store.GetConfigVal("siebel.header.user"); err == nil {
+		t.Error("expected removed config")
+	}
+
+	expectedChangeList := []ConfigChange{
+		{Table: ConfigTable, ChangeType: ConfigAdded, Key: "siebel.header.pass", NewValue: "secret"},
+		{Table: ConfigTable, ChangeType: ConfigRemoved, Key: "siebel.header.user", OldValue: "crm"},
+		{Table: ConfigTable, ChangeType: ConfigUpdated, Key: "siebel.url", OldValue: "http://siebel1", NewValue: "http://siebel2"},
+		{Table: MappingTable, ChangeType: ConfigAdded, MappingType: "IDTYPE", Key: "I", NewValue: "ID Card"},
+	}
+
+	if !reflect.DeepEqual(changeList, expectedChangeList) {
+		t.Errorf("unexpected changes %+v", changeList)
+	}
+}
+
+func TestConfigStoreSetViper(t *testing.T) {
+	store := newTestConfigStore(
+		&configSnapshot{configMap: map[string]string{"store.viper.url": "http://siebel1"}},
+		&configSnapshot{configMap: map[string]string{"store.viper.url": "http://siebel2"}},
+	)
+	store.config.SetViper = true
+
+	if err := store.Start("config-test"); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Stop()
+
+	if err := store.Refresh("config-test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if val := config.GetString("store.viper.url"); val != "http://siebel1" {
+		t.Errorf("expected viper of the first load but got %s", val)
+	}
+
+	if val, _ := store.GetConfigVal("store.viper.url"); val != "http://siebel2" {
+		t.Errorf("expected the refreshed config but got %s", val)
+	}
+}
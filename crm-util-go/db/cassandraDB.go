@@ -2,14 +2,20 @@ package db
 
 import (
 	"crm-util-go/validate"
+	"fmt"
 	"github.com/gocql/gocql"
+	"strings"
 	"sync"
 )
 
-var lockSessionOne sync.Mutex
-var lockSessionTrx sync.Mutex
-var sessionOne *gocql.Session
-var sessionTrx *gocql.Session
+// cassandraSessionMap keeps one session for each cluster, keyspace, policies and consistency, the session is shared by the copies of CassandraPool
+var cassandraSessionMu sync.Mutex
+var cassandraSessionMap = make(map[string]*cassandraSession)
+
+type cassandraSession struct {
+	mu      sync.Mutex
+	session *gocql.Session
+}
 
 func (csdPool CassandraPool) NewCluster(consistencyLevel gocql.Consistency) *gocql.ClusterConfig {
 	var emptyDbAuthen DbAuthen
@@ -22,6 +28,18 @@ func (csdPool CassandraPool) NewCluster(consistencyLevel gocql.Consistency) *goc
 	cluster.Timeout = csdPool.ConnectTimeout
 	cluster.ConnectTimeout = csdPool.ConnectTimeout
 
+	if csdPool.QueryTimeout > 0 {
+		cluster.Timeout = csdPool.QueryTimeout
+	}
+
+	if hostPolicy := csdPool.hostSelectionPolicy(); hostPolicy != nil {
+		cluster.PoolConfig.HostSelectionPolicy = hostPolicy
+	}
+
+	if retryPolicy := csdPool.RetryPolicy.gocqlPolicy(); retryPolicy != nil {
+		cluster.RetryPolicy = retryPolicy
+	}
+
 	if validate.HasStringValue(csdPool.CQLVersion) {
 		cluster.CQLVersion = csdPool.CQLVersion
 	}
@@ -40,86 +58,74 @@ func (csdPool CassandraPool) NewCluster(consistencyLevel gocql.Consistency) *goc
 	return cluster
 }
 
-func (csdPool CassandraPool) initSessionOne(transID string) (*gocql.Session, error) {
+// sessionKey has every setting of NewCluster and IdempotentQuery, so the pools of other timeouts or policies do not share a session.
+func (csdPool CassandraPool) sessionKey(consistencyLevel gocql.Consistency) string {
+	return fmt.Sprintf("%s:%d/%s/%s/%s/%s/%s/%d/%t/%s/%s/%+v/%+v", strings.Join(csdPool.Hosts, ","), csdPool.Port, csdPool.Keyspace,
+		csdPool.DbAuthen.Username, csdPool.CQLVersion, csdPool.LocalDC, consistencyLevel.String(), csdPool.NativeProtoVersion,
+		csdPool.TokenAware, csdPool.ConnectTimeout, csdPool.QueryTimeout, csdPool.RetryPolicy, csdPool.SpeculativeExecution)
+}
 
-	lockSessionOne.Lock()
-	defer lockSessionOne.Unlock()
+func (csdPool CassandraPool) getSessionEntry(consistencyLevel gocql.Consistency) *cassandraSession {
+	key := csdPool.sessionKey(consistencyLevel)
 
-	var err error
+	cassandraSessionMu.Lock()
+	defer cassandraSessionMu.Unlock()
 
-	if sessionOne != nil {
-		if !sessionOne.Closed() {
-			return sessionOne, err
-		}
-	}
+	entry, found := cassandraSessionMap[key]
 
-	cluster := csdPool.NewCluster(gocql.One)
-
-	sessionOne, err = cluster.CreateSession()
-
-	if err != nil {
-		csdPool.Logger.Error(transID, "Can not connect to Cassandra DB because " + err.Error(), err)
-		return sessionOne, err
-	} else {
-		csdPool.Logger.Info(transID, "Connect to Cassandra DB success")
+	if !found {
+		entry = &cassandraSession{}
+		cassandraSessionMap[key] = entry
 	}
 
-	return sessionOne, err
+	return entry
 }
 
-func (csdPool CassandraPool) initSessionTransaction(transID string) (*gocql.Session, error) {
-
-	lockSessionTrx.Lock()
-	defer lockSessionTrx.Unlock()
+// getSession returns the shared session, the session is created again when it was closed.
+func (csdPool CassandraPool) getSession(transID string, consistencyLevel gocql.Consistency) (*gocql.Session, error) {
+	entry := csdPool.getSessionEntry(consistencyLevel)
 
-	var err error
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
 
-	if sessionTrx != nil {
-		if !sessionTrx.Closed() {
-			return sessionTrx, err
-		}
+	if entry.session != nil && !entry.session.Closed() {
+		return entry.session, nil
 	}
 
-	cluster := csdPool.NewCluster(gocql.LocalQuorum)
+	cluster := csdPool.NewCluster(consistencyLevel)
 
-	sessionTrx, err = cluster.CreateSession()
+	session, err := cluster.CreateSession()
 
 	if err != nil {
 		csdPool.Logger.Error(transID, "Can not connect to Cassandra DB because " + err.Error(), err)
-		return sessionTrx, err
+		return nil, err
 	} else {
 		csdPool.Logger.Info(transID, "Connect to Cassandra DB success")
 	}
 
-	return sessionTrx, err
+	entry.session = session
+
+	return session, nil
 }
 
 func (csdPool CassandraPool) GetSessionOne(transID string) (*gocql.Session, error) {
-	if sessionOne == nil {
-		return csdPool.initSessionOne(transID)
-	} else if sessionOne.Closed() {
-		return csdPool.initSessionOne(transID)
-	} else {
-		return sessionOne, nil
-	}
+	return csdPool.getSession(transID, gocql.One)
 }
 
 func (csdPool CassandraPool) GetSessionTransaction(transID string) (*gocql.Session, error) {
-	if sessionTrx == nil {
-		return csdPool.initSessionTransaction(transID)
-	} else if sessionTrx.Closed() {
-		return csdPool.initSessionTransaction(transID)
-	} else {
-		return sessionTrx, nil
-	}
+	return csdPool.getSession(transID, gocql.LocalQuorum)
 }
 
+// CloseAllSession closes the sessions of this cluster and keyspace, the next Get creates a new session.
 func (csdPool CassandraPool) CloseAllSession() {
-	if sessionOne != nil {
-		sessionOne.Close()
-	}
+	for _, consistencyLevel := range []gocql.Consistency{gocql.One, gocql.LocalQuorum} {
+		entry := csdPool.getSessionEntry(consistencyLevel)
 
-	if sessionTrx != nil {
-		sessionTrx.Close()
+		entry.mu.Lock()
+		if entry.session != nil {
+			entry.session.Close()
+			entry.session = nil
+		}
+		entry.mu.Unlock()
 	}
-}
\ No newline at end of file
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"crm-util-go/common"
 	"crm-util-go/logging"
 	"encoding/json"
@@ -132,6 +133,56 @@ func TestCassandraGetMappingValue(t *testing.T) {
 	}
 }
 
+type testPageRow struct {
+	Bucket string `cql:"bucket"`
+	Seq    int    `cql:"seq"`
+}
+
+func TestCassandraSelectPage(t *testing.T) {
+	initCassandra()
+	defer csdPool.CloseAllSession()
+
+	ctx := WithTransID(context.Background(), common.NewUUID())
+
+	if err := ExecCQL(ctx, csdPool, "CREATE TABLE IF NOT EXISTS crm_util_page_test (bucket text, seq int, PRIMARY KEY (bucket, seq))"); err != nil {
+		t.Fatal(err)
+	}
+	defer ExecCQL(ctx, csdPool, "DROP TABLE IF EXISTS crm_util_page_test")
+
+	for seq := 1; seq <= 5; seq++ {
+		if err := ExecCQL(ctx, csdPool, "INSERT INTO crm_util_page_test (bucket, seq) VALUES (?, ?)", "page", seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 5 rows in pages of 2 are read as 1-2, 3-4 and 5, the last page has no PageState.
+	var pageState []byte
+	var seqList []int
+
+	for i := 0; i < 3; i++ {
+		page, err := SelectPageCQL[testPageRow](ctx, csdPool, 2, pageState,
+			"SELECT bucket, seq FROM crm_util_page_test WHERE bucket = ?", "page")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(page.Rows) > 2 {
+			t.Fatalf("expected at most 2 rows on page %d but got %+v", i+1, page.Rows)
+		}
+
+		for _, row := range page.Rows {
+			seqList = append(seqList, row.Seq)
+		}
+
+		pageState = page.PageState
+	}
+
+	if fmt.Sprint(seqList) != "[1 2 3 4 5]" || len(pageState) != 0 {
+		t.Errorf("expected the pages of [1 2 3 4 5] but got %v, PageState %v", seqList, pageState)
+	}
+}
+
 /*
 	 err := session.Query(`INSERT INTO tweet (timeline, id, text) VALUES (?, ?, ?)`,
 			"me", gocql.TimeUUID(), "hello world").WithContext(ctx).Exec()
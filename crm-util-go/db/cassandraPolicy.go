@@ -0,0 +1,122 @@
+package db
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// hostSelectionPolicy returns nil to use the round robin of gocql.
+func (csdPool CassandraPool) hostSelectionPolicy() gocql.HostSelectionPolicy {
+	var hostPolicy gocql.HostSelectionPolicy
+
+	if csdPool.LocalDC != "" {
+		hostPolicy = gocql.DCAwareRoundRobinPolicy(csdPool.LocalDC)
+	}
+
+	if csdPool.TokenAware {
+		if hostPolicy == nil {
+			hostPolicy = gocql.RoundRobinHostPolicy()
+		}
+
+		hostPolicy = gocql.TokenAwareHostPolicy(hostPolicy)
+	}
+
+	return hostPolicy
+}
+
+/*
+IdempotentQuery marks a query of GetSessionOne or GetSessionTransaction as idempotent,
+so it can use the SpeculativeExecution of the pool. SelectCQL, SelectOneCQL and SelectPageCQL call it for every read.
+
+	session, err := csdPool.GetSessionOne(transID)
+	iterator := csdPool.IdempotentQuery(session.Query("SELECT value FROM config WHERE key = ?", key)).Iter()
+*/
+func (csdPool CassandraPool) IdempotentQuery(query *gocql.Query) *gocql.Query {
+	query.Idempotent(true)
+
+	if spec := csdPool.SpeculativeExecution; spec.Attempts > 0 {
+		query.SetSpeculativeExecutionPolicy(&gocql.SimpleSpeculativeExecution{
+			NumAttempts:  spec.Attempts,
+			TimeoutDelay: spec.Delay,
+		})
+	}
+
+	return query
+}
+
+// gocqlPolicy returns nil when there is no retry.
+func (policy CassandraRetryPolicy) gocqlPolicy() gocql.RetryPolicy {
+	if policy.maxRetries() == 0 {
+		return nil
+	}
+
+	return cassandraRetryPolicy{policy: policy}
+}
+
+func (policy CassandraRetryPolicy) maxRetries() int {
+	if len(policy.DowngradeConsistency) > policy.NumRetries {
+		return len(policy.DowngradeConsistency)
+	}
+
+	return policy.NumRetries
+}
+
+func (policy CassandraRetryPolicy) backoff(attempts int) time.Duration {
+	if policy.MinBackoff <= 0 || attempts <= 0 {
+		return 0
+	}
+
+	backoff := policy.MinBackoff
+
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+
+		if policy.MaxBackoff > 0 && backoff >= policy.MaxBackoff {
+			return policy.MaxBackoff
+		}
+	}
+
+	if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+		return policy.MaxBackoff
+	}
+
+	return backoff
+}
+
+// cassandraRetryPolicy implements gocql.RetryPolicy with the backoff and the consistency downgrade of CassandraRetryPolicy.
+type cassandraRetryPolicy struct {
+	policy CassandraRetryPolicy
+	sleep  func(time.Duration)
+}
+
+func (p cassandraRetryPolicy) Attempt(q gocql.RetryableQuery) bool {
+	attempts := q.Attempts()
+
+	if attempts > p.policy.maxRetries() {
+		return false
+	}
+
+	if attempts > 0 && attempts <= len(p.policy.DowngradeConsistency) {
+		q.SetConsistency(p.policy.DowngradeConsistency[attempts-1])
+	}
+
+	if backoff := p.policy.backoff(attempts); backoff > 0 {
+		sleep := p.sleep
+		if sleep == nil {
+			sleep = time.Sleep
+		}
+
+		sleep(backoff)
+	}
+
+	return true
+}
+
+func (p cassandraRetryPolicy) GetRetryType(err error) gocql.RetryType {
+	if len(p.policy.DowngradeConsistency) > 0 {
+		return (&gocql.DowngradingConsistencyRetryPolicy{}).GetRetryType(err)
+	}
+
+	return gocql.RetryNextHost
+}
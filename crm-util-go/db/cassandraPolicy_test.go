@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+type testRetryableQuery struct {
+	attempts    int
+	consistency gocql.Consistency
+}
+
+func (q *testRetryableQuery) Attempts() int                      { return q.attempts }
+func (q *testRetryableQuery) SetConsistency(c gocql.Consistency) { q.consistency = c }
+func (q *testRetryableQuery) GetConsistency() gocql.Consistency  { return q.consistency }
+func (q *testRetryableQuery) Context() context.Context           { return context.Background() }
+
+func TestCassandraNewClusterPolicies(t *testing.T) {
+	csdPool := CassandraPool{Hosts: []string{"10.0.0.1"}, Port: 9042, ConnectTimeout: 3 * time.Second}
+	cluster := csdPool.NewCluster(gocql.One)
+
+	if cluster.PoolConfig.HostSelectionPolicy != nil || cluster.RetryPolicy != nil {
+		t.Errorf("default pool should keep the policies of gocql")
+	}
+
+	if cluster.Timeout != 3*time.Second {
+		t.Errorf("timeout %s, expected the connect timeout", cluster.Timeout)
+	}
+
+	csdPool.LocalDC = "DC1"
+	csdPool.TokenAware = true
+	csdPool.QueryTimeout = 800 * time.Millisecond
+	csdPool.RetryPolicy = CassandraRetryPolicy{NumRetries: 2}
+	cluster = csdPool.NewCluster(gocql.LocalQuorum)
+
+	if cluster.PoolConfig.HostSelectionPolicy == nil || cluster.RetryPolicy == nil {
+		t.Errorf("host selection and retry policy should be set")
+	}
+
+	if cluster.Timeout != 800*time.Millisecond || cluster.ConnectTimeout != 3*time.Second {
+		t.Errorf("unexpected timeout %s and connect timeout %s", cluster.Timeout, cluster.ConnectTimeout)
+	}
+}
+
+func TestCassandraRetryPolicyBackoff(t *testing.T) {
+	var sleepList []time.Duration
+	policy := cassandraRetryPolicy{
+		policy: CassandraRetryPolicy{NumRetries: 4, MinBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond},
+		sleep: func(d time.Duration) {
+			sleepList = append(sleepList, d)
+		},
+	}
+
+	query := &testRetryableQuery{consistency: gocql.LocalQuorum}
+
+	for query.attempts = 1; policy.Attempt(query); query.attempts++ {
+	}
+
+	if query.attempts != 5 {
+		t.Errorf("stopped at attempt %d, expected 5", query.attempts)
+	}
+
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	if len(sleepList) != len(expected) {
+		t.Fatalf("sleep %v, expected %v", sleepList, expected)
+	}
+
+	for i := range expected {
+		if sleepList[i] != expected[i] {
+			t.Errorf("sleep %v, expected %v", sleepList, expected)
+			break
+		}
+	}
+
+	if query.consistency != gocql.LocalQuorum {
+		t.Errorf("consistency should not be downgraded")
+	}
+
+	if policy.GetRetryType(errors.New("timeout")) != gocql.RetryNextHost {
+		t.Errorf("expected RetryNextHost")
+	}
+}
+
+func TestCassandraRetryPolicyDowngrade(t *testing.T) {
+	policy := CassandraRetryPolicy{DowngradeConsistency: []gocql.Consistency{gocql.LocalOne, gocql.One}}.gocqlPolicy()
+	query := &testRetryableQuery{consistency: gocql.LocalQuorum}
+
+	query.attempts = 1
+	if !policy.Attempt(query) || query.consistency != gocql.LocalOne {
+		t.Errorf("first retry should use LocalOne, got %s", query.consistency)
+	}
+
+	query.attempts = 2
+	if !policy.Attempt(query) || query.consistency != gocql.One {
+		t.Errorf("second retry should use One, got %s", query.consistency)
+	}
+
+	query.attempts = 3
+	if policy.Attempt(query) {
+		t.Errorf("no retry after the last consistency")
+	}
+
+	if policy.GetRetryType(&gocql.RequestErrReadTimeout{}) != gocql.Retry {
+		t.Errorf("read timeout should be retried on the same host")
+	}
+
+	if (CassandraRetryPolicy{}).gocqlPolicy() != nil {
+		t.Errorf("empty policy should not retry")
+	}
+}
+
+func TestCassandraIdempotentQuery(t *testing.T) {
+	csdPool := CassandraPool{SpeculativeExecution: CassandraSpeculativeExecution{Attempts: 2, Delay: 50 * time.Millisecond}}
+	query := csdPool.IdempotentQuery(&gocql.Query{})
+
+	if !query.IsIdempotent() {
+		t.Errorf("query should be idempotent")
+	}
+}
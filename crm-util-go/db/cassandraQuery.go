@@ -0,0 +1,430 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+var ErrEmptyBatch = errors.New("batch has no statement")
+
+// cqlDiscard reads the columns which have no cql tag in the struct.
+type cqlDiscard struct{}
+
+func (cqlDiscard) UnmarshalCQL(info gocql.TypeInfo, data []byte) error {
+	return nil
+}
+
+/*
+SelectCQL maps every row to T by the cql tag of the fields, gocql reads the next pages while iterating.
+The reads use the session of consistency One.
+
+	type Customer struct {
+		CustomerID string    `cql:"customer_id"`
+		Name       string    `cql:"name"`
+		UpdatedAt  time.Time `cql:"updated_at"`
+	}
+
+	ctx := db.WithTransID(context.Background(), transID)
+	customerList, err := db.SelectCQL[Customer](ctx, csdPool, "SELECT * FROM customer WHERE segment = ?", "VIP")
+*/
+func SelectCQL[T any](ctx context.Context, csdPool CassandraPool, stmt string, args ...interface{}) ([]T, error) {
+	transID := TransIDFromContext(ctx)
+	startDT := csdPool.Logger.LogRequestDBClient(transID)
+	csdPool.Logger.Debug(transID, stmt)
+
+	ctx, cancel := csdPool.callContext(ctx)
+	defer cancel()
+
+	session, err := csdPool.GetSessionOne(transID)
+
+	var resultList []T
+	if err == nil {
+		resultList, _, err = selectStructs[T](csdPool.IdempotentQuery(session.Query(stmt, args...)).WithContext(ctx), 0)
+	}
+
+	csdPool.Logger.LogResponseDBClient(transID, csdPool.responseCode(transID, err), startDT)
+
+	return resultList, err
+}
+
+// SelectOneCQL returns the first row, gocql.ErrNotFound when there is no row.
+func SelectOneCQL[T any](ctx context.Context, csdPool CassandraPool, stmt string, args ...interface{}) (T, error) {
+	var result T
+
+	transID := TransIDFromContext(ctx)
+	startDT := csdPool.Logger.LogRequestDBClient(transID)
+	csdPool.Logger.Debug(transID, stmt)
+
+	ctx, cancel := csdPool.callContext(ctx)
+	defer cancel()
+
+	session, err := csdPool.GetSessionOne(transID)
+
+	if err == nil {
+		var resultList []T
+		resultList, _, err = selectStructs[T](csdPool.IdempotentQuery(session.Query(stmt, args...)).WithContext(ctx).PageSize(1).PageState(nil), 1)
+
+		if err == nil && len(resultList) == 0 {
+			err = gocql.ErrNotFound
+		} else if err == nil {
+			result = resultList[0]
+		}
+	}
+
+	csdPool.Logger.LogResponseDBClient(transID, csdPool.responseCode(transID, err), startDT)
+
+	return result, err
+}
+
+/*
+SelectPageCQL reads one page of pageSize rows from pageState, the first page has an empty pageState.
+The caller keeps the PageState of the result to read the next page, e.g. as the cursor of a REST API.
+Query.PageState turns off the auto paging of gocql, even for the empty pageState, so the call returns at most pageSize rows.
+
+	page, err := db.SelectPageCQL[Customer](ctx, csdPool, 100, pageState, "SELECT * FROM customer")
+	nextCursor := base64.URLEncoding.EncodeToString(page.PageState)
+*/
+func SelectPageCQL[T any](ctx context.Context, csdPool CassandraPool, pageSize int, pageState []byte,
+	stmt string, args ...interface{}) (CQLPage[T], error) {
+
+	var page CQLPage[T]
+
+	transID := TransIDFromContext(ctx)
+	startDT := csdPool.Logger.LogRequestDBClient(transID)
+	csdPool.Logger.Debug(transID, stmt)
+
+	ctx, cancel := csdPool.callContext(ctx)
+	defer cancel()
+
+	session, err := csdPool.GetSessionOne(transID)
+
+	if err == nil {
+		query := csdPool.IdempotentQuery(session.Query(stmt, args...)).WithContext(ctx).PageSize(pageSize).PageState(pageState)
+		page.Rows, page.PageState, err = selectStructs[T](query, 0)
+	}
+
+	csdPool.Logger.LogResponseDBClient(transID, csdPool.responseCode(transID, err), startDT)
+
+	return page, err
+}
+
+// selectStructs reads the rows of query, the page state after the rows is returned for the query of PageState.
+func selectStructs[T any](query *gocql.Query, limit int) ([]T, []byte, error) {
+	var resultList []T
+
+	if reflect.TypeOf(resultList).Elem().Kind() != reflect.Struct {
+		return nil, nil, ErrNotStruct
+	}
+
+	iterator := query.Iter()
+
+	columns := make([]string, len(iterator.Columns()))
+	for i, column := range iterator.Columns() {
+		columns[i] = column.Name
+	}
+
+	scanner := iterator.Scanner()
+
+	for scanner.Next() {
+		var result T
+
+		if err := scanner.Scan(cqlScanList(columns, reflect.ValueOf(&result).Elem())...); err != nil {
+			iterator.Close()
+			return nil, nil, fmt.Errorf("can not read row from Cassandra: %w", err)
+		}
+
+		resultList = append(resultList, result)
+
+		if limit > 0 && len(resultList) >= limit {
+			break
+		}
+	}
+
+	pageState := iterator.PageState()
+
+	if err := iterator.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	return resultList, pageState, nil
+}
+
+// cqlScanList returns the field pointers of dest in the order of columns, the columns without cql tag are discarded.
+func cqlScanList(columns []string, dest reflect.Value) []interface{} {
+	fields := getStructFields(dest.Type(), "cql")
+	scanList := make([]interface{}, len(columns))
+
+	for i, column := range columns {
+		if index, found := fields[strings.ToLower(column)]; found {
+			scanList[i] = dest.FieldByIndex(index).Addr().Interface()
+		} else {
+			scanList[i] = cqlDiscard{}
+		}
+	}
+
+	return scanList
+}
+
+/*
+InsertStatement builds the INSERT of the fields which have a cql tag, the columns are sorted by name.
+
+	INSERT INTO customer (customer_id,name) VALUES (?,?) IF NOT EXISTS USING TTL ? AND TIMESTAMP ?
+*/
+func InsertStatement[T any](table string, row T, opts CQLWriteOptions) (CQLStatement, error) {
+	rowValue := reflect.ValueOf(row)
+
+	if rowValue.Kind() != reflect.Struct {
+		return CQLStatement{}, ErrNotStruct
+	}
+
+	fields := getStructFields(rowValue.Type(), "cql")
+	columns := sortedKeys(fields)
+	args := make([]interface{}, 0, len(columns)+2)
+
+	for _, column := range columns {
+		args = append(args, rowValue.FieldByIndex(fields[column]).Interface())
+	}
+
+	var cql strings.Builder
+	cql.WriteString("INSERT INTO " + table + " (" + strings.Join(columns, ",") + ") VALUES " + cqlBindList(len(columns)))
+
+	if opts.IfNotExists {
+		cql.WriteString(" IF NOT EXISTS")
+	}
+
+	var usingList []string
+
+	if ttl := int64(opts.TTL / time.Second); ttl > 0 {
+		usingList = append(usingList, "TTL ?")
+		args = append(args, ttl)
+	}
+
+	if !opts.Timestamp.IsZero() {
+		usingList = append(usingList, "TIMESTAMP ?")
+		args = append(args, opts.Timestamp.UnixMicro())
+	}
+
+	if len(usingList) > 0 {
+		cql.WriteString(" USING " + strings.Join(usingList, " AND "))
+	}
+
+	return CQLStatement{Stmt: cql.String(), Args: args}, nil
+}
+
+/*
+InsertCQL writes row to table with the session of consistency LocalQuorum.
+With IfNotExists, Applied is false and Existing is the current row when the primary key already exists.
+
+	result, err := db.InsertCQL(ctx, csdPool, "customer", customer, db.CQLWriteOptions{TTL: 24 * time.Hour, IfNotExists: true})
+*/
+func InsertCQL[T any](ctx context.Context, csdPool CassandraPool, table string, row T, opts CQLWriteOptions) (LWTResult, error) {
+	statement, err := InsertStatement(table, row, opts)
+
+	if err != nil {
+		return LWTResult{}, err
+	}
+
+	if opts.IfNotExists {
+		return ExecCAS(ctx, csdPool, statement.Stmt, statement.Args...)
+	}
+
+	if err = ExecCQL(ctx, csdPool, statement.Stmt, statement.Args...); err != nil {
+		return LWTResult{}, err
+	}
+
+	return LWTResult{Applied: true}, nil
+}
+
+// ExecCQL runs an INSERT, UPDATE or DELETE with the session of consistency LocalQuorum.
+func ExecCQL(ctx context.Context, csdPool CassandraPool, stmt string, args ...interface{}) error {
+	transID := TransIDFromContext(ctx)
+	startDT := csdPool.Logger.LogRequestDBClient(transID)
+	csdPool.Logger.Debug(transID, stmt)
+
+	ctx, cancel := csdPool.callContext(ctx)
+	defer cancel()
+
+	session, err := csdPool.GetSessionTransaction(transID)
+
+	if err == nil {
+		err = session.Query(stmt, args...).WithContext(ctx).Exec()
+	}
+
+	csdPool.Logger.LogResponseDBClient(transID, csdPool.responseCode(transID, err), startDT)
+
+	return err
+}
+
+/*
+ExecCAS runs a lightweight transaction, the statement has IF NOT EXISTS, IF EXISTS or IF conditions.
+
+	result, err := db.ExecCAS(ctx, csdPool, "UPDATE customer SET name = ?, version = ? WHERE customer_id = ? IF version = ?",
+		name, version+1, customerID, version)
+*/
+func ExecCAS(ctx context.Context, csdPool CassandraPool, stmt string, args ...interface{}) (LWTResult, error) {
+	transID := TransIDFromContext(ctx)
+	startDT := csdPool.Logger.LogRequestDBClient(transID)
+	csdPool.Logger.Debug(transID, stmt)
+
+	ctx, cancel := csdPool.callContext(ctx)
+	defer cancel()
+
+	session, err := csdPool.GetSessionTransaction(transID)
+
+	var result LWTResult
+	if err == nil {
+		existing := make(map[string]interface{})
+		result.Applied, err = session.Query(stmt, args...).WithContext(ctx).SerialConsistency(gocql.LocalSerial).MapScanCAS(existing)
+		result.Existing = lwtExisting(result.Applied, existing)
+	}
+
+	csdPool.Logger.LogResponseDBClient(transID, csdPool.responseCode(transID, err), startDT)
+
+	return result, err
+}
+
+/*
+CQLBatch collects the statements of ExecuteBatch.
+A LoggedBatch is applied atomically, an UnloggedBatch is for the rows of one partition.
+Timestamp is the write time of every statement when it is not zero.
+
+	batch := db.NewCQLBatch(gocql.LoggedBatch)
+	batch.Add("UPDATE customer SET name = ? WHERE customer_id = ?", name, customerID)
+	batch.AddStatement(insertStatement)
+	err := db.ExecuteBatch(ctx, csdPool, batch)
+*/
+type CQLBatch struct {
+	Type       gocql.BatchType
+	Timestamp  time.Time
+	statements []CQLStatement
+}
+
+func NewCQLBatch(batchType gocql.BatchType) *CQLBatch {
+	return &CQLBatch{Type: batchType}
+}
+
+func (batch *CQLBatch) Add(stmt string, args ...interface{}) *CQLBatch {
+	return batch.AddStatement(CQLStatement{Stmt: stmt, Args: args})
+}
+
+func (batch *CQLBatch) AddStatement(statement CQLStatement) *CQLBatch {
+	batch.statements = append(batch.statements, statement)
+	return batch
+}
+
+func (batch *CQLBatch) Size() int {
+	return len(batch.statements)
+}
+
+func (batch *CQLBatch) newBatch(ctx context.Context, session *gocql.Session) *gocql.Batch {
+	gocqlBatch := session.NewBatch(batch.Type).WithContext(ctx)
+
+	for _, statement := range batch.statements {
+		gocqlBatch.Query(statement.Stmt, statement.Args...)
+	}
+
+	if !batch.Timestamp.IsZero() {
+		gocqlBatch.WithTimestamp(batch.Timestamp.UnixMicro())
+	}
+
+	return gocqlBatch
+}
+
+// ExecuteBatch runs the batch with the session of consistency LocalQuorum.
+func ExecuteBatch(ctx context.Context, csdPool CassandraPool, batch *CQLBatch) error {
+	if batch.Size() == 0 {
+		return ErrEmptyBatch
+	}
+
+	transID := TransIDFromContext(ctx)
+	startDT := csdPool.Logger.LogRequestDBClient(transID)
+	csdPool.Logger.Debug(transID, fmt.Sprintf("Execute batch of %d statements", batch.Size()))
+
+	ctx, cancel := csdPool.callContext(ctx)
+	defer cancel()
+
+	session, err := csdPool.GetSessionTransaction(transID)
+
+	if err == nil {
+		err = session.ExecuteBatch(batch.newBatch(ctx, session))
+	}
+
+	csdPool.Logger.LogResponseDBClient(transID, csdPool.responseCode(transID, err), startDT)
+
+	return err
+}
+
+// ExecuteBatchCAS runs a batch of conditional statements of one partition, nothing is applied when a condition fails.
+func ExecuteBatchCAS(ctx context.Context, csdPool CassandraPool, batch *CQLBatch) (LWTResult, error) {
+	if batch.Size() == 0 {
+		return LWTResult{}, ErrEmptyBatch
+	}
+
+	transID := TransIDFromContext(ctx)
+	startDT := csdPool.Logger.LogRequestDBClient(transID)
+	csdPool.Logger.Debug(transID, fmt.Sprintf("Execute conditional batch of %d statements", batch.Size()))
+
+	ctx, cancel := csdPool.callContext(ctx)
+	defer cancel()
+
+	session, err := csdPool.GetSessionTransaction(transID)
+
+	var result LWTResult
+	if err == nil {
+		existing := make(map[string]interface{})
+		var iterator *gocql.Iter
+
+		gocqlBatch := batch.newBatch(ctx, session).SerialConsistency(gocql.LocalSerial)
+		result.Applied, iterator, err = session.MapExecuteBatchCAS(gocqlBatch, existing)
+
+		if iterator != nil {
+			if closeErr := iterator.Close(); err == nil {
+				err = closeErr
+			}
+		}
+
+		result.Existing = lwtExisting(result.Applied, existing)
+	}
+
+	csdPool.Logger.LogResponseDBClient(transID, csdPool.responseCode(transID, err), startDT)
+
+	return result, err
+}
+
+// lwtExisting removes the [applied] column, the existing row is returned only when the statement is not applied.
+func lwtExisting(applied bool, existing map[string]interface{}) map[string]interface{} {
+	delete(existing, "[applied]")
+
+	if applied || len(existing) == 0 {
+		return nil
+	}
+
+	return existing
+}
+
+// callContext bounds one call by CallTimeout when ctx has no deadline.
+func (csdPool CassandraPool) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, found := ctx.Deadline(); found || csdPool.CallTimeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, csdPool.CallTimeout)
+}
+
+// responseCode logs the error and returns the response code for LogResponseDBClient of the caller.
+func (csdPool CassandraPool) responseCode(transID string, err error) string {
+	if errors.Is(err, gocql.ErrNotFound) {
+		return "201000"
+	} else if err != nil {
+		csdPool.Logger.Error(transID, "Cassandra DB Error: "+err.Error(), err)
+		return "802014"
+	}
+
+	return "0"
+}
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"context"
+	"crm-util-go/logging"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+type testCustomerBase struct {
+	CustomerID string `cql:"customer_id"`
+}
+
+type testCustomer struct {
+	testCustomerBase
+	Name      string    `cql:"name" db:"CUSTOMER_NAME"`
+	Segment   *string   `cql:"segment"`
+	UpdatedAt time.Time `cql:"updated_at"`
+	Note      string    `cql:"-"`
+	Internal  string
+}
+
+func TestCQLScanList(t *testing.T) {
+	var customer testCustomer
+	dest := reflect.ValueOf(&customer).Elem()
+
+	scanList := cqlScanList([]string{"Customer_ID", "name", "segment", "unknown_column", "updated_at"}, dest)
+
+	if len(scanList) != 5 {
+		t.Fatalf("scan list size %d, expected 5", len(scanList))
+	}
+
+	if scanList[0] != &customer.CustomerID || scanList[1] != &customer.Name ||
+		scanList[2] != &customer.Segment || scanList[4] != &customer.UpdatedAt {
+		t.Errorf("scan list does not point to the fields: %v", scanList)
+	}
+
+	if _, ok := scanList[3].(gocql.Unmarshaler); !ok {
+		t.Errorf("unknown column should be discarded, got %T", scanList[3])
+	}
+}
+
+func TestGetStructFieldsByTag(t *testing.T) {
+	cqlFields := getStructFields(reflect.TypeOf(testCustomer{}), "cql")
+	dbFields := getStructFields(reflect.TypeOf(testCustomer{}), "db")
+
+	if len(cqlFields) != 4 {
+		t.Errorf("cql fields %v, expected 4 fields", cqlFields)
+	}
+
+	if _, found := cqlFields["note"]; found {
+		t.Errorf("field with cql tag - should be skipped")
+	}
+
+	if len(dbFields) != 1 || dbFields["customer_name"] == nil {
+		t.Errorf("db fields %v, expected customer_name only", dbFields)
+	}
+}
+
+func TestInsertStatement(t *testing.T) {
+	segment := "VIP"
+	customer := testCustomer{Name: "Somchai", Segment: &segment}
+	customer.CustomerID = "C001"
+
+	statement, err := InsertStatement("customer", customer, CQLWriteOptions{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "INSERT INTO customer (customer_id,name,segment,updated_at) VALUES (?,?,?,?)"
+	if statement.Stmt != expected {
+		t.Errorf("statement %q, expected %q", statement.Stmt, expected)
+	}
+
+	if len(statement.Args) != 4 || statement.Args[0] != "C001" || statement.Args[1] != "Somchai" {
+		t.Errorf("unexpected args %v", statement.Args)
+	}
+
+	writeTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	statement, err = InsertStatement("customer", customer, CQLWriteOptions{
+		TTL:         90 * time.Minute,
+		Timestamp:   writeTime,
+		IfNotExists: true,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasSuffix(statement.Stmt, "VALUES (?,?,?,?) IF NOT EXISTS USING TTL ? AND TIMESTAMP ?") {
+		t.Errorf("unexpected statement %q", statement.Stmt)
+	}
+
+	if len(statement.Args) != 6 || statement.Args[4] != int64(5400) || statement.Args[5] != writeTime.UnixMicro() {
+		t.Errorf("unexpected TTL and timestamp args %v", statement.Args)
+	}
+
+	if _, err = InsertStatement("customer", "not a struct", CQLWriteOptions{}); err != ErrNotStruct {
+		t.Errorf("expected ErrNotStruct, got %v", err)
+	}
+}
+
+func TestCQLBatch(t *testing.T) {
+	batch := NewCQLBatch(gocql.UnloggedBatch)
+	batch.Add("DELETE FROM customer WHERE customer_id = ?", "C001").
+		AddStatement(CQLStatement{Stmt: "DELETE FROM customer WHERE customer_id = ?", Args: []interface{}{"C002"}})
+
+	if batch.Size() != 2 || batch.Type != gocql.UnloggedBatch {
+		t.Errorf("unexpected batch %+v", batch)
+	}
+
+	if err := ExecuteBatch(context.Background(), CassandraPool{}, NewCQLBatch(gocql.LoggedBatch)); err != ErrEmptyBatch {
+		t.Errorf("expected ErrEmptyBatch, got %v", err)
+	}
+}
+
+func TestLWTExisting(t *testing.T) {
+	if existing := lwtExisting(true, map[string]interface{}{"[applied]": true}); existing != nil {
+		t.Errorf("applied statement should not return the existing row: %v", existing)
+	}
+
+	existing := lwtExisting(false, map[string]interface{}{"[applied]": false, "name": "Somchai"})
+
+	if len(existing) != 1 || existing["name"] != "Somchai" {
+		t.Errorf("unexpected existing row %v", existing)
+	}
+}
+
+func TestCassandraSessionKey(t *testing.T) {
+	csdPool := CassandraPool{Hosts: []string{"10.0.0.1", "10.0.0.2"}, Port: 9042, Keyspace: "crm"}
+	otherPool := csdPool
+	otherPool.Keyspace = "billing"
+
+	if csdPool.getSessionEntry(gocql.One) != csdPool.getSessionEntry(gocql.One) {
+		t.Errorf("copies of a pool should share the session")
+	}
+
+	if csdPool.getSessionEntry(gocql.One) == csdPool.getSessionEntry(gocql.LocalQuorum) {
+		t.Errorf("consistency levels should not share the session")
+	}
+
+	if csdPool.getSessionEntry(gocql.One) == otherPool.getSessionEntry(gocql.One) {
+		t.Errorf("keyspaces should not share the session")
+	}
+
+	policyList := map[string]func(pool *CassandraPool){
+		"QueryTimeout":         func(pool *CassandraPool) { pool.QueryTimeout = time.Second },
+		"TokenAware":           func(pool *CassandraPool) { pool.TokenAware = true },
+		"RetryPolicy":          func(pool *CassandraPool) { pool.RetryPolicy.NumRetries = 3 },
+		"DowngradeConsistency": func(pool *CassandraPool) { pool.RetryPolicy.DowngradeConsistency = []gocql.Consistency{gocql.LocalOne} },
+		"SpeculativeExecution": func(pool *CassandraPool) { pool.SpeculativeExecution.Attempts = 2 },
+	}
+
+	for name, update := range policyList {
+		policyPool := csdPool
+		update(&policyPool)
+
+		if csdPool.getSessionEntry(gocql.One) == policyPool.getSessionEntry(gocql.One) {
+			t.Errorf("pools of another %s should not share the session", name)
+		}
+	}
+
+	// no session is created yet
+	csdPool.CloseAllSession()
+}
+
+func TestCassandraLogAction(t *testing.T) {
+	logger := logging.InitUtilLogger("crm-util-go", logging.CrmDatabase)
+	readLog := readTestLog(t, logger)
+
+	// The pool without hosts fails to create the session, the request and response are still logged.
+	csdPool := CassandraPool{Keyspace: "crm-log-test", Logger: logger}
+	defer csdPool.CloseAllSession()
+
+	if err := ExecCQL(context.Background(), csdPool, "DELETE FROM customer WHERE customer_id = ?", "C1"); err == nil {
+		t.Fatal("expected the pool without hosts to fail")
+	}
+
+	if _, err := SelectCQL[testCustomer](context.Background(), csdPool, "SELECT * FROM customer"); err == nil {
+		t.Fatal("expected the pool without hosts to fail")
+	}
+
+	logText := readLog()
+	for _, action := range []string{"func: ExecCQL", "func: SelectCQL"} {
+		if strings.Count(logText, action) != 2 {
+			t.Errorf("expected the request and response of %s in the log\n%s", action, logText)
+		}
+	}
+}
+
+func TestCassandraCallContext(t *testing.T) {
+	csdPool := CassandraPool{CallTimeout: time.Minute}
+
+	ctx, cancel := csdPool.callContext(context.Background())
+	defer cancel()
+
+	if deadline, found := ctx.Deadline(); !found || time.Until(deadline) > time.Minute {
+		t.Errorf("expected the deadline of CallTimeout but got %v, %t", deadline, found)
+	}
+
+	callerCtx, callerCancel := context.WithTimeout(context.Background(), time.Second)
+	defer callerCancel()
+
+	if ctx, _ = csdPool.callContext(callerCtx); ctx != callerCtx {
+		t.Errorf("expected the deadline of the caller to be the timeout of the call")
+	}
+
+	if ctx, _ = (CassandraPool{}).callContext(context.Background()); ctx != context.Background() {
+		t.Errorf("expected no deadline without CallTimeout")
+	}
+}
@@ -0,0 +1,281 @@
+package db
+
+import (
+	"context"
+	"crm-util-go/logging"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrBulkKeyRequired = errors.New("KeyColumns is required for upsert")
+	ErrBulkRowColumns  = errors.New("row values do not match columns")
+)
+
+func (e BulkChunkError) Error() string {
+	return fmt.Sprintf("chunk %d (row %d-%d) error: %v", e.Chunk, e.StartRow, e.EndRow, e.Err)
+}
+
+func (e BulkChunkError) Unwrap() error {
+	return e.Err
+}
+
+/*
+BulkInsert inserts rows by multi-row INSERT on MariaDB and INSERT ALL on Oracle, go-oci8 has no array binding.
+The chunks are split by ChunkSize and MaxPacketBytes, which must be lower than max_allowed_packet of MariaDB.
+Each chunk is one statement, the chunks run in the transaction of WithTx when ctx is tx.Context().
+
+	result, err := db.BulkInsert(ctx, dbPool, "CAMPAIGN_TRANS", []string{"CAMP_TRANS_ID", "STATUS"},
+		[][]interface{}{{"T1", "Wait"}, {"T2", "Call"}}, db.BulkOptions{ChunkSize: 1000, ContinueOnError: true})
+*/
+func BulkInsert(ctx context.Context, dbPool DBPool, table string, columns []string, rows [][]interface{}, opts ...BulkOptions) (BulkResult, error) {
+	return bulkExec(ctx, dbPool, "BulkInsert", table, columns, rows, false, opts)
+}
+
+// BulkUpsert inserts or updates rows by INSERT ... ON DUPLICATE KEY UPDATE on MariaDB and MERGE on Oracle.
+func BulkUpsert(ctx context.Context, dbPool DBPool, table string, columns []string, rows [][]interface{}, opts ...BulkOptions) (BulkResult, error) {
+	return bulkExec(ctx, dbPool, "BulkUpsert", table, columns, rows, true, opts)
+}
+
+func bulkExec(ctx context.Context, dbPool DBPool, funcName string, table string, columns []string, rows [][]interface{},
+	upsert bool, optsList []BulkOptions) (BulkResult, error) {
+	var result BulkResult
+	var opts BulkOptions
+
+	if len(optsList) > 0 {
+		opts = optsList[0]
+	}
+
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultBulkChunkSize
+	}
+
+	if opts.MaxPacketBytes <= 0 {
+		opts.MaxPacketBytes = DefaultBulkMaxPacketBytes
+	}
+
+	dialect := dbPool.Dialect()
+
+	if upsert && dialect != DialectMaria && len(opts.KeyColumns) == 0 {
+		return result, ErrBulkKeyRequired
+	}
+
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			return result, fmt.Errorf("%w: row %d has %d values for %d columns", ErrBulkRowColumns, i, len(row), len(columns))
+		}
+	}
+
+	transID := TransIDFromContext(ctx)
+	action := dbAction(funcName)
+	startDT := dbPool.Logger.LogRequest(transID, logging.DatabaseClient, "", action)
+
+	executor, err := dbPool.executor(ctx, transID)
+
+	for start := 0; err == nil && start < len(rows); {
+		end := bulkChunkEnd(rows, start, len(columns), opts)
+
+		var sqlStmt string
+		if dialect == DialectMaria {
+			sqlStmt = buildBulkMaria(table, columns, end-start, upsert, opts)
+		} else {
+			sqlStmt = rebindOracle(buildBulkOracle(table, columns, end-start, upsert, opts))
+		}
+
+		var args []interface{}
+		for _, row := range rows[start:end] {
+			args = append(args, row...)
+		}
+
+		result.Chunks++
+		dbPool.Logger.Debug(transID, fmt.Sprintf("Bulk chunk %d row %d-%d: %s", result.Chunks, start, end-1, sqlStmt))
+
+		execResult, execErr := executor.ExecContext(ctx, sqlStmt, args...)
+
+		if execErr != nil {
+			chunkErr := BulkChunkError{Chunk: result.Chunks, StartRow: start, EndRow: end - 1, Err: execErr}
+			dbPool.Logger.Error(transID, chunkErr.Error(), execErr)
+			result.ChunkErrors = append(result.ChunkErrors, chunkErr)
+
+			if !opts.ContinueOnError || ctx.Err() != nil {
+				break
+			}
+		} else if rowsAffected, rowsErr := execResult.RowsAffected(); rowsErr == nil {
+			result.RowsAffected += rowsAffected
+		}
+
+		start = end
+	}
+
+	if err == nil && len(result.ChunkErrors) > 0 {
+		err = fmt.Errorf("%d of %d chunks failed, first %w", len(result.ChunkErrors), result.Chunks, result.ChunkErrors[0])
+	}
+
+	dbPool.Logger.LogResponse(transID, logging.DatabaseClient, "", action, dbPool.responseCode(transID, err), startDT)
+
+	return result, wrapDbError(dbPool, err)
+}
+
+// bulkChunkEnd returns the end row of the chunk within ChunkSize, MaxPacketBytes and the bind parameters limit.
+func bulkChunkEnd(rows [][]interface{}, start int, columnCount int, opts BulkOptions) int {
+	maxRows := opts.ChunkSize
+	if columnCount > 0 && maxBindParameters/columnCount < maxRows {
+		maxRows = maxBindParameters / columnCount
+	}
+
+	packetBytes := 0
+	end := start
+
+	for end < len(rows) && end-start < maxRows {
+		rowBytes := columnCount * 4
+
+		for _, value := range rows[end] {
+			switch v := value.(type) {
+			case string:
+				rowBytes += len(v)
+			case []byte:
+				rowBytes += len(v)
+			default:
+				rowBytes += 16
+			}
+		}
+
+		if end > start && packetBytes+rowBytes > opts.MaxPacketBytes {
+			break
+		}
+
+		packetBytes += rowBytes
+		end++
+	}
+
+	return end
+}
+
+func bulkUpdateColumns(columns []string, opts BulkOptions) []string {
+	if len(opts.UpdateColumns) > 0 {
+		return opts.UpdateColumns
+	}
+
+	var updateColumns []string
+
+	for _, column := range columns {
+		isKey := false
+
+		for _, keyColumn := range opts.KeyColumns {
+			if strings.EqualFold(column, keyColumn) {
+				isKey = true
+				break
+			}
+		}
+
+		if !isKey {
+			updateColumns = append(updateColumns, column)
+		}
+	}
+
+	return updateColumns
+}
+
+func buildBulkMaria(table string, columns []string, rowCount int, upsert bool, opts BulkOptions) string {
+	var sqlBuilder strings.Builder
+	rowPlaceholder := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+
+	sqlBuilder.WriteString("INSERT INTO ")
+	sqlBuilder.WriteString(table)
+	sqlBuilder.WriteString(" (")
+	sqlBuilder.WriteString(strings.Join(columns, ", "))
+	sqlBuilder.WriteString(") VALUES ")
+
+	for i := 0; i < rowCount; i++ {
+		if i > 0 {
+			sqlBuilder.WriteString(", ")
+		}
+		sqlBuilder.WriteString(rowPlaceholder)
+	}
+
+	if upsert {
+		var updateList []string
+		for _, column := range bulkUpdateColumns(columns, opts) {
+			updateList = append(updateList, column+" = VALUES("+column+")")
+		}
+
+		if len(updateList) > 0 {
+			sqlBuilder.WriteString(" ON DUPLICATE KEY UPDATE ")
+			sqlBuilder.WriteString(strings.Join(updateList, ", "))
+		}
+	}
+
+	return sqlBuilder.String()
+}
+
+func buildBulkOracle(table string, columns []string, rowCount int, upsert bool, opts BulkOptions) string {
+	var sqlBuilder strings.Builder
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+
+	if !upsert {
+		sqlBuilder.WriteString("INSERT ALL")
+
+		for i := 0; i < rowCount; i++ {
+			sqlBuilder.WriteString(" INTO ")
+			sqlBuilder.WriteString(table)
+			sqlBuilder.WriteString(" (")
+			sqlBuilder.WriteString(strings.Join(columns, ", "))
+			sqlBuilder.WriteString(") VALUES (")
+			sqlBuilder.WriteString(placeholders)
+			sqlBuilder.WriteString(")")
+		}
+
+		sqlBuilder.WriteString(" SELECT 1 FROM DUAL")
+		return sqlBuilder.String()
+	}
+
+	var selectList []string
+	for _, column := range columns {
+		selectList = append(selectList, "? AS "+column)
+	}
+	selectRow := "SELECT " + strings.Join(selectList, ", ") + " FROM DUAL"
+
+	sqlBuilder.WriteString("MERGE INTO ")
+	sqlBuilder.WriteString(table)
+	sqlBuilder.WriteString(" T USING (")
+
+	for i := 0; i < rowCount; i++ {
+		if i > 0 {
+			sqlBuilder.WriteString(" UNION ALL ")
+		}
+		sqlBuilder.WriteString(selectRow)
+	}
+
+	var onList []string
+	for _, column := range opts.KeyColumns {
+		onList = append(onList, "T."+column+" = S."+column)
+	}
+
+	sqlBuilder.WriteString(") S ON (")
+	sqlBuilder.WriteString(strings.Join(onList, " AND "))
+	sqlBuilder.WriteString(")")
+
+	var updateList []string
+	for _, column := range bulkUpdateColumns(columns, opts) {
+		updateList = append(updateList, "T."+column+" = S."+column)
+	}
+
+	if len(updateList) > 0 {
+		sqlBuilder.WriteString(" WHEN MATCHED THEN UPDATE SET ")
+		sqlBuilder.WriteString(strings.Join(updateList, ", "))
+	}
+
+	var sourceList []string
+	for _, column := range columns {
+		sourceList = append(sourceList, "S."+column)
+	}
+
+	sqlBuilder.WriteString(" WHEN NOT MATCHED THEN INSERT (")
+	sqlBuilder.WriteString(strings.Join(columns, ", "))
+	sqlBuilder.WriteString(") VALUES (")
+	sqlBuilder.WriteString(strings.Join(sourceList, ", "))
+	sqlBuilder.WriteString(")")
+
+	return sqlBuilder.String()
+}
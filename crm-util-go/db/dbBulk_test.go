@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBulkInsertChunk(t *testing.T) {
+	columns := []string{"CAMP_TRANS_ID", "STATUS"}
+	rows := [][]interface{}{{"T1", "Wait"}, {"T2", "Wait"}, {"T3", "Call"}, {"T4", "Call"}, {"T5", "Done"}}
+
+	connector := &testConnector{results: map[string]testResult{
+		"INSERT INTO CAMPAIGN_TRANS (CAMP_TRANS_ID, STATUS) VALUES (?, ?), (?, ?)": {err: errors.New("Duplicate entry 'T1'")},
+	}}
+	dbPool := newTestDBPool(connector)
+
+	result, err := BulkInsert(context.Background(), dbPool, "CAMPAIGN_TRANS", columns, rows, BulkOptions{ChunkSize: 2, ContinueOnError: true})
+
+	if err == nil || result.Chunks != 3 || len(result.ChunkErrors) != 2 || result.RowsAffected != 1 {
+		t.Fatalf("unexpected result %+v, %v", result, err)
+	}
+
+	if chunkErr := result.ChunkErrors[1]; chunkErr.Chunk != 2 || chunkErr.StartRow != 2 || chunkErr.EndRow != 3 {
+		t.Errorf("unexpected chunk error %+v", chunkErr)
+	}
+
+	result, _ = BulkInsert(context.Background(), dbPool, "CAMPAIGN_TRANS", columns, rows, BulkOptions{ChunkSize: 2})
+	if result.Chunks != 1 || len(result.ChunkErrors) != 1 {
+		t.Errorf("expected stop at the first error but got %+v", result)
+	}
+
+	if _, err = BulkInsert(context.Background(), dbPool, "CAMPAIGN_TRANS", columns, [][]interface{}{{"T1"}}); !errors.Is(err, ErrBulkRowColumns) {
+		t.Errorf("expected ErrBulkRowColumns but got %v", err)
+	}
+
+	// Each row is 2*4 + 2 + 4 bytes, a packet of 30 bytes has 2 rows
+	if end := bulkChunkEnd(rows, 0, 2, BulkOptions{ChunkSize: 100, MaxPacketBytes: 30}); end != 2 {
+		t.Errorf("expected chunk end 2 but got %d", end)
+	}
+}
+
+func TestBulkUpsertSQL(t *testing.T) {
+	columns := []string{"CAMP_TRANS_ID", "STATUS", "UPDATED_DATE"}
+	opts := BulkOptions{KeyColumns: []string{"CAMP_TRANS_ID"}}
+
+	mariaSQL := buildBulkMaria("CAMPAIGN_TRANS", columns, 2, true, opts)
+	expected := "INSERT INTO CAMPAIGN_TRANS (CAMP_TRANS_ID, STATUS, UPDATED_DATE) VALUES (?, ?, ?), (?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE STATUS = VALUES(STATUS), UPDATED_DATE = VALUES(UPDATED_DATE)"
+	if mariaSQL != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, mariaSQL)
+	}
+
+	oracleSQL := rebindOracle(buildBulkOracle("CAMPAIGN_TRANS", columns, 2, true, opts))
+	expected = "MERGE INTO CAMPAIGN_TRANS T USING (SELECT :1 AS CAMP_TRANS_ID, :2 AS STATUS, :3 AS UPDATED_DATE FROM DUAL " +
+		"UNION ALL SELECT :4 AS CAMP_TRANS_ID, :5 AS STATUS, :6 AS UPDATED_DATE FROM DUAL) S ON (T.CAMP_TRANS_ID = S.CAMP_TRANS_ID) " +
+		"WHEN MATCHED THEN UPDATE SET T.STATUS = S.STATUS, T.UPDATED_DATE = S.UPDATED_DATE " +
+		"WHEN NOT MATCHED THEN INSERT (CAMP_TRANS_ID, STATUS, UPDATED_DATE) VALUES (S.CAMP_TRANS_ID, S.STATUS, S.UPDATED_DATE)"
+	if oracleSQL != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, oracleSQL)
+	}
+
+	insertSQL := buildBulkOracle("CAMPAIGN_TRANS", columns[:2], 2, false, opts)
+	if !strings.HasPrefix(insertSQL, "INSERT ALL INTO CAMPAIGN_TRANS (CAMP_TRANS_ID, STATUS) VALUES (?, ?) INTO") ||
+		!strings.HasSuffix(insertSQL, " SELECT 1 FROM DUAL") {
+		t.Errorf("unexpected INSERT ALL %s", insertSQL)
+	}
+
+	oraclePool := newTestDBPool(&testConnector{})
+	oraclePool.Driver = DriverOracle
+	if _, err := BulkUpsert(context.Background(), oraclePool, "CAMPAIGN_TRANS", columns, nil); !errors.Is(err, ErrBulkKeyRequired) {
+		t.Errorf("expected ErrBulkKeyRequired but got %v", err)
+	}
+}
+
+func TestBulkLogAction(t *testing.T) {
+	dbPool := newTestDBPool(&testConnector{})
+	readLog := readTestLog(t, dbPool.Logger)
+	rows := [][]interface{}{{"T1", "Wait"}}
+
+	BulkInsert(context.Background(), dbPool, "CAMPAIGN_TRANS", []string{"CAMP_TRANS_ID", "STATUS"}, rows)
+	BulkUpsert(context.Background(), dbPool, "CAMPAIGN_TRANS", []string{"CAMP_TRANS_ID", "STATUS"}, rows)
+
+	logText := readLog()
+	for _, action := range []string{"func: BulkInsert", "func: BulkUpsert"} {
+		if strings.Count(logText, action) != 2 {
+			t.Errorf("expected the request and response of %s in the log\n%s", action, logText)
+		}
+	}
+}
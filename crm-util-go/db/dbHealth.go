@@ -0,0 +1,274 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+var errHealthTimeout = errors.New("health check timeout")
+
+// healthCheck returns the reports of one registered pool, up is false when the pool can not serve requests.
+type healthCheck func(ctx context.Context, transID string) (reports []DBPoolReport, up bool)
+
+// HealthReport checks every pool of Registry.
+func HealthReport() DBHealthReport {
+	return Registry.HealthReport(context.Background())
+}
+
+// HealthHandler serves the HealthReport of Registry as JSON.
+func HealthHandler() http.Handler {
+	return Registry.HealthHandler()
+}
+
+/*
+HealthReport pings the registered pools in parallel, a pool which does not answer before
+DefaultHealthPingTimeout or the deadline of ctx is reported as not reachable.
+A DBPoolCluster is DOWN only when no primary node is reachable.
+*/
+func (r *DBRegistry) HealthReport(ctx context.Context) DBHealthReport {
+	if _, found := ctx.Deadline(); !found {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultHealthPingTimeout)
+		defer cancel()
+	}
+
+	transID := TransIDFromContext(ctx)
+
+	var names []string
+	var checks []healthCheck
+	var drivers []DBDriver
+
+	for _, name := range r.Names() {
+		if dbPool, err := r.Get(name); err == nil {
+			names = append(names, name)
+			drivers = append(drivers, dbPool.Driver)
+			checks = append(checks, dbPool.healthCheck)
+		}
+	}
+
+	clusters, cassandraPools := r.snapshot()
+
+	for _, name := range sortedKeys(clusters) {
+		names = append(names, name)
+		drivers = append(drivers, DriverMaria)
+		checks = append(checks, clusters[name].healthCheck)
+	}
+
+	for _, name := range sortedKeys(cassandraPools) {
+		names = append(names, name)
+		drivers = append(drivers, DriverCassandra)
+		checks = append(checks, cassandraPools[name].healthCheck(name))
+	}
+
+	type checkResult struct {
+		reports []DBPoolReport
+		up      bool
+	}
+
+	resultList := make([]chan checkResult, len(checks))
+
+	for i, check := range checks {
+		resultList[i] = make(chan checkResult, 1)
+
+		go func(check healthCheck, result chan<- checkResult) {
+			reports, up := check(ctx, transID)
+			result <- checkResult{reports: reports, up: up}
+		}(check, resultList[i])
+	}
+
+	report := DBHealthReport{
+		Status:    HealthStatusUp,
+		Timestamp: time.Now(),
+		Pools:     []DBPoolReport{},
+	}
+
+	for i, result := range resultList {
+		select {
+		case res := <-result:
+			report.Pools = append(report.Pools, res.reports...)
+
+			if !res.up {
+				report.Status = HealthStatusDown
+			}
+		case <-ctx.Done():
+			report.Pools = append(report.Pools, DBPoolReport{
+				Name:      names[i],
+				Driver:    drivers[i],
+				LastError: errHealthTimeout.Error(),
+			})
+			report.Status = HealthStatusDown
+		}
+	}
+
+	return report
+}
+
+/*
+HealthHandler serves the HealthReport as JSON with status 200 when UP and 503 when DOWN.
+
+	r.GET("/monitoring/db", echo.WrapHandler(db.HealthHandler()))
+*/
+func (r *DBRegistry) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		report := r.HealthReport(req.Context())
+
+		statusCode := http.StatusOK
+		if report.Status != HealthStatusUp {
+			statusCode = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+func (dbPool DBPool) healthCheck(ctx context.Context, transID string) ([]DBPoolReport, bool) {
+	report := dbPool.poolReport(ctx, transID)
+	return []DBPoolReport{report}, report.Reachable
+}
+
+/*
+poolReport pings the pool with ctx and reads the connection stats.
+The ping does not count a fail of GetSQLDB, so a slow health check never re-creates the pool of the requests.
+*/
+func (dbPool DBPool) poolReport(ctx context.Context, transID string) DBPoolReport {
+	report := DBPoolReport{
+		Name:   dbPool.Name,
+		Driver: dbPool.Driver,
+	}
+
+	var err error
+	startDT := time.Now()
+
+	if dbPool.Driver == DriverMongo {
+		if mongoClient := dbPool.driverState().getMongoClient(); mongoClient != nil {
+			err = mongoClient.Ping(ctx, readpref.Primary())
+		} else {
+			_, err = dbPool.GetMongoDBPool(transID)
+		}
+
+		report.PingLatencyMs = durationMs(time.Since(startDT))
+
+		st := dbPool.driverState()
+		report.OpenConnections = int(atomic.LoadInt64(&st.connsOpen))
+		report.InUse = int(atomic.LoadInt64(&st.connsCheckedOut))
+		report.Idle = report.OpenConnections - report.InUse
+	} else {
+		var sqlDB *sql.DB
+		sqlDB, err = dbPool.sqlPool(transID)
+
+		if err == nil {
+			err = sqlDB.PingContext(ctx)
+		}
+
+		report.PingLatencyMs = durationMs(time.Since(startDT))
+
+		if sqlDB != nil {
+			stats := sqlDB.Stats()
+			report.OpenConnections = stats.OpenConnections
+			report.InUse = stats.InUse
+			report.Idle = stats.Idle
+			report.MaxOpenConnections = stats.MaxOpenConnections
+			report.WaitCount = stats.WaitCount
+			report.WaitDurationMs = durationMs(stats.WaitDuration)
+		}
+	}
+
+	report.Reachable = err == nil
+
+	health := dbPool.Health()
+	report.CountFail = health.CountFail
+	report.LastError = health.LastError
+	report.ReplicaLag = health.ReplicaLag
+
+	if err != nil {
+		report.LastError = err.Error()
+	}
+
+	return report
+}
+
+// healthCheck reports every node, the replica lag is the result of the background replica check of ReadPool.
+func (dbPool DBPoolCluster) healthCheck(ctx context.Context, transID string) ([]DBPoolReport, bool) {
+	primaryList, replicaList := dbPool.nodePools()
+	var reports []DBPoolReport
+	up := false
+
+	if len(replicaList) > 0 {
+		dbPool.startReplicaCheck(transID)
+	}
+
+	for _, nodePool := range primaryList {
+		report := nodePool.poolReport(ctx, transID)
+		report.Role = NodePrimary
+		reports = append(reports, report)
+
+		up = up || report.Reachable
+	}
+
+	for _, nodePool := range replicaList {
+		report := nodePool.poolReport(ctx, transID)
+		report.Role = NodeReplica
+
+		if report.Reachable {
+			health := nodePool.Health()
+			report.ReplicaLag = health.ReplicaLag
+			report.LastError = health.LastError
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports, up
+}
+
+// healthCheck pings the shared session of consistency One, gocql does not expose its connection stats.
+func (csdPool CassandraPool) healthCheck(name string) healthCheck {
+	return func(ctx context.Context, transID string) ([]DBPoolReport, bool) {
+		report := DBPoolReport{
+			Name:   name,
+			Driver: DriverCassandra,
+		}
+
+		startDT := time.Now()
+		session, err := csdPool.GetSessionOne(transID)
+
+		if err == nil {
+			err = session.Query("SELECT release_version FROM system.local").WithContext(ctx).Exec()
+		}
+
+		report.PingLatencyMs = durationMs(time.Since(startDT))
+		report.Reachable = err == nil
+
+		if err != nil {
+			report.LastError = err.Error()
+			csdPool.Logger.Error(transID, "Can not verify a connection to Cassandra DB because "+err.Error(), err)
+		}
+
+		return []DBPoolReport{report}, report.Reachable
+	}
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthReport(t *testing.T) {
+	registry := NewDBRegistry()
+	registry.pools["crm-test"] = newTestDBPool(&testConnector{})
+
+	report := registry.HealthReport(WithTransID(context.Background(), "health-test"))
+
+	if report.Status != HealthStatusUp || len(report.Pools) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	pool := report.Pools[0]
+	if pool.Name != "crm-test" || !pool.Reachable || pool.OpenConnections != 1 || pool.Idle != 1 || pool.LastError != "" {
+		t.Errorf("unexpected pool report %+v", pool)
+	}
+
+	registry.Register("crm-maria", DBPool{Driver: DriverMaria, DataSourceName: unreachableMariaDSN, Logger: newTestDBPool(&testConnector{}).Logger})
+
+	// The health check pings the pool without the fail count, so it never re-creates the pool
+	for i := 0; i <= DbMaxFailTimes; i++ {
+		report = registry.HealthReport(context.Background())
+	}
+
+	if report.Status != HealthStatusDown || len(report.Pools) != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	pool = report.Pools[0]
+	if pool.Name != "crm-maria" || pool.Reachable || pool.CountFail != 0 || pool.LastError == "" {
+		t.Errorf("unexpected pool report %+v", pool)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	registry := NewDBRegistry()
+	registry.pools["crm-test"] = newTestDBPool(&testConnector{})
+
+	recorder := httptest.NewRecorder()
+	registry.HealthHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/monitoring/db", nil))
+
+	var report DBHealthReport
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder.Code != http.StatusOK || report.Status != HealthStatusUp || len(report.Pools) != 1 {
+		t.Errorf("unexpected response %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	registry.Register("crm-maria", DBPool{Driver: DriverMaria, DataSourceName: unreachableMariaDSN, Logger: newTestDBPool(&testConnector{}).Logger})
+
+	recorder = httptest.NewRecorder()
+	registry.HealthHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/monitoring/db", nil))
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 but got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,536 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMigrationLocked   = errors.New("migration lock is held by another process")
+	ErrMigrationChecksum = errors.New("applied migration checksum mismatch")
+	ErrMigrationNoDown   = errors.New("migration has no down file")
+	ErrMigrationFile     = errors.New("invalid migration file")
+)
+
+var migrationFileRegex = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+var plsqlBlockRegex = regexp.MustCompile(`(?is)^(CREATE\s+(OR\s+REPLACE\s+)?((NON)?EDITIONABLE\s+)?(PROCEDURE|FUNCTION|PACKAGE|TRIGGER|TYPE)\b|DECLARE\b|BEGIN\b)`)
+
+type migrationHistory struct {
+	Version   int64     `db:"VERSION"`
+	Name      string    `db:"NAME"`
+	Checksum  string    `db:"CHECKSUM"`
+	AppliedAt time.Time `db:"APPLIED_AT"`
+}
+
+/*
+Migrator applies the versioned migration files of fsys to the pool, every pod can run Up at start up,
+the lock lets only one pod migrate and the others wait.
+
+	//go:embed migrations/*.sql
+	var migrationFS embed.FS
+
+	migrations, _ := fs.Sub(migrationFS, "migrations") // or os.DirFS("migrations")
+	applied, err := db.NewMigrator(dbPool, migrations, db.MigrationConfig{}).Up(ctx)
+
+MariaDB and Oracle commit every DDL, a failed migration is not recorded and must be fixed by hand before the next Up.
+*/
+type Migrator struct {
+	dbPool  DBPool
+	fsys    fs.FS
+	config  MigrationConfig
+	dialect SQLDialect
+}
+
+func NewMigrator(dbPool DBPool, fsys fs.FS, config MigrationConfig) *Migrator {
+	if config.HistoryTable == "" {
+		config.HistoryTable = DefaultMigrationHistoryTable
+	}
+
+	if config.LockName == "" {
+		config.LockName = DefaultMigrationLockName
+	}
+
+	if config.LockTimeout <= 0 {
+		config.LockTimeout = DefaultMigrationLockTimeout
+	}
+
+	return &Migrator{dbPool: dbPool, fsys: fsys, config: config, dialect: dbPool.Dialect()}
+}
+
+// LoadMigrations reads the migration files sorted by version.
+func (m *Migrator) LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(m.fsys, ".")
+
+	if err != nil {
+		return nil, err
+	}
+
+	migrationMap := make(map[int64]*Migration)
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		match := migrationFileRegex.FindStringSubmatch(entry.Name())
+
+		if match == nil {
+			return nil, fmt.Errorf("%w: %s", ErrMigrationFile, entry.Name())
+		}
+
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		data, err := fs.ReadFile(m.fsys, entry.Name())
+
+		if err != nil {
+			return nil, err
+		}
+
+		migration, found := migrationMap[version]
+
+		if !found {
+			migration = &Migration{Version: version, Name: match[2]}
+			migrationMap[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("%w: duplicate version %d", ErrMigrationFile, version)
+		}
+
+		if match[3] == "up" {
+			migration.UpSQL = string(data)
+			migration.Checksum = migrationChecksum(migration.UpSQL)
+		} else {
+			migration.DownSQL = string(data)
+		}
+	}
+
+	var migrationList []Migration
+
+	for _, migration := range migrationMap {
+		if migration.UpSQL == "" {
+			return nil, fmt.Errorf("%w: version %d has no up file", ErrMigrationFile, migration.Version)
+		}
+
+		migrationList = append(migrationList, *migration)
+	}
+
+	sort.Slice(migrationList, func(i, j int) bool {
+		return migrationList[i].Version < migrationList[j].Version
+	})
+
+	return migrationList, nil
+}
+
+// migrationChecksum ignores the line endings, so the checksum is the same on Windows and Linux checkouts.
+func migrationChecksum(script string) string {
+	sum := sha256.Sum256([]byte(strings.ReplaceAll(script, "\r\n", "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Status returns every migration file and the applied versions which have no file.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	migrationList, err := m.LoadMigrations()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var statusList []MigrationStatus
+
+	err = m.withConn(ctx, false, func(conn *sql.Conn) error {
+		historyMap, err := m.readHistory(ctx, conn)
+
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrationList {
+			status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+
+			if history, found := historyMap[migration.Version]; found {
+				status.Applied = true
+				status.AppliedAt = history.AppliedAt
+				status.ChecksumMismatch = history.Checksum != migration.Checksum
+				delete(historyMap, migration.Version)
+			}
+
+			statusList = append(statusList, status)
+		}
+
+		for _, history := range historyMap {
+			statusList = append(statusList, MigrationStatus{Version: history.Version, Name: history.Name, Applied: true, AppliedAt: history.AppliedAt})
+		}
+
+		return nil
+	})
+
+	sort.Slice(statusList, func(i, j int) bool {
+		return statusList[i].Version < statusList[j].Version
+	})
+
+	return statusList, err
+}
+
+// Up applies the pending migrations in version order, DryRun logs the statements only.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	migrationList, err := m.LoadMigrations()
+
+	if err != nil {
+		return nil, err
+	}
+
+	transID := TransIDFromContext(ctx)
+	var appliedList []Migration
+
+	err = m.withConn(ctx, !m.config.DryRun, func(conn *sql.Conn) error {
+		historyMap, err := m.readHistory(ctx, conn)
+
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrationList {
+			if history, found := historyMap[migration.Version]; found {
+				if history.Checksum != migration.Checksum {
+					return fmt.Errorf("%w: version %d %s", ErrMigrationChecksum, migration.Version, migration.Name)
+				}
+				continue
+			}
+
+			startDT := time.Now()
+
+			if err = m.execScript(ctx, conn, migration, migration.UpSQL); err != nil {
+				return err
+			}
+
+			if !m.config.DryRun {
+				_, err = conn.ExecContext(ctx, m.bind("INSERT INTO "+m.config.HistoryTable+
+					" (VERSION, NAME, CHECKSUM, APPLIED_AT, EXECUTION_MS) VALUES (?, ?, ?, ?, ?)"),
+					migration.Version, migration.Name, migration.Checksum, time.Now(), time.Since(startDT).Milliseconds())
+
+				if err != nil {
+					return fmt.Errorf("insert migration history version %d error: %w", migration.Version, err)
+				}
+			}
+
+			m.dbPool.Logger.Info(transID, fmt.Sprintf("Migrate up version %d %s success", migration.Version, migration.Name))
+			appliedList = append(appliedList, migration)
+		}
+
+		return nil
+	})
+
+	return appliedList, err
+}
+
+// Down reverts the latest applied migrations by their down files.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	migrationList, err := m.LoadMigrations()
+
+	if err != nil {
+		return nil, err
+	}
+
+	transID := TransIDFromContext(ctx)
+	var revertedList []Migration
+
+	err = m.withConn(ctx, !m.config.DryRun, func(conn *sql.Conn) error {
+		historyMap, err := m.readHistory(ctx, conn)
+
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrationList) - 1; i >= 0 && len(revertedList) < steps; i-- {
+			migration := migrationList[i]
+
+			if _, found := historyMap[migration.Version]; !found {
+				continue
+			}
+
+			if migration.DownSQL == "" {
+				return fmt.Errorf("%w: version %d %s", ErrMigrationNoDown, migration.Version, migration.Name)
+			}
+
+			if err = m.execScript(ctx, conn, migration, migration.DownSQL); err != nil {
+				return err
+			}
+
+			if !m.config.DryRun {
+				_, err = conn.ExecContext(ctx, m.bind("DELETE FROM "+m.config.HistoryTable+" WHERE VERSION = ?"), migration.Version)
+
+				if err != nil {
+					return fmt.Errorf("delete migration history version %d error: %w", migration.Version, err)
+				}
+			}
+
+			m.dbPool.Logger.Info(transID, fmt.Sprintf("Migrate down version %d %s success", migration.Version, migration.Name))
+			revertedList = append(revertedList, migration)
+		}
+
+		return nil
+	})
+
+	return revertedList, err
+}
+
+func (m *Migrator) execScript(ctx context.Context, conn *sql.Conn, migration Migration, script string) error {
+	transID := TransIDFromContext(ctx)
+
+	for i, statement := range SplitSQLStatements(script, m.dialect) {
+		if m.config.DryRun {
+			m.dbPool.Logger.Info(transID, fmt.Sprintf("DryRun version %d: %s", migration.Version, statement))
+			continue
+		}
+
+		m.dbPool.Logger.Debug(transID, statement)
+
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("migration version %d %s statement %d error: %w", migration.Version, migration.Name, i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) bind(sqlStmt string) string {
+	if m.dialect == DialectMaria {
+		return sqlStmt
+	}
+
+	return rebindOracle(sqlStmt)
+}
+
+// withConn runs fn on one connection, the lock and the history table require the same session.
+func (m *Migrator) withConn(ctx context.Context, lock bool, fn func(conn *sql.Conn) error) error {
+	transID := TransIDFromContext(ctx)
+	sqlDB, err := m.dbPool.GetSQLDB(transID)
+
+	if err != nil {
+		return err
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if lock {
+		lockHandle, err := m.lock(ctx, conn)
+
+		if err != nil {
+			return err
+		}
+		defer m.unlock(ctx, conn, lockHandle)
+
+		if err = m.createHistoryTable(ctx, conn); err != nil {
+			return err
+		}
+	}
+
+	return fn(conn)
+}
+
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn) (string, error) {
+	timeout := int64(m.config.LockTimeout.Seconds())
+
+	if m.dialect == DialectMaria {
+		var result sql.NullInt64
+		err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", m.config.LockName, timeout).Scan(&result)
+
+		if err != nil {
+			return "", err
+		} else if result.Int64 != 1 {
+			return "", ErrMigrationLocked
+		}
+
+		return m.config.LockName, nil
+	}
+
+	var result int64
+	var lockHandle string
+
+	_, err := conn.ExecContext(ctx, `DECLARE v_handle VARCHAR2(128);
+BEGIN
+  DBMS_LOCK.ALLOCATE_UNIQUE(:1, v_handle);
+  :2 := DBMS_LOCK.REQUEST(v_handle, DBMS_LOCK.X_MODE, :3, FALSE);
+  :4 := v_handle;
+END;`, m.config.LockName, sql.Out{Dest: &result}, timeout, sql.Out{Dest: &lockHandle})
+
+	if err != nil {
+		return "", err
+	}
+
+	// 0: success, 4: already own the lock
+	if result != 0 && result != 4 {
+		return "", ErrMigrationLocked
+	}
+
+	return lockHandle, nil
+}
+
+func (m *Migrator) unlock(ctx context.Context, conn *sql.Conn, lockHandle string) {
+	var err error
+
+	if m.dialect == DialectMaria {
+		var result sql.NullInt64
+		err = conn.QueryRowContext(ctx, "SELECT RELEASE_LOCK(?)", lockHandle).Scan(&result)
+	} else {
+		_, err = conn.ExecContext(ctx, "DECLARE v_result NUMBER; BEGIN v_result := DBMS_LOCK.RELEASE(:1); END;", lockHandle)
+	}
+
+	if err != nil {
+		m.dbPool.Logger.Error(TransIDFromContext(ctx), "Release migration lock error", err)
+	}
+}
+
+func (m *Migrator) historyExists(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var count int64
+	var err error
+
+	schema, table := "", strings.ToUpper(m.config.HistoryTable)
+	if index := strings.Index(table, "."); index > 0 {
+		schema, table = table[:index], table[index+1:]
+	}
+
+	if m.dialect == DialectMaria {
+		if schema == "" {
+			err = conn.QueryRowContext(ctx, "SELECT COUNT(1) FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA = DATABASE() AND UPPER(TABLE_NAME) = ?", table).Scan(&count)
+		} else {
+			err = conn.QueryRowContext(ctx, "SELECT COUNT(1) FROM INFORMATION_SCHEMA.TABLES WHERE UPPER(TABLE_SCHEMA) = ? AND UPPER(TABLE_NAME) = ?", schema, table).Scan(&count)
+		}
+	} else if schema == "" {
+		err = conn.QueryRowContext(ctx, "SELECT COUNT(1) FROM USER_TABLES WHERE TABLE_NAME = :1", table).Scan(&count)
+	} else {
+		err = conn.QueryRowContext(ctx, "SELECT COUNT(1) FROM ALL_TABLES WHERE OWNER = :1 AND TABLE_NAME = :2", schema, table).Scan(&count)
+	}
+
+	return count > 0, err
+}
+
+func (m *Migrator) createHistoryTable(ctx context.Context, conn *sql.Conn) error {
+	exists, err := m.historyExists(ctx, conn)
+
+	if err != nil || exists {
+		return err
+	}
+
+	createSQL := "CREATE TABLE " + m.config.HistoryTable + " (VERSION BIGINT NOT NULL PRIMARY KEY, NAME VARCHAR(255) NOT NULL, " +
+		"CHECKSUM VARCHAR(64) NOT NULL, APPLIED_AT DATETIME NOT NULL, EXECUTION_MS BIGINT NOT NULL)"
+
+	if m.dialect != DialectMaria {
+		createSQL = "CREATE TABLE " + m.config.HistoryTable + " (VERSION NUMBER(19) NOT NULL PRIMARY KEY, NAME VARCHAR2(255) NOT NULL, " +
+			"CHECKSUM VARCHAR2(64) NOT NULL, APPLIED_AT TIMESTAMP NOT NULL, EXECUTION_MS NUMBER(19) NOT NULL)"
+	}
+
+	_, err = conn.ExecContext(ctx, createSQL)
+	return err
+}
+
+func (m *Migrator) readHistory(ctx context.Context, conn *sql.Conn) (map[int64]migrationHistory, error) {
+	historyMap := make(map[int64]migrationHistory)
+
+	if exists, err := m.historyExists(ctx, conn); err != nil || !exists {
+		return historyMap, err
+	}
+
+	historyList, err := queryStructs[migrationHistory](ctx, conn, 0,
+		"SELECT VERSION, NAME, CHECKSUM, APPLIED_AT FROM "+m.config.HistoryTable+" ORDER BY VERSION")
+
+	for _, history := range historyList {
+		historyMap[history.Version] = history
+	}
+
+	return historyMap, err
+}
+
+/*
+SplitSQLStatements splits a script by ; outside string literals and comments, the ; is removed.
+For Oracle, a PL/SQL block (CREATE PROCEDURE, FUNCTION, PACKAGE, TRIGGER, TYPE, DECLARE or BEGIN)
+ends with a line of a single /, and keeps its own semicolons.
+*/
+func SplitSQLStatements(script string, dialect SQLDialect) []string {
+	script = strings.ReplaceAll(script, "\r\n", "\n")
+	oracle := dialect == DialectOracle || dialect == DialectOracle12c
+
+	var statements []string
+	var current strings.Builder
+	hasContent, plsql := false, false
+
+	flush := func() {
+		if statement := strings.TrimSpace(current.String()); hasContent && statement != "" {
+			statements = append(statements, statement)
+		}
+
+		current.Reset()
+		hasContent, plsql = false, false
+	}
+
+	for i := 0; i < len(script); i++ {
+		ch := script[i]
+
+		// A line of a single / ends a PL/SQL block, or follows a statement in SQL*Plus style
+		if oracle && (i == 0 || script[i-1] == '\n') {
+			lineEnd := strings.IndexByte(script[i:], '\n')
+			if lineEnd < 0 {
+				lineEnd = len(script) - i
+			}
+
+			if strings.TrimSpace(script[i:i+lineEnd]) == "/" {
+				flush()
+				i += lineEnd
+				continue
+			}
+		}
+
+		switch {
+		case ch == '-' && strings.HasPrefix(script[i:], "--"):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			current.WriteString(script[i : i+end])
+			i += end - 1
+		case ch == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				end = len(script) - i - 2
+			} else {
+				end += 2
+			}
+			current.WriteString(script[i : i+2+end])
+			i += 1 + end
+		case ch == '\'' || ch == '"' || ch == '`':
+			end := strings.IndexByte(script[i+1:], ch)
+			if end < 0 {
+				end = len(script) - i - 2
+			}
+			current.WriteString(script[i : i+2+end])
+			hasContent = true
+			i += 1 + end
+		case ch == ';' && !plsql:
+			flush()
+		default:
+			if !hasContent && ch != ' ' && ch != '\t' && ch != '\n' {
+				hasContent = true
+				plsql = oracle && plsqlBlockRegex.MatchString(script[i:])
+			}
+			current.WriteByte(ch)
+		}
+	}
+
+	flush()
+
+	return statements
+}
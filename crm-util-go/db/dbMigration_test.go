@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestSplitSQLStatements(t *testing.T) {
+	script := `-- create campaign
+CREATE TABLE CAMPAIGN (ID NUMBER, NAME VARCHAR2(100) DEFAULT 'a;b');
+/* comment; */
+INSERT INTO CAMPAIGN VALUES (1, 'it''s; ok');
+
+CREATE OR REPLACE PROCEDURE CLOSE_CAMPAIGN(P_ID NUMBER) AS
+BEGIN
+  UPDATE CAMPAIGN SET NAME = 'closed' WHERE ID = P_ID;
+  COMMIT;
+END;
+/
+BEGIN
+  CLOSE_CAMPAIGN(1);
+END;
+/
+`
+
+	statements := SplitSQLStatements(script, DialectOracle)
+	expected := []string{
+		"-- create campaign\nCREATE TABLE CAMPAIGN (ID NUMBER, NAME VARCHAR2(100) DEFAULT 'a;b')",
+		"/* comment; */\nINSERT INTO CAMPAIGN VALUES (1, 'it''s; ok')",
+		"CREATE OR REPLACE PROCEDURE CLOSE_CAMPAIGN(P_ID NUMBER) AS\nBEGIN\n  UPDATE CAMPAIGN SET NAME = 'closed' WHERE ID = P_ID;\n  COMMIT;\nEND;",
+		"BEGIN\n  CLOSE_CAMPAIGN(1);\nEND;",
+	}
+
+	if !reflect.DeepEqual(statements, expected) {
+		t.Errorf("expected %q but got %q", expected, statements)
+	}
+
+	statements = SplitSQLStatements("CREATE TABLE A (ID INT);\r\nBEGIN;\r\nINSERT INTO A VALUES (1);\r\n-- end", DialectMaria)
+	if !reflect.DeepEqual(statements, []string{"CREATE TABLE A (ID INT)", "BEGIN", "INSERT INTO A VALUES (1)"}) {
+		t.Errorf("unexpected MariaDB statements %q", statements)
+	}
+}
+
+func newTestMigrationFS() fstest.MapFS {
+	return fstest.MapFS{
+		"0001_create_campaign.up.sql":   {Data: []byte("CREATE TABLE CAMPAIGN (ID INT);\nCREATE INDEX CAMPAIGN_IDX ON CAMPAIGN (ID);")},
+		"0001_create_campaign.down.sql": {Data: []byte("DROP TABLE CAMPAIGN;")},
+		"0002_add_status.up.sql":        {Data: []byte("ALTER TABLE CAMPAIGN ADD STATUS VARCHAR(20);")},
+		"README.md":                     {Data: []byte("migration files")},
+	}
+}
+
+func TestMigratorUp(t *testing.T) {
+	connector := &testConnector{results: map[string]testResult{
+		"SELECT GET_LOCK":     {columns: []string{"RESULT"}, rows: [][]driver.Value{{int64(1)}}},
+		"SELECT RELEASE_LOCK": {columns: []string{"RESULT"}, rows: [][]driver.Value{{int64(1)}}},
+		"SELECT COUNT(1)":     {columns: []string{"COUNT"}, rows: [][]driver.Value{{int64(0)}}},
+	}}
+	dbPool := newTestDBPool(connector)
+
+	applied, err := NewMigrator(dbPool, newTestMigrationFS(), MigrationConfig{}).Up(context.Background())
+
+	if err != nil || len(applied) != 2 {
+		t.Fatalf("Up expected 2 migrations but got %d, %v", len(applied), err)
+	}
+
+	execList := connector.getExecList()
+	expected := []string{"CREATE TABLE SCHEMA_MIGRATION_HISTORY", "CREATE TABLE CAMPAIGN (ID INT)", "CREATE INDEX CAMPAIGN_IDX ON CAMPAIGN (ID)",
+		"INSERT INTO SCHEMA_MIGRATION_HISTORY", "ALTER TABLE CAMPAIGN ADD STATUS VARCHAR(20)", "INSERT INTO SCHEMA_MIGRATION_HISTORY"}
+
+	if len(execList) != len(expected) {
+		t.Fatalf("expected %q but got %q", expected, execList)
+	}
+
+	for i := range expected {
+		if !strings.HasPrefix(execList[i], expected[i]) {
+			t.Errorf("statement %d expected %s but got %s", i, expected[i], execList[i])
+		}
+	}
+}
+
+func TestMigratorStatusAndChecksum(t *testing.T) {
+	appliedAt := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	migrationFS := newTestMigrationFS()
+
+	connector := &testConnector{results: map[string]testResult{
+		"SELECT GET_LOCK":     {columns: []string{"RESULT"}, rows: [][]driver.Value{{int64(1)}}},
+		"SELECT RELEASE_LOCK": {columns: []string{"RESULT"}, rows: [][]driver.Value{{int64(1)}}},
+		"SELECT COUNT(1)":     {columns: []string{"COUNT"}, rows: [][]driver.Value{{int64(1)}}},
+		"SELECT VERSION": {
+			columns: []string{"VERSION", "NAME", "CHECKSUM", "APPLIED_AT"},
+			rows: [][]driver.Value{
+				{int64(1), "create_campaign", migrationChecksum("CREATE TABLE CAMPAIGN (ID INT);\r\nCREATE INDEX CAMPAIGN_IDX ON CAMPAIGN (ID);"), appliedAt},
+			},
+		},
+	}}
+	dbPool := newTestDBPool(connector)
+	migrator := NewMigrator(dbPool, migrationFS, MigrationConfig{})
+
+	statusList, err := migrator.Status(context.Background())
+	if err != nil || len(statusList) != 2 {
+		t.Fatalf("Status expected 2 but got %+v, %v", statusList, err)
+	}
+
+	if !statusList[0].Applied || statusList[0].ChecksumMismatch || !statusList[0].AppliedAt.Equal(appliedAt) || statusList[1].Applied {
+		t.Errorf("unexpected status %+v", statusList)
+	}
+
+	dryRunList, err := NewMigrator(dbPool, migrationFS, MigrationConfig{DryRun: true}).Up(context.Background())
+	if err != nil || len(dryRunList) != 1 || dryRunList[0].Version != 2 || len(connector.getExecList()) != 0 {
+		t.Errorf("dry run expected version 2 without exec but got %+v, %q, %v", dryRunList, connector.getExecList(), err)
+	}
+
+	migrationFS["0001_create_campaign.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE CAMPAIGN (ID BIGINT);")}
+
+	if _, err = migrator.Up(context.Background()); !errors.Is(err, ErrMigrationChecksum) {
+		t.Errorf("expected ErrMigrationChecksum but got %v", err)
+	}
+}
@@ -1,10 +1,15 @@
 package db
 
 import (
+	"crm-util-go/errorcode"
 	"crm-util-go/logging"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"github.com/gocql/gocql"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"reflect"
 	"strings"
 	"time"
@@ -15,33 +20,382 @@ const (
 	DbMaxFailTimes int    = 20
 )
 
+const (
+	DefaultMigrationHistoryTable = "SCHEMA_MIGRATION_HISTORY"
+	DefaultMigrationLockName     = "crm_schema_migration"
+	DefaultMigrationLockTimeout  = 60 * time.Second
+)
+
+const (
+	DefaultBulkChunkSize      = 500
+	DefaultBulkMaxPacketBytes = 4 * 1024 * 1024
+	maxBindParameters         = 65535
+)
+
+const (
+	DefaultMaxReplicaLag        = 10 * time.Second
+	DefaultReplicaCheckInterval = 5 * time.Second
+)
+
+const (
+	DefaultTxMaxRetry     = 3
+	DefaultTxRetryBackoff = 100 * time.Millisecond
+)
+
 type DbAuthen struct {
 	Username string
 	Password string
 }
 
+/*
+	LocalDC routes the queries to the hosts of the local data center, TokenAware routes a query to a replica of its partition.
+	QueryTimeout is the timeout of each request, ConnectTimeout is used when it is zero.
+	CallTimeout is the timeout of one SelectCQL, ExecCQL or batch call with its retries and speculative executions,
+	the deadline of ctx is the timeout of the call when ctx has one.
+	The pools of the same hosts, keyspace, user, LocalDC, timeouts and policies share the sessions.
+*/
 type CassandraPool struct {
-	Hosts              []string
-	Port               int
-	ConnectTimeout     time.Duration
-	Keyspace           string
-	ConsistencyLevel   gocql.Consistency
-	DbAuthen           DbAuthen
-	CQLVersion         string
-	NativeProtoVersion int
-	Logger             *logging.PatternLogger
+	Hosts                []string
+	Port                 int
+	ConnectTimeout       time.Duration
+	Keyspace             string
+	ConsistencyLevel     gocql.Consistency
+	DbAuthen             DbAuthen
+	CQLVersion           string
+	NativeProtoVersion   int
+	LocalDC              string
+	TokenAware           bool
+	QueryTimeout         time.Duration
+	CallTimeout          time.Duration
+	RetryPolicy          CassandraRetryPolicy
+	SpeculativeExecution CassandraSpeculativeExecution
+	Logger               *logging.PatternLogger
+}
+
+/*
+	CassandraRetryPolicy retries a failed query up to NumRetries times,
+	waiting MinBackoff doubled on each retry up to MaxBackoff.
+	DowngradeConsistency is the consistency of each retry, e.g. []gocql.Consistency{gocql.LocalOne}
+	retries a LocalQuorum query once with LocalOne when the replicas are not available.
+*/
+type CassandraRetryPolicy struct {
+	NumRetries           int
+	MinBackoff           time.Duration
+	MaxBackoff           time.Duration
+	DowngradeConsistency []gocql.Consistency
 }
 
+// CassandraSpeculativeExecution sends an idempotent read to another host every Delay until one answers, at most Attempts more times.
+type CassandraSpeculativeExecution struct {
+	Attempts int
+	Delay    time.Duration
+}
+
+// DBDriver of DBPool, the empty Driver is DriverMaria like the pools before the registry
+type DBDriver string
+
+const (
+	DriverOracle = DBDriver("oci8")
+	DriverMaria  = DBDriver("mysql")
+	DriverMongo  = DBDriver("mongo")
+	// DriverCassandra is used by HealthReport only, CassandraPool is registered by Registry.RegisterCassandra
+	DriverCassandra = DBDriver("cassandra")
+)
+
 /*
 	MaxLifetime := 5 * time.Minute
+	Name and Driver are set for a pool of Registry, the pool from Registry.Register owns its connection pool.
+	SlowQueryThreshold > 0 times every Query, Exec and Prepare into QueryStats and logs the slower statements.
 */
 type DBPool struct {
-	DataSourceName string
-	MaxOpenConns   int
-	MaxIdleConns   int
-	MaxLifetime    time.Duration
-	Logger         *logging.PatternLogger
-	AppName        string
+	Name               string
+	Driver             DBDriver
+	DataSourceName     string
+	MaxOpenConns       int
+	MaxIdleConns       int
+	MaxLifetime        time.Duration
+	SlowQueryThreshold time.Duration
+	Logger             *logging.PatternLogger
+	AppName            string
+	state              *dbPoolState
+}
+
+// SQLDialect of SelectBuilder, DialectOracle pages by ROWNUM and DialectOracle12c by OFFSET/FETCH
+type SQLDialect string
+
+const (
+	DialectOracle    = SQLDialect("oracle")
+	DialectOracle12c = SQLDialect("oracle12c")
+	DialectMaria     = SQLDialect("maria")
+)
+
+/*
+TxOptions of WithTx, MaxRetry 0 is DefaultTxMaxRetry and -1 disables the retry on deadlock and serialization errors.
+ErrCode generates the CrmErrorCodeResp when the transaction fails.
+*/
+type TxOptions struct {
+	Isolation    sql.IsolationLevel
+	ReadOnly     bool
+	MaxRetry     int
+	RetryBackoff time.Duration
+	ErrCode      errorcode.CrmErrorCode
+}
+
+/*
+MigrationConfig of Migrator, the migration files are "<version>_<name>.up.sql" and "<version>_<name>.down.sql".
+The Oracle lock uses DBMS_LOCK, the user requires GRANT EXECUTE ON DBMS_LOCK.
+*/
+type MigrationConfig struct {
+	HistoryTable string
+	LockName     string
+	LockTimeout  time.Duration
+	DryRun       bool
+}
+
+type Migration struct {
+	Version  int64
+	Name     string
+	UpSQL    string
+	DownSQL  string
+	Checksum string
+}
+
+type MigrationStatus struct {
+	Version          int64     `json:"version"`
+	Name             string    `json:"name"`
+	Applied          bool      `json:"applied"`
+	AppliedAt        time.Time `json:"appliedAt,omitempty"`
+	ChecksumMismatch bool      `json:"checksumMismatch,omitempty"`
+}
+
+/*
+BulkOptions of BulkInsert and BulkUpsert, a chunk is one INSERT or MERGE statement.
+KeyColumns is required by the Oracle MERGE, UpdateColumns defaults to the columns which are not KeyColumns.
+*/
+type BulkOptions struct {
+	ChunkSize       int
+	MaxPacketBytes  int
+	KeyColumns      []string
+	UpdateColumns   []string
+	ContinueOnError bool
+}
+
+type BulkChunkError struct {
+	Chunk    int
+	StartRow int
+	EndRow   int
+	Err      error
+}
+
+type BulkResult struct {
+	RowsAffected int64
+	Chunks       int
+	ChunkErrors  []BulkChunkError
+}
+
+type DBPoolHealth struct {
+	Name      string    `json:"name"`
+	Driver    DBDriver  `json:"driver"`
+	Connected bool      `json:"connected"`
+	Healthy   bool      `json:"healthy"`
+	CountFail int       `json:"countFail"`
+	LastCheck time.Time `json:"lastCheck"`
+	LastError string    `json:"lastError,omitempty"`
+	// ReplicaLag of the last replica check of a DBPoolCluster replica
+	ReplicaLag string `json:"replicaLag,omitempty"`
+}
+
+const (
+	DefaultMongoTimeout      = 3 * time.Second
+	DefaultMongoVersionField = "version"
+	DefaultMongoCreatedField = "createdDate"
+	DefaultMongoUpdatedField = "updatedDate"
+)
+
+/*
+	The fields are the bson names in the document, an empty field uses the default name.
+	Timeout applies to an operation without deadline in its context.
+*/
+type MongoRepositoryConfig struct {
+	Timeout           time.Duration
+	VersionField      string
+	CreatedField      string
+	UpdatedField      string
+	DisableVersion    bool
+	DisableTimestamps bool
+}
+
+const (
+	DefaultMongoWatchRetryBackoff    = time.Second
+	DefaultMongoWatchMaxRetryBackoff = time.Minute
+	DefaultMongoWatchMaxAwaitTime    = 5 * time.Second
+)
+
+/*
+	An empty Collection watches every collection of Database, Name is the key of the resume token
+	and defaults to Database.Collection.
+	Pipeline filters the events, e.g. mongo.Pipeline{{{"$match", bson.D{{"operationType", "update"}}}}}.
+	FullDocument options.UpdateLookup returns the current document with an update event.
+	ResumeTokenStore keeps the token of the last handled event, a restarted watcher continues after it.
+*/
+type MongoWatcherConfig struct {
+	Name             string
+	Database         string
+	Collection       string
+	Pipeline         interface{}
+	FullDocument     options.FullDocument
+	BatchSize        int32
+	MaxAwaitTime     time.Duration
+	ResumeTokenStore ResumeTokenStore
+	RetryBackoff     time.Duration
+	MaxRetryBackoff  time.Duration
+}
+
+type MongoNamespace struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"coll"`
+}
+
+type MongoUpdateDescription struct {
+	UpdatedFields bson.M   `bson:"updatedFields"`
+	RemovedFields []string `bson:"removedFields"`
+}
+
+// MongoChangeEvent is a change stream event, ID is the resume token of the event.
+type MongoChangeEvent struct {
+	ID                bson.Raw                `bson:"_id"`
+	OperationType     string                  `bson:"operationType"`
+	ClusterTime       primitive.Timestamp     `bson:"clusterTime"`
+	Namespace         MongoNamespace          `bson:"ns"`
+	DocumentKey       bson.M                  `bson:"documentKey"`
+	FullDocument      bson.Raw                `bson:"fullDocument"`
+	UpdateDescription *MongoUpdateDescription `bson:"updateDescription"`
+}
+
+const (
+	DefaultConfigRefreshInterval = 5 * time.Minute
+	ConfigTable                  = "config"
+	MappingTable                 = "mapping"
+	ConfigAdded                  = "ADDED"
+	ConfigUpdated                = "UPDATED"
+	ConfigRemoved                = "REMOVED"
+)
+
+/*
+	Empty ConfigKeys or MappingTypes loads the whole table.
+	SetViper also sets the config values of the first load to viper like CassandraPool.LoadConfig,
+	the refreshed values are read with GetConfigVal or OnChange because viper is not safe for concurrent use.
+*/
+type ConfigStoreConfig struct {
+	RefreshInterval time.Duration
+	ConfigKeys      []string
+	MappingTypes    []string
+	SetViper        bool
+}
+
+// ConfigChange of the config table has an empty MappingType, Key of the mapping table is the from_value.
+type ConfigChange struct {
+	Table       string `json:"table"`
+	ChangeType  string `json:"changeType"`
+	MappingType string `json:"mappingType,omitempty"`
+	Key         string `json:"key"`
+	OldValue    string `json:"oldValue,omitempty"`
+	NewValue    string `json:"newValue,omitempty"`
+}
+
+/*
+	CQLWriteOptions is used by InsertCQL and InsertStatement.
+	TTL is rounded down to seconds, zero TTL or Timestamp uses the default of the table and the coordinator.
+	IfNotExists makes the insert a lightweight transaction, see LWTResult.
+*/
+type CQLWriteOptions struct {
+	TTL         time.Duration
+	Timestamp   time.Time
+	IfNotExists bool
+}
+
+// LWTResult of a lightweight transaction, Existing is the current row when the statement is not applied.
+type LWTResult struct {
+	Applied  bool
+	Existing map[string]interface{}
+}
+
+type CQLStatement struct {
+	Stmt string
+	Args []interface{}
+}
+
+// CQLPage is a page of SelectPageCQL, PageState is empty on the last page.
+type CQLPage[T any] struct {
+	Rows      []T
+	PageState []byte
+}
+
+const (
+	SQLOpQuery           = "Query"
+	SQLOpExec            = "Exec"
+	SQLOpPrepare         = "Prepare"
+	DefaultMaxQueryStats = 1000
+	// QueryStatOther collects the statements after DefaultMaxQueryStats different statements
+	QueryStatOther = "<other>"
+)
+
+/*
+	SQL is normalized, literals and binds are replaced by ? and lists of binds are collapsed.
+	RowsAffected is the sum of Exec results.
+*/
+type QueryStat struct {
+	Operation     string        `json:"operation"`
+	SQL           string        `json:"sql"`
+	Count         int64         `json:"count"`
+	ErrorCount    int64         `json:"errorCount"`
+	SlowCount     int64         `json:"slowCount"`
+	RowsAffected  int64         `json:"rowsAffected"`
+	TotalDuration time.Duration `json:"totalDuration"`
+	MinDuration   time.Duration `json:"minDuration"`
+	MaxDuration   time.Duration `json:"maxDuration"`
+}
+
+func (stat QueryStat) AvgDuration() time.Duration {
+	if stat.Count == 0 {
+		return 0
+	}
+
+	return stat.TotalDuration / time.Duration(stat.Count)
+}
+
+const (
+	HealthStatusUp           = "UP"
+	HealthStatusDown         = "DOWN"
+	DefaultHealthPingTimeout = 5 * time.Second
+)
+
+/*
+	Status is DOWN when any pool is not reachable.
+	Connections of Mongo come from the pool monitor, WaitCount and MaxOpenConnections are of SQL pools only.
+*/
+type DBHealthReport struct {
+	Status    string         `json:"status"`
+	Timestamp time.Time      `json:"timestamp"`
+	Pools     []DBPoolReport `json:"pools"`
+}
+
+type DBPoolReport struct {
+	Name               string   `json:"name"`
+	Driver             DBDriver `json:"driver"`
+	Reachable          bool     `json:"reachable"`
+	PingLatencyMs      float64  `json:"pingLatencyMs"`
+	OpenConnections    int      `json:"openConnections"`
+	InUse              int      `json:"inUse"`
+	Idle               int      `json:"idle"`
+	MaxOpenConnections int      `json:"maxOpenConnections,omitempty"`
+	WaitCount          int64    `json:"waitCount"`
+	WaitDurationMs     float64  `json:"waitDurationMs"`
+	CountFail          int      `json:"countFail"`
+	LastError          string   `json:"lastError,omitempty"`
+	// Role and ReplicaLag of a DBPoolCluster node
+	Role       DBNodeRole `json:"role,omitempty"`
+	ReplicaLag string     `json:"replicaLag,omitempty"`
 }
 
 /*
@@ -53,15 +407,33 @@ type DBPool struct {
 type DBNode struct {
 	NodeName       string
 	DataSourceName string
+	Role           DBNodeRole
 }
 
+type DBNodeRole string
+
+const (
+	NodePrimary = DBNodeRole("primary")
+	NodeReplica = DBNodeRole("replica")
+)
+
+/*
+	GetMariaDBPoolCluster fails over between all nodes.
+	PrimaryPool and ReadPool route by Role, a node without Role is primary.
+	ReadPool uses the replica lag checked in the background every ReplicaCheckInterval.
+	MaxReplicaLag := 10 * time.Second, ReplicaCheckInterval := 5 * time.Second
+*/
 type DBPoolCluster struct {
-	DBNode       []DBNode
-	RegisterName string
-	MaxOpenConns int
-	MaxIdleConns int
-	MaxLifetime  time.Duration
-	Logger       *logging.PatternLogger
+	DBNode               []DBNode
+	RegisterName         string
+	MaxOpenConns         int
+	MaxIdleConns         int
+	MaxLifetime          time.Duration
+	MaxReplicaLag        time.Duration
+	ReplicaCheckInterval time.Duration
+	SlowQueryThreshold   time.Duration
+	Logger               *logging.PatternLogger
+	state                *dbPoolState
 }
 
 type CrmDateTime time.Time
@@ -113,6 +485,26 @@ func (ct *CrmDateTime) Time() time.Time {
 	return time.Time(*ct)
 }
 
+// Scan reads DATE, DATETIME and TIMESTAMP columns, NULL is the zero time.
+func (ct *CrmDateTime) Scan(value interface{}) error {
+	var t sql.NullTime
+	if err := t.Scan(value); err != nil {
+		return err
+	}
+
+	*ct = CrmDateTime(t.Time)
+	return nil
+}
+
+func (ct CrmDateTime) Value() (driver.Value, error) {
+	t := time.Time(ct)
+
+	if t.IsZero() {
+		return nil, nil
+	}
+	return t, nil
+}
+
 type Int64 sql.NullInt64
 
 func (ni *Int64) Scan(value interface{}) error {
@@ -129,6 +521,10 @@ func (ni *Int64) Scan(value interface{}) error {
 	return nil
 }
 
+func (ni Int64) Value() (driver.Value, error) {
+	return sql.NullInt64(ni).Value()
+}
+
 func (ni *Int64) MarshalJSON() ([]byte, error) {
 	if !ni.Valid {
 		return []byte("null"), nil
@@ -158,6 +554,10 @@ func (nf *Float64) Scan(value interface{}) error {
 	return nil
 }
 
+func (nf Float64) Value() (driver.Value, error) {
+	return sql.NullFloat64(nf).Value()
+}
+
 func (nf *Float64) MarshalJSON() ([]byte, error) {
 	if !nf.Valid {
 		return []byte("null"), nil
@@ -188,6 +588,10 @@ func (ns *String) Scan(value interface{}) error {
 	return nil
 }
 
+func (ns String) Value() (driver.Value, error) {
+	return sql.NullString(ns).Value()
+}
+
 func (ns *String) MarshalJSON() ([]byte, error) {
 	if !ns.Valid {
 		return []byte("null"), nil
@@ -0,0 +1,253 @@
+package db
+
+import (
+	"context"
+	"crm-util-go/common"
+	"crm-util-go/errorcode"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrNotStruct = errors.New("type parameter must be a struct")
+
+type transIDKey struct{}
+
+// WithTransID puts the transID into ctx, the query helpers use it for logging.
+func WithTransID(ctx context.Context, transID string) context.Context {
+	return context.WithValue(ctx, transIDKey{}, transID)
+}
+
+// TransIDFromContext returns the transID of WithTx, WithTransID or a new UUID.
+func TransIDFromContext(ctx context.Context) string {
+	if tx, ok := ctx.Value(txKey{}).(*Tx); ok {
+		return tx.transID
+	}
+
+	if transID, ok := ctx.Value(transIDKey{}).(string); ok && transID != "" {
+		return transID
+	}
+
+	return common.NewUUID()
+}
+
+// sqlExecutor is implemented by *sql.DB, *sql.Tx and *sql.Conn
+type sqlExecutor interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// executor returns the Tx of WithTx in ctx, or the *sql.DB of the pool.
+func (dbPool DBPool) executor(ctx context.Context, transID string) (sqlExecutor, error) {
+	if tx := dbPool.txFromContext(ctx); tx != nil {
+		return tx, nil
+	}
+
+	return dbPool.GetSQLDB(transID)
+}
+
+// structFieldsCache keeps the lower case column name to field index map of each struct type and tag name
+var structFieldsCache sync.Map
+
+type structFieldsKey struct {
+	structType reflect.Type
+	tagName    string
+}
+
+func getStructFields(structType reflect.Type, tagName string) map[string][]int {
+	key := structFieldsKey{structType: structType, tagName: tagName}
+
+	if fields, found := structFieldsCache.Load(key); found {
+		return fields.(map[string][]int)
+	}
+
+	fields := make(map[string][]int)
+	addStructFields(structType, tagName, nil, fields)
+	structFieldsCache.Store(key, fields)
+
+	return fields
+}
+
+func addStructFields(structType reflect.Type, tagName string, parentIndex []int, fields map[string][]int) {
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		tag := field.Tag.Get(tagName)
+
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+
+		index := append(append([]int{}, parentIndex...), i)
+
+		if tag == "" {
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				addStructFields(field.Type, tagName, index, fields)
+			}
+			continue
+		}
+
+		column := strings.ToLower(strings.Split(tag, ",")[0])
+
+		if _, found := fields[column]; !found {
+			fields[column] = index
+		}
+	}
+}
+
+// scanStruct scans the current row into dest, the columns without db tag are discarded.
+func scanStruct(rows *sql.Rows, columns []string, dest reflect.Value) error {
+	fields := getStructFields(dest.Type(), "db")
+	scanList := make([]interface{}, len(columns))
+
+	for i, column := range columns {
+		if index, found := fields[strings.ToLower(column)]; found {
+			scanList[i] = dest.FieldByIndex(index).Addr().Interface()
+		} else {
+			scanList[i] = new(interface{})
+		}
+	}
+
+	return rows.Scan(scanList...)
+}
+
+func queryStructs[T any](ctx context.Context, executor sqlExecutor, limit int, sqlStmt string, args ...interface{}) ([]T, error) {
+	var resultList []T
+
+	if reflect.TypeOf(resultList).Elem().Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+
+	rows, err := executor.QueryContext(ctx, sqlStmt, args...)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var result T
+
+		if err = scanStruct(rows, columns, reflect.ValueOf(&result).Elem()); err != nil {
+			return nil, fmt.Errorf("can not read row from database: %w", err)
+		}
+
+		resultList = append(resultList, result)
+
+		if limit > 0 && len(resultList) >= limit {
+			break
+		}
+	}
+
+	return resultList, rows.Err()
+}
+
+// responseCode logs the error and returns the response code, the caller logs the response by LogResponseDBClient
+// because the func of the log is taken from the caller.
+func (dbPool DBPool) responseCode(transID string, err error) string {
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, mongo.ErrNoDocuments) {
+		return "201000"
+	} else if err != nil {
+		dbPool.Logger.Error(transID, "Query DB Error: "+err.Error(), err)
+		return "802014"
+	}
+
+	return "0"
+}
+
+// dbAction is the action of the monitor log for the func of a helper, which is not the caller of LogRequestDBClient.
+func dbAction(funcName string) string {
+	return "package: crm-util-go/db, func: " + funcName
+}
+
+func wrapDbError(dbPool DBPool, err error) error {
+	if err == nil || errors.Is(err, sql.ErrNoRows) || errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	return errorcode.DbError{DbName: dbPool.Name, Err: err}
+}
+
+/*
+QueryStructs maps every row to T by the db tag of the fields, the column name is case-insensitive.
+The field can be a value or a pointer of string, number, time.Time, String, Int64, Float64 or CrmDateTime.
+
+	type CampaignTrans struct {
+		CampTransID string      `db:"CAMP_TRANS_ID"`
+		SubStatus   String      `db:"SUB_STATUS"`
+		CreatedDate CrmDateTime `db:"CREATED_DATE"`
+	}
+
+	ctx := db.WithTransID(context.Background(), transID)
+	// In WithTx, ctx is tx.Context() to query in the transaction
+	campTransList, err := db.QueryStructs[CampaignTrans](ctx, dbPool, "SELECT * FROM CAMPAIGN_TRANS WHERE STATUS = ?", "Accept")
+*/
+func QueryStructs[T any](ctx context.Context, dbPool DBPool, sqlStmt string, args ...interface{}) ([]T, error) {
+	transID := TransIDFromContext(ctx)
+	startDT := dbPool.Logger.LogRequestDBClient(transID)
+	dbPool.Logger.Debug(transID, sqlStmt)
+
+	executor, err := dbPool.executor(ctx, transID)
+
+	var resultList []T
+	if err == nil {
+		resultList, err = queryStructs[T](ctx, executor, 0, sqlStmt, args...)
+	}
+
+	dbPool.Logger.LogResponseDBClient(transID, dbPool.responseCode(transID, err), startDT)
+
+	return resultList, wrapDbError(dbPool, err)
+}
+
+// QueryOne returns the first row, or sql.ErrNoRows when the query has no row.
+func QueryOne[T any](ctx context.Context, dbPool DBPool, sqlStmt string, args ...interface{}) (T, error) {
+	var result T
+
+	transID := TransIDFromContext(ctx)
+	startDT := dbPool.Logger.LogRequestDBClient(transID)
+	dbPool.Logger.Debug(transID, sqlStmt)
+
+	executor, err := dbPool.executor(ctx, transID)
+
+	if err == nil {
+		var resultList []T
+		resultList, err = queryStructs[T](ctx, executor, 1, sqlStmt, args...)
+
+		if err == nil && len(resultList) == 0 {
+			err = sql.ErrNoRows
+		} else if err == nil {
+			result = resultList[0]
+		}
+	}
+
+	dbPool.Logger.LogResponseDBClient(transID, dbPool.responseCode(transID, err), startDT)
+
+	return result, wrapDbError(dbPool, err)
+}
+
+func Exec(ctx context.Context, dbPool DBPool, sqlStmt string, args ...interface{}) (sql.Result, error) {
+	transID := TransIDFromContext(ctx)
+	startDT := dbPool.Logger.LogRequestDBClient(transID)
+	dbPool.Logger.Debug(transID, sqlStmt)
+
+	executor, err := dbPool.executor(ctx, transID)
+
+	var result sql.Result
+	if err == nil {
+		result, err = executor.ExecContext(ctx, sqlStmt, args...)
+	}
+
+	dbPool.Logger.LogResponseDBClient(transID, dbPool.responseCode(transID, err), startDT)
+
+	return result, wrapDbError(dbPool, err)
+}
@@ -0,0 +1,283 @@
+package db
+
+import (
+	"context"
+	"crm-util-go/errorcode"
+	"crm-util-go/logging"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testConnector is an in-memory driver, a query returns the testResult of the longest matched SQL prefix.
+type testConnector struct {
+	mu       sync.Mutex
+	results  map[string]testResult
+	execList []string
+}
+
+type testResult struct {
+	columns []string
+	rows    [][]driver.Value
+	err     error
+}
+
+type testConn struct {
+	connector *testConnector
+}
+
+type testStmt struct {
+	connector *testConnector
+	query     string
+}
+
+type testRows struct {
+	result testResult
+	index  int
+}
+
+type testTx struct {
+	connector *testConnector
+}
+
+func (c *testConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return testConn{connector: c}, nil
+}
+
+func (c *testConnector) Driver() driver.Driver {
+	return nil
+}
+
+func (c *testConnector) find(query string) testResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var found testResult
+	foundPrefix := ""
+
+	for prefix, result := range c.results {
+		if strings.HasPrefix(query, prefix) && len(prefix) >= len(foundPrefix) {
+			found, foundPrefix = result, prefix
+		}
+	}
+
+	return found
+}
+
+func (c *testConnector) addExec(query string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.execList = append(c.execList, query)
+}
+
+func (c *testConnector) getExecList() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string{}, c.execList...)
+}
+
+func (c testConn) Prepare(query string) (driver.Stmt, error) {
+	return testStmt{connector: c.connector, query: query}, nil
+}
+
+func (c testConn) Close() error {
+	return nil
+}
+
+func (c testConn) Begin() (driver.Tx, error) {
+	return testTx{connector: c.connector}, nil
+}
+
+func (s testStmt) Close() error {
+	return nil
+}
+
+func (s testStmt) NumInput() int {
+	return -1
+}
+
+func (s testStmt) Exec(args []driver.Value) (driver.Result, error) {
+	result := s.connector.find(s.query)
+
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	s.connector.addExec(s.query)
+
+	return driver.RowsAffected(1), nil
+}
+
+func (s testStmt) Query(args []driver.Value) (driver.Rows, error) {
+	result := s.connector.find(s.query)
+
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	return &testRows{result: result}, nil
+}
+
+func (r *testRows) Columns() []string {
+	return r.result.columns
+}
+
+func (r *testRows) Close() error {
+	return nil
+}
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if r.index >= len(r.result.rows) {
+		return io.EOF
+	}
+
+	copy(dest, r.result.rows[r.index])
+	r.index++
+
+	return nil
+}
+
+func (tx testTx) Commit() error {
+	tx.connector.addExec("COMMIT")
+	return nil
+}
+
+func (tx testTx) Rollback() error {
+	tx.connector.addExec("ROLLBACK")
+	return nil
+}
+
+func newTestDBPool(connector *testConnector) DBPool {
+	logger := logging.InitUtilLogger("crm-util-go", logging.CrmDatabase)
+	logger.Level = logging.LEVEL_OFF
+
+	return DBPool{
+		Name:   "crm-test",
+		Driver: DriverMaria,
+		Logger: logger,
+		state:  &dbPoolState{sqlDB: sql.OpenDB(connector)},
+	}
+}
+
+type testCampaignTrans struct {
+	CampTransID string       `db:"CAMP_TRANS_ID"`
+	CampID      *string      `db:"camp_id"`
+	SubStatus   String       `db:"SUB_STATUS"`
+	CallCount   Int64        `db:"CALL_COUNT"`
+	Score       Float64      `db:"SCORE"`
+	PinReqDate  CrmDateTime  `db:"PIN_REQUEST_DATE"`
+	CreatedDate *CrmDateTime `db:"CREATED_DATE"`
+	Remark      string       `db:"-"`
+	testAudit
+}
+
+type testAudit struct {
+	CreatedBy string `db:"CREATED_BY"`
+}
+
+func TestQueryStructs(t *testing.T) {
+	createdDate := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	dbPool := newTestDBPool(&testConnector{results: map[string]testResult{
+		"SELECT": {
+			columns: []string{"CAMP_TRANS_ID", "CAMP_ID", "SUB_STATUS", "CALL_COUNT", "SCORE", "PIN_REQUEST_DATE", "CREATED_DATE", "CREATED_BY", "UNMAPPED"},
+			rows: [][]driver.Value{
+				{"T1", "C1", "Wait", int64(3), 1.5, createdDate, createdDate, "admin", "x"},
+				{"T2", nil, nil, nil, nil, nil, nil, "admin", nil},
+			},
+		},
+	}})
+
+	ctx := WithTransID(context.Background(), "trans-01")
+	campTransList, err := QueryStructs[testCampaignTrans](ctx, dbPool, "SELECT * FROM CAMPAIGN_TRANS")
+
+	if err != nil {
+		t.Fatalf("QueryStructs Error: %v", err)
+	}
+
+	if len(campTransList) != 2 {
+		t.Fatalf("expected 2 rows but got %d", len(campTransList))
+	}
+
+	first := campTransList[0]
+	if first.CampTransID != "T1" || *first.CampID != "C1" || first.SubStatus.String != "Wait" || !first.CallCount.Valid ||
+		first.Score.Float64 != 1.5 || !first.PinReqDate.Time().Equal(createdDate) || first.CreatedBy != "admin" {
+		t.Errorf("unexpected first row %+v", first)
+	}
+
+	second := campTransList[1]
+	if second.CampID != nil || second.SubStatus.Valid || second.CallCount.Valid || !second.PinReqDate.Time().IsZero() || second.CreatedDate != nil {
+		t.Errorf("unexpected null row %+v", second)
+	}
+}
+
+func TestQueryOneAndExec(t *testing.T) {
+	connector := &testConnector{results: map[string]testResult{
+		"SELECT EMPTY": {columns: []string{"CAMP_TRANS_ID"}},
+		"SELECT ONE":   {columns: []string{"CAMP_TRANS_ID"}, rows: [][]driver.Value{{"T1"}, {"T2"}}},
+		"UPDATE ERROR": {err: errors.New("ORA-00942: table or view does not exist")},
+	}}
+	dbPool := newTestDBPool(connector)
+	ctx := context.Background()
+
+	if result, err := QueryOne[testCampaignTrans](ctx, dbPool, "SELECT ONE"); err != nil || result.CampTransID != "T1" {
+		t.Errorf("QueryOne expected T1 but got %+v, %v", result, err)
+	}
+
+	if _, err := QueryOne[testCampaignTrans](ctx, dbPool, "SELECT EMPTY"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("QueryOne expected sql.ErrNoRows but got %v", err)
+	}
+
+	if _, err := QueryOne[string](ctx, dbPool, "SELECT ONE"); !errors.Is(err, ErrNotStruct) {
+		t.Errorf("QueryOne expected ErrNotStruct but got %v", err)
+	}
+
+	if result, err := Exec(ctx, dbPool, "UPDATE CAMPAIGN_TRANS SET STATUS = ?", "Done"); err != nil {
+		t.Errorf("Exec Error: %v", err)
+	} else if rowsAffected, _ := result.RowsAffected(); rowsAffected != 1 {
+		t.Errorf("expected 1 row affected but got %d", rowsAffected)
+	}
+
+	_, err := Exec(ctx, dbPool, "UPDATE ERROR")
+	var dbErr errorcode.DbError
+	if !errors.As(err, &dbErr) || dbErr.DbName != "crm-test" {
+		t.Errorf("Exec expected DbError but got %v", err)
+	}
+}
+
+// readTestLog writes the log of dbPool to a file of the test and returns the function which reads the file.
+func readTestLog(t *testing.T, logger *logging.PatternLogger) func() string {
+	logger.Level = logging.LEVEL_ALL
+	logger.EnableFileLogger(t.TempDir(), "db")
+
+	return func() string {
+		data, _ := os.ReadFile(logger.SetLogger.Path + "/db_" + time.Now().Format("2006-01-02") + ".log")
+		return string(data)
+	}
+}
+
+func TestQueryLogAction(t *testing.T) {
+	dbPool := newTestDBPool(&testConnector{results: map[string]testResult{
+		"SELECT": {columns: []string{"CAMP_TRANS_ID"}, rows: [][]driver.Value{{"T1"}}},
+	}})
+	readLog := readTestLog(t, dbPool.Logger)
+	ctx := WithTransID(context.Background(), "log-test")
+
+	QueryStructs[testCampaignTrans](ctx, dbPool, "SELECT CAMP_TRANS_ID FROM CAMPAIGN_TRANS")
+	QueryOne[testCampaignTrans](ctx, dbPool, "SELECT CAMP_TRANS_ID FROM CAMPAIGN_TRANS")
+	Exec(ctx, dbPool, "UPDATE CAMPAIGN_TRANS SET STATUS = 'Done'")
+
+	logText := readLog()
+	for _, action := range []string{"func: QueryStructs", "func: QueryOne", "func: Exec"} {
+		if strings.Count(logText, action) != 2 {
+			t.Errorf("expected the request and response of %s in the log\n%s", action, logText)
+		}
+	}
+}
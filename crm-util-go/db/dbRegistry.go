@@ -0,0 +1,488 @@
+package db
+
+import (
+	"context"
+	"crm-util-go/logging"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrPoolNotFound      = errors.New("database pool not found")
+	ErrPoolAlreadyExists = errors.New("database pool already registered")
+	ErrUnsupportedDriver = errors.New("unsupported database driver")
+)
+
+// dbPoolState holds the connection pool and health of one DBPool, copies of the DBPool share it.
+type dbPoolState struct {
+	mu              sync.Mutex
+	initMu          sync.Mutex
+	sqlDB           *sql.DB
+	mongoClient     *mongo.Client
+	countFail       int
+	lastErr         error
+	lastCheck       time.Time
+	connsCheckedOut int64
+	connsOpen       int64
+
+	// Statement stats of SlowQueryThreshold, shared by the nodes of a DBPoolCluster
+	statsOnce  sync.Once
+	queryStats *queryStats
+
+	// Nodes of DBPoolCluster and the replica check result of a node
+	clusterNodes   map[string]DBPool
+	readSeq        uint64
+	replicaHealthy bool
+	replicaLag     time.Duration
+	replicaCheck   time.Time
+
+	// Background replica check of a DBPoolCluster, started by the first ReadPool
+	replicaCheckMu   sync.Mutex
+	replicaCheckStop chan struct{}
+	replicaCheckDone chan struct{}
+}
+
+// Pools of DBPool without state, they keep the behavior of a single pool per database type.
+var (
+	defaultOracleState       = &dbPoolState{}
+	defaultMariaState        = &dbPoolState{}
+	defaultMongoState        = &dbPoolState{}
+	defaultMariaClusterState = &dbPoolState{}
+)
+
+func (dbPool DBPool) getState(defaultState *dbPoolState) *dbPoolState {
+	if dbPool.state != nil {
+		return dbPool.state
+	}
+
+	return defaultState
+}
+
+func (st *dbPoolState) setHealth(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.lastErr = err
+	st.lastCheck = time.Now()
+}
+
+func (st *dbPoolState) getMongoClient() *mongo.Client {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.mongoClient
+}
+
+func (dbPool DBPool) sqlOpener(driverName string) func() (*sql.DB, error) {
+	return func() (*sql.DB, error) {
+		if dbPool.SlowQueryThreshold > 0 {
+			return dbPool.openInstrumentedDB(driverName)
+		}
+
+		return sql.Open(driverName, dbPool.DataSourceName)
+	}
+}
+
+func (dbPool DBPool) openSQLDB(transID string, st *dbPoolState, dbLabel string, open func() (*sql.DB, error)) {
+	sqlDB, err := open()
+
+	if err != nil {
+		dbPool.Logger.Error(transID, "Can not initial "+dbLabel+" connection pool", err)
+		st.lastErr = err
+		return
+	}
+
+	sqlDB.SetMaxOpenConns(dbPool.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(dbPool.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(dbPool.MaxLifetime)
+
+	st.sqlDB = sqlDB
+	st.countFail = 0
+	dbPool.Logger.Info(transID, "Init "+dbLabel+"DBPool success "+dbPool.Name)
+}
+
+// openedSQLDB opens the pool on the first call, it does not verify a connection.
+func (dbPool DBPool) openedSQLDB(transID string, st *dbPoolState, dbLabel string, open func() (*sql.DB, error)) (*sql.DB, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.sqlDB == nil {
+		dbPool.openSQLDB(transID, st, dbLabel, open)
+	}
+
+	if st.sqlDB == nil {
+		return nil, st.lastErr
+	}
+
+	return st.sqlDB, nil
+}
+
+// getSQLDB opens the pool on the first call and verifies a connection on every call.
+// The pool is re-created after DbMaxFailTimes continuous ping errors.
+func (dbPool DBPool) getSQLDB(transID string, st *dbPoolState, dbLabel string, open func() (*sql.DB, error)) (*sql.DB, error) {
+	sqlDB, err := dbPool.openedSQLDB(transID, st, dbLabel, open)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dbPool.Logger.Debug(transID, fmt.Sprintf("%s DB Stat: %+v", dbLabel, sqlDB.Stats()))
+
+	err = sqlDB.PingContext(context.Background())
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.lastErr = err
+	st.lastCheck = time.Now()
+
+	if err != nil {
+		dbPool.Logger.Error(transID, "Can not verify a connection to "+dbLabel+" DB because "+err.Error(), err)
+
+		st.countFail++
+
+		if st.countFail > DbMaxFailTimes && st.sqlDB == sqlDB {
+			sqlDB.Close()
+			st.sqlDB = nil
+			dbPool.openSQLDB(transID, st, dbLabel, open)
+		}
+	} else {
+		st.countFail = 0
+	}
+
+	return st.sqlDB, err
+}
+
+func (st *dbPoolState) closeSQLDB() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.sqlDB == nil {
+		return nil
+	}
+
+	err := st.sqlDB.Close()
+	st.sqlDB = nil
+
+	return err
+}
+
+// GetSQLDB returns the *sql.DB of an Oracle or Maria pool, the empty Driver is Maria like driverState.
+func (dbPool DBPool) GetSQLDB(transID string) (*sql.DB, error) {
+	switch dbPool.Driver {
+	case DriverOracle:
+		return dbPool.GetOracleDBPool(transID)
+	case DriverMaria, "":
+		return dbPool.GetMariaDBPool(transID)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDriver, dbPool.Driver)
+	}
+}
+
+// sqlPool returns the pool of GetSQLDB without the ping, so a health check does not count a fail or re-create the pool.
+func (dbPool DBPool) sqlPool(transID string) (*sql.DB, error) {
+	switch dbPool.Driver {
+	case DriverOracle:
+		return dbPool.openedSQLDB(transID, dbPool.getState(defaultOracleState), "Oracle", dbPool.sqlOpener("oci8"))
+	case DriverMaria, "":
+		return dbPool.openedSQLDB(transID, dbPool.getState(defaultMariaState), "Maria", dbPool.sqlOpener("mysql"))
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDriver, dbPool.Driver)
+	}
+}
+
+func (dbPool DBPool) Close(transID string) error {
+	switch dbPool.Driver {
+	case DriverOracle:
+		return dbPool.CloseOracleDBPool()
+	case DriverMaria, "":
+		return dbPool.CloseMariaDBPool()
+	case DriverMongo:
+		return dbPool.Disconnect(transID)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedDriver, dbPool.Driver)
+	}
+}
+
+// driverState returns the state of the pool or the default state of its driver.
+func (dbPool DBPool) driverState() *dbPoolState {
+	switch dbPool.Driver {
+	case DriverOracle:
+		return dbPool.getState(defaultOracleState)
+	case DriverMongo:
+		return dbPool.getState(defaultMongoState)
+	default:
+		return dbPool.getState(defaultMariaState)
+	}
+}
+
+func (dbPool DBPool) Health() DBPoolHealth {
+	st := dbPool.driverState()
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	health := DBPoolHealth{
+		Name:      dbPool.Name,
+		Driver:    dbPool.Driver,
+		Connected: st.sqlDB != nil || st.mongoClient != nil,
+		Healthy:   (st.sqlDB != nil || st.mongoClient != nil) && st.lastErr == nil && !st.lastCheck.IsZero(),
+		CountFail: st.countFail,
+		LastCheck: st.lastCheck,
+	}
+
+	if st.lastErr != nil {
+		health.LastError = st.lastErr.Error()
+	}
+
+	if !st.replicaCheck.IsZero() {
+		health.Healthy = health.Healthy && st.replicaHealthy
+		health.ReplicaLag = st.replicaLag.String()
+	}
+
+	return health
+}
+
+/*
+DBRegistry keeps the named database pools of a service.
+
+	oraclePool, err := db.Registry.Register("crm-oracle", db.DBPool{Driver: db.DriverOracle, DataSourceName: ...})
+	oraclePool, err = db.Registry.Get("crm-oracle")
+	sqlDB, err := oraclePool.GetSQLDB(transID)
+	defer db.Registry.CloseAll(transID)
+
+DBPoolCluster and CassandraPool are registered for HealthReport and CloseAll.
+*/
+type DBRegistry struct {
+	mu             sync.RWMutex
+	pools          map[string]DBPool
+	clusters       map[string]DBPoolCluster
+	cassandraPools map[string]CassandraPool
+}
+
+var Registry = NewDBRegistry()
+
+func NewDBRegistry() *DBRegistry {
+	return &DBRegistry{
+		pools:          make(map[string]DBPool),
+		clusters:       make(map[string]DBPoolCluster),
+		cassandraPools: make(map[string]CassandraPool),
+	}
+}
+
+func (r *DBRegistry) nameExists(name string) bool {
+	_, foundPool := r.pools[name]
+	_, foundCluster := r.clusters[name]
+	_, foundCassandra := r.cassandraPools[name]
+
+	return foundPool || foundCluster || foundCassandra
+}
+
+// Register gives the pool its own connection pool and health state.
+func (r *DBRegistry) Register(name string, dbPool DBPool) (DBPool, error) {
+	if dbPool.Driver == "" {
+		dbPool.Driver = DriverMaria
+	}
+
+	switch dbPool.Driver {
+	case DriverOracle, DriverMaria, DriverMongo:
+	default:
+		return dbPool, fmt.Errorf("%w: %s", ErrUnsupportedDriver, dbPool.Driver)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.nameExists(name) {
+		return dbPool, fmt.Errorf("%w: %s", ErrPoolAlreadyExists, name)
+	}
+
+	if dbPool.Logger == nil {
+		dbPool.Logger = logging.InitUtilLogger("crm-util-go", logging.CrmDatabase)
+	}
+
+	dbPool.Name = name
+	dbPool.state = &dbPoolState{}
+	r.pools[name] = dbPool
+
+	return dbPool, nil
+}
+
+// RegisterCluster gives the cluster its own failover pool and node pools.
+func (r *DBRegistry) RegisterCluster(name string, dbPoolCluster DBPoolCluster) (DBPoolCluster, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.nameExists(name) {
+		return dbPoolCluster, fmt.Errorf("%w: %s", ErrPoolAlreadyExists, name)
+	}
+
+	if dbPoolCluster.Logger == nil {
+		dbPoolCluster.Logger = logging.InitUtilLogger("crm-util-go", logging.CrmDatabase)
+	}
+
+	dbPoolCluster.RegisterName = name
+	dbPoolCluster.state = &dbPoolState{}
+	r.clusters[name] = dbPoolCluster
+
+	return dbPoolCluster, nil
+}
+
+// RegisterCassandra adds the pool to HealthReport, the pools of the same cluster and keyspace share their sessions.
+func (r *DBRegistry) RegisterCassandra(name string, csdPool CassandraPool) (CassandraPool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.nameExists(name) {
+		return csdPool, fmt.Errorf("%w: %s", ErrPoolAlreadyExists, name)
+	}
+
+	if csdPool.Logger == nil {
+		csdPool.Logger = logging.InitUtilLogger("crm-util-go", logging.CrmDatabase)
+	}
+
+	r.cassandraPools[name] = csdPool
+
+	return csdPool, nil
+}
+
+func (r *DBRegistry) GetCluster(name string) (DBPoolCluster, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	dbPoolCluster, found := r.clusters[name]
+
+	if !found {
+		return dbPoolCluster, fmt.Errorf("%w: %s", ErrPoolNotFound, name)
+	}
+
+	return dbPoolCluster, nil
+}
+
+func (r *DBRegistry) GetCassandra(name string) (CassandraPool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	csdPool, found := r.cassandraPools[name]
+
+	if !found {
+		return csdPool, fmt.Errorf("%w: %s", ErrPoolNotFound, name)
+	}
+
+	return csdPool, nil
+}
+
+func (r *DBRegistry) Get(name string) (DBPool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	dbPool, found := r.pools[name]
+
+	if !found {
+		return dbPool, fmt.Errorf("%w: %s", ErrPoolNotFound, name)
+	}
+
+	return dbPool, nil
+}
+
+func (r *DBRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.pools))
+	for name := range r.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (r *DBRegistry) Health() []DBPoolHealth {
+	var healthList []DBPoolHealth
+
+	for _, name := range r.Names() {
+		if dbPool, err := r.Get(name); err == nil {
+			healthList = append(healthList, dbPool.Health())
+		}
+	}
+
+	return healthList
+}
+
+// Remove closes the pool and removes it from the registry.
+func (r *DBRegistry) Remove(transID string, name string) error {
+	r.mu.Lock()
+	dbPool, found := r.pools[name]
+	delete(r.pools, name)
+	r.mu.Unlock()
+
+	if !found {
+		return fmt.Errorf("%w: %s", ErrPoolNotFound, name)
+	}
+
+	return dbPool.Close(transID)
+}
+
+// CloseAll closes every pool and returns the first error, the pools stay registered and reconnect on next use.
+func (r *DBRegistry) CloseAll(transID string) error {
+	var firstErr error
+
+	for _, name := range r.Names() {
+		dbPool, err := r.Get(name)
+
+		// The pool is removed after Names, Remove has closed it
+		if err != nil {
+			continue
+		}
+
+		if err = dbPool.Close(transID); err != nil {
+			dbPool.Logger.Error(transID, "Close database pool "+name+" error", err)
+
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	clusters, cassandraPools := r.snapshot()
+
+	for name, dbPoolCluster := range clusters {
+		if err := dbPoolCluster.CloseMariaDBPoolCluster(); err != nil {
+			dbPoolCluster.Logger.Error(transID, "Close database cluster "+name+" error", err)
+
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	for _, csdPool := range cassandraPools {
+		csdPool.CloseAllSession()
+	}
+
+	return firstErr
+}
+
+func (r *DBRegistry) snapshot() (map[string]DBPoolCluster, map[string]CassandraPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	clusters := make(map[string]DBPoolCluster, len(r.clusters))
+	for name, dbPoolCluster := range r.clusters {
+		clusters[name] = dbPoolCluster
+	}
+
+	cassandraPools := make(map[string]CassandraPool, len(r.cassandraPools))
+	for name, csdPool := range r.cassandraPools {
+		cassandraPools[name] = csdPool
+	}
+
+	return clusters, cassandraPools
+}
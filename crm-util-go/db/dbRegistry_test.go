@@ -0,0 +1,146 @@
+package db
+
+import (
+	"crm-util-go/logging"
+	"errors"
+	"testing"
+)
+
+const unreachableMariaDSN = "crmapp:crmapp@tcp(127.0.0.1:1)/CRMX?timeout=1s"
+
+func newTestRegistry() *DBRegistry {
+	registry := NewDBRegistry()
+
+	logger := logging.InitUtilLogger("crm-util-go", logging.CrmDatabase)
+	logger.Level = logging.LEVEL_OFF
+
+	registry.Register("crm-maria-1", DBPool{Driver: DriverMaria, DataSourceName: unreachableMariaDSN, Logger: logger})
+	registry.Register("crm-maria-2", DBPool{Driver: DriverMaria, DataSourceName: unreachableMariaDSN, Logger: logger})
+
+	return registry
+}
+
+func TestRegistryRegister(t *testing.T) {
+	registry := newTestRegistry()
+
+	if _, err := registry.Register("crm-maria-1", DBPool{Driver: DriverMaria}); !errors.Is(err, ErrPoolAlreadyExists) {
+		t.Errorf("expected ErrPoolAlreadyExists but got %v", err)
+	}
+
+	if _, err := registry.Register("crm-cassandra", DBPool{Driver: "cassandra"}); !errors.Is(err, ErrUnsupportedDriver) {
+		t.Errorf("expected ErrUnsupportedDriver but got %v", err)
+	}
+
+	if _, err := registry.Get("crm-oracle"); !errors.Is(err, ErrPoolNotFound) {
+		t.Errorf("expected ErrPoolNotFound but got %v", err)
+	}
+
+	dbPool, err := registry.Get("crm-maria-2")
+	if err != nil || dbPool.Name != "crm-maria-2" || dbPool.state == nil {
+		t.Errorf("unexpected pool %+v, error %v", dbPool, err)
+	}
+
+	if names := registry.Names(); len(names) != 2 || names[0] != "crm-maria-1" {
+		t.Errorf("unexpected names %v", names)
+	}
+
+	if err = registry.Remove("", "crm-maria-2"); err != nil {
+		t.Errorf("Remove Error: %v", err)
+	}
+
+	if err = registry.Remove("", "crm-maria-2"); !errors.Is(err, ErrPoolNotFound) {
+		t.Errorf("expected ErrPoolNotFound but got %v", err)
+	}
+}
+
+func TestRegistryEmptyDriver(t *testing.T) {
+	registry := newTestRegistry()
+	defer registry.CloseAll("")
+
+	logger := logging.InitUtilLogger("crm-util-go", logging.CrmDatabase)
+	logger.Level = logging.LEVEL_OFF
+
+	dbPool, err := registry.Register("crm-maria-3", DBPool{DataSourceName: unreachableMariaDSN, Logger: logger})
+	if err != nil || dbPool.Driver != DriverMaria {
+		t.Fatalf("expected the empty driver registered as Maria but got %s, %v", dbPool.Driver, err)
+	}
+
+	// The hand-built pool with the empty Driver is Maria in GetSQLDB like in Health
+	dbPool.Driver = ""
+
+	if sqlDB, err := dbPool.sqlPool(""); err != nil || sqlDB == nil {
+		t.Errorf("expected the Maria pool but got %v", err)
+	}
+
+	if _, err = dbPool.GetSQLDB(""); err == nil || errors.Is(err, ErrUnsupportedDriver) {
+		t.Errorf("expected the connection error of Maria but got %v", err)
+	}
+
+	if err = dbPool.Close(""); err != nil {
+		t.Errorf("Close Error: %v", err)
+	}
+}
+
+func TestRegistryPoolState(t *testing.T) {
+	registry := newTestRegistry()
+	defer registry.CloseAll("")
+
+	maria1, _ := registry.Get("crm-maria-1")
+	maria2, _ := registry.Get("crm-maria-2")
+
+	sqlDB, err := maria1.GetSQLDB("")
+	if err == nil {
+		t.Fatalf("expected ping error from unreachable database")
+	}
+
+	if sqlDB == nil {
+		t.Fatalf("expected *sql.DB to be kept after ping error")
+	}
+
+	health := maria1.Health()
+	if !health.Connected || health.Healthy || health.CountFail != 1 || health.LastError == "" {
+		t.Errorf("unexpected health %+v", health)
+	}
+
+	if health = maria2.Health(); health.Connected || health.CountFail != 0 {
+		t.Errorf("crm-maria-2 expected own state but got %+v", health)
+	}
+
+	if health = (DBPool{Driver: DriverMaria}).Health(); health.CountFail != 0 {
+		t.Errorf("default pool expected own state but got %+v", health)
+	}
+
+	if healthList := registry.Health(); len(healthList) != 2 {
+		t.Errorf("expected 2 health but got %d", len(healthList))
+	}
+
+	if err = registry.CloseAll(""); err != nil {
+		t.Errorf("CloseAll Error: %v", err)
+	}
+
+	if health = maria1.Health(); health.Connected {
+		t.Errorf("expected closed pool but got %+v", health)
+	}
+}
+
+func TestMariaDBPoolClusterReopen(t *testing.T) {
+	logger := logging.InitUtilLogger("crm-util-go", logging.CrmDatabase)
+	logger.Level = logging.LEVEL_OFF
+
+	cluster := DBPoolCluster{
+		DBNode:       []DBNode{{NodeName: "galera1", DataSourceName: unreachableMariaDSN}},
+		RegisterName: "crm-galera",
+		Logger:       logger,
+	}
+
+	// Re-opening the pool with the same RegisterName must not panic from sql.Register
+	for i := 0; i < 2; i++ {
+		if sqlDB, _ := cluster.GetMariaDBPoolCluster(""); sqlDB == nil {
+			t.Fatalf("expected *sql.DB")
+		}
+
+		if err := cluster.CloseMariaDBPoolCluster(); err != nil {
+			t.Errorf("CloseMariaDBPoolCluster Error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package db
+
+import (
+	"context"
+	"crm-util-go/errorcode"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Tx is the transaction of WithTx, Context returns the ctx which nested WithTx and the query helpers join.
+type Tx struct {
+	*sql.Tx
+	ctx       context.Context
+	dbPool    DBPool
+	state     *dbPoolState
+	transID   string
+	savepoint *int
+}
+
+type txKey struct{}
+
+func (tx *Tx) Context() context.Context {
+	return tx.ctx
+}
+
+func (tx *Tx) TransID() string {
+	return tx.transID
+}
+
+// txFromContext returns the Tx of the same pool in ctx.
+func (dbPool DBPool) txFromContext(ctx context.Context) *Tx {
+	if tx, ok := ctx.Value(txKey{}).(*Tx); ok && tx.state == dbPool.driverState() {
+		return tx
+	}
+
+	return nil
+}
+
+// IsRetryableTxError reports the deadlock and serialization errors, the whole transaction can be retried.
+func IsRetryableTxError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+
+	if errors.As(err, &mysqlErr) {
+		// 1213: Deadlock found when trying to get lock
+		return mysqlErr.Number == 1213
+	}
+
+	// ORA-08177: can't serialize access for this transaction
+	// ORA-00060: deadlock detected while waiting for resource
+	return err != nil && (strings.Contains(err.Error(), "ORA-08177") || strings.Contains(err.Error(), "ORA-00060"))
+}
+
+/*
+WithTx commits when fn returns nil, rolls back when fn returns an error or panics,
+and retries the whole transaction on deadlock and serialization errors.
+fn can return *errorcode.CrmErrorCodeResp, it is returned as is after the rollback.
+The returned response unwraps to the error of fn, so IsRetryableTxError also sees the error of a nested WithTx.
+
+	crmErrorCodeResp := db.WithTx(ctx, dbPool, db.TxOptions{Isolation: sql.LevelSerializable, ErrCode: errCode}, func(tx *db.Tx) error {
+		_, err := tx.ExecContext(tx.Context(), "UPDATE CAMPAIGN_TRANS SET STATUS = ? WHERE CAMP_TRANS_ID = ?", status, campTransID)
+		return err
+	})
+
+WithTx in fn with tx.Context() runs in a savepoint of the same transaction, an error rolls back to the savepoint only.
+
+	return db.WithTx(tx.Context(), dbPool, opts, func(tx *db.Tx) error { ... })
+*/
+func WithTx(ctx context.Context, dbPool DBPool, opts TxOptions, fn func(tx *Tx) error) *errorcode.CrmErrorCodeResp {
+	if parent := dbPool.txFromContext(ctx); parent != nil {
+		return dbPool.toCrmErrorCodeResp(parent.transID, opts, parent.withSavepoint(fn))
+	}
+
+	transID := TransIDFromContext(ctx)
+	startDT := dbPool.Logger.LogRequestDBClient(transID)
+
+	maxRetry := opts.MaxRetry
+	if maxRetry == 0 {
+		maxRetry = DefaultTxMaxRetry
+	}
+
+	retryBackoff := opts.RetryBackoff
+	if retryBackoff <= 0 {
+		retryBackoff = DefaultTxRetryBackoff
+	}
+
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		err = dbPool.runTx(ctx, transID, opts, fn)
+
+		if err == nil || !IsRetryableTxError(err) || attempt >= maxRetry || ctx.Err() != nil {
+			break
+		}
+
+		dbPool.Logger.Warn(transID, fmt.Sprintf("Retry transaction %d/%d because %s", attempt+1, maxRetry, err.Error()))
+
+		if !sleepContext(ctx, retryBackoff*time.Duration(attempt+1)) {
+			break
+		}
+	}
+
+	crmErrorCodeResp := dbPool.toCrmErrorCodeResp(transID, opts, err)
+
+	if crmErrorCodeResp != nil {
+		dbPool.Logger.LogResponseDBClient(transID, crmErrorCodeResp.ErrorCode, startDT)
+	} else {
+		dbPool.Logger.LogResponseDBClient(transID, "0", startDT)
+	}
+
+	return crmErrorCodeResp
+}
+
+func (dbPool DBPool) runTx(ctx context.Context, transID string, opts TxOptions, fn func(tx *Tx) error) (err error) {
+	sqlDB, err := dbPool.GetSQLDB(transID)
+
+	if err != nil {
+		return err
+	}
+
+	sqlTx, err := sqlDB.BeginTx(ctx, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly})
+
+	if err != nil {
+		return fmt.Errorf("start a transaction error: %w", err)
+	}
+
+	tx := &Tx{Tx: sqlTx, dbPool: dbPool, state: dbPool.driverState(), transID: transID, savepoint: new(int)}
+	tx.ctx = context.WithValue(ctx, txKey{}, tx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			dbPool.Logger.Error(transID, fmt.Sprintf("Rollback transaction because panic: %v", r))
+			sqlTx.Rollback()
+			panic(r)
+		}
+	}()
+
+	if err = callTxFunc(fn, tx); err != nil {
+		dbPool.Logger.Info(transID, "Rollback transaction because "+err.Error())
+
+		if rollbackErr := sqlTx.Rollback(); rollbackErr != nil {
+			dbPool.Logger.Error(transID, "Rollback transaction error", rollbackErr)
+		}
+
+		return err
+	}
+
+	if err = sqlTx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction error: %w", err)
+	}
+
+	return nil
+}
+
+func (tx *Tx) withSavepoint(fn func(tx *Tx) error) (err error) {
+	*tx.savepoint++
+	savepoint := fmt.Sprintf("SP_%d", *tx.savepoint)
+
+	if _, err = tx.ExecContext(tx.ctx, "SAVEPOINT "+savepoint); err != nil {
+		return fmt.Errorf("create savepoint error: %w", err)
+	}
+
+	rollback := func(reason string) {
+		tx.dbPool.Logger.Info(tx.transID, "Rollback to savepoint "+savepoint+" because "+reason)
+
+		if _, rollbackErr := tx.ExecContext(tx.ctx, "ROLLBACK TO SAVEPOINT "+savepoint); rollbackErr != nil {
+			tx.dbPool.Logger.Error(tx.transID, "Rollback to savepoint error", rollbackErr)
+		}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			rollback(fmt.Sprintf("panic: %v", r))
+			panic(r)
+		}
+	}()
+
+	if err = callTxFunc(fn, tx); err != nil {
+		rollback(err.Error())
+		return err
+	}
+
+	// Oracle releases the savepoint on commit, it has no RELEASE SAVEPOINT
+	if tx.dbPool.Driver != DriverOracle {
+		if _, err = tx.ExecContext(tx.ctx, "RELEASE SAVEPOINT "+savepoint); err != nil {
+			return fmt.Errorf("release savepoint error: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// callTxFunc treats a nil *errorcode.CrmErrorCodeResp as nil, so fn can return the result of a nested WithTx.
+func callTxFunc(fn func(tx *Tx) error, tx *Tx) error {
+	err := fn(tx)
+
+	if crmErrorCodeResp, ok := err.(*errorcode.CrmErrorCodeResp); ok && crmErrorCodeResp == nil {
+		return nil
+	}
+
+	return err
+}
+
+func (dbPool DBPool) toCrmErrorCodeResp(transID string, opts TxOptions, err error) *errorcode.CrmErrorCodeResp {
+	if err == nil {
+		return nil
+	}
+
+	var crmErrorCodeResp *errorcode.CrmErrorCodeResp
+
+	if errors.As(err, &crmErrorCodeResp) {
+		return crmErrorCodeResp
+	}
+
+	dbPool.Logger.Error(transID, "Transaction error: "+err.Error(), err)
+
+	var resp errorcode.CrmErrorCodeResp
+
+	if IsRetryableTxError(err) || errors.Is(err, sql.ErrTxDone) {
+		resp = opts.ErrCode.GenerateCRMDatabaseError(err.Error())
+	} else {
+		resp = opts.ErrCode.GenerateAppError(dbPool.Logger.ApplicationName, err.Error())
+	}
+
+	return resp.WithCause(err)
+}
+
+// sleepContext returns false when ctx is done before d.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
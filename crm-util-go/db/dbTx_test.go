@@ -0,0 +1,180 @@
+package db
+
+import (
+	"context"
+	"crm-util-go/errorcode"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func newTestTxOptions() TxOptions {
+	errorcode.InitConfig("../config")
+
+	return TxOptions{RetryBackoff: time.Millisecond, ErrCode: errorcode.CrmErrorCode{SystemCode: "CIB", ModuleCode: "CN"}}
+}
+
+func TestWithTxCommitRollback(t *testing.T) {
+	connector := &testConnector{results: map[string]testResult{
+		"UPDATE ERROR": {err: errors.New("ORA-01400: cannot insert NULL")},
+	}}
+	dbPool := newTestDBPool(connector)
+	opts := newTestTxOptions()
+
+	crmErrorCodeResp := WithTx(context.Background(), dbPool, opts, func(tx *Tx) error {
+		_, err := Exec(tx.Context(), dbPool, "UPDATE CAMPAIGN_TRANS")
+		return err
+	})
+
+	if crmErrorCodeResp != nil {
+		t.Fatalf("WithTx Error: %+v", crmErrorCodeResp)
+	}
+
+	crmErrorCodeResp = WithTx(context.Background(), dbPool, opts, func(tx *Tx) error {
+		_, err := Exec(tx.Context(), dbPool, "UPDATE ERROR")
+		return err
+	})
+
+	if crmErrorCodeResp == nil || !strings.Contains(crmErrorCodeResp.ErrorMessage, "ORA-01400") {
+		t.Errorf("expected ORA-01400 but got %+v", crmErrorCodeResp)
+	}
+
+	businessResp := opts.ErrCode.GenerateBusinessLogic("Campaign is closed")
+	crmErrorCodeResp = WithTx(context.Background(), dbPool, opts, func(tx *Tx) error {
+		return &businessResp
+	})
+
+	if crmErrorCodeResp == nil || crmErrorCodeResp.ErrorCode != businessResp.ErrorCode {
+		t.Errorf("expected business error but got %+v", crmErrorCodeResp)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("expected panic")
+			}
+		}()
+
+		WithTx(context.Background(), dbPool, opts, func(tx *Tx) error {
+			panic("nil pointer")
+		})
+	}()
+
+	expected := "UPDATE CAMPAIGN_TRANS,COMMIT,ROLLBACK,ROLLBACK,ROLLBACK"
+	if execList := strings.Join(connector.getExecList(), ","); execList != expected {
+		t.Errorf("expected %s but got %s", expected, execList)
+	}
+}
+
+func TestWithTxSavepoint(t *testing.T) {
+	connector := &testConnector{results: map[string]testResult{}}
+	dbPool := newTestDBPool(connector)
+	opts := newTestTxOptions()
+
+	crmErrorCodeResp := WithTx(context.Background(), dbPool, opts, func(tx *Tx) error {
+		Exec(tx.Context(), dbPool, "INSERT CAMPAIGN")
+
+		innerResp := WithTx(tx.Context(), dbPool, opts, func(inner *Tx) error {
+			Exec(inner.Context(), dbPool, "INSERT CAMPAIGN_TRANS")
+			return errors.New("invalid campaign trans")
+		})
+
+		if innerResp == nil {
+			t.Errorf("expected inner error")
+		}
+
+		return WithTx(tx.Context(), dbPool, opts, func(inner *Tx) error {
+			return nil
+		})
+	})
+
+	if crmErrorCodeResp != nil {
+		t.Fatalf("WithTx Error: %+v", crmErrorCodeResp)
+	}
+
+	expected := "INSERT CAMPAIGN,SAVEPOINT SP_1,INSERT CAMPAIGN_TRANS,ROLLBACK TO SAVEPOINT SP_1,SAVEPOINT SP_2,RELEASE SAVEPOINT SP_2,COMMIT"
+	if execList := strings.Join(connector.getExecList(), ","); execList != expected {
+		t.Errorf("expected %s but got %s", expected, execList)
+	}
+}
+
+func TestWithTxRetryDeadlock(t *testing.T) {
+	connector := &testConnector{results: map[string]testResult{
+		"UPDATE DEADLOCK": {err: &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}},
+	}}
+	dbPool := newTestDBPool(connector)
+	opts := newTestTxOptions()
+
+	attempt := 0
+	crmErrorCodeResp := WithTx(context.Background(), dbPool, opts, func(tx *Tx) error {
+		attempt++
+
+		if attempt < 3 {
+			_, err := Exec(tx.Context(), dbPool, "UPDATE DEADLOCK")
+			return err
+		}
+
+		return nil
+	})
+
+	if crmErrorCodeResp != nil || attempt != 3 {
+		t.Errorf("expected success at attempt 3 but got %d, %+v", attempt, crmErrorCodeResp)
+	}
+
+	attempt = 0
+	opts.MaxRetry = 1
+	crmErrorCodeResp = WithTx(context.Background(), dbPool, opts, func(tx *Tx) error {
+		attempt++
+		return errors.New("ORA-08177: can't serialize access for this transaction")
+	})
+
+	if crmErrorCodeResp == nil || attempt != 2 {
+		t.Errorf("expected error after 2 attempts but got %d, %+v", attempt, crmErrorCodeResp)
+	}
+}
+
+func TestWithTxRetryNestedDeadlock(t *testing.T) {
+	connector := &testConnector{results: map[string]testResult{
+		"UPDATE DEADLOCK": {err: &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}},
+	}}
+	dbPool := newTestDBPool(connector)
+	opts := newTestTxOptions()
+
+	attempt := 0
+	crmErrorCodeResp := WithTx(context.Background(), dbPool, opts, func(tx *Tx) error {
+		attempt++
+
+		return WithTx(tx.Context(), dbPool, opts, func(inner *Tx) error {
+			if attempt < 2 {
+				_, err := Exec(inner.Context(), dbPool, "UPDATE DEADLOCK")
+				return err
+			}
+
+			return nil
+		})
+	})
+
+	if crmErrorCodeResp != nil || attempt != 2 {
+		t.Errorf("expected the deadlock of the savepoint to retry the transaction but got %d, %+v", attempt, crmErrorCodeResp)
+	}
+
+	// The backoff stops when ctx is cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	opts.RetryBackoff = time.Hour
+	attempt = 0
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+	crmErrorCodeResp = WithTx(ctx, dbPool, opts, func(tx *Tx) error {
+		attempt++
+
+		_, err := Exec(tx.Context(), dbPool, "UPDATE DEADLOCK")
+		return err
+	})
+
+	if crmErrorCodeResp == nil || !IsRetryableTxError(crmErrorCodeResp) || attempt != 1 {
+		t.Errorf("expected the deadlock after 1 attempt but got %d, %+v", attempt, crmErrorCodeResp)
+	}
+}
@@ -2,91 +2,393 @@ package db
 
 import (
 	"context"
+	"crm-util-go/common"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
-	"github.com/benthor/clustersql"
 	"github.com/go-sql-driver/mysql"
-	"sync"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
-var lockInitMariaDBPoolCluster sync.Mutex
+// clusterConnector connects to all nodes at the same time and uses the first connection that succeeds,
+// it replaces clustersql which can be created only once per process.
+type clusterConnector struct {
+	driver mysql.MySQLDriver
+	nodes  []DBNode
+}
+
+type clusterConn struct {
+	conn driver.Conn
+	err  error
+}
+
+func (c clusterConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	connChan := make(chan clusterConn, len(c.nodes))
 
-// Database connection pool
-var mariaPoolCluster *sql.DB
-var countFailMariaCluster int
+	for _, dbNode := range c.nodes {
+		go func(dsn string) {
+			conn, err := c.driver.Open(dsn)
+			connChan <- clusterConn{conn: conn, err: err}
+		}(dbNode.DataSourceName)
+	}
 
-func (dbPool DBPoolCluster) initMariaDBPoolCluster(transID string) *sql.DB {
+	var err error = driver.ErrBadConn
 
-	lockInitMariaDBPoolCluster.Lock()
-	defer lockInitMariaDBPoolCluster.Unlock()
+	for i := 0; i < len(c.nodes); i++ {
+		result := <-connChan
 
-	var err error
+		if result.err == nil {
+			// Close the connections which succeed later
+			go func(remain int) {
+				for ; remain > 0; remain-- {
+					if late := <-connChan; late.conn != nil {
+						late.conn.Close()
+					}
+				}
+			}(len(c.nodes) - i - 1)
 
-	if mariaPoolCluster != nil {
-		if countFailMariaCluster < DbMaxFailTimes {
-			return mariaPoolCluster
+			return result.conn, nil
 		}
 
-		err = mariaPoolCluster.PingContext(context.Background())
+		err = result.err
+	}
 
-		if err != nil {
-			mariaPoolCluster.Close()
-		} else {
-			countFailMariaCluster = 0
-			return mariaPoolCluster
+	return nil, err
+}
+
+func (c clusterConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+func (dbPool DBPoolCluster) getState() *dbPoolState {
+	if dbPool.state != nil {
+		return dbPool.state
+	}
+
+	return defaultMariaClusterState
+}
+
+func (dbPool DBPoolCluster) toDBPool() DBPool {
+	return DBPool{
+		Name:         dbPool.RegisterName,
+		Driver:       DriverMaria,
+		MaxOpenConns: dbPool.MaxOpenConns,
+		MaxIdleConns: dbPool.MaxIdleConns,
+		MaxLifetime:  dbPool.MaxLifetime,
+		Logger:       dbPool.Logger,
+	}
+}
+
+// openClusterDB does not call sql.Register, so the pool can be re-created with the same RegisterName.
+func (dbPool DBPoolCluster) openClusterDB() (*sql.DB, error) {
+	if dbPool.SlowQueryThreshold > 0 {
+		inst := newSQLInstrument(dbPool.RegisterName, dbPool.SlowQueryThreshold, dbPool.Logger, dbPool.getState().getQueryStats())
+		return sql.OpenDB(instrumentedConnector{connector: clusterConnector{nodes: dbPool.DBNode}, inst: inst}), nil
+	}
+
+	return sql.OpenDB(clusterConnector{nodes: dbPool.DBNode}), nil
+}
+
+func (dbPool DBPoolCluster) GetMariaDBPoolCluster(transID string) (*sql.DB, error) {
+	return dbPool.toDBPool().getSQLDB(transID, dbPool.getState(), "MariaCluster", dbPool.openClusterDB)
+}
+
+// CloseMariaDBPoolCluster closes the failover pool and the node pools of PrimaryPool and ReadPool.
+func (dbPool DBPoolCluster) CloseMariaDBPoolCluster() error {
+	st := dbPool.getState()
+	st.stopReplicaCheck()
+
+	err := st.closeSQLDB()
+
+	st.mu.Lock()
+	nodePools := st.clusterNodes
+	st.clusterNodes = nil
+	st.mu.Unlock()
+
+	for _, nodePool := range nodePools {
+		if closeErr := nodePool.CloseMariaDBPool(); closeErr != nil && err == nil {
+			err = closeErr
 		}
 	}
 
-	mysqlDriver := mysql.MySQLDriver{}
+	return err
+}
+
+// nodePools returns the primary and replica pools of the nodes, a node pool has its own *sql.DB.
+func (dbPool DBPoolCluster) nodePools() (primaryList []DBPool, replicaList []DBPool) {
+	st := dbPool.getState()
 
-	clusterDriver := clustersql.NewDriver(mysqlDriver)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.clusterNodes == nil {
+		st.clusterNodes = make(map[string]DBPool)
+	}
 
-	// clusterDriver.AddNode("galera1", "user:password@tcp(dbhost1:3306)/db_name")
 	for _, dbNode := range dbPool.DBNode {
-		clusterDriver.AddNode(dbNode.NodeName, dbNode.DataSourceName)
+		nodePool, found := st.clusterNodes[dbNode.NodeName]
+
+		if !found {
+			nodePool = DBPool{
+				Name:               dbPool.RegisterName + "/" + dbNode.NodeName,
+				Driver:             DriverMaria,
+				DataSourceName:     dbNode.DataSourceName,
+				MaxOpenConns:       dbPool.MaxOpenConns,
+				MaxIdleConns:       dbPool.MaxIdleConns,
+				MaxLifetime:        dbPool.MaxLifetime,
+				SlowQueryThreshold: dbPool.SlowQueryThreshold,
+				Logger:             dbPool.Logger,
+				state:              &dbPoolState{queryStats: st.getQueryStats()},
+			}
+			st.clusterNodes[dbNode.NodeName] = nodePool
+		}
+
+		if dbNode.Role == NodeReplica {
+			replicaList = append(replicaList, nodePool)
+		} else {
+			primaryList = append(primaryList, nodePool)
+		}
 	}
 
-	sql.Register(dbPool.RegisterName, clusterDriver)
+	return primaryList, replicaList
+}
 
-	mariaPoolCluster, err = sql.Open(dbPool.RegisterName, "NoDSN")
+// PrimaryPool returns the first primary which accepts a connection, for writes and WithTx.
+func (dbPool DBPoolCluster) PrimaryPool(transID string) DBPool {
+	primaryList, _ := dbPool.nodePools()
 
-	if err != nil {
-		dbPool.Logger.Error(transID, "Can not initial Maria connection pool", err)
+	if len(primaryList) == 0 {
+		return dbPool.toDBPool()
 	}
 
-	if mariaPoolCluster != nil {
-		mariaPoolCluster.SetMaxOpenConns(dbPool.MaxOpenConns)
-		mariaPoolCluster.SetMaxIdleConns(dbPool.MaxIdleConns)
-		mariaPoolCluster.SetConnMaxLifetime(dbPool.MaxLifetime)
-		countFailMariaCluster = 0
-		dbPool.Logger.Info(transID, "Init MariaDBPoolCluster success")
+	for _, primaryPool := range primaryList {
+		if _, err := primaryPool.GetMariaDBPool(transID); err == nil {
+			return primaryPool
+		}
+
+		dbPool.Logger.Warn(transID, "MariaDB cluster primary "+primaryPool.Name+" is unavailable")
 	}
 
-	return mariaPoolCluster
+	return primaryList[0]
 }
 
-func (dbPool DBPoolCluster) GetMariaDBPoolCluster(transID string) (*sql.DB, error) {
-	var err error
+/*
+ReadPool returns a healthy replica by round robin, or the primary when no replica is healthy.
+The first ReadPool checks the replicas, later calls use the result of the background check.
+In WithTx, ctx has the transaction of the primary and ReadPool returns the primary.
+
+	campTransList, err := db.QueryStructs[CampaignTrans](ctx, cluster.ReadPool(ctx), sqlStmt, args...)
+	crmErrorCodeResp := db.WithTx(ctx, cluster.PrimaryPool(transID), opts, fn)
+*/
+func (dbPool DBPoolCluster) ReadPool(ctx context.Context) DBPool {
+	transID := TransIDFromContext(ctx)
+	primaryList, replicaList := dbPool.nodePools()
+
+	if tx, ok := ctx.Value(txKey{}).(*Tx); ok {
+		for _, primaryPool := range primaryList {
+			if tx.state == primaryPool.state {
+				return primaryPool
+			}
+		}
+	}
+
+	if len(replicaList) > 0 {
+		dbPool.startReplicaCheck(transID)
+
+		st := dbPool.getState()
+		seq := atomic.AddUint64(&st.readSeq, 1)
+
+		for i := range replicaList {
+			replicaPool := replicaList[(int(seq)+i)%len(replicaList)]
 
-	if mariaPoolCluster == nil {
-		mariaPoolCluster = dbPool.initMariaDBPoolCluster(transID)
+			if replicaPool.state.isReplicaHealthy() {
+				return replicaPool
+			}
+		}
+
+		dbPool.Logger.Warn(transID, "MariaDB cluster has no healthy replica, read from primary")
 	}
 
-	dbPool.Logger.Debug(transID, fmt.Sprintf("Maria DB Stat: %+v", mariaPoolCluster.Stats()))
+	return dbPool.PrimaryPool(transID)
+}
 
-	err = mariaPoolCluster.PingContext(context.Background())
+func (st *dbPoolState) isReplicaHealthy() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.replicaHealthy
+}
+
+// startReplicaCheck checks the replicas once, then every ReplicaCheckInterval in the background until CloseMariaDBPoolCluster.
+func (dbPool DBPoolCluster) startReplicaCheck(transID string) {
+	st := dbPool.getState()
+
+	st.replicaCheckMu.Lock()
+	defer st.replicaCheckMu.Unlock()
+
+	if st.replicaCheckStop != nil {
+		return
+	}
+
+	dbPool.checkReplicas(transID)
+
+	st.replicaCheckStop = make(chan struct{})
+	st.replicaCheckDone = make(chan struct{})
+	go dbPool.replicaCheckLoop(st.replicaCheckStop, st.replicaCheckDone)
+}
+
+func (dbPool DBPoolCluster) replicaCheckLoop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	checkInterval := dbPool.ReplicaCheckInterval
+	if checkInterval <= 0 {
+		checkInterval = DefaultReplicaCheckInterval
+	}
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			dbPool.checkReplicas(common.NewUUID())
+		}
+	}
+}
+
+// stopReplicaCheck stops the background replica check and waits for the running check.
+func (st *dbPoolState) stopReplicaCheck() {
+	st.replicaCheckMu.Lock()
+	stop, done := st.replicaCheckStop, st.replicaCheckDone
+	st.replicaCheckStop, st.replicaCheckDone = nil, nil
+	st.replicaCheckMu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (dbPool DBPoolCluster) checkReplicas(transID string) {
+	_, replicaList := dbPool.nodePools()
+
+	for _, replicaPool := range replicaList {
+		dbPool.updateReplicaHealth(transID, replicaPool)
+	}
+}
+
+// updateReplicaHealth keeps the replication lag of replicaPool for ReadPool and NodeHealth.
+func (dbPool DBPoolCluster) updateReplicaHealth(transID string, replicaPool DBPool) {
+	st := replicaPool.state
+	healthy, lag, err := dbPool.checkReplica(transID, replicaPool)
 
 	if err != nil {
-		dbPool.Logger.Error(transID, "Can not verify a connection to Maria DB because "+err.Error(), err)
+		dbPool.Logger.Warn(transID, "MariaDB cluster replica "+replicaPool.Name+" check error", err)
+	} else if !healthy {
+		dbPool.Logger.Warn(transID, fmt.Sprintf("MariaDB cluster replica %s is unhealthy, lag %s", replicaPool.Name, lag))
+	}
+
+	st.mu.Lock()
+	st.replicaHealthy = healthy
+	st.replicaLag = lag
+	st.replicaCheck = time.Now()
+	st.mu.Unlock()
+}
+
+// checkReplica reads Seconds_Behind_Master of SHOW SLAVE STATUS, or wsrep_local_state of a Galera node (4 is Synced).
+// A node which is neither a replica nor a Galera node is healthy.
+func (dbPool DBPoolCluster) checkReplica(transID string, replicaPool DBPool) (bool, time.Duration, error) {
+	maxLag := dbPool.MaxReplicaLag
+	if maxLag <= 0 {
+		maxLag = DefaultMaxReplicaLag
+	}
 
-		countFailMariaCluster++
+	sqlDB, err := replicaPool.GetMariaDBPool(transID)
 
-		if countFailMariaCluster > DbMaxFailTimes {
-			mariaPoolCluster = dbPool.initMariaDBPoolCluster(transID)
+	if err != nil {
+		return false, 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), maxLag)
+	defer cancel()
+
+	slaveStatus, err := queryRowMap(ctx, sqlDB, "SHOW SLAVE STATUS")
+
+	if err != nil {
+		return false, 0, err
+	}
+
+	if slaveStatus != nil {
+		seconds, err := strconv.ParseInt(slaveStatus["seconds_behind_master"].String, 10, 64)
+
+		if err != nil || !slaveStatus["seconds_behind_master"].Valid {
+			// NULL when the replication thread is not running
+			return false, 0, nil
 		}
-	} else {
-		countFailMariaCluster = 0
+
+		lag := time.Duration(seconds) * time.Second
+		return lag <= maxLag, lag, nil
+	}
+
+	wsrepStatus, err := queryRowMap(ctx, sqlDB, "SHOW STATUS LIKE 'wsrep_local_state'")
+
+	if err != nil {
+		return false, 0, err
+	}
+
+	if wsrepStatus != nil {
+		return wsrepStatus["value"].String == "4", 0, nil
+	}
+
+	return true, 0, nil
+}
+
+// queryRowMap returns the first row by lower case column name, or nil when the query has no row.
+func queryRowMap(ctx context.Context, sqlDB *sql.DB, sqlStmt string) (map[string]sql.NullString, error) {
+	rows, err := sqlDB.QueryContext(ctx, sqlStmt)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+
+	if err != nil || !rows.Next() {
+		return nil, err
+	}
+
+	values := make([]sql.NullString, len(columns))
+	scanList := make([]interface{}, len(columns))
+	for i := range values {
+		scanList[i] = &values[i]
+	}
+
+	if err = rows.Scan(scanList...); err != nil {
+		return nil, err
+	}
+
+	rowMap := make(map[string]sql.NullString)
+	for i, column := range columns {
+		rowMap[strings.ToLower(column)] = values[i]
+	}
+
+	return rowMap, nil
+}
+
+// NodeHealth returns the health of the node pools, a replica is unhealthy when its last lag check failed.
+func (dbPool DBPoolCluster) NodeHealth() []DBPoolHealth {
+	primaryList, replicaList := dbPool.nodePools()
+	var healthList []DBPoolHealth
+
+	for _, nodePool := range append(primaryList, replicaList...) {
+		healthList = append(healthList, nodePool.Health())
 	}
 
-	return mariaPoolCluster, err
+	return healthList
 }
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+func newTestCluster(nodeResults map[string]map[string]testResult) DBPoolCluster {
+	cluster := DBPoolCluster{
+		DBNode: []DBNode{
+			{NodeName: "primary1", Role: NodePrimary},
+			{NodeName: "replica1", Role: NodeReplica},
+			{NodeName: "replica2", Role: NodeReplica},
+		},
+		RegisterName: "crm-galera",
+		state:        &dbPoolState{clusterNodes: make(map[string]DBPool)},
+	}
+
+	for nodeName, results := range nodeResults {
+		nodePool := newTestDBPool(&testConnector{results: results})
+		nodePool.Name = nodeName
+		cluster.Logger = nodePool.Logger
+		cluster.state.clusterNodes[nodeName] = nodePool
+	}
+
+	return cluster
+}
+
+func slaveStatusResult(secondsBehindMaster driver.Value) testResult {
+	return testResult{
+		columns: []string{"Slave_IO_State", "Seconds_Behind_Master"},
+		rows:    [][]driver.Value{{"Waiting for master to send event", secondsBehindMaster}},
+	}
+}
+
+func TestClusterReadPool(t *testing.T) {
+	cluster := newTestCluster(map[string]map[string]testResult{
+		"primary1": {},
+		"replica1": {"SHOW SLAVE STATUS": slaveStatusResult(int64(120))},
+		"replica2": {
+			"SHOW SLAVE STATUS":                    {columns: []string{"Slave_IO_State"}},
+			"SHOW STATUS LIKE 'wsrep_local_state'": {columns: []string{"Variable_name", "Value"}, rows: [][]driver.Value{{"wsrep_local_state", "4"}}},
+		},
+	})
+	defer cluster.CloseMariaDBPoolCluster()
+
+	// replica1 lags 120 seconds, the reads go to the synced Galera node replica2
+	for i := 0; i < 4; i++ {
+		if readPool := cluster.ReadPool(context.Background()); readPool.Name != "replica2" {
+			t.Errorf("read %d expected replica2 but got %s", i, readPool.Name)
+		}
+	}
+
+	if primaryPool := cluster.PrimaryPool(""); primaryPool.Name != "primary1" {
+		t.Errorf("expected primary1 but got %s", primaryPool.Name)
+	}
+
+	healthList := cluster.NodeHealth()
+	if len(healthList) != 3 || healthList[1].Name != "replica1" || healthList[1].Healthy || healthList[1].ReplicaLag != "2m0s" {
+		t.Errorf("unexpected health %+v", healthList)
+	}
+}
+
+func TestClusterReadPoolFallback(t *testing.T) {
+	cluster := newTestCluster(map[string]map[string]testResult{
+		"primary1": {},
+		"replica1": {"SHOW SLAVE STATUS": slaveStatusResult(nil)},
+		"replica2": {
+			"SHOW STATUS LIKE 'wsrep_local_state'": {columns: []string{"Variable_name", "Value"}, rows: [][]driver.Value{{"wsrep_local_state", "2"}}},
+		},
+	})
+
+	if readPool := cluster.ReadPool(context.Background()); readPool.Name != "primary1" {
+		t.Errorf("expected fallback to primary1 but got %s", readPool.Name)
+	}
+
+	cluster.CloseMariaDBPoolCluster()
+
+	// A read in the transaction of the primary stays on the primary
+	cluster = newTestCluster(map[string]map[string]testResult{
+		"primary1": {},
+		"replica1": {"SHOW SLAVE STATUS": slaveStatusResult(int64(0))},
+		"replica2": {"SHOW SLAVE STATUS": slaveStatusResult(int64(0))},
+	})
+	defer cluster.CloseMariaDBPoolCluster()
+
+	opts := newTestTxOptions()
+	WithTx(context.Background(), cluster.PrimaryPool(""), opts, func(tx *Tx) error {
+		if readPool := cluster.ReadPool(tx.Context()); readPool.Name != "primary1" {
+			t.Errorf("expected primary1 in transaction but got %s", readPool.Name)
+		}
+		return nil
+	})
+
+	if readPool := cluster.ReadPool(context.Background()); readPool.Name != "replica1" && readPool.Name != "replica2" {
+		t.Errorf("expected a replica but got %s", readPool.Name)
+	}
+}
+
+func TestClusterReplicaCheckInBackground(t *testing.T) {
+	connector := &testConnector{results: map[string]testResult{"SHOW SLAVE STATUS": slaveStatusResult(int64(0))}}
+	cluster := newTestCluster(map[string]map[string]testResult{"primary1": {}})
+	cluster.DBNode = cluster.DBNode[:2]
+	cluster.ReplicaCheckInterval = 50 * time.Millisecond
+	defer cluster.CloseMariaDBPoolCluster()
+
+	replicaPool := newTestDBPool(connector)
+	replicaPool.Name = "replica1"
+	cluster.state.clusterNodes["replica1"] = replicaPool
+
+	if readPool := cluster.ReadPool(context.Background()); readPool.Name != "replica1" {
+		t.Fatalf("expected replica1 but got %s", readPool.Name)
+	}
+
+	connector.mu.Lock()
+	connector.results["SHOW SLAVE STATUS"] = slaveStatusResult(int64(120))
+	connector.mu.Unlock()
+
+	// ReadPool uses the last check, the lag is found by the background check
+	if readPool := cluster.ReadPool(context.Background()); readPool.Name != "replica1" {
+		t.Fatalf("expected the cached replica1 but got %s", readPool.Name)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for cluster.ReadPool(context.Background()).Name != "primary1" {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the background check to move the reads to primary1")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
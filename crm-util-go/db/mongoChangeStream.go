@@ -0,0 +1,401 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"crm-util-go/common"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The resume token is older than the oplog, or the change stream can not be resumed.
+const (
+	mongoChangeStreamHistoryLost = 286
+	mongoChangeStreamFatalError  = 280
+)
+
+var ErrMongoWatchHistoryLost = errors.New("mongo change stream can not resume from the resume token")
+
+var tokenFileNameRegex = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// ResumeTokenStore keeps the resume token of a watcher, LoadResumeToken returns nil when there is no token.
+type ResumeTokenStore interface {
+	LoadResumeToken(ctx context.Context, name string) (bson.Raw, error)
+	SaveResumeToken(ctx context.Context, name string, token bson.Raw) error
+}
+
+// DecodeFullDocument decodes FullDocument to v, it returns mongo.ErrNoDocuments when the event has no document.
+func (event MongoChangeEvent) DecodeFullDocument(v interface{}) error {
+	if len(event.FullDocument) == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return bson.Unmarshal(event.FullDocument, v)
+}
+
+/*
+MongoWatcher calls onChange for every change of the watched collection, the resume token is saved
+after onChange returns nil. When onChange returns an error the watcher resumes after the last saved
+token with a backoff, the same as a rollback of KafkaConfig.Consumer.
+
+	watcher := db.NewMongoWatcher(dbPool, db.MongoWatcherConfig{
+		Database:         "test",
+		Collection:       "employee",
+		FullDocument:     options.UpdateLookup,
+		ResumeTokenStore: db.NewMongoResumeTokenStore(dbPool, "test", "resumeToken"),
+	})
+
+	wg.Add(1)
+	go watcher.Watch(&wg, transID, func(transID string, event db.MongoChangeEvent) error {
+		var employee Employee
+		err := event.DecodeFullDocument(&employee)
+		...
+	})
+
+	watcher.Shutdown()
+*/
+type MongoWatcher struct {
+	dbPool DBPool
+	config MongoWatcherConfig
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewMongoWatcher(dbPool DBPool, config MongoWatcherConfig) *MongoWatcher {
+	if config.Name == "" {
+		config.Name = config.Database + "." + config.Collection
+	}
+
+	if config.MaxAwaitTime <= 0 {
+		config.MaxAwaitTime = DefaultMongoWatchMaxAwaitTime
+	}
+
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = DefaultMongoWatchRetryBackoff
+	}
+
+	if config.MaxRetryBackoff <= 0 {
+		config.MaxRetryBackoff = DefaultMongoWatchMaxRetryBackoff
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &MongoWatcher{dbPool: dbPool, config: config, ctx: ctx, cancel: cancel}
+}
+
+// Shutdown stops Watch, the event in onChange finishes first.
+func (w *MongoWatcher) Shutdown() {
+	w.cancel()
+}
+
+/*
+Watch blocks until Shutdown, it returns ErrMongoWatchHistoryLost when the saved resume token is no longer in the oplog,
+the token must then be removed from the store to start from the current changes.
+*/
+func (w *MongoWatcher) Watch(wg *sync.WaitGroup, transID string,
+	onChange func(transID string, event MongoChangeEvent) error) error {
+
+	defer wg.Done()
+
+	w.dbPool.Logger.Info(transID, fmt.Sprintf("Starting Mongo Watcher %s", w.config.Name))
+
+	resumeToken, err := w.loadResumeToken(transID)
+
+	if err != nil {
+		return err
+	}
+
+	backoff := w.config.RetryBackoff
+
+	for w.ctx.Err() == nil {
+		var handled bool
+		resumeToken, handled, err = w.watchStream(transID, resumeToken, onChange)
+
+		if err == nil || w.ctx.Err() != nil {
+			break
+		}
+
+		if isChangeStreamFatal(err) {
+			w.dbPool.Logger.Error(transID, "Mongo Watcher "+w.config.Name+" can not resume: "+err.Error(), err)
+			return fmt.Errorf("%w: %v", ErrMongoWatchHistoryLost, err)
+		}
+
+		if handled {
+			backoff = w.config.RetryBackoff
+		}
+
+		w.dbPool.Logger.Error(transID, fmt.Sprintf("Mongo Watcher %s error, resume in %s: %s", w.config.Name, backoff, err.Error()), err)
+
+		select {
+		case <-w.ctx.Done():
+		case <-time.After(backoff):
+		}
+
+		backoff = nextWatchBackoff(backoff, w.config.MaxRetryBackoff)
+	}
+
+	w.dbPool.Logger.Info(transID, fmt.Sprintf("Stopped Mongo Watcher %s", w.config.Name))
+
+	return nil
+}
+
+func (w *MongoWatcher) loadResumeToken(transID string) (bson.Raw, error) {
+	if w.config.ResumeTokenStore == nil {
+		return nil, nil
+	}
+
+	resumeToken, err := w.config.ResumeTokenStore.LoadResumeToken(w.ctx, w.config.Name)
+
+	if err != nil {
+		w.dbPool.Logger.Error(transID, "Mongo Watcher "+w.config.Name+" load resume token error: "+err.Error(), err)
+	}
+
+	return resumeToken, err
+}
+
+func (w *MongoWatcher) saveResumeToken(transID string, resumeToken bson.Raw) error {
+	if w.config.ResumeTokenStore == nil {
+		return nil
+	}
+
+	err := w.config.ResumeTokenStore.SaveResumeToken(context.Background(), w.config.Name, resumeToken)
+
+	if err != nil {
+		w.dbPool.Logger.Error(transID, "Mongo Watcher "+w.config.Name+" save resume token error: "+err.Error(), err)
+	}
+
+	return err
+}
+
+func (w *MongoWatcher) changeStreamOptions(resumeToken bson.Raw) *options.ChangeStreamOptions {
+	opts := options.ChangeStream().SetMaxAwaitTime(w.config.MaxAwaitTime)
+
+	if w.config.FullDocument != "" {
+		opts.SetFullDocument(w.config.FullDocument)
+	}
+
+	if w.config.BatchSize > 0 {
+		opts.SetBatchSize(w.config.BatchSize)
+	}
+
+	if resumeToken != nil {
+		opts.SetResumeAfter(resumeToken)
+	}
+
+	return opts
+}
+
+// watchStream opens the change stream after resumeToken and returns the token of the last handled event.
+func (w *MongoWatcher) watchStream(transID string, resumeToken bson.Raw,
+	onChange func(transID string, event MongoChangeEvent) error) (bson.Raw, bool, error) {
+
+	mongoClient, err := w.dbPool.mongoClient(transID)
+
+	if err != nil {
+		return resumeToken, false, err
+	}
+
+	pipeline := w.config.Pipeline
+	if pipeline == nil {
+		pipeline = mongo.Pipeline{}
+	}
+
+	var changeStream *mongo.ChangeStream
+	database := mongoClient.Database(w.config.Database)
+	opts := w.changeStreamOptions(resumeToken)
+
+	if w.config.Collection == "" {
+		changeStream, err = database.Watch(w.ctx, pipeline, opts)
+	} else {
+		changeStream, err = database.Collection(w.config.Collection).Watch(w.ctx, pipeline, opts)
+	}
+
+	if err != nil {
+		return resumeToken, false, err
+	}
+
+	defer changeStream.Close(context.Background())
+
+	// Without a saved token a failed onChange resumes from the start of this stream
+	if resumeToken == nil {
+		resumeToken = changeStream.ResumeToken()
+	}
+
+	handled := false
+
+	for {
+		if !changeStream.TryNext(w.ctx) {
+			if err = changeStream.Err(); err != nil || w.ctx.Err() != nil {
+				return resumeToken, handled, err
+			}
+
+			// The post batch resume token moves on without events, so a filtered stream does not fall behind the oplog
+			if postBatchToken := changeStream.ResumeToken(); postBatchToken != nil && !bytes.Equal(postBatchToken, resumeToken) {
+				if w.saveResumeToken(transID, postBatchToken) == nil {
+					resumeToken = postBatchToken
+				}
+			}
+
+			continue
+		}
+
+		var event MongoChangeEvent
+
+		if err = changeStream.Decode(&event); err != nil {
+			return resumeToken, handled, err
+		}
+
+		msgTransID := common.NewUUID()
+		w.dbPool.Logger.Info(msgTransID, fmt.Sprintf("Start change on %s.%s, Operation: %s, DocumentKey: %v",
+			event.Namespace.Database, event.Namespace.Collection, event.OperationType, event.DocumentKey))
+
+		if err = onChange(msgTransID, event); err != nil {
+			w.dbPool.Logger.Error(msgTransID, "Mongo Watcher "+w.config.Name+" Rollback Change: "+err.Error(), err)
+			return resumeToken, handled, err
+		}
+
+		eventToken := changeStream.ResumeToken()
+
+		if err = w.saveResumeToken(msgTransID, eventToken); err != nil {
+			return resumeToken, handled, err
+		}
+
+		resumeToken = eventToken
+		handled = true
+	}
+}
+
+func isChangeStreamFatal(err error) bool {
+	var serverErr mongo.ServerError
+
+	if errors.As(err, &serverErr) {
+		return serverErr.HasErrorCode(mongoChangeStreamHistoryLost) || serverErr.HasErrorCode(mongoChangeStreamFatalError)
+	}
+
+	return false
+}
+
+func nextWatchBackoff(backoff time.Duration, maxBackoff time.Duration) time.Duration {
+	backoff *= 2
+
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+
+	return backoff
+}
+
+// MongoResumeTokenStore keeps the resume tokens in a collection, one document per watcher name.
+type MongoResumeTokenStore struct {
+	dbPool         DBPool
+	dbName         string
+	collectionName string
+}
+
+func NewMongoResumeTokenStore(dbPool DBPool, dbName string, collectionName string) MongoResumeTokenStore {
+	return MongoResumeTokenStore{dbPool: dbPool, dbName: dbName, collectionName: collectionName}
+}
+
+type resumeTokenDoc struct {
+	Name        string             `bson:"_id"`
+	Token       bson.Raw           `bson:"token"`
+	UpdatedDate primitive.DateTime `bson:"updatedDate"`
+}
+
+func (store MongoResumeTokenStore) collection(ctx context.Context) (*mongo.Collection, error) {
+	mongoClient, err := store.dbPool.mongoClient(TransIDFromContext(ctx))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return mongoClient.Database(store.dbName).Collection(store.collectionName), nil
+}
+
+func (store MongoResumeTokenStore) LoadResumeToken(ctx context.Context, name string) (bson.Raw, error) {
+	coll, err := store.collection(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ctxFind, cancelFind := context.WithTimeout(ctx, DefaultMongoTimeout)
+	defer cancelFind()
+
+	var tokenDoc resumeTokenDoc
+	err = coll.FindOne(ctxFind, bson.D{{Key: "_id", Value: name}}).Decode(&tokenDoc)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+
+	return tokenDoc.Token, err
+}
+
+func (store MongoResumeTokenStore) SaveResumeToken(ctx context.Context, name string, token bson.Raw) error {
+	coll, err := store.collection(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	ctxSave, cancelSave := context.WithTimeout(ctx, DefaultMongoTimeout)
+	defer cancelSave()
+
+	tokenDoc := resumeTokenDoc{Name: name, Token: token, UpdatedDate: primitive.NewDateTimeFromTime(time.Now())}
+	_, err = coll.ReplaceOne(ctxSave, bson.D{{Key: "_id", Value: name}}, tokenDoc, options.Replace().SetUpsert(true))
+
+	return err
+}
+
+// FileResumeTokenStore keeps the resume token of a watcher in the file Dir/name.token as extended JSON.
+type FileResumeTokenStore struct {
+	Dir string
+}
+
+func (store FileResumeTokenStore) tokenFile(name string) string {
+	return filepath.Join(store.Dir, tokenFileNameRegex.ReplaceAllString(name, "_")+".token")
+}
+
+func (store FileResumeTokenStore) LoadResumeToken(ctx context.Context, name string) (bson.Raw, error) {
+	data, err := os.ReadFile(store.tokenFile(name))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var token bson.Raw
+	err = bson.UnmarshalExtJSON(data, true, &token)
+
+	return token, err
+}
+
+// SaveResumeToken writes a temporary file and renames it, a crash does not leave a partial token.
+func (store FileResumeTokenStore) SaveResumeToken(ctx context.Context, name string, token bson.Raw) error {
+	data, err := bson.MarshalExtJSON(token, true, false)
+
+	if err != nil {
+		return err
+	}
+
+	tokenFile := store.tokenFile(name)
+	tmpFile := tokenFile + ".tmp"
+
+	if err = os.WriteFile(tmpFile, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, tokenFile)
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"crm-util-go/logging"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestFileResumeTokenStore(t *testing.T) {
+	store := FileResumeTokenStore{Dir: t.TempDir()}
+	ctx := context.Background()
+
+	token, err := store.LoadResumeToken(ctx, "test.employee")
+	if err != nil || token != nil {
+		t.Fatalf("expected no token but got %v, %v", token, err)
+	}
+
+	savedToken, _ := bson.Marshal(bson.D{{Key: "_data", Value: "8263A1B2C3000000012B022C0100296E5A1004"}})
+
+	if err = store.SaveResumeToken(ctx, "test.employee", savedToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if token, err = store.LoadResumeToken(ctx, "test.employee"); err != nil || token.String() != bson.Raw(savedToken).String() {
+		t.Errorf("expected %v but got %v, %v", bson.Raw(savedToken), token, err)
+	}
+}
+
+func TestMongoChangeEvent(t *testing.T) {
+	rowID := primitive.NewObjectID()
+	data, _ := bson.Marshal(bson.D{
+		{Key: "_id", Value: bson.D{{Key: "_data", Value: "826"}}},
+		{Key: "operationType", Value: "update"},
+		{Key: "ns", Value: bson.D{{Key: "db", Value: "test"}, {Key: "coll", Value: "employee"}}},
+		{Key: "documentKey", Value: bson.D{{Key: "_id", Value: rowID}}},
+		{Key: "fullDocument", Value: bson.D{{Key: "_id", Value: rowID}, {Key: "campName", Value: "Campaign 1"}}},
+		{Key: "updateDescription", Value: bson.D{{Key: "updatedFields", Value: bson.D{{Key: "campName", Value: "Campaign 1"}}}}},
+	})
+
+	var event MongoChangeEvent
+	if err := bson.Unmarshal(data, &event); err != nil {
+		t.Fatal(err)
+	}
+
+	var campaign testCampaignDoc
+	if err := event.DecodeFullDocument(&campaign); err != nil || campaign.CampName != "Campaign 1" || *campaign.RowID != rowID {
+		t.Errorf("unexpected document %+v, %v", campaign, err)
+	}
+
+	if event.Namespace.Collection != "employee" || event.UpdateDescription == nil || event.UpdateDescription.UpdatedFields["campName"] != "Campaign 1" {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	if err := (MongoChangeEvent{}).DecodeFullDocument(&campaign); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("expected mongo.ErrNoDocuments but got %v", err)
+	}
+
+	if !isChangeStreamFatal(mongo.CommandError{Code: mongoChangeStreamHistoryLost}) || isChangeStreamFatal(errors.New("connection reset")) {
+		t.Error("unexpected isChangeStreamFatal")
+	}
+
+	if backoff := nextWatchBackoff(40*time.Second, time.Minute); backoff != time.Minute {
+		t.Errorf("expected max backoff but got %s", backoff)
+	}
+}
+
+func TestMongoWatcherShutdown(t *testing.T) {
+	logger := logging.InitUtilLogger("crm-util-go", logging.CrmDatabase)
+	logger.Level = logging.LEVEL_OFF
+
+	watcher := NewMongoWatcher(DBPool{Logger: logger}, MongoWatcherConfig{
+		Database:         "test",
+		Collection:       "employee",
+		ResumeTokenStore: FileResumeTokenStore{Dir: t.TempDir()},
+	})
+	watcher.Shutdown()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	err := watcher.Watch(&wg, "watch-test", func(transID string, event MongoChangeEvent) error {
+		t.Error("unexpected event")
+		return nil
+	})
+
+	wg.Wait()
+
+	if err != nil || watcher.config.Name != "test.employee" {
+		t.Errorf("unexpected watcher %+v, %v", watcher.config, err)
+	}
+}
@@ -3,7 +3,7 @@ package db
 import (
 	"context"
 	"fmt"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -16,62 +16,69 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
-var lockInitMongoDBPool sync.Mutex
-
-var mongoPool *mongo.Client
-var connsCheckedOut int
-
 const (
 	mongoConnectTimeout         = 8 * time.Second
 	mongoServerSelectionTimeout = 30 * time.Second
 	mongoWriteTimeout           = 8 * time.Second
 )
 
-func (dbPool DBPool) handleMongoPoolMonitor(evt *event.PoolEvent) {
-	switch evt.Type {
-	case event.GetSucceeded:
-		connsCheckedOut++
-	case event.ConnectionReturned:
-		connsCheckedOut--
-	case event.PoolClosedEvent:
-		connsCheckedOut = 0
-	}
+func (dbPool DBPool) handleMongoPoolMonitor(st *dbPoolState) func(evt *event.PoolEvent) {
+	return func(evt *event.PoolEvent) {
+		var connsCheckedOut int64
+
+		switch evt.Type {
+		case event.GetSucceeded:
+			connsCheckedOut = atomic.AddInt64(&st.connsCheckedOut, 1)
+		case event.ConnectionReturned:
+			connsCheckedOut = atomic.AddInt64(&st.connsCheckedOut, -1)
+		case event.ConnectionCreated:
+			atomic.AddInt64(&st.connsOpen, 1)
+			connsCheckedOut = atomic.LoadInt64(&st.connsCheckedOut)
+		case event.ConnectionClosed:
+			atomic.AddInt64(&st.connsOpen, -1)
+			connsCheckedOut = atomic.LoadInt64(&st.connsCheckedOut)
+		case event.PoolClosedEvent:
+			atomic.StoreInt64(&st.connsCheckedOut, 0)
+			atomic.StoreInt64(&st.connsOpen, 0)
+		default:
+			connsCheckedOut = atomic.LoadInt64(&st.connsCheckedOut)
+		}
 
-	if evt.PoolOptions != nil {
-		dbPool.Logger.Trace("", fmt.Sprintf("Mongo DB Event: %s, Address: %s, PoolOptions: %+v, ConnsCheckedOut: %d, Reason: %s",
-			evt.Type, evt.Address, *evt.PoolOptions, connsCheckedOut, evt.Reason))
-	} else {
-		dbPool.Logger.Trace("", fmt.Sprintf("Mongo DB Event: %s, Address: %s, PoolOptions: null, ConnsCheckedOut: %d, Reason: %s",
-			evt.Type, evt.Address, connsCheckedOut, evt.Reason))
+		if evt.PoolOptions != nil {
+			dbPool.Logger.Trace("", fmt.Sprintf("Mongo DB Event: %s, Address: %s, PoolOptions: %+v, ConnsCheckedOut: %d, Reason: %s",
+				evt.Type, evt.Address, *evt.PoolOptions, connsCheckedOut, evt.Reason))
+		} else {
+			dbPool.Logger.Trace("", fmt.Sprintf("Mongo DB Event: %s, Address: %s, PoolOptions: null, ConnsCheckedOut: %d, Reason: %s",
+				evt.Type, evt.Address, connsCheckedOut, evt.Reason))
+		}
 	}
 }
 
-func (dbPool DBPool) pingMongoDB(transID string) error {
+func (dbPool DBPool) pingMongoDB(transID string, st *dbPoolState, mongoClient *mongo.Client) error {
 	ctxPing, cancelPing := context.WithTimeout(context.Background(), mongoConnectTimeout)
 	defer cancelPing()
 
-	err := mongoPool.Ping(ctxPing, readpref.Primary())
+	err := mongoClient.Ping(ctxPing, readpref.Primary())
 
 	if err != nil {
 		dbPool.Logger.Error(transID, "Can not verify a connection to Mongo DB because "+err.Error(), err)
 	}
 
+	st.setHealth(err)
+
 	return err
 }
 
-func (dbPool DBPool) initMongoDBPool(transID string) (*mongo.Client, error) {
-	lockInitMongoDBPool.Lock()
-	defer lockInitMongoDBPool.Unlock()
-
+func (dbPool DBPool) initMongoDBPool(transID string, st *dbPoolState) (*mongo.Client, error) {
 	var err error
 
-	if mongoPool != nil {
-		err = dbPool.pingMongoDB(transID)
+	if st.mongoClient != nil {
+		err = dbPool.pingMongoDB(transID, st, st.mongoClient)
 
 		if err != nil {
-			dbPool.Disconnect(transID)
+			dbPool.disconnectMongoDB(transID, st)
 		} else {
-			return mongoPool, err
+			return st.mongoClient, err
 		}
 	}
 
@@ -97,7 +104,7 @@ func (dbPool DBPool) initMongoDBPool(transID string) (*mongo.Client, error) {
 	}
 
 	poolMonitor := &event.PoolMonitor{
-		Event: dbPool.handleMongoPoolMonitor,
+		Event: dbPool.handleMongoPoolMonitor(st),
 	}
 
 	clientOptions.SetPoolMonitor(poolMonitor)
@@ -105,42 +112,49 @@ func (dbPool DBPool) initMongoDBPool(transID string) (*mongo.Client, error) {
 	ctxConn, cancelConn := context.WithTimeout(context.Background(), mongoConnectTimeout)
 	defer cancelConn()
 
-	mongoPool, err = mongo.Connect(ctxConn, clientOptions)
+	mongoClient, err := mongo.Connect(ctxConn, clientOptions)
 
 	if err != nil {
 		dbPool.Logger.Error(transID, "Can not connect to Mongo DB because "+err.Error())
-		return mongoPool, err
+		st.setHealth(err)
+		return mongoClient, err
 	}
 
-	err = dbPool.pingMongoDB(transID)
+	st.mu.Lock()
+	st.mongoClient = mongoClient
+	st.mu.Unlock()
 
-	return mongoPool, err
+	err = dbPool.pingMongoDB(transID, st, mongoClient)
+
+	return mongoClient, err
 }
 
 func (dbPool DBPool) GetMongoDBPool(transID string) (*mongo.Client, error) {
-	var err error
-
-	if mongoPool == nil {
-		return dbPool.initMongoDBPool(transID)
-	}
+	st := dbPool.getState(defaultMongoState)
 
-	err = dbPool.pingMongoDB(transID)
+	mongoClient := st.getMongoClient()
 
-	if err != nil {
-		return dbPool.initMongoDBPool(transID)
+	if mongoClient != nil && dbPool.pingMongoDB(transID, st, mongoClient) == nil {
+		return mongoClient, nil
 	}
 
-	return mongoPool, err
+	st.initMu.Lock()
+	defer st.initMu.Unlock()
+
+	return dbPool.initMongoDBPool(transID, st)
 }
 
-func (dbPool DBPool) Disconnect(transID string) error {
+func (dbPool DBPool) disconnectMongoDB(transID string, st *dbPoolState) error {
 	var err error
 
-	if mongoPool != nil {
-		err = mongoPool.Disconnect(context.Background())
+	if st.mongoClient != nil {
+		err = st.mongoClient.Disconnect(context.Background())
 
 		if err == nil {
-			mongoPool = nil
+			st.mu.Lock()
+			st.mongoClient = nil
+			st.mu.Unlock()
+
 			dbPool.Logger.Info(transID, "Disconnect to Mongo DB success")
 		} else {
 			dbPool.Logger.Error(transID, "Can not disconnect to Mongo DB because "+err.Error())
@@ -150,8 +164,22 @@ func (dbPool DBPool) Disconnect(transID string) error {
 	return err
 }
 
+func (dbPool DBPool) Disconnect(transID string) error {
+	st := dbPool.getState(defaultMongoState)
+
+	st.initMu.Lock()
+	defer st.initMu.Unlock()
+
+	return dbPool.disconnectMongoDB(transID, st)
+}
+
+// GetCollection returns the collection of the pool's own client, the package function GetCollection uses the default pool.
+func (dbPool DBPool) GetCollection(dbName string, collectionName string) *mongo.Collection {
+	return dbPool.getState(defaultMongoState).getMongoClient().Database(dbName).Collection(collectionName)
+}
+
 func GetCollection(dbName string, collectionName string) *mongo.Collection {
-	return mongoPool.Database(dbName).Collection(collectionName)
+	return DBPool{}.GetCollection(dbName, collectionName)
 }
 
 // NewFindOptions pageNo start with 0
@@ -186,7 +214,7 @@ func NewTransactionOptions() *options.TransactionOptions {
 }
 
 func RunCommand(dbName string, cmd interface{}, opts ...*options.RunCmdOptions) *mongo.SingleResult {
-	db := mongoPool.Database(dbName)
+	db := defaultMongoState.getMongoClient().Database(dbName)
 	return db.RunCommand(context.Background(), cmd, opts...)
 }
 
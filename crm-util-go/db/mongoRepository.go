@@ -0,0 +1,444 @@
+package db
+
+import (
+	"context"
+	"crm-util-go/logging"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrMongoIDRequired      = errors.New("mongo document has no _id")
+	ErrMongoVersionRequired = errors.New("mongo document has no version")
+	ErrMongoVersionConflict = errors.New("mongo document was changed by another update")
+)
+
+/*
+MongoRepository reads and writes the documents of one collection as T, the fields of T are mapped by the bson tag.
+Insert, Update and Upsert set the created and updated timestamps and increase the version, Update only changes
+the document of the same version and returns ErrMongoVersionConflict otherwise.
+
+	type Employee struct {
+		RowID       *primitive.ObjectID `bson:"_id,omitempty"`
+		FirstName   *string             `bson:"firstName"`
+		Version     int64               `bson:"version"`
+		CreatedDate *primitive.DateTime `bson:"createdDate,omitempty"`
+		UpdatedDate *primitive.DateTime `bson:"updatedDate,omitempty"`
+	}
+
+	empRepo := db.NewMongoRepository[Employee](dbPool, "test", "employee", db.MongoRepositoryConfig{})
+	employee, err := empRepo.Insert(ctx, Employee{FirstName: pointer.NewString("Paravit")})
+	employee.FirstName = pointer.NewString("Paravit1")
+	employee, err = empRepo.Update(ctx, employee)
+*/
+type MongoRepository[T any] struct {
+	dbPool         DBPool
+	dbName         string
+	collectionName string
+	config         MongoRepositoryConfig
+}
+
+func NewMongoRepository[T any](dbPool DBPool, dbName string, collectionName string, config MongoRepositoryConfig) MongoRepository[T] {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultMongoTimeout
+	}
+
+	if config.VersionField == "" {
+		config.VersionField = DefaultMongoVersionField
+	}
+
+	if config.CreatedField == "" {
+		config.CreatedField = DefaultMongoCreatedField
+	}
+
+	if config.UpdatedField == "" {
+		config.UpdatedField = DefaultMongoUpdatedField
+	}
+
+	return MongoRepository[T]{dbPool: dbPool, dbName: dbName, collectionName: collectionName, config: config}
+}
+
+// mongoClient returns the connected client without a ping, GetMongoDBPool connects the first time.
+func (dbPool DBPool) mongoClient(transID string) (*mongo.Client, error) {
+	if mongoClient := dbPool.getState(defaultMongoState).getMongoClient(); mongoClient != nil {
+		return mongoClient, nil
+	}
+
+	return dbPool.GetMongoDBPool(transID)
+}
+
+func (repo MongoRepository[T]) collection(transID string) (*mongo.Collection, error) {
+	mongoClient, err := repo.dbPool.mongoClient(transID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return mongoClient.Database(repo.dbName).Collection(repo.collectionName), nil
+}
+
+// run calls fn with the collection and a timeout, and logs the request and response with the action of the operation.
+func (repo MongoRepository[T]) run(ctx context.Context, operation string, fn func(ctx context.Context, coll *mongo.Collection) error) error {
+	transID := TransIDFromContext(ctx)
+	action := dbAction("MongoRepository." + operation)
+	startDT := repo.dbPool.Logger.LogRequest(transID, logging.DatabaseClient, "", action)
+	repo.dbPool.Logger.Debug(transID, "Mongo "+operation+" "+repo.dbName+"."+repo.collectionName)
+
+	coll, err := repo.collection(transID)
+
+	if err == nil {
+		if _, found := ctx.Deadline(); !found {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, repo.config.Timeout)
+			defer cancel()
+		}
+
+		err = fn(ctx, coll)
+	}
+
+	repo.dbPool.Logger.LogResponse(transID, logging.DatabaseClient, "", action, repo.dbPool.responseCode(transID, err), startDT)
+
+	return wrapDbError(repo.dbPool, err)
+}
+
+// FindByID returns mongo.ErrNoDocuments when the document is not found.
+func (repo MongoRepository[T]) FindByID(ctx context.Context, id interface{}) (T, error) {
+	return repo.FindOne(ctx, bson.D{{Key: "_id", Value: id}})
+}
+
+func (repo MongoRepository[T]) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (T, error) {
+	var result T
+
+	err := repo.run(ctx, "FindOne", func(ctx context.Context, coll *mongo.Collection) error {
+		return coll.FindOne(ctx, nilToEmptyFilter(filter), opts...).Decode(&result)
+	})
+
+	return result, err
+}
+
+/*
+Find returns the documents of filter, NewFindOptions sets the paging.
+
+	employeeList, err := empRepo.Find(ctx, bson.D{{"age", bson.D{{"$gt", 30}}}}, db.NewFindOptions(10, 0).SetSort(bson.D{{"age", 1}}))
+*/
+func (repo MongoRepository[T]) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]T, error) {
+	resultList := []T{}
+
+	err := repo.run(ctx, "Find", func(ctx context.Context, coll *mongo.Collection) error {
+		cursor, err := coll.Find(ctx, nilToEmptyFilter(filter), opts...)
+
+		if err != nil {
+			return err
+		}
+
+		return cursor.All(ctx, &resultList)
+	})
+
+	return resultList, err
+}
+
+// Count returns the number of documents of filter, the total records of a paging.
+func (repo MongoRepository[T]) Count(ctx context.Context, filter interface{}) (int64, error) {
+	var count int64
+
+	err := repo.run(ctx, "Count", func(ctx context.Context, coll *mongo.Collection) error {
+		var err error
+		count, err = coll.CountDocuments(ctx, nilToEmptyFilter(filter))
+		return err
+	})
+
+	return count, err
+}
+
+// Insert returns the inserted document with its _id, timestamps and version 1.
+func (repo MongoRepository[T]) Insert(ctx context.Context, doc T) (T, error) {
+	var result T
+
+	err := repo.run(ctx, "Insert", func(ctx context.Context, coll *mongo.Collection) error {
+		insertDoc, err := repo.insertDocument(doc, time.Now())
+
+		if err != nil {
+			return err
+		}
+
+		if _, err = coll.InsertOne(ctx, insertDoc); err != nil {
+			return err
+		}
+
+		return decodeDocument(insertDoc, &result)
+	})
+
+	return result, err
+}
+
+// Update sets the fields of doc to the document of the same _id and version, and returns the updated document.
+func (repo MongoRepository[T]) Update(ctx context.Context, doc T) (T, error) {
+	var result T
+
+	err := repo.run(ctx, "Update", func(ctx context.Context, coll *mongo.Collection) error {
+		filter, update, err := repo.updateDocument(doc, time.Now())
+
+		if err != nil {
+			return err
+		}
+
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&result)
+
+		if errors.Is(err, mongo.ErrNoDocuments) && !repo.config.DisableVersion {
+			// The _id exists with another version
+			count, countErr := coll.CountDocuments(ctx, filter[:1])
+
+			if countErr != nil {
+				return countErr
+			} else if count > 0 {
+				return ErrMongoVersionConflict
+			}
+		}
+
+		return err
+	})
+
+	return result, err
+}
+
+// Upsert updates the document of filter or inserts doc when no document matches, without a version check.
+func (repo MongoRepository[T]) Upsert(ctx context.Context, filter interface{}, doc T) (T, error) {
+	var result T
+
+	err := repo.run(ctx, "Upsert", func(ctx context.Context, coll *mongo.Collection) error {
+		update, err := repo.upsertDocument(doc, time.Now())
+
+		if err != nil {
+			return err
+		}
+
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetUpsert(true)
+		return coll.FindOneAndUpdate(ctx, nilToEmptyFilter(filter), update, opts).Decode(&result)
+	})
+
+	return result, err
+}
+
+// Delete returns mongo.ErrNoDocuments when the document is not found.
+func (repo MongoRepository[T]) Delete(ctx context.Context, id interface{}) error {
+	return repo.run(ctx, "Delete", func(ctx context.Context, coll *mongo.Collection) error {
+		deleteResult, err := coll.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+
+		if err == nil && deleteResult.DeletedCount == 0 {
+			err = mongo.ErrNoDocuments
+		}
+
+		return err
+	})
+}
+
+// WithTransaction runs fn in a transaction of the repository's pool, see WithMongoTransaction.
+func (repo MongoRepository[T]) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return WithMongoTransaction(ctx, repo.dbPool, fn)
+}
+
+/*
+WithMongoTransaction runs fn in a transaction with NewTransactionOptions, the repository calls with the ctx of fn
+join the transaction. The transaction is committed when fn returns nil, and fn is retried on a transient error
+so it must not have other side effects. A call inside a transaction joins the outer transaction.
+
+	err := db.WithMongoTransaction(ctx, dbPool, func(ctx context.Context) error {
+		if _, err := empRepo.Insert(ctx, employee1); err != nil {
+			return err
+		}
+		_, err := empRepo.Insert(ctx, employee2)
+		return err
+	})
+*/
+func WithMongoTransaction(ctx context.Context, dbPool DBPool, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	transID := TransIDFromContext(ctx)
+	mongoClient, err := dbPool.mongoClient(transID)
+
+	if err != nil {
+		return wrapDbError(dbPool, err)
+	}
+
+	session, err := mongoClient.StartSession()
+
+	if err != nil {
+		dbPool.Logger.Error(transID, "Error Start New Session "+err.Error(), err)
+		return wrapDbError(dbPool, err)
+	}
+
+	defer session.EndSession(context.Background())
+
+	_, err = session.WithTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionContext)
+	}, NewTransactionOptions())
+
+	if err != nil {
+		dbPool.Logger.Error(transID, "Mongo transaction error "+err.Error(), err)
+	}
+
+	return err
+}
+
+// insertDocument adds the _id, timestamps and version 1 to doc.
+func (repo MongoRepository[T]) insertDocument(doc T, now time.Time) (bson.D, error) {
+	document, err := toDocument(doc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	id, found := lookupDocument(document, "_id")
+
+	if !found || id == nil {
+		id = primitive.NewObjectID()
+	}
+
+	insertDoc := bson.D{{Key: "_id", Value: id}}
+	insertDoc = append(insertDoc, repo.withoutManagedFields(document)...)
+
+	if !repo.config.DisableTimestamps {
+		dateTime := primitive.NewDateTimeFromTime(now)
+		insertDoc = append(insertDoc,
+			bson.E{Key: repo.config.CreatedField, Value: dateTime},
+			bson.E{Key: repo.config.UpdatedField, Value: dateTime})
+	}
+
+	if !repo.config.DisableVersion {
+		insertDoc = append(insertDoc, bson.E{Key: repo.config.VersionField, Value: int64(1)})
+	}
+
+	return insertDoc, nil
+}
+
+// updateDocument returns the filter of _id and version, and the update which sets the fields of doc.
+func (repo MongoRepository[T]) updateDocument(doc T, now time.Time) (filter bson.D, update bson.D, err error) {
+	document, err := toDocument(doc)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	id, found := lookupDocument(document, "_id")
+
+	if !found || id == nil {
+		return nil, nil, ErrMongoIDRequired
+	}
+
+	filter = bson.D{{Key: "_id", Value: id}}
+
+	if !repo.config.DisableVersion {
+		version, found := lookupDocument(document, repo.config.VersionField)
+
+		if !found || version == nil {
+			return nil, nil, ErrMongoVersionRequired
+		}
+
+		filter = append(filter, bson.E{Key: repo.config.VersionField, Value: version})
+	}
+
+	return filter, repo.setDocument(document, now, false), nil
+}
+
+func (repo MongoRepository[T]) upsertDocument(doc T, now time.Time) (bson.D, error) {
+	document, err := toDocument(doc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.setDocument(document, now, true), nil
+}
+
+func (repo MongoRepository[T]) setDocument(document bson.D, now time.Time, upsert bool) bson.D {
+	setDoc := repo.withoutManagedFields(document)
+
+	if !repo.config.DisableTimestamps {
+		setDoc = append(setDoc, bson.E{Key: repo.config.UpdatedField, Value: primitive.NewDateTimeFromTime(now)})
+	}
+
+	update := bson.D{{Key: "$set", Value: setDoc}}
+
+	if upsert && !repo.config.DisableTimestamps {
+		update = append(update, bson.E{Key: "$setOnInsert", Value: bson.D{{Key: repo.config.CreatedField, Value: primitive.NewDateTimeFromTime(now)}}})
+	}
+
+	if !repo.config.DisableVersion {
+		update = append(update, bson.E{Key: "$inc", Value: bson.D{{Key: repo.config.VersionField, Value: int64(1)}}})
+	}
+
+	return update
+}
+
+// withoutManagedFields removes _id, version and timestamps which are set by the repository.
+func (repo MongoRepository[T]) withoutManagedFields(document bson.D) bson.D {
+	fieldList := make(bson.D, 0, len(document))
+
+	for _, element := range document {
+		switch element.Key {
+		case "_id":
+			continue
+		case repo.config.VersionField:
+			if !repo.config.DisableVersion {
+				continue
+			}
+		case repo.config.CreatedField, repo.config.UpdatedField:
+			if !repo.config.DisableTimestamps {
+				continue
+			}
+		}
+
+		fieldList = append(fieldList, element)
+	}
+
+	return fieldList
+}
+
+func toDocument(doc interface{}) (bson.D, error) {
+	data, err := bson.Marshal(doc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var document bson.D
+	err = bson.Unmarshal(data, &document)
+
+	return document, err
+}
+
+func decodeDocument(document bson.D, result interface{}) error {
+	data, err := bson.Marshal(document)
+
+	if err != nil {
+		return err
+	}
+
+	return bson.Unmarshal(data, result)
+}
+
+func lookupDocument(document bson.D, key string) (interface{}, bool) {
+	for _, element := range document {
+		if element.Key == key {
+			return element.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+func nilToEmptyFilter(filter interface{}) interface{} {
+	if filter == nil {
+		return bson.D{}
+	}
+
+	return filter
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"crm-util-go/logging"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type testCampaignDoc struct {
+	RowID       *primitive.ObjectID `bson:"_id,omitempty"`
+	CampName    string              `bson:"campName"`
+	Score       int                 `bson:"score"`
+	Version     int64               `bson:"version"`
+	CreatedDate *primitive.DateTime `bson:"createdDate,omitempty"`
+	UpdatedDate *primitive.DateTime `bson:"updatedDate,omitempty"`
+}
+
+func TestMongoRepositoryInsertDocument(t *testing.T) {
+	repo := NewMongoRepository[testCampaignDoc](DBPool{}, "test", "campaign", MongoRepositoryConfig{})
+	now := time.Date(2021, 11, 2, 23, 59, 59, 0, time.UTC)
+
+	insertDoc, err := repo.insertDocument(testCampaignDoc{CampName: "Campaign 1", Score: 10, Version: 5}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result testCampaignDoc
+	if err = decodeDocument(insertDoc, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.RowID == nil || result.RowID.IsZero() || result.CampName != "Campaign 1" || result.Version != 1 {
+		t.Errorf("unexpected inserted document %+v", result)
+	}
+
+	if result.CreatedDate == nil || result.CreatedDate.Time().UTC() != now || *result.UpdatedDate != *result.CreatedDate {
+		t.Errorf("unexpected timestamps %+v", result)
+	}
+
+	repo = NewMongoRepository[testCampaignDoc](DBPool{}, "test", "campaign", MongoRepositoryConfig{DisableVersion: true, DisableTimestamps: true})
+
+	if insertDoc, err = repo.insertDocument(testCampaignDoc{Version: 5}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if version, _ := lookupDocument(insertDoc, "version"); version != int64(5) {
+		t.Errorf("expected the version of the document but got %v", version)
+	}
+
+	if _, found := lookupDocument(insertDoc, "createdDate"); found {
+		t.Errorf("unexpected createdDate %v", insertDoc)
+	}
+}
+
+func TestMongoRepositoryUpdateDocument(t *testing.T) {
+	repo := NewMongoRepository[testCampaignDoc](DBPool{}, "test", "campaign", MongoRepositoryConfig{})
+	now := time.Now()
+	rowID := primitive.NewObjectID()
+	createdDate := primitive.NewDateTimeFromTime(now.Add(-time.Hour))
+
+	if _, _, err := repo.updateDocument(testCampaignDoc{CampName: "Campaign 1"}, now); !errors.Is(err, ErrMongoIDRequired) {
+		t.Errorf("expected ErrMongoIDRequired but got %v", err)
+	}
+
+	filter, update, err := repo.updateDocument(testCampaignDoc{RowID: &rowID, CampName: "Campaign 1", Version: 3, CreatedDate: &createdDate}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedFilter := bson.D{{Key: "_id", Value: rowID}, {Key: "version", Value: int64(3)}}
+	if !equalDocument(filter, expectedFilter) {
+		t.Errorf("unexpected filter %v", filter)
+	}
+
+	expectedUpdate := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "campName", Value: "Campaign 1"}, {Key: "score", Value: int32(0)}, {Key: "updatedDate", Value: primitive.NewDateTimeFromTime(now)}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: int64(1)}}},
+	}
+	if !equalDocument(update, expectedUpdate) {
+		t.Errorf("unexpected update %v", update)
+	}
+
+	update, err = repo.upsertDocument(testCampaignDoc{RowID: &rowID, CampName: "Campaign 2"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if setOnInsert, found := lookupDocument(update, "$setOnInsert"); !found || !equalDocument(setOnInsert.(bson.D), bson.D{{Key: "createdDate", Value: primitive.NewDateTimeFromTime(now)}}) {
+		t.Errorf("unexpected upsert %v", update)
+	}
+}
+
+func equalDocument(document bson.D, expected bson.D) bool {
+	data, err := bson.MarshalExtJSON(document, true, false)
+	expectedData, expectedErr := bson.MarshalExtJSON(expected, true, false)
+
+	return err == nil && expectedErr == nil && string(data) == string(expectedData)
+}
+
+func TestMongoRepositoryLogAction(t *testing.T) {
+	logger := logging.InitUtilLogger("crm-util-go", logging.CrmDatabase)
+	readLog := readTestLog(t, logger)
+
+	// The invalid uri fails before a connection, the request and response are still logged.
+	dbPool := DBPool{Name: "crm-test", DataSourceName: "invalid-uri", Logger: logger, state: &dbPoolState{}}
+	repo := NewMongoRepository[testCampaignDoc](dbPool, "test", "campaign", MongoRepositoryConfig{})
+
+	if _, err := repo.Find(context.Background(), nil); err == nil {
+		t.Fatal("expected the invalid uri to fail")
+	}
+
+	if logText := readLog(); strings.Count(logText, "func: MongoRepository.Find\"") != 2 {
+		t.Errorf("expected the request and response of MongoRepository.Find in the log\n%s", logText)
+	}
+}
@@ -5,10 +5,8 @@ import (
 	"crm-util-go/common"
 	"crm-util-go/errorcode"
 	"database/sql"
-	"fmt"
 	_ "github.com/mattn/go-oci8"
 	"strings"
-	"sync"
 )
 
 // [username/[password]@]host[:port][/service_name][?param1=value1&...&paramN=valueN]
@@ -25,82 +23,15 @@ import (
 //
 // questionph - when true, enables question mark placeholders. Defaults to false. (uses strconv.ParseBool to check for true)
 
-var lockInitOracleDBPool sync.Mutex
-var oraclePool *sql.DB
-var countFailOracle int
-
-func (dbPool DBPool) initOracleDBPool(transID string) *sql.DB {
-
-	lockInitOracleDBPool.Lock()
-	defer lockInitOracleDBPool.Unlock()
-
-	var err error
-
-	if oraclePool != nil {
-		if countFailOracle < DbMaxFailTimes {
-			return oraclePool
-		}
-
-		err = oraclePool.PingContext(context.Background())
-
-		if err != nil {
-			oraclePool.Close()
-		} else {
-			countFailOracle = 0
-			return oraclePool
-		}
-	}
-
-	oraclePool, err = sql.Open("oci8", dbPool.DataSourceName)
-
-	if err != nil {
-		dbPool.Logger.Error(transID, "Can not initial Oracle connection pool", err)
-	}
-
-	if oraclePool != nil {
-		oraclePool.SetMaxOpenConns(dbPool.MaxOpenConns)
-		oraclePool.SetMaxIdleConns(dbPool.MaxIdleConns)
-		countFailOracle = 0
-		dbPool.Logger.Info(transID, "Init OracleDBPool success")
-	}
-
-	return oraclePool
-}
-
 func (dbPool DBPool) GetOracleDBPool(transID string) (*sql.DB, error) {
-	var err error
-
-	if oraclePool == nil {
-		oraclePool = dbPool.initOracleDBPool(transID)
-	}
-
-	dbPool.Logger.Debug(transID, fmt.Sprintf("Oracle DB Stat: %+v", oraclePool.Stats()))
-
-	err = oraclePool.PingContext(context.Background())
-
-	if err != nil {
-		dbPool.Logger.Error(transID, "Can not verify a connection to Oracle DB because "+err.Error(), err)
-
-		countFailOracle++
-
-		if countFailOracle > DbMaxFailTimes {
-			oraclePool = dbPool.initOracleDBPool(transID)
-		}
-	} else {
-		countFailOracle = 0
-	}
-
-	return oraclePool, err
+	return dbPool.getSQLDB(transID, dbPool.getState(defaultOracleState), "Oracle", dbPool.sqlOpener("oci8"))
 }
 
 func (dbPool DBPool) CloseOracleDBPool() error {
-	if oraclePool != nil {
-		return oraclePool.Close()
-	}
-
-	return nil
+	return dbPool.getState(defaultOracleState).closeSQLDB()
 }
 
+// BeginTransactionOracle starts a serializable transaction, WithTx commits and rolls back automatically.
 func (dbPool DBPool) BeginTransactionOracle(transID string, errCode errorcode.CrmErrorCode) (*sql.Tx, *errorcode.CrmErrorCodeResp) {
 	oraclePool, err := dbPool.GetOracleDBPool(transID)
 
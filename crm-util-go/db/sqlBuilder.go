@@ -0,0 +1,284 @@
+package db
+
+import (
+	"crm-util-go/common"
+	"strings"
+)
+
+/*
+SelectBuilder builds a SELECT with ? bind parameters, Build converts them to :1, :2 for Oracle.
+
+	query := db.Select("CAMP_TRANS_ID", "STATUS", "CREATED_DATE").From("CAMPAIGN_TRANS").
+		Where("STATUS = ?", status).
+		WhereIn("SUB_STATUS", "Wait", "Call").
+		OrderBy("CREATED_DATE DESC", "CAMP_TRANS_ID DESC").
+		Page(pageNo, 50)
+
+	sqlStmt, args := query.Build(db.DialectMaria)
+	countSQL, countArgs := query.BuildCount(db.DialectMaria)
+
+Keyset pagination continues after the last row of the previous page with the values of the OrderBy columns.
+
+	sqlStmt, args := query.SeekAfter(lastCreatedDate, lastCampTransID).Limit(50).Build(dbPool.Dialect())
+*/
+type SelectBuilder struct {
+	columns   []string
+	from      string
+	where     []string
+	args      []interface{}
+	orderBy   []string
+	seekAfter []interface{}
+	limit     int
+	offset    int
+}
+
+func Select(columns ...string) SelectBuilder {
+	return SelectBuilder{columns: columns}
+}
+
+// Dialect returns DialectOracle for an Oracle pool, use DialectOracle12c for OFFSET/FETCH.
+func (dbPool DBPool) Dialect() SQLDialect {
+	if dbPool.Driver == DriverOracle {
+		return DialectOracle
+	}
+
+	return DialectMaria
+}
+
+// From is the table name or the table with joins.
+func (q SelectBuilder) From(from string) SelectBuilder {
+	q.from = from
+	return q
+}
+
+// Where adds a condition with AND, the condition uses ? for each arg.
+func (q SelectBuilder) Where(condition string, args ...interface{}) SelectBuilder {
+	q.where = append(q.where[:len(q.where):len(q.where)], condition)
+	q.args = append(q.args[:len(q.args):len(q.args)], args...)
+	return q
+}
+
+// WhereIn adds "column IN (?, ?)", an empty values adds a false condition.
+func (q SelectBuilder) WhereIn(column string, values ...interface{}) SelectBuilder {
+	if len(values) == 0 {
+		return q.Where("1 = 0")
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+	return q.Where(column+" IN ("+placeholders+")", values...)
+}
+
+// OrderBy columns can end with ASC or DESC.
+func (q SelectBuilder) OrderBy(orderBy ...string) SelectBuilder {
+	q.orderBy = append(q.orderBy[:len(q.orderBy):len(q.orderBy)], orderBy...)
+	return q
+}
+
+func (q SelectBuilder) Limit(limit int) SelectBuilder {
+	q.limit = limit
+	return q
+}
+
+func (q SelectBuilder) Offset(offset int) SelectBuilder {
+	q.offset = offset
+	return q
+}
+
+// Page sets Limit and Offset, pageNo starts with 1.
+func (q SelectBuilder) Page(pageNo int, pageSize int) SelectBuilder {
+	if pageNo < 1 {
+		pageNo = 1
+	}
+
+	q.limit = pageSize
+	q.offset = (pageNo - 1) * pageSize
+	return q
+}
+
+// SeekAfter sets the OrderBy values of the last row, the OrderBy must be unique and has the same number of columns.
+func (q SelectBuilder) SeekAfter(values ...interface{}) SelectBuilder {
+	q.seekAfter = values
+	q.offset = 0
+	return q
+}
+
+// seekCondition expands (A, B) > (?, ?) to A > ? OR (A = ? AND B > ?), which works on every dialect.
+func (q SelectBuilder) seekCondition() (string, []interface{}) {
+	var orList []string
+	var args []interface{}
+
+	for i := 0; i < len(q.seekAfter) && i < len(q.orderBy); i++ {
+		var andList []string
+
+		for j := 0; j < i; j++ {
+			column, _ := splitOrderBy(q.orderBy[j])
+			andList = append(andList, column+" = ?")
+			args = append(args, q.seekAfter[j])
+		}
+
+		column, desc := splitOrderBy(q.orderBy[i])
+		if desc {
+			andList = append(andList, column+" < ?")
+		} else {
+			andList = append(andList, column+" > ?")
+		}
+		args = append(args, q.seekAfter[i])
+
+		orList = append(orList, "("+strings.Join(andList, " AND ")+")")
+	}
+
+	return "(" + strings.Join(orList, " OR ") + ")", args
+}
+
+func splitOrderBy(orderBy string) (column string, desc bool) {
+	fields := strings.Fields(orderBy)
+
+	if len(fields) > 1 && strings.EqualFold(fields[len(fields)-1], "DESC") {
+		return strings.Join(fields[:len(fields)-1], " "), true
+	}
+
+	if len(fields) > 1 && strings.EqualFold(fields[len(fields)-1], "ASC") {
+		return strings.Join(fields[:len(fields)-1], " "), false
+	}
+
+	return orderBy, false
+}
+
+func (q SelectBuilder) buildWhere(sqlBuilder *strings.Builder, withSeek bool) []interface{} {
+	where := q.where
+	args := append([]interface{}{}, q.args...)
+
+	if withSeek && len(q.seekAfter) > 0 {
+		condition, seekArgs := q.seekCondition()
+		where = append(where[:len(where):len(where)], condition)
+		args = append(args, seekArgs...)
+	}
+
+	if len(where) > 0 {
+		sqlBuilder.WriteString(" WHERE ")
+		sqlBuilder.WriteString(strings.Join(where, " AND "))
+	}
+
+	return args
+}
+
+// Build returns the SQL and args for Prepare or the query helpers.
+func (q SelectBuilder) Build(dialect SQLDialect) (string, []interface{}) {
+	var sqlBuilder strings.Builder
+	sqlBuilder.WriteString("SELECT ")
+
+	if len(q.columns) > 0 {
+		sqlBuilder.WriteString(strings.Join(q.columns, ", "))
+	} else {
+		sqlBuilder.WriteString("*")
+	}
+
+	sqlBuilder.WriteString(" FROM ")
+	sqlBuilder.WriteString(q.from)
+
+	args := q.buildWhere(&sqlBuilder, true)
+
+	if len(q.orderBy) > 0 {
+		sqlBuilder.WriteString(" ORDER BY ")
+		sqlBuilder.WriteString(strings.Join(q.orderBy, ", "))
+	}
+
+	sqlStmt := sqlBuilder.String()
+
+	if q.limit > 0 || q.offset > 0 {
+		sqlStmt = q.paging(dialect, sqlStmt)
+	}
+
+	if dialect == DialectOracle || dialect == DialectOracle12c {
+		sqlStmt = rebindOracle(sqlStmt)
+	}
+
+	return sqlStmt, args
+}
+
+// BuildCount returns SELECT COUNT(1) with the same conditions, without order, seek and paging.
+func (q SelectBuilder) BuildCount(dialect SQLDialect) (string, []interface{}) {
+	var sqlBuilder strings.Builder
+	sqlBuilder.WriteString("SELECT COUNT(1) AS TOTAL FROM ")
+	sqlBuilder.WriteString(q.from)
+
+	args := q.buildWhere(&sqlBuilder, false)
+	sqlStmt := sqlBuilder.String()
+
+	if dialect == DialectOracle || dialect == DialectOracle12c {
+		sqlStmt = rebindOracle(sqlStmt)
+	}
+
+	return sqlStmt, args
+}
+
+func (q SelectBuilder) paging(dialect SQLDialect, sqlStmt string) string {
+	var sqlBuilder strings.Builder
+
+	switch dialect {
+	case DialectOracle:
+		// The same ROWNUM paging as GenerateSQLPagingOracle
+		sqlBuilder.WriteString("SELECT DATA2.* FROM (SELECT ROWNUM MYNUM, DATA1.* FROM (")
+		sqlBuilder.WriteString(sqlStmt)
+		sqlBuilder.WriteString(") DATA1")
+
+		if q.limit > 0 {
+			sqlBuilder.WriteString(" WHERE ROWNUM <= ")
+			sqlBuilder.WriteString(common.IntToString(q.offset + q.limit))
+		}
+
+		sqlBuilder.WriteString(") DATA2 WHERE MYNUM > ")
+		sqlBuilder.WriteString(common.IntToString(q.offset))
+	case DialectOracle12c:
+		sqlBuilder.WriteString(sqlStmt)
+		sqlBuilder.WriteString(" OFFSET ")
+		sqlBuilder.WriteString(common.IntToString(q.offset))
+		sqlBuilder.WriteString(" ROWS")
+
+		if q.limit > 0 {
+			sqlBuilder.WriteString(" FETCH NEXT ")
+			sqlBuilder.WriteString(common.IntToString(q.limit))
+			sqlBuilder.WriteString(" ROWS ONLY")
+		}
+	default:
+		sqlBuilder.WriteString(sqlStmt)
+
+		if q.limit > 0 {
+			sqlBuilder.WriteString(" LIMIT ")
+			sqlBuilder.WriteString(common.IntToString(q.limit))
+		} else {
+			// MariaDB requires LIMIT with OFFSET
+			sqlBuilder.WriteString(" LIMIT 18446744073709551615")
+		}
+
+		if q.offset > 0 {
+			sqlBuilder.WriteString(" OFFSET ")
+			sqlBuilder.WriteString(common.IntToString(q.offset))
+		}
+	}
+
+	return sqlBuilder.String()
+}
+
+// rebindOracle converts ? to :1, :2 except ? in a string literal.
+func rebindOracle(sqlStmt string) string {
+	var sqlBuilder strings.Builder
+	inQuote := false
+	bindNo := 0
+
+	for _, ch := range sqlStmt {
+		switch {
+		case ch == '\'':
+			inQuote = !inQuote
+			sqlBuilder.WriteRune(ch)
+		case ch == '?' && !inQuote:
+			bindNo++
+			sqlBuilder.WriteString(":")
+			sqlBuilder.WriteString(common.IntToString(bindNo))
+		default:
+			sqlBuilder.WriteRune(ch)
+		}
+	}
+
+	return sqlBuilder.String()
+}
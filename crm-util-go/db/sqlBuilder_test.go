@@ -0,0 +1,67 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelectBuilder(t *testing.T) {
+	query := Select("CAMP_TRANS_ID", "STATUS").From("CAMPAIGN_TRANS").
+		Where("STATUS = ?", "Accept").
+		WhereIn("SUB_STATUS", "Wait", "Call").
+		Where("REMARK <> '?'").
+		OrderBy("CREATED_DATE DESC", "CAMP_TRANS_ID").
+		Page(3, 50)
+
+	testCases := []struct {
+		dialect  SQLDialect
+		expected string
+	}{
+		{DialectMaria, "SELECT CAMP_TRANS_ID, STATUS FROM CAMPAIGN_TRANS WHERE STATUS = ? AND SUB_STATUS IN (?, ?) AND REMARK <> '?' " +
+			"ORDER BY CREATED_DATE DESC, CAMP_TRANS_ID LIMIT 50 OFFSET 100"},
+		{DialectOracle12c, "SELECT CAMP_TRANS_ID, STATUS FROM CAMPAIGN_TRANS WHERE STATUS = :1 AND SUB_STATUS IN (:2, :3) AND REMARK <> '?' " +
+			"ORDER BY CREATED_DATE DESC, CAMP_TRANS_ID OFFSET 100 ROWS FETCH NEXT 50 ROWS ONLY"},
+		{DialectOracle, "SELECT DATA2.* FROM (SELECT ROWNUM MYNUM, DATA1.* FROM (SELECT CAMP_TRANS_ID, STATUS FROM CAMPAIGN_TRANS " +
+			"WHERE STATUS = :1 AND SUB_STATUS IN (:2, :3) AND REMARK <> '?' ORDER BY CREATED_DATE DESC, CAMP_TRANS_ID) DATA1 " +
+			"WHERE ROWNUM <= 150) DATA2 WHERE MYNUM > 100"},
+	}
+
+	for _, testCase := range testCases {
+		sqlStmt, args := query.Build(testCase.dialect)
+
+		if sqlStmt != testCase.expected {
+			t.Errorf("%s expected\n%s\nbut got\n%s", testCase.dialect, testCase.expected, sqlStmt)
+		}
+
+		if !reflect.DeepEqual(args, []interface{}{"Accept", "Wait", "Call"}) {
+			t.Errorf("%s unexpected args %v", testCase.dialect, args)
+		}
+	}
+
+	countSQL, countArgs := query.BuildCount(DialectOracle)
+	expected := "SELECT COUNT(1) AS TOTAL FROM CAMPAIGN_TRANS WHERE STATUS = :1 AND SUB_STATUS IN (:2, :3) AND REMARK <> '?'"
+	if countSQL != expected || len(countArgs) != 3 {
+		t.Errorf("count expected\n%s\nbut got\n%s %v", expected, countSQL, countArgs)
+	}
+}
+
+func TestSelectBuilderSeekAfter(t *testing.T) {
+	base := Select().From("CAMPAIGN_TRANS").Where("STATUS = ?", "Accept").OrderBy("CREATED_DATE DESC", "CAMP_TRANS_ID ASC")
+	sqlStmt, args := base.SeekAfter("2023-05-01", "T9").Limit(20).Build(DialectMaria)
+
+	expected := "SELECT * FROM CAMPAIGN_TRANS WHERE STATUS = ? AND ((CREATED_DATE < ?) OR (CREATED_DATE = ? AND CAMP_TRANS_ID > ?)) " +
+		"ORDER BY CREATED_DATE DESC, CAMP_TRANS_ID ASC LIMIT 20"
+
+	if sqlStmt != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, sqlStmt)
+	}
+
+	if !reflect.DeepEqual(args, []interface{}{"Accept", "2023-05-01", "2023-05-01", "T9"}) {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	// The builder is immutable, base keeps its own conditions
+	if sqlStmt, _ = base.Build(DialectMaria); sqlStmt != "SELECT * FROM CAMPAIGN_TRANS WHERE STATUS = ? ORDER BY CREATED_DATE DESC, CAMP_TRANS_ID ASC" {
+		t.Errorf("unexpected base %s", sqlStmt)
+	}
+}
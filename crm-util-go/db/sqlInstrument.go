@@ -0,0 +1,523 @@
+package db
+
+import (
+	"context"
+	"crm-util-go/logging"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Frames of database/sql and this package are skipped to log the caller of the statement.
+var sqlCallerSkipPrefix = []string{"database/sql.", "crm-util-go/db."}
+
+var (
+	regexBindList    = regexp.MustCompile(`\(\s*\?(\s*,\s*\?)*\s*\)`)
+	regexBindRowList = regexp.MustCompile(`\(\?\)(\s*,\s*\(\?\))+`)
+)
+
+type queryStatKey struct {
+	operation string
+	sql       string
+}
+
+type queryStats struct {
+	mu    sync.Mutex
+	stats map[queryStatKey]*QueryStat
+}
+
+func newQueryStats() *queryStats {
+	return &queryStats{stats: make(map[queryStatKey]*QueryStat)}
+}
+
+func (qs *queryStats) add(operation string, normalizedSQL string, elapsed time.Duration, rowsAffected int64, slow bool, err error) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	key := queryStatKey{operation: operation, sql: normalizedSQL}
+	stat, found := qs.stats[key]
+
+	if !found {
+		if len(qs.stats) >= DefaultMaxQueryStats {
+			key.sql = QueryStatOther
+			stat, found = qs.stats[key]
+		}
+
+		if !found {
+			stat = &QueryStat{Operation: operation, SQL: key.sql, MinDuration: elapsed}
+			qs.stats[key] = stat
+		}
+	}
+
+	stat.Count++
+	stat.TotalDuration += elapsed
+
+	if elapsed < stat.MinDuration {
+		stat.MinDuration = elapsed
+	}
+
+	if elapsed > stat.MaxDuration {
+		stat.MaxDuration = elapsed
+	}
+
+	if rowsAffected > 0 {
+		stat.RowsAffected += rowsAffected
+	}
+
+	if slow {
+		stat.SlowCount++
+	}
+
+	if err != nil {
+		stat.ErrorCount++
+	}
+}
+
+// list returns the stats ordered by TotalDuration, the slowest statements first.
+func (qs *queryStats) list() []QueryStat {
+	qs.mu.Lock()
+	statList := make([]QueryStat, 0, len(qs.stats))
+	for _, stat := range qs.stats {
+		statList = append(statList, *stat)
+	}
+	qs.mu.Unlock()
+
+	sort.Slice(statList, func(i, j int) bool {
+		if statList[i].TotalDuration != statList[j].TotalDuration {
+			return statList[i].TotalDuration > statList[j].TotalDuration
+		}
+
+		return statList[i].SQL < statList[j].SQL
+	})
+
+	return statList
+}
+
+func (qs *queryStats) reset() {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	qs.stats = make(map[queryStatKey]*QueryStat)
+}
+
+func (st *dbPoolState) getQueryStats() *queryStats {
+	st.statsOnce.Do(func() {
+		if st.queryStats == nil {
+			st.queryStats = newQueryStats()
+		}
+	})
+
+	return st.queryStats
+}
+
+// QueryStats returns the statement stats of a pool with SlowQueryThreshold, the slowest statements first.
+func (dbPool DBPool) QueryStats() []QueryStat {
+	return dbPool.driverState().getQueryStats().list()
+}
+
+func (dbPool DBPool) ResetQueryStats() {
+	dbPool.driverState().getQueryStats().reset()
+}
+
+// QueryStats returns the statement stats of the failover pool and the node pools.
+func (dbPool DBPoolCluster) QueryStats() []QueryStat {
+	return dbPool.getState().getQueryStats().list()
+}
+
+func (dbPool DBPoolCluster) ResetQueryStats() {
+	dbPool.getState().getQueryStats().reset()
+}
+
+/*
+NormalizeSQL replaces the literals and binds of a statement by ? so the same statement with different values
+has the same text, comments are removed and white spaces are collapsed.
+
+	NormalizeSQL("SELECT * FROM CAMPAIGN WHERE CAMP_ID = :1 AND STATUS IN ('A', 'B')")
+	// SELECT * FROM CAMPAIGN WHERE CAMP_ID = ? AND STATUS IN (?)
+*/
+func NormalizeSQL(query string) string {
+	var builder strings.Builder
+	builder.Grow(len(query))
+
+	isIdentChar := func(c byte) bool {
+		return c == '_' || c == '$' || c == '#' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+
+	pendingSpace := false
+	var prev byte
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			pendingSpace = true
+			continue
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+			pendingSpace = true
+			continue
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 3
+			}
+			pendingSpace = true
+			continue
+		}
+
+		if pendingSpace {
+			if builder.Len() > 0 {
+				builder.WriteByte(' ')
+			}
+			pendingSpace = false
+			prev = ' '
+		}
+
+		switch {
+		case c == '\'':
+			// String literal, '' is an escaped quote
+			for i++; i < len(query); i++ {
+				if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			builder.WriteByte('?')
+			prev = '?'
+		case c == '"' || c == '`':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				end = len(query) - i - 1
+			} else {
+				end++
+			}
+			builder.WriteString(query[i : i+end+1])
+			i += end
+			prev = c
+		case c == ':' && i+1 < len(query) && isIdentChar(query[i+1]) && prev != ':':
+			for i+1 < len(query) && isIdentChar(query[i+1]) {
+				i++
+			}
+			builder.WriteByte('?')
+			prev = '?'
+		case c >= '0' && c <= '9' && !isIdentChar(prev):
+			for i+1 < len(query) && (query[i+1] == '.' || query[i+1] >= '0' && query[i+1] <= '9') {
+				i++
+			}
+			builder.WriteByte('?')
+			prev = '?'
+		default:
+			builder.WriteByte(c)
+			prev = c
+		}
+	}
+
+	normalizedSQL := regexBindList.ReplaceAllString(builder.String(), "(?)")
+	return regexBindRowList.ReplaceAllString(normalizedSQL, "(?)")
+}
+
+// sqlInstrument times the statements of one connection pool.
+type sqlInstrument struct {
+	poolName  string
+	threshold time.Duration
+	logger    *logging.PatternLogger
+	stats     *queryStats
+}
+
+func (inst *sqlInstrument) record(ctx context.Context, operation string, query string, numBind int, rowsAffected int64, startDT time.Time, err error) {
+	elapsed := time.Since(startDT)
+	slow := elapsed >= inst.threshold
+	normalizedSQL := NormalizeSQL(query)
+
+	inst.stats.add(operation, normalizedSQL, elapsed, rowsAffected, slow, err)
+
+	if !slow || !inst.logger.AllowLogging(logging.LEVEL_WARN) {
+		return
+	}
+
+	// Bind values are never logged, they can contain customer data
+	caller := logging.GetCallerStackTrace(sqlCallerSkipPrefix...)
+	msg := fmt.Sprintf("Slow SQL %s %.3f ms, Pool: %s, Rows: %d, Binds: %d, Caller: %s:%d %s, SQL: %s",
+		operation, durationMs(elapsed), inst.poolName, rowsAffected, numBind, caller.File, caller.Line, caller.Function, normalizedSQL)
+
+	if err != nil {
+		msg += ", Error: " + err.Error()
+	}
+
+	inst.logger.Warn(TransIDFromContext(ctx), msg)
+}
+
+func newSQLInstrument(poolName string, threshold time.Duration, logger *logging.PatternLogger, stats *queryStats) *sqlInstrument {
+	return &sqlInstrument{poolName: poolName, threshold: threshold, logger: logger, stats: stats}
+}
+
+// openInstrumentedDB opens the pool on the connector of the registered driver wrapped by the instrument.
+func (dbPool DBPool) openInstrumentedDB(driverName string) (*sql.DB, error) {
+	sqlDB, err := sql.Open(driverName, dbPool.DataSourceName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDriver := sqlDB.Driver()
+	sqlDB.Close()
+
+	var connector driver.Connector
+
+	if driverCtx, ok := sqlDriver.(driver.DriverContext); ok {
+		if connector, err = driverCtx.OpenConnector(dbPool.DataSourceName); err != nil {
+			return nil, err
+		}
+	} else {
+		connector = dsnConnector{dsn: dbPool.DataSourceName, driver: sqlDriver}
+	}
+
+	inst := newSQLInstrument(dbPool.Name, dbPool.SlowQueryThreshold, dbPool.Logger, dbPool.driverState().getQueryStats())
+
+	return sql.OpenDB(instrumentedConnector{connector: connector, inst: inst}), nil
+}
+
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type instrumentedConnector struct {
+	connector driver.Connector
+	inst      *sqlInstrument
+}
+
+func (c instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connector.Connect(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &instrumentedConn{Conn: conn, inst: c.inst}, nil
+}
+
+func (c instrumentedConnector) Driver() driver.Driver {
+	return c.connector.Driver()
+}
+
+// instrumentedConn passes the optional interfaces to the driver connection, a missing one falls back like database/sql does.
+type instrumentedConn struct {
+	driver.Conn
+	inst *sqlInstrument
+}
+
+func (conn *instrumentedConn) Prepare(query string) (driver.Stmt, error) {
+	return conn.PrepareContext(context.Background(), query)
+}
+
+func (conn *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	startDT := time.Now()
+
+	var stmt driver.Stmt
+	var err error
+
+	if preparer, ok := conn.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = conn.Conn.Prepare(query)
+	}
+
+	conn.inst.record(ctx, SQLOpPrepare, query, 0, 0, startDT, err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &instrumentedStmt{Stmt: stmt, conn: conn, query: query}, nil
+}
+
+func (conn *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := conn.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		return nil, errors.New("sql: driver does not support non-default isolation level")
+	}
+
+	if opts.ReadOnly {
+		return nil, errors.New("sql: driver does not support read-only transactions")
+	}
+
+	return conn.Conn.Begin()
+}
+
+func (conn *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := conn.Conn.(driver.ExecerContext)
+
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	startDT := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+
+	if err == driver.ErrSkip {
+		return nil, err
+	}
+
+	conn.inst.record(ctx, SQLOpExec, query, len(args), resultRowsAffected(result, err), startDT, err)
+
+	return result, err
+}
+
+func (conn *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := conn.Conn.(driver.QueryerContext)
+
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	startDT := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+
+	if err == driver.ErrSkip {
+		return nil, err
+	}
+
+	conn.inst.record(ctx, SQLOpQuery, query, len(args), 0, startDT, err)
+
+	return rows, err
+}
+
+func (conn *instrumentedConn) Ping(ctx context.Context) error {
+	if pinger, ok := conn.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (conn *instrumentedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := conn.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+
+	return nil
+}
+
+func (conn *instrumentedConn) IsValid() bool {
+	if validator, ok := conn.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+
+	return true
+}
+
+func (conn *instrumentedConn) CheckNamedValue(namedValue *driver.NamedValue) error {
+	if checker, ok := conn.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(namedValue)
+	}
+
+	return driver.ErrSkip
+}
+
+type instrumentedStmt struct {
+	driver.Stmt
+	conn  *instrumentedConn
+	query string
+}
+
+func (stmt *instrumentedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	startDT := time.Now()
+
+	var result driver.Result
+	var err error
+
+	if execer, ok := stmt.Stmt.(driver.StmtExecContext); ok {
+		result, err = execer.ExecContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValueToValue(args); err == nil {
+			result, err = stmt.Stmt.Exec(values)
+		}
+	}
+
+	stmt.conn.inst.record(ctx, SQLOpExec, stmt.query, len(args), resultRowsAffected(result, err), startDT, err)
+
+	return result, err
+}
+
+func (stmt *instrumentedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	startDT := time.Now()
+
+	var rows driver.Rows
+	var err error
+
+	if queryer, ok := stmt.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValueToValue(args); err == nil {
+			rows, err = stmt.Stmt.Query(values)
+		}
+	}
+
+	stmt.conn.inst.record(ctx, SQLOpQuery, stmt.query, len(args), 0, startDT, err)
+
+	return rows, err
+}
+
+// CheckNamedValue uses the checker of the statement then of the connection, the same order as database/sql.
+func (stmt *instrumentedStmt) CheckNamedValue(namedValue *driver.NamedValue) error {
+	if checker, ok := stmt.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(namedValue)
+	}
+
+	return stmt.conn.CheckNamedValue(namedValue)
+}
+
+func namedValueToValue(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("sql: driver does not support the use of Named Parameters")
+		}
+		values[i] = arg.Value
+	}
+
+	return values, nil
+}
+
+func resultRowsAffected(result driver.Result, err error) int64 {
+	if err != nil || result == nil {
+		return 0
+	}
+
+	rowsAffected, rowsErr := result.RowsAffected()
+
+	if rowsErr != nil {
+		return 0
+	}
+
+	return rowsAffected
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNormalizeSQL(t *testing.T) {
+	testList := []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT * FROM CAMPAIGN WHERE CAMP_ID = :1 AND STATUS IN ('A', 'B')", "SELECT * FROM CAMPAIGN WHERE CAMP_ID = ? AND STATUS IN (?)"},
+		{"select  camp_id,\r\n\tcamp_name -- comment\r\n from CAMPAIGN_2 where score > 1.5 and name = 'O''Neil'", "select camp_id, camp_name from CAMPAIGN_2 where score > ? and name = ?"},
+		{"INSERT INTO CAMP (A, B) VALUES (?, ?), (?, ?), (?,?)", "INSERT INTO CAMP (A, B) VALUES (?)"},
+		{"SELECT /*+ INDEX(C) */ \"COL:1\" FROM C WHERE ID IN (?, ?, ?) LIMIT 10", "SELECT \"COL:1\" FROM C WHERE ID IN (?) LIMIT ?"},
+		{"BEGIN v_count := :count; END;", "BEGIN v_count := ?; END;"},
+	}
+
+	for _, test := range testList {
+		if normalizedSQL := NormalizeSQL(test.query); normalizedSQL != test.expected {
+			t.Errorf("NormalizeSQL(%q) = %q, expected %q", test.query, normalizedSQL, test.expected)
+		}
+	}
+}
+
+func newTestInstrumentedPool(connector *testConnector, threshold time.Duration) DBPool {
+	dbPool := newTestDBPool(connector)
+	dbPool.SlowQueryThreshold = threshold
+
+	inst := newSQLInstrument(dbPool.Name, threshold, dbPool.Logger, dbPool.state.getQueryStats())
+	dbPool.state.sqlDB = sql.OpenDB(instrumentedConnector{connector: connector, inst: inst})
+
+	return dbPool
+}
+
+func TestQueryStats(t *testing.T) {
+	connector := &testConnector{results: map[string]testResult{
+		"SELECT CAMP_ID":    {columns: []string{"CAMP_ID"}, rows: [][]driver.Value{{"C1"}}},
+		"UPDATE CAMP_ERROR": {err: errors.New("ORA-00942: table or view does not exist")},
+		"UPDATE CAMPAIGN":   {},
+		"SELECT SLOW_CAMP":  {columns: []string{"CAMP_ID"}},
+	}}
+	dbPool := newTestInstrumentedPool(connector, time.Hour)
+	ctx := WithTransID(context.Background(), "stats-test")
+
+	for _, campID := range []string{"C1", "C2"} {
+		if _, err := Exec(ctx, dbPool, "UPDATE CAMPAIGN SET STATUS = 'A' WHERE CAMP_ID = ?", campID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := Exec(ctx, dbPool, "UPDATE CAMP_ERROR SET STATUS = 'A'"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if _, err := QueryStructs[struct{ CampID string }](ctx, dbPool, "SELECT CAMP_ID FROM CAMPAIGN WHERE CAMP_ID = ?", "C1"); err != nil {
+		t.Fatal(err)
+	}
+
+	statMap := make(map[string]QueryStat)
+	for _, stat := range dbPool.QueryStats() {
+		statMap[stat.Operation+" "+stat.SQL] = stat
+	}
+
+	stat := statMap["Exec UPDATE CAMPAIGN SET STATUS = ? WHERE CAMP_ID = ?"]
+	if stat.Count != 2 || stat.RowsAffected != 2 || stat.ErrorCount != 0 || stat.SlowCount != 0 || stat.AvgDuration() <= 0 {
+		t.Errorf("unexpected exec stat %+v", stat)
+	}
+
+	if stat = statMap["Exec UPDATE CAMP_ERROR SET STATUS = ?"]; stat.Count != 1 || stat.ErrorCount != 1 {
+		t.Errorf("unexpected error stat %+v", stat)
+	}
+
+	if stat = statMap["Query SELECT CAMP_ID FROM CAMPAIGN WHERE CAMP_ID = ?"]; stat.Count != 1 {
+		t.Errorf("unexpected query stat %+v", stat)
+	}
+
+	if stat = statMap["Prepare UPDATE CAMPAIGN SET STATUS = ? WHERE CAMP_ID = ?"]; stat.Count != 2 {
+		t.Errorf("unexpected prepare stat %+v", stat)
+	}
+
+	dbPool.ResetQueryStats()
+
+	if statList := dbPool.QueryStats(); len(statList) != 0 {
+		t.Errorf("expected no stats after reset but got %+v", statList)
+	}
+
+	slowPool := newTestInstrumentedPool(connector, time.Nanosecond)
+
+	txErr := WithTx(ctx, slowPool, newTestTxOptions(), func(tx *Tx) error {
+		_, err := QueryStructs[struct{ CampID string }](tx.Context(), slowPool, "SELECT SLOW_CAMP FROM DUAL")
+		return err
+	})
+
+	if txErr != nil {
+		t.Fatalf("WithTx Error: %+v", txErr)
+	}
+
+	statList := slowPool.QueryStats()
+	if len(statList) == 0 {
+		t.Fatal("expected stats of the slow pool")
+	}
+
+	for _, stat = range statList {
+		if stat.SlowCount != stat.Count {
+			t.Errorf("expected every statement slow %+v", stat)
+		}
+	}
+}
@@ -11,6 +11,10 @@ func (dbErr DbError) Error() string {
 	return fmt.Sprintf("Database Name: %s Error %v", dbErr.DbName, dbErr.Err)
 }
 
+func (dbErr DbError) Unwrap() error {
+	return dbErr.Err
+}
+
 type HttpError struct {
 	HttpStatusCode 	int
 	Err 			error
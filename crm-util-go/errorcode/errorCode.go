@@ -17,6 +17,23 @@ type CrmErrorCode struct {
 type CrmErrorCodeResp struct {
 	ErrorCode    string
 	ErrorMessage string
+	cause        error
+}
+
+// Error lets a func return *CrmErrorCodeResp as error, for example the func of db.WithTx.
+func (resp *CrmErrorCodeResp) Error() string {
+	return resp.ErrorMessage
+}
+
+// Unwrap returns the cause of WithCause, so errors.Is and errors.As can check the error behind the response.
+func (resp *CrmErrorCodeResp) Unwrap() error {
+	return resp.cause
+}
+
+// WithCause returns a copy of resp which keeps err as its cause.
+func (resp CrmErrorCodeResp) WithCause(err error) *CrmErrorCodeResp {
+	resp.cause = err
+	return &resp
 }
 
 type BackendResp struct {
@@ -1,23 +1,16 @@
 package kafkautil
 
 import (
+	"context"
 	"crm-util-go/common"
 	"crm-util-go/pointer"
 	"fmt"
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"strconv"
 	"strings"
-	"sync"
+	"time"
 )
 
-func (kc *KafkaConfig) StartConsumer() {
-	kc.IsShutdown = false
-}
-
-func (kc *KafkaConfig) ShutdownConsumer() {
-	kc.IsShutdown = true
-}
-
 func (kc *KafkaConfig) postLineNotify(transID string, message string) {
 	kc.Logger.Debug(transID, message)
 	/*
@@ -65,19 +58,17 @@ func (kc *KafkaConfig) logKafkaHeaders(msgTransID string, kafkaHeaders []kafka.H
 	}
 }
 
-func (kc *KafkaConfig) Consumer(wg *sync.WaitGroup, transID string,
-	onMessage func(transID string, topicName string, kafkaKey string, kafkaMsg string) error) {
-
-	defer wg.Done()
-	defer kc.ShutdownConsumer()
-
-	kc.Logger.Info(transID, fmt.Sprintf("Starting Consumer with KafkaConfig: %#v", kc))
+func (kc *KafkaConfig) consumerConfigMap() *kafka.ConfigMap {
+	autoOffsetReset := kc.AutoOffsetReset
+	if autoOffsetReset == "" {
+		autoOffsetReset = "earliest"
+	}
 
 	// "enable.ssl.certificate.verification": false,
-	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+	return &kafka.ConfigMap{
 		"bootstrap.servers":                     kc.BootstrapServers,
 		"group.id":                              kc.GroupID,
-		"auto.offset.reset":                     "earliest",
+		"auto.offset.reset":                     autoOffsetReset,
 		"enable.auto.commit":                    false,
 		"enable.partition.eof":                  true,
 		"security.protocol":                     kc.SecurityProtocol,
@@ -88,37 +79,85 @@ func (kc *KafkaConfig) Consumer(wg *sync.WaitGroup, transID string,
 		"sasl.kerberos.min.time.before.relogin": kc.KerberosReloginMS,
 		"ssl.ca.location":                       kc.SslCALocation,
 		"ssl.cipher.suites":                     kc.SslCipherSuites,
+	}
+}
+
+/*
+Consumer processes the messages until ctx is cancelled or ShutdownConsumer is called,
+then it finishes the running message and closes the consumer within ShutdownTimeout.
+It returns nil when it is stopped, or the error which stops it.
+*/
+func (kc *KafkaConfig) Consumer(ctx context.Context, transID string,
+	onMessage func(transID string, topicName string, kafkaKey string, kafkaMsg string) error) error {
+
+	return kc.ConsumerMessage(ctx, transID, func(msgTransID string, msg *kafka.Message) error {
+		return onMessage(msgTransID, SourceTopic(msg), string(msg.Key), string(msg.Value))
 	})
+}
+
+// ConsumerMessage is the same as Consumer but onMessage receives the whole kafka message
+// including headers, partition, offset and timestamp.
+// With RetryPolicy, the topic of msg is a retry topic when it is a retry, see SourceTopic.
+func (kc *KafkaConfig) ConsumerMessage(ctx context.Context, transID string,
+	onMessage func(transID string, msg *kafka.Message) error) error {
+
+	// The context of the call stops what it started, it does not stop the other consumers of kc
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	kc.Logger.Info(transID, "Starting Consumer. "+kc.consumerInfo())
+
+	consumer, err := kafka.NewConsumer(kc.consumerConfigMap())
 
 	if err != nil {
 		kc.Logger.Error(transID, fmt.Sprintf("Consumer.NewConsumer Error: %v", err))
-		panic(err)
+		return err
+	}
+
+	var retryProducer *kafka.Producer
+
+	if kc.RetryPolicy != nil {
+		retryProducer, err = kc.newRetryProducer()
+
+		if err != nil {
+			consumer.Close()
+			kc.Logger.Error(transID, fmt.Sprintf("Consumer.NewProducer of RetryPolicy Error: %v", err))
+			return err
+		}
+
+		defer retryProducer.Close()
 	}
 
-	err = consumer.SubscribeTopics(kc.TopicName, nil)
+	err = consumer.SubscribeTopics(kc.subscribeTopicNames(), nil)
 
 	if err != nil {
+		consumer.Close()
 		kc.Logger.Error(transID, fmt.Sprintf("Consumer.SubscribeTopics Error: %v", err))
-		panic(err)
+		return err
 	}
 
-	defer consumer.Close()
+	pausedMap := make(map[topicPartition]pausedPartition)
 
 	// Synchronous commits
-	var isRun bool = true
-	var pollTimeoutMs int = 10000
+	var runErr error
 
-	for isRun {
-		if kc.IsShutdown {
-			isRun = false
-			break
-		}
+	for runErr == nil && !kc.isStopped(ctx) {
+		kc.resumeDuePartitions(transID, consumer, pausedMap, time.Now())
 
-		event := consumer.Poll(pollTimeoutMs)
+		event := consumer.Poll(pollTimeoutMs(pausedMap, time.Now(), kafkaPollTimeoutMs))
 
 		switch e := event.(type) {
 		case *kafka.Message:
 			msgTransID := common.NewUUID()
+
+			if notBefore := retryNotBefore(e); kc.RetryPolicy != nil && time.Now().Before(notBefore) {
+				if err = kc.pauseUntil(msgTransID, consumer, pausedMap, e, notBefore); err != nil {
+					runErr = err
+					kc.Logger.Error(msgTransID, getTopicInfo(e.TopicPartition)+" Consumer.Pause Error: "+err.Error())
+				}
+				break
+			}
+
 			kafkaKey := string(e.Key)
 			kafkaMsg := string(e.Value)
 			kafkaHeaders := e.Headers
@@ -131,13 +170,24 @@ func (kc *KafkaConfig) Consumer(wg *sync.WaitGroup, transID string,
 
 			kc.logKafkaHeaders(msgTransID, kafkaHeaders)
 
-			err = onMessage(msgTransID, pointer.GetStringValue(tp.Topic), kafkaKey, kafkaMsg)
+			if kc.RetryPolicy != nil {
+				var attempts int
+				attempts, err = kc.processImmediateRetry(msgTransID, e, onMessage, func(backoff time.Duration) bool {
+					return kc.sleep(ctx, backoff)
+				})
+
+				if err != nil && kc.forwardFailedMessage(msgTransID, retryProducer, e, attempts, err) == nil {
+					err = nil
+				}
+			} else {
+				err = onMessage(msgTransID, e)
+			}
 
 			if err == nil {
 				_, err = consumer.CommitMessage(e)
 
 				if err != nil {
-					kc.IsShutdown = true
+					runErr = err
 					kc.Logger.Error(msgTransID, topicInfo+" Consumer.CommitMessage Error: "+err.Error())
 				} else {
 					kc.Logger.Info(msgTransID, topicInfo+" Consumer.CommitMessage Success")
@@ -151,7 +201,7 @@ func (kc *KafkaConfig) Consumer(wg *sync.WaitGroup, transID string,
 				_, err = consumer.SeekPartitions([]kafka.TopicPartition{e.TopicPartition})
 
 				if err != nil {
-					kc.IsShutdown = true
+					runErr = err
 					kc.Logger.Error(msgTransID, topicInfo+" Consumer.SeekPartitions Error: "+err.Error())
 				}
 
@@ -160,10 +210,23 @@ func (kc *KafkaConfig) Consumer(wg *sync.WaitGroup, transID string,
 		case kafka.PartitionEOF:
 			kc.Logger.Info(transID, fmt.Sprintf("Reached the end of a partition. %v", e))
 		case kafka.Error:
-			kc.IsShutdown = true
+			// librdkafka recovers from the errors which are not fatal, e.g. all brokers down
+			if e.IsFatal() {
+				runErr = e
+			}
 			kc.Logger.Error(transID, fmt.Sprintf("Consumer.Poll Error: %v", e))
+		case nil:
 		default:
 			kc.Logger.Info(transID, fmt.Sprintf("Ignored %v", e))
 		}
 	}
+
+	kc.Logger.Info(transID, "Stopping Consumer")
+
+	// The offsets are committed after each message, so the consumer is closed only.
+	if err = kc.closeConsumer(transID, consumer, time.Now().Add(kc.shutdownTimeout())); err != nil && runErr == nil {
+		runErr = err
+	}
+
+	return runErr
 }
@@ -0,0 +1,421 @@
+package kafkautil
+
+import (
+	"context"
+	"crm-util-go/common"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+const (
+	kafkaDefaultWorkerCount           int           = 8
+	kafkaWorkerQueueSize              int           = 100
+	kafkaConcurrentPollTimeoutMs      int           = 100
+	kafkaPausedCheckInterval          time.Duration = 100 * time.Millisecond
+	kafkaDefaultCommitInterval        time.Duration = 5 * time.Second
+	kafkaDefaultRetryBackoff          time.Duration = 5 * time.Second
+	kafkaDefaultRebalanceDrainTimeout time.Duration = 30 * time.Second
+)
+
+type consumerJob struct {
+	msg     *kafka.Message
+	offsets *partitionOffsets
+}
+
+// consumerWorkerPool sends the messages of a partition, or of a key when OrderByKey, to the same worker.
+type consumerWorkerPool struct {
+	kc            *KafkaConfig
+	tracker       *offsetTracker
+	retryProducer messageProducer
+	onMessage     func(transID string, msg *kafka.Message) error
+	queues        []chan consumerJob
+	stop          chan struct{}
+	wg            sync.WaitGroup
+}
+
+// newConsumerWorkerPool starts the workers, retryProducer is used by RetryPolicy only.
+func (kc *KafkaConfig) newConsumerWorkerPool(tracker *offsetTracker, retryProducer messageProducer,
+	onMessage func(transID string, msg *kafka.Message) error) *consumerWorkerPool {
+
+	workerCount := kc.WorkerCount
+	if workerCount <= 0 {
+		workerCount = kafkaDefaultWorkerCount
+	}
+
+	pool := &consumerWorkerPool{
+		kc:            kc,
+		tracker:       tracker,
+		retryProducer: retryProducer,
+		onMessage:     onMessage,
+		queues:        make([]chan consumerJob, workerCount),
+		stop:          make(chan struct{}),
+	}
+
+	for i := range pool.queues {
+		pool.queues[i] = make(chan consumerJob, kafkaWorkerQueueSize)
+
+		pool.wg.Add(1)
+		go pool.runWorker(pool.queues[i])
+	}
+
+	return pool
+}
+
+func (pool *consumerWorkerPool) workerIndex(msg *kafka.Message) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(getTopicPartitionName(msg.TopicPartition)))
+
+	if pool.kc.OrderByKey {
+		hash.Write(msg.Key)
+	}
+
+	return int(hash.Sum32() % uint32(len(pool.queues)))
+}
+
+// dispatch tracks the offset and queues the message, false when the queue of the worker is full.
+// Only the poll loop sends to the queues, so the send does not block after the check.
+func (pool *consumerWorkerPool) dispatch(msg *kafka.Message) bool {
+	queue := pool.queues[pool.workerIndex(msg)]
+
+	if len(queue) == cap(queue) {
+		return false
+	}
+
+	queue <- consumerJob{msg: msg, offsets: pool.tracker.track(msg.TopicPartition)}
+
+	return true
+}
+
+/*
+receive pauses the partition of a retry message which is not due yet, so its delay does not hold back a worker
+or the poll loop, the message is consumed again after resumeDuePartitions. The other messages are dispatched.
+When the queue of the worker is full, the partition is paused until the queue is drained to half,
+so the poll loop keeps polling for the rebalance, the commits and max.poll.interval.ms.
+*/
+func (pool *consumerWorkerPool) receive(consumer partitionPauser, pausedMap map[topicPartition]pausedPartition,
+	msg *kafka.Message) error {
+
+	if notBefore := retryNotBefore(msg); pool.kc.RetryPolicy != nil && time.Now().Before(notBefore) {
+		return pool.kc.pauseUntil(common.NewUUID(), consumer, pausedMap, msg, notBefore)
+	}
+
+	if pool.dispatch(msg) {
+		return nil
+	}
+
+	queue := pool.queues[pool.workerIndex(msg)]
+	paused := pausedPartition{
+		tp:       msg.TopicPartition,
+		resumeAt: time.Now().Add(kafkaPausedCheckInterval),
+		drained:  func() bool { return len(queue) <= cap(queue)/2 },
+	}
+
+	if err := pausePartition(consumer, pausedMap, paused); err != nil {
+		return err
+	}
+
+	pool.kc.Logger.Warn(common.NewUUID(), getTopicInfo(msg.TopicPartition)+" Pause partition while the queue of its worker is full")
+
+	return nil
+}
+
+// shutdown skips the queued messages and waits for the running messages,
+// false when they are still running after timeout.
+func (pool *consumerWorkerPool) shutdown(timeout time.Duration) bool {
+	close(pool.stop)
+
+	for _, queue := range pool.queues {
+		close(queue)
+	}
+
+	return waitTimeout(&pool.wg, timeout)
+}
+
+func (pool *consumerWorkerPool) isStopped() bool {
+	select {
+	case <-pool.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (pool *consumerWorkerPool) runWorker(queue <-chan consumerJob) {
+	defer pool.wg.Done()
+
+	for job := range queue {
+		if pool.isStopped() || !pool.tracker.begin(job.offsets) {
+			continue
+		}
+
+		processed := pool.process(job)
+		pool.tracker.finish(job.offsets, job.msg.TopicPartition.Offset, processed)
+	}
+}
+
+// wait returns false when the consumer stops or the partition of job is revoked before d.
+func (pool *consumerWorkerPool) wait(job consumerJob, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pool.stop:
+			return false
+		case <-timer.C:
+			return !pool.tracker.isRevoked(job.offsets)
+		case <-ticker.C:
+			if pool.tracker.isRevoked(job.offsets) {
+				return false
+			}
+		}
+	}
+}
+
+// process calls onMessage until it succeeds or the message is forwarded by RetryPolicy,
+// false when the consumer stops or the partition is revoked first.
+func (pool *consumerWorkerPool) process(job consumerJob) bool {
+	kc := pool.kc
+	msg := job.msg
+	topicInfo := getTopicInfo(msg.TopicPartition)
+
+	retryBackoff := kc.RetryBackoff
+	if retryBackoff <= 0 {
+		retryBackoff = kafkaDefaultRetryBackoff
+	}
+
+	for {
+		msgTransID := common.NewUUID()
+
+		kc.Logger.Info(msgTransID, fmt.Sprintf("Start message on %s, Key: %s, Value: %s",
+			topicInfo, string(msg.Key), string(msg.Value)))
+
+		kc.logKafkaHeaders(msgTransID, msg.Headers)
+
+		var err error
+
+		if kc.RetryPolicy != nil {
+			var attempts int
+			attempts, err = kc.processImmediateRetry(msgTransID, msg, pool.onMessage, func(backoff time.Duration) bool {
+				return pool.wait(job, backoff)
+			})
+
+			if err != nil && (pool.isStopped() || pool.tracker.isRevoked(job.offsets)) {
+				return false
+			}
+
+			if err != nil && kc.forwardFailedMessage(msgTransID, pool.retryProducer, msg, attempts, err) == nil {
+				return true
+			}
+		} else {
+			err = pool.onMessage(msgTransID, msg)
+		}
+
+		if err == nil {
+			return true
+		}
+
+		notifyMessage := "Error Kafka Consumer. App: " + kc.Logger.ApplicationName + ", " +
+			topicInfo + ", Error: " + err.Error()
+
+		kc.Logger.Error(msgTransID, topicInfo+" Consumer Retry Message after "+retryBackoff.String())
+		kc.postLineNotify(msgTransID, notifyMessage)
+
+		if !pool.wait(job, retryBackoff) {
+			kc.Logger.Info(msgTransID, topicInfo+" Consumer is stopped or partition is revoked, stop retry")
+			return false
+		}
+	}
+}
+
+/*
+ConsumerConcurrent processes the messages by WorkerCount workers,
+the messages of a partition are processed in order, or the messages of a key when OrderByKey.
+A failed message is retried after RetryBackoff and holds back the messages after it in its worker,
+with RetryPolicy it is published to the retry topics instead, and the partition of a retry topic is paused
+until its next message is due, so the delay does not hold back a worker.
+When the queue of a worker is full, the partition of the message is paused until the queue is drained,
+so the poll loop does not block and the consumer stays in the group.
+The offsets are committed every CommitInterval, up to the last message before the first message
+which is not processed yet, so a message is consumed again after restart when it is not processed.
+
+It stops when ctx is cancelled or ShutdownConsumer is called, the queued messages are skipped,
+then the running messages, the final commit and the close of the consumer are waited within ShutdownTimeout.
+It returns nil when it is stopped, or the error which stops it.
+*/
+func (kc *KafkaConfig) ConsumerConcurrent(ctx context.Context, transID string,
+	onMessage func(transID string, msg *kafka.Message) error) error {
+
+	// The context of the call stops what it started, it does not stop the other consumers of kc
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	kc.Logger.Info(transID, "Starting Concurrent Consumer. "+kc.consumerInfo())
+
+	consumer, err := kafka.NewConsumer(kc.consumerConfigMap())
+
+	if err != nil {
+		kc.Logger.Error(transID, fmt.Sprintf("Consumer.NewConsumer Error: %v", err))
+		return err
+	}
+
+	var retryProducer *kafka.Producer
+
+	if kc.RetryPolicy != nil {
+		retryProducer, err = kc.newRetryProducer()
+
+		if err != nil {
+			consumer.Close()
+			kc.Logger.Error(transID, fmt.Sprintf("Consumer.NewProducer of RetryPolicy Error: %v", err))
+			return err
+		}
+
+		defer retryProducer.Close()
+	}
+
+	tracker := newOffsetTracker()
+	pool := kc.newConsumerWorkerPool(tracker, retryProducer, onMessage)
+	pausedMap := make(map[topicPartition]pausedPartition)
+
+	// The rebalance callback runs in Poll, on the goroutine of the poll loop which owns pausedMap
+	err = consumer.SubscribeTopics(kc.subscribeTopicNames(), func(c *kafka.Consumer, event kafka.Event) error {
+		kc.onRebalance(transID, c, tracker, pausedMap, event)
+		return nil
+	})
+
+	if err != nil {
+		pool.shutdown(kc.shutdownTimeout())
+		consumer.Close()
+		kc.Logger.Error(transID, fmt.Sprintf("Consumer.SubscribeTopics Error: %v", err))
+		return err
+	}
+
+	commitInterval := kc.CommitInterval
+	if commitInterval <= 0 {
+		commitInterval = kafkaDefaultCommitInterval
+	}
+
+	commitTicker := time.NewTicker(commitInterval)
+	defer commitTicker.Stop()
+
+	var runErr error
+
+	for runErr == nil && !kc.isStopped(ctx) {
+		kc.resumeDuePartitions(transID, consumer, pausedMap, time.Now())
+
+		event := consumer.Poll(pollTimeoutMs(pausedMap, time.Now(), kafkaConcurrentPollTimeoutMs))
+
+		switch e := event.(type) {
+		case *kafka.Message:
+			if err = pool.receive(consumer, pausedMap, e); err != nil {
+				runErr = err
+				kc.Logger.Error(transID, getTopicInfo(e.TopicPartition)+" Consumer.Pause Error: "+err.Error())
+			}
+		case kafka.PartitionEOF:
+			kc.Logger.Info(transID, fmt.Sprintf("Reached the end of a partition. %v", e))
+		case kafka.Error:
+			// librdkafka recovers from the errors which are not fatal, e.g. all brokers down
+			if e.IsFatal() {
+				runErr = e
+			}
+			kc.Logger.Error(transID, fmt.Sprintf("Consumer.Poll Error: %v", e))
+		case nil:
+		default:
+			kc.Logger.Info(transID, fmt.Sprintf("Ignored %v", e))
+		}
+
+		select {
+		case <-commitTicker.C:
+			kc.commitProcessed(transID, consumer, tracker)
+		default:
+		}
+	}
+
+	kc.Logger.Info(transID, "Stopping Concurrent Consumer, waiting for the running messages")
+
+	deadline := time.Now().Add(kc.shutdownTimeout())
+
+	if !pool.shutdown(time.Until(deadline)) {
+		kc.Logger.Warn(transID, "Running messages are not finished within ShutdownTimeout, they are consumed again after restart")
+
+		if runErr == nil {
+			runErr = ErrShutdownTimeout
+		}
+	}
+
+	if err = kc.commitProcessed(transID, consumer, tracker); err != nil && runErr == nil {
+		runErr = err
+	}
+
+	if err = kc.closeConsumer(transID, consumer, deadline); err != nil && runErr == nil {
+		runErr = err
+	}
+
+	return runErr
+}
+
+// onRebalance commits the processed messages of the revoked partitions before they are assigned to another consumer.
+func (kc *KafkaConfig) onRebalance(transID string, consumer *kafka.Consumer, tracker *offsetTracker,
+	pausedMap map[topicPartition]pausedPartition, event kafka.Event) {
+
+	switch e := event.(type) {
+	case kafka.AssignedPartitions:
+		kc.Logger.Info(transID, fmt.Sprintf("Assigned partitions %v", e.Partitions))
+	case kafka.RevokedPartitions:
+		kc.Logger.Info(transID, fmt.Sprintf("Revoked partitions %v", e.Partitions))
+
+		tracker.revoke(e.Partitions)
+
+		for _, tp := range e.Partitions {
+			delete(pausedMap, toTopicPartition(tp))
+		}
+
+		drainTimeout := kc.RebalanceDrainTimeout
+		if drainTimeout <= 0 {
+			drainTimeout = kafkaDefaultRebalanceDrainTimeout
+		}
+
+		if !tracker.waitIdle(e.Partitions, drainTimeout) {
+			kc.Logger.Warn(transID, "Revoked partitions are still processing after "+drainTimeout.String())
+		}
+
+		if consumer.AssignmentLost() {
+			kc.Logger.Warn(transID, "Assignment is lost, the revoked partitions are not committed")
+		} else {
+			kc.commitProcessed(transID, consumer, tracker, e.Partitions...)
+		}
+
+		tracker.remove(e.Partitions)
+	}
+}
+
+func (kc *KafkaConfig) commitProcessed(transID string, consumer *kafka.Consumer, tracker *offsetTracker,
+	partitionList ...kafka.TopicPartition) error {
+
+	commitList := tracker.commitList(partitionList...)
+
+	if len(commitList) == 0 {
+		return nil
+	}
+
+	if _, err := consumer.CommitOffsets(commitList); err != nil {
+		kc.Logger.Error(transID, fmt.Sprintf("Consumer.CommitOffsets %v Error: %s", commitList, err.Error()))
+		return err
+	}
+
+	tracker.setCommitted(commitList)
+	kc.Logger.Info(transID, fmt.Sprintf("Consumer.CommitOffsets Success %v", commitList))
+
+	return nil
+}
+
+func getTopicPartitionName(tp kafka.TopicPartition) string {
+	return fmt.Sprintf("%s[%d]", toTopicPartition(tp).topic, tp.Partition)
+}
@@ -0,0 +1,300 @@
+package kafkautil
+
+import (
+	"crm-util-go/logging"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+func newTestMessage(topic string, partition int32, offset int64, key string) *kafka.Message {
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: kafka.Offset(offset)},
+		Key:            []byte(key),
+	}
+}
+
+func TestOffsetTrackerContiguousCommit(t *testing.T) {
+	tracker := newOffsetTracker()
+
+	var offsetsList []*partitionOffsets
+	for offset := int64(10); offset < 14; offset++ {
+		offsetsList = append(offsetsList, tracker.track(newTestMessage("order", 0, offset, "").TopicPartition))
+	}
+
+	for _, offsets := range offsetsList {
+		tracker.begin(offsets)
+	}
+
+	tracker.finish(offsetsList[1], 11, true)
+	tracker.finish(offsetsList[2], 12, true)
+
+	if commitList := tracker.commitList(); len(commitList) != 0 {
+		t.Fatalf("offset 10 is running, expected no commit, got %v", commitList)
+	}
+
+	tracker.finish(offsetsList[0], 10, true)
+	commitList := tracker.commitList()
+
+	if len(commitList) != 1 || commitList[0].Offset != 13 {
+		t.Fatalf("expected commit offset 13, got %v", commitList)
+	}
+
+	tracker.setCommitted(commitList)
+
+	if commitList = tracker.commitList(); len(commitList) != 0 {
+		t.Errorf("committed offset should not be committed again, got %v", commitList)
+	}
+
+	tracker.finish(offsetsList[3], 13, false)
+
+	if commitList = tracker.commitList(); len(commitList) != 0 {
+		t.Errorf("not processed offset should hold back the commit, got %v", commitList)
+	}
+}
+
+func TestOffsetTrackerRevoke(t *testing.T) {
+	tracker := newOffsetTracker()
+
+	running := tracker.track(newTestMessage("order", 1, 5, "").TopicPartition)
+	queued := tracker.track(newTestMessage("order", 1, 6, "").TopicPartition)
+	other := tracker.track(newTestMessage("order", 2, 7, "").TopicPartition)
+
+	tracker.begin(running)
+	tracker.begin(other)
+
+	revokedList := []kafka.TopicPartition{newTestMessage("order", 1, 0, "").TopicPartition}
+	tracker.revoke(revokedList)
+
+	if tracker.begin(queued) {
+		t.Errorf("queued message of a revoked partition should be skipped")
+	}
+
+	if tracker.waitIdle(revokedList, 20*time.Millisecond) {
+		t.Errorf("waitIdle should time out while offset 5 is running")
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		tracker.finish(running, 5, true)
+	}()
+
+	if !tracker.waitIdle(revokedList, time.Second) {
+		t.Fatalf("waitIdle should return after offset 5 is processed")
+	}
+
+	commitList := tracker.commitList(revokedList...)
+
+	if len(commitList) != 1 || commitList[0].Partition != 1 || commitList[0].Offset != 6 {
+		t.Errorf("expected commit offset 6 of partition 1 only, got %v", commitList)
+	}
+
+	tracker.remove(revokedList)
+
+	if commitList = tracker.commitList(revokedList...); len(commitList) != 0 {
+		t.Errorf("removed partition should not be committed, got %v", commitList)
+	}
+}
+
+func newTestKafkaConfig() *KafkaConfig {
+	return &KafkaConfig{
+		WorkerCount:  4,
+		RetryBackoff: 10 * time.Millisecond,
+		Logger:       logging.InitUtilLogger("crm-util-go", logging.CrmUtil),
+	}
+}
+
+func TestConsumerWorkerPoolPartitionOrder(t *testing.T) {
+	kc := newTestKafkaConfig()
+	tracker := newOffsetTracker()
+
+	var mu sync.Mutex
+	processed := make(map[int32][]kafka.Offset)
+
+	pool := kc.newConsumerWorkerPool(tracker, nil, func(transID string, msg *kafka.Message) error {
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		processed[msg.TopicPartition.Partition] = append(processed[msg.TopicPartition.Partition], msg.TopicPartition.Offset)
+
+		return nil
+	})
+
+	for offset := int64(0); offset < 20; offset++ {
+		for partition := int32(0); partition < 3; partition++ {
+			pool.dispatch(newTestMessage("order", partition, offset, ""))
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(tracker.commitList()) < 3 || tracker.commitList()[0].Offset != 20 {
+		if time.Now().After(deadline) {
+			t.Fatalf("messages are not processed, commit list %v", tracker.commitList())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	pool.shutdown(time.Second)
+
+	for partition := int32(0); partition < 3; partition++ {
+		offsetList := processed[partition]
+
+		if len(offsetList) != 20 {
+			t.Fatalf("partition %d processed %d messages, expected 20", partition, len(offsetList))
+		}
+
+		for i, offset := range offsetList {
+			if offset != kafka.Offset(i) {
+				t.Fatalf("partition %d is not in order: %v", partition, offsetList)
+			}
+		}
+	}
+
+	for _, tp := range tracker.commitList() {
+		if tp.Offset != 20 {
+			t.Errorf("expected commit offset 20, got %v", tp)
+		}
+	}
+}
+
+func TestConsumerWorkerPoolRetry(t *testing.T) {
+	kc := newTestKafkaConfig()
+	tracker := newOffsetTracker()
+
+	var mu sync.Mutex
+	attempts := 0
+
+	pool := kc.newConsumerWorkerPool(tracker, nil, func(transID string, msg *kafka.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if msg.TopicPartition.Offset == 0 {
+			attempts++
+
+			if attempts < 3 {
+				return errors.New("downstream timeout")
+			}
+		}
+
+		return nil
+	})
+
+	pool.dispatch(newTestMessage("order", 0, 0, ""))
+	pool.dispatch(newTestMessage("order", 0, 1, ""))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for commitList := tracker.commitList(); len(commitList) == 0 || commitList[0].Offset != 2; commitList = tracker.commitList() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected commit offset 2, got %v", commitList)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	pool.shutdown(time.Second)
+
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestConsumerWorkerPoolShutdownStopsRetry(t *testing.T) {
+	kc := newTestKafkaConfig()
+	tracker := newOffsetTracker()
+
+	started := make(chan struct{}, 1)
+	pool := kc.newConsumerWorkerPool(tracker, nil, func(transID string, msg *kafka.Message) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+
+		return errors.New("always fail")
+	})
+
+	pool.dispatch(newTestMessage("order", 0, 0, ""))
+	<-started
+
+	done := make(chan struct{})
+	go func() {
+		pool.shutdown(time.Second)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown should stop the retry")
+	}
+
+	if commitList := tracker.commitList(); len(commitList) != 0 {
+		t.Errorf("failed message should not be committed, got %v", commitList)
+	}
+}
+
+func TestConsumerWorkerPoolPauseFullQueue(t *testing.T) {
+	kc := newTestKafkaConfig()
+	tracker := newOffsetTracker()
+	pauser := &memoryPauser{paused: make(map[int32]bool)}
+	pausedMap := make(map[topicPartition]pausedPartition)
+
+	// The pool without workers keeps the queued messages
+	queue := make(chan consumerJob, 2)
+	pool := &consumerWorkerPool{kc: kc, tracker: tracker, queues: []chan consumerJob{queue}}
+
+	for offset := int64(0); offset < 3; offset++ {
+		if err := pool.receive(pauser, pausedMap, newTestMessage("order", 0, offset, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(queue) != 2 || !pauser.paused[0] || len(pauser.seekList) != 1 || pauser.seekList[0].Offset != 2 {
+		t.Fatalf("the partition should be paused and seek back to offset 2 when the queue is full")
+	}
+
+	if timeoutMs := pollTimeoutMs(pausedMap, time.Now(), 500); timeoutMs > 100 {
+		t.Errorf("poll timeout %d, expected the queue to be checked within 100", timeoutMs)
+	}
+
+	kc.resumeDuePartitions("T1", pauser, pausedMap, time.Now().Add(time.Second))
+
+	if !pauser.paused[0] || len(pausedMap) != 1 {
+		t.Fatalf("the partition should stay paused while the queue is full")
+	}
+
+	<-queue
+	kc.resumeDuePartitions("T1", pauser, pausedMap, time.Now().Add(2*time.Second))
+
+	if pauser.paused[0] || len(pausedMap) != 0 {
+		t.Errorf("the partition should be resumed when the queue is drained to half")
+	}
+}
+
+func TestConsumerWorkerIndexByKey(t *testing.T) {
+	kc := newTestKafkaConfig()
+	pool := &consumerWorkerPool{kc: kc, queues: make([]chan consumerJob, 16)}
+
+	if pool.workerIndex(newTestMessage("order", 0, 0, "A")) != pool.workerIndex(newTestMessage("order", 0, 1, "B")) {
+		t.Errorf("messages of a partition should use the same worker")
+	}
+
+	kc.OrderByKey = true
+	workerSet := make(map[int]bool)
+
+	for _, key := range []string{"A", "B", "C", "D", "E", "F", "G", "H"} {
+		index := pool.workerIndex(newTestMessage("order", 0, 0, key))
+
+		if index != pool.workerIndex(newTestMessage("order", 0, 1, key)) {
+			t.Errorf("messages of key %s should use the same worker", key)
+		}
+
+		workerSet[index] = true
+	}
+
+	if len(workerSet) < 2 {
+		t.Errorf("keys of a partition should use more than one worker")
+	}
+}
@@ -0,0 +1,219 @@
+package kafkautil
+
+import (
+	"crm-util-go/pointer"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+func toTopicPartition(tp kafka.TopicPartition) topicPartition {
+	return topicPartition{topic: pointer.GetStringValue(tp.Topic), partition: tp.Partition}
+}
+
+// partitionOffsets is replaced by a new one when the partition is assigned again, so a late worker can not change it.
+type partitionOffsets struct {
+	pending   []kafka.Offset
+	done      map[kafka.Offset]bool
+	next      kafka.Offset
+	committed kafka.Offset
+	running   int
+	revoked   bool
+}
+
+/*
+offsetTracker keeps the offsets of the dispatched messages of each partition.
+The commit offset of a partition is the offset after the last message of its contiguous processed messages,
+a message which is still processing holds back the commit of the messages after it.
+*/
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[topicPartition]*partitionOffsets
+	changed    chan struct{}
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		partitions: make(map[topicPartition]*partitionOffsets),
+		changed:    make(chan struct{}),
+	}
+}
+
+// track adds the offset of a dispatched message, the offsets of a partition must be added in order.
+func (t *offsetTracker) track(tp kafka.TopicPartition) *partitionOffsets {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := toTopicPartition(tp)
+	offsets, found := t.partitions[key]
+
+	if !found {
+		offsets = &partitionOffsets{
+			done:      make(map[kafka.Offset]bool),
+			next:      kafka.OffsetInvalid,
+			committed: kafka.OffsetInvalid,
+		}
+		t.partitions[key] = offsets
+	}
+
+	offsets.pending = append(offsets.pending, tp.Offset)
+
+	return offsets
+}
+
+// begin is called by the worker before processing, false when the partition is revoked and the message is skipped.
+func (t *offsetTracker) begin(offsets *partitionOffsets) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if offsets.revoked {
+		return false
+	}
+
+	offsets.running++
+
+	return true
+}
+
+// finish is called after begin, the offset becomes committable only when the message is processed.
+func (t *offsetTracker) finish(offsets *partitionOffsets, offset kafka.Offset, processed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	offsets.running--
+
+	if processed {
+		offsets.done[offset] = true
+
+		for len(offsets.pending) > 0 && offsets.done[offsets.pending[0]] {
+			delete(offsets.done, offsets.pending[0])
+			offsets.next = offsets.pending[0] + 1
+			offsets.pending = offsets.pending[1:]
+		}
+	}
+
+	t.notifyLocked()
+}
+
+func (t *offsetTracker) isRevoked(offsets *partitionOffsets) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return offsets.revoked
+}
+
+func (t *offsetTracker) notifyLocked() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// revoke stops the workers from starting the queued messages of the partitions.
+func (t *offsetTracker) revoke(partitionList []kafka.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, tp := range partitionList {
+		if offsets, found := t.partitions[toTopicPartition(tp)]; found {
+			offsets.revoked = true
+		}
+	}
+
+	t.notifyLocked()
+}
+
+// waitIdle waits until no message of the partitions is processing, false on timeout.
+func (t *offsetTracker) waitIdle(partitionList []kafka.TopicPartition, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		t.mu.Lock()
+		running := 0
+		for _, tp := range partitionList {
+			if offsets, found := t.partitions[toTopicPartition(tp)]; found {
+				running += offsets.running
+			}
+		}
+		changed := t.changed
+		t.mu.Unlock()
+
+		if running == 0 {
+			return true
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// remove forgets the partitions, the offsets are tracked again from the next message after the partition is assigned.
+func (t *offsetTracker) remove(partitionList []kafka.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, tp := range partitionList {
+		delete(t.partitions, toTopicPartition(tp))
+	}
+}
+
+// commitList returns the commit offsets which are not committed yet, only of partitionList when it is not empty.
+func (t *offsetTracker) commitList(partitionList ...kafka.TopicPartition) []kafka.TopicPartition {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var keyList []topicPartition
+
+	if len(partitionList) > 0 {
+		for _, tp := range partitionList {
+			keyList = append(keyList, toTopicPartition(tp))
+		}
+	} else {
+		for key := range t.partitions {
+			keyList = append(keyList, key)
+		}
+	}
+
+	var commitList []kafka.TopicPartition
+
+	for _, key := range keyList {
+		offsets, found := t.partitions[key]
+
+		if !found || offsets.next == kafka.OffsetInvalid || offsets.next == offsets.committed {
+			continue
+		}
+
+		topic := key.topic
+		commitList = append(commitList, kafka.TopicPartition{Topic: &topic, Partition: key.partition, Offset: offsets.next})
+	}
+
+	sort.Slice(commitList, func(i, j int) bool {
+		if *commitList[i].Topic != *commitList[j].Topic {
+			return *commitList[i].Topic < *commitList[j].Topic
+		}
+		return commitList[i].Partition < commitList[j].Partition
+	})
+
+	return commitList
+}
+
+// setCommitted records the offsets of a successful commit.
+func (t *offsetTracker) setCommitted(commitList []kafka.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, tp := range commitList {
+		if offsets, found := t.partitions[toTopicPartition(tp)]; found && tp.Offset > offsets.committed {
+			offsets.committed = tp.Offset
+		}
+	}
+}
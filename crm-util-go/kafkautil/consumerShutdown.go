@@ -0,0 +1,157 @@
+package kafkautil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	kafkaPollTimeoutMs          int           = 500
+	kafkaShutdownCheckInterval  time.Duration = 100 * time.Millisecond
+	kafkaDefaultShutdownTimeout time.Duration = 30 * time.Second
+)
+
+var ErrShutdownTimeout = errors.New("kafka consumer is not stopped within ShutdownTimeout")
+
+/*
+SignalContext is cancelled on SIGINT, SIGTERM or SIGQUIT, the consumers of the context finish the running messages,
+commit the offsets and close, call stop to release the signals.
+
+Ex.
+ctx, stop := kafkautil.SignalContext(context.Background())
+defer stop()
+
+err := kc.Consumer(ctx, transID, onMessage)
+*/
+func SignalContext(parent context.Context) (ctx context.Context, stop context.CancelFunc) {
+	return signal.NotifyContext(parent, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+}
+
+/*
+kafkaShutdownMap holds the KafkaConfig of ShutdownConsumer until StartConsumer.
+The flag is kept out of KafkaConfig, so the copies of the value receivers do not race with ShutdownConsumer
+and a copy which runs its own consumers is not stopped by the shutdown of the original.
+*/
+var (
+	kafkaShutdownMu  sync.RWMutex
+	kafkaShutdownMap = make(map[*KafkaConfig]bool)
+)
+
+// StartConsumer clears the shutdown of ShutdownConsumer, so the consumers of kc can start again.
+func (kc *KafkaConfig) StartConsumer() {
+	kafkaShutdownMu.Lock()
+	defer kafkaShutdownMu.Unlock()
+
+	delete(kafkaShutdownMap, kc)
+}
+
+/*
+ShutdownConsumer stops the running consumers of kc, the same as the cancellation of their context,
+and the consumers started later until StartConsumer. A consumer which returns does not stop the others.
+*/
+func (kc *KafkaConfig) ShutdownConsumer() {
+	kafkaShutdownMu.Lock()
+	defer kafkaShutdownMu.Unlock()
+
+	kafkaShutdownMap[kc] = true
+}
+
+func (kc *KafkaConfig) IsShutdown() bool {
+	kafkaShutdownMu.RLock()
+	defer kafkaShutdownMu.RUnlock()
+
+	return kafkaShutdownMap[kc]
+}
+
+func (kc *KafkaConfig) isStopped(ctx context.Context) bool {
+	return ctx.Err() != nil || kc.IsShutdown()
+}
+
+// sleep returns false when the consumer stops before d.
+func (kc *KafkaConfig) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	ticker := time.NewTicker(kafkaShutdownCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return !kc.IsShutdown()
+		case <-ticker.C:
+			if kc.IsShutdown() {
+				return false
+			}
+		}
+	}
+}
+
+func (kc *KafkaConfig) shutdownTimeout() time.Duration {
+	if kc.ShutdownTimeout <= 0 {
+		return kafkaDefaultShutdownTimeout
+	}
+
+	return kc.ShutdownTimeout
+}
+
+// closeConsumer returns ErrShutdownTimeout when the consumer is not closed before deadline,
+// the consumer leaves the group in background.
+func (kc *KafkaConfig) closeConsumer(transID string, consumer io.Closer, deadline time.Time) error {
+	done := make(chan error, 1)
+
+	go func() {
+		done <- consumer.Close()
+	}()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			kc.Logger.Error(transID, "Consumer.Close Error: "+err.Error())
+			return err
+		}
+
+		kc.Logger.Info(transID, "Consumer.Close Success")
+		return nil
+	case <-timer.C:
+		kc.Logger.Error(transID, "Consumer.Close Error: "+ErrShutdownTimeout.Error())
+		return ErrShutdownTimeout
+	}
+}
+
+// waitTimeout returns false when wg is still waiting after timeout.
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// consumerInfo is logged instead of the whole KafkaConfig which is changed by ShutdownConsumer concurrently.
+func (kc *KafkaConfig) consumerInfo() string {
+	return "BootstrapServers: " + kc.BootstrapServers + ", TopicName: " + strings.Join(kc.TopicName, ",") +
+		", GroupID: " + kc.GroupID + ", SecurityProtocol: " + kc.SecurityProtocol
+}
@@ -0,0 +1,198 @@
+package kafkautil
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+type blockingCloser struct {
+	release chan struct{}
+}
+
+func (c blockingCloser) Close() error {
+	<-c.release
+	return nil
+}
+
+func TestKafkaConfigSleep(t *testing.T) {
+	kc := newTestKafkaConfig()
+
+	if !kc.sleep(context.Background(), 10*time.Millisecond) {
+		t.Errorf("sleep should return true after d")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	if kc.sleep(ctx, time.Minute) || time.Since(start) > time.Second {
+		t.Errorf("sleep should return false when ctx is cancelled")
+	}
+
+	time.AfterFunc(20*time.Millisecond, kc.ShutdownConsumer)
+
+	start = time.Now()
+	if kc.sleep(context.Background(), time.Minute) || time.Since(start) > time.Second {
+		t.Errorf("sleep should return false when the consumer is shutdown")
+	}
+
+	kc.StartConsumer()
+
+	if kc.IsShutdown() {
+		t.Errorf("StartConsumer should clear the shutdown")
+	}
+}
+
+func TestKafkaConfigCopyShutdown(t *testing.T) {
+	kc := newTestKafkaConfig()
+	defer kc.StartConsumer()
+
+	// The value receivers, e.g. Publish and ReplayDLQ, take a copy of kc while ShutdownConsumer runs
+	copyKc := *kc
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 10; i++ {
+			copyKc = *kc
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	kc.ShutdownConsumer()
+	<-done
+
+	if !kc.IsShutdown() || copyKc.IsShutdown() {
+		t.Errorf("the shutdown of kc should not stop the consumers of its copy")
+	}
+
+	kc.StartConsumer()
+	copyKc.ShutdownConsumer()
+	defer copyKc.StartConsumer()
+
+	if kc.IsShutdown() {
+		t.Errorf("the shutdown of the copy should not stop the consumers of kc")
+	}
+}
+
+func TestCloseConsumerTimeout(t *testing.T) {
+	kc := newTestKafkaConfig()
+	closer := blockingCloser{release: make(chan struct{})}
+	defer close(closer.release)
+
+	err := kc.closeConsumer("transID", closer, time.Now().Add(20*time.Millisecond))
+
+	if !errors.Is(err, ErrShutdownTimeout) {
+		t.Errorf("expected ErrShutdownTimeout, got %v", err)
+	}
+}
+
+func TestConsumerWorkerPoolShutdownTimeout(t *testing.T) {
+	kc := newTestKafkaConfig()
+	tracker := newOffsetTracker()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	pool := kc.newConsumerWorkerPool(tracker, nil, func(transID string, msg *kafka.Message) error {
+		close(started)
+		<-release
+		return nil
+	})
+
+	pool.dispatch(newTestMessage("order", 0, 0, ""))
+	<-started
+
+	if pool.shutdown(20 * time.Millisecond) {
+		t.Errorf("shutdown should time out while the message is running")
+	}
+
+	close(release)
+
+	if !waitTimeout(&pool.wg, time.Second) {
+		t.Fatalf("worker should stop after the running message")
+	}
+
+	if commitList := tracker.commitList(); len(commitList) != 1 || commitList[0].Offset != 1 {
+		t.Errorf("running message should be committable after it is finished, got %v", commitList)
+	}
+}
+
+func TestConsumerReturnsError(t *testing.T) {
+	kc := newTestKafkaConfig()
+	kc.BootstrapServers = "localhost:1"
+	kc.TopicName = []string{"order"}
+
+	err := kc.Consumer(context.Background(), "transID", func(transID string, topicName string, kafkaKey string, kafkaMsg string) error {
+		return nil
+	})
+
+	if err == nil {
+		t.Errorf("Consumer with invalid config should return the error of NewConsumer")
+	}
+}
+
+func TestConsumerStopsOnContext(t *testing.T) {
+	kc := newTestKafkaConfig()
+	kc.BootstrapServers = "localhost:1"
+	kc.TopicName = []string{"order"}
+	kc.GroupID = "shutdown-test"
+	kc.SecurityProtocol = "PLAINTEXT"
+	kc.SaslMechanism = "PLAIN"
+	kc.KerberosPrincipalName = "kafkaclient"
+	kc.KerberosServiceName = "kafka"
+	kc.ShutdownTimeout = 5 * time.Second
+
+	for _, consume := range []func(ctx context.Context) error{
+		func(ctx context.Context) error {
+			return kc.ConsumerMessage(ctx, "transID", func(transID string, msg *kafka.Message) error { return nil })
+		},
+		func(ctx context.Context) error {
+			return kc.ConsumerConcurrent(ctx, "transID", func(transID string, msg *kafka.Message) error { return nil })
+		},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		start := time.Now()
+		err := consume(ctx)
+		cancel()
+
+		if err != nil {
+			t.Errorf("stopped consumer should return nil, got %v", err)
+		}
+
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Errorf("consumer should stop soon after ctx is cancelled, took %s", elapsed)
+		}
+
+		if kc.IsShutdown() {
+			t.Errorf("the stopped consumer should not shut down the other consumers of kc")
+		}
+	}
+}
+
+func TestSignalContext(t *testing.T) {
+	ctx, stop := SignalContext(context.Background())
+	defer stop()
+
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Skip(err)
+	}
+
+	if err = process.Signal(syscall.SIGTERM); err != nil {
+		t.Skip(err)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("SIGTERM should cancel the context")
+	}
+}
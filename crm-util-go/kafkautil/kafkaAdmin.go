@@ -0,0 +1,511 @@
+package kafkautil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+const (
+	kafkaAdminTimeout   time.Duration = 30 * time.Second
+	kafkaAdminTimeoutMs int           = 10000
+	kafkaAdminGroupID   string        = "crm-util-go.admin"
+	kafkaNoOffset       int64         = -1
+)
+
+var ErrUnknownOffsetReset = errors.New("kafka offset reset must be earliest, latest, timestamp or offset")
+
+// kafkaAdminClient is implemented by *kafka.AdminClient.
+type kafkaAdminClient interface {
+	CreateTopics(ctx context.Context, topics []kafka.TopicSpecification,
+		options ...kafka.CreateTopicsAdminOption) ([]kafka.TopicResult, error)
+	DeleteTopics(ctx context.Context, topics []string, options ...kafka.DeleteTopicsAdminOption) ([]kafka.TopicResult, error)
+	DescribeConfigs(ctx context.Context, resources []kafka.ConfigResource,
+		options ...kafka.DescribeConfigsAdminOption) ([]kafka.ConfigResourceResult, error)
+	GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error)
+	ListConsumerGroups(ctx context.Context, options ...kafka.ListConsumerGroupsAdminOption) (kafka.ListConsumerGroupsResult, error)
+	ListConsumerGroupOffsets(ctx context.Context, groupsPartitions []kafka.ConsumerGroupTopicPartitions,
+		options ...kafka.ListConsumerGroupOffsetsAdminOption) (kafka.ListConsumerGroupOffsetsResult, error)
+	AlterConsumerGroupOffsets(ctx context.Context, groupsPartitions []kafka.ConsumerGroupTopicPartitions,
+		options ...kafka.AlterConsumerGroupOffsetsAdminOption) (kafka.AlterConsumerGroupOffsetsResult, error)
+	Close()
+}
+
+// kafkaOffsetClient is implemented by *kafka.Consumer.
+type kafkaOffsetClient interface {
+	QueryWatermarkOffsets(topic string, partition int32, timeoutMs int) (low int64, high int64, err error)
+	OffsetsForTimes(times []kafka.TopicPartition, timeoutMs int) ([]kafka.TopicPartition, error)
+	Close() error
+}
+
+/*
+KafkaAdmin manages the topics and the consumer group offsets with the security fields of KafkaConfig.
+
+	admin, err := kafkautil.NewKafkaAdmin(transID, kc)
+	defer admin.Close()
+	lagList, err := admin.GroupLag(ctx, transID, "crm-order", "order")
+*/
+type KafkaAdmin struct {
+	kc      KafkaConfig
+	admin   kafkaAdminClient
+	offsets kafkaOffsetClient
+}
+
+func NewKafkaAdmin(transID string, kc KafkaConfig) (*KafkaAdmin, error) {
+	kc.Logger.Info(transID, "Starting KafkaAdmin. BootstrapServers: "+kc.BootstrapServers)
+
+	// The consumer never joins its group, it queries the offsets of the partitions only.
+	adminConfig := kc
+	adminConfig.GroupID = kafkaAdminGroupID
+
+	consumer, err := kafka.NewConsumer(adminConfig.consumerConfigMap())
+
+	if err != nil {
+		kc.Logger.Error(transID, "KafkaAdmin can not connect to kafka server", err)
+		return nil, err
+	}
+
+	admin, err := kafka.NewAdminClientFromConsumer(consumer)
+
+	if err != nil {
+		consumer.Close()
+		kc.Logger.Error(transID, "KafkaAdmin.NewAdminClient Error: "+err.Error())
+		return nil, err
+	}
+
+	return &KafkaAdmin{kc: kc, admin: admin, offsets: consumer}, nil
+}
+
+func (a *KafkaAdmin) Close() {
+	a.admin.Close()
+	a.offsets.Close()
+}
+
+// joinErrors returns nil when errList is empty, errors.Join is not available in go 1.18.
+func joinErrors(errList []string) error {
+	if len(errList) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(errList, ", "))
+}
+
+func topicResultError(topicResultList []kafka.TopicResult) error {
+	var errList []string
+
+	for _, result := range topicResultList {
+		if result.Error.Code() != kafka.ErrNoError {
+			errList = append(errList, result.Topic+": "+result.Error.Error())
+		}
+	}
+
+	return joinErrors(errList)
+}
+
+// CreateTopics waits until the topics are created, the topics which exist already are returned in the error.
+func (a *KafkaAdmin) CreateTopics(ctx context.Context, transID string, topicList ...KafkaTopicSpec) error {
+	specList := make([]kafka.TopicSpecification, 0, len(topicList))
+
+	for _, topic := range topicList {
+		specList = append(specList, kafka.TopicSpecification{
+			Topic:             topic.Name,
+			NumPartitions:     topic.Partitions,
+			ReplicationFactor: topic.ReplicationFactor,
+			Config:            topic.Config,
+		})
+	}
+
+	resultList, err := a.admin.CreateTopics(ctx, specList, kafka.SetAdminOperationTimeout(kafkaAdminTimeout))
+
+	if err == nil {
+		err = topicResultError(resultList)
+	}
+
+	if err != nil {
+		a.kc.Logger.Error(transID, "KafkaAdmin.CreateTopics Error: "+err.Error())
+		return err
+	}
+
+	a.kc.Logger.Info(transID, fmt.Sprintf("KafkaAdmin created topics %+v", topicList))
+
+	return nil
+}
+
+func (a *KafkaAdmin) DeleteTopics(ctx context.Context, transID string, topicNames ...string) error {
+	resultList, err := a.admin.DeleteTopics(ctx, topicNames, kafka.SetAdminOperationTimeout(kafkaAdminTimeout))
+
+	if err == nil {
+		err = topicResultError(resultList)
+	}
+
+	if err != nil {
+		a.kc.Logger.Error(transID, "KafkaAdmin.DeleteTopics Error: "+err.Error())
+		return err
+	}
+
+	a.kc.Logger.Info(transID, "KafkaAdmin deleted topics "+strings.Join(topicNames, ", "))
+
+	return nil
+}
+
+func (a *KafkaAdmin) topicMetadata(topicName string) (kafka.TopicMetadata, error) {
+	metadata, err := a.admin.GetMetadata(&topicName, false, kafkaAdminTimeoutMs)
+
+	if err != nil {
+		return kafka.TopicMetadata{}, err
+	}
+
+	topic, found := metadata.Topics[topicName]
+
+	if !found {
+		return topic, fmt.Errorf("%s: %w", topicName, kafka.NewError(kafka.ErrUnknownTopicOrPart, "unknown topic", false))
+	}
+
+	if topic.Error.Code() != kafka.ErrNoError {
+		return topic, fmt.Errorf("%s: %w", topicName, topic.Error)
+	}
+
+	sort.Slice(topic.Partitions, func(i, j int) bool {
+		return topic.Partitions[i].ID < topic.Partitions[j].ID
+	})
+
+	return topic, nil
+}
+
+// DescribeTopics describes topicNames, or all the topics except the internal topics when topicNames is empty.
+func (a *KafkaAdmin) DescribeTopics(ctx context.Context, transID string, topicNames ...string) ([]KafkaTopicDescription, error) {
+	if len(topicNames) == 0 {
+		metadata, err := a.admin.GetMetadata(nil, true, kafkaAdminTimeoutMs)
+
+		if err != nil {
+			a.kc.Logger.Error(transID, "KafkaAdmin.GetMetadata Error: "+err.Error())
+			return nil, err
+		}
+
+		for topicName := range metadata.Topics {
+			if !strings.HasPrefix(topicName, "__") {
+				topicNames = append(topicNames, topicName)
+			}
+		}
+
+		sort.Strings(topicNames)
+	}
+
+	descriptionList := make([]KafkaTopicDescription, 0, len(topicNames))
+	resourceList := make([]kafka.ConfigResource, 0, len(topicNames))
+
+	for _, topicName := range topicNames {
+		topic, err := a.topicMetadata(topicName)
+
+		if err != nil {
+			a.kc.Logger.Error(transID, "KafkaAdmin.DescribeTopics Error: "+err.Error())
+			return nil, err
+		}
+
+		description := KafkaTopicDescription{Name: topicName, Config: make(map[string]string)}
+
+		for _, partition := range topic.Partitions {
+			description.Partitions = append(description.Partitions, KafkaPartitionDescription{
+				Partition: partition.ID,
+				Leader:    partition.Leader,
+				Replicas:  partition.Replicas,
+				Isrs:      partition.Isrs,
+			})
+		}
+
+		descriptionList = append(descriptionList, description)
+		resourceList = append(resourceList, kafka.ConfigResource{Type: kafka.ResourceTopic, Name: topicName})
+	}
+
+	if len(resourceList) == 0 {
+		return descriptionList, nil
+	}
+
+	configList, err := a.admin.DescribeConfigs(ctx, resourceList)
+
+	if err != nil {
+		a.kc.Logger.Error(transID, "KafkaAdmin.DescribeConfigs Error: "+err.Error())
+		return nil, err
+	}
+
+	topicConfigMap := make(map[string]map[string]string)
+	for _, description := range descriptionList {
+		topicConfigMap[description.Name] = description.Config
+	}
+
+	for _, config := range configList {
+		if config.Error.Code() != kafka.ErrNoError {
+			err = fmt.Errorf("%s: %w", config.Name, config.Error)
+			a.kc.Logger.Error(transID, "KafkaAdmin.DescribeConfigs Error: "+err.Error())
+			return nil, err
+		}
+
+		for name, entry := range config.Config {
+			if topicConfig, found := topicConfigMap[config.Name]; found && !entry.IsDefault && entry.Source != kafka.ConfigSourceDefault {
+				topicConfig[name] = entry.Value
+			}
+		}
+	}
+
+	return descriptionList, nil
+}
+
+func (a *KafkaAdmin) ListConsumerGroups(ctx context.Context, transID string) ([]KafkaConsumerGroup, error) {
+	result, err := a.admin.ListConsumerGroups(ctx, kafka.SetAdminRequestTimeout(kafkaAdminTimeout))
+
+	if err == nil && len(result.Errors) > 0 {
+		errList := make([]string, 0, len(result.Errors))
+
+		for _, groupErr := range result.Errors {
+			errList = append(errList, groupErr.Error())
+		}
+
+		err = joinErrors(errList)
+	}
+
+	if err != nil {
+		a.kc.Logger.Error(transID, "KafkaAdmin.ListConsumerGroups Error: "+err.Error())
+		return nil, err
+	}
+
+	groupList := make([]KafkaConsumerGroup, 0, len(result.Valid))
+
+	for _, group := range result.Valid {
+		groupList = append(groupList, KafkaConsumerGroup{
+			GroupID:  group.GroupID,
+			State:    group.State.String(),
+			IsSimple: group.IsSimpleConsumerGroup,
+		})
+	}
+
+	sort.Slice(groupList, func(i, j int) bool {
+		return groupList[i].GroupID < groupList[j].GroupID
+	})
+
+	return groupList, nil
+}
+
+// committedOffsets returns the committed offset of each partition of topic, kafkaNoOffset when it is not committed.
+func (a *KafkaAdmin) committedOffsets(ctx context.Context, groupID string, topic kafka.TopicMetadata) (map[int32]int64, error) {
+	partitionList := make([]kafka.TopicPartition, 0, len(topic.Partitions))
+
+	for _, partition := range topic.Partitions {
+		partitionList = append(partitionList, kafka.TopicPartition{Topic: &topic.Topic, Partition: partition.ID})
+	}
+
+	result, err := a.admin.ListConsumerGroupOffsets(ctx,
+		[]kafka.ConsumerGroupTopicPartitions{{Group: groupID, Partitions: partitionList}})
+
+	if err != nil {
+		return nil, err
+	}
+
+	offsetMap := make(map[int32]int64)
+	var errList []string
+
+	for _, group := range result.ConsumerGroupsTopicPartitions {
+		for _, tp := range group.Partitions {
+			if tp.Error != nil {
+				errList = append(errList, getTopicInfo(tp)+": "+tp.Error.Error())
+			} else if tp.Offset >= 0 {
+				offsetMap[tp.Partition] = int64(tp.Offset)
+			}
+		}
+	}
+
+	for _, partition := range topic.Partitions {
+		if _, found := offsetMap[partition.ID]; !found {
+			offsetMap[partition.ID] = kafkaNoOffset
+		}
+	}
+
+	return offsetMap, joinErrors(errList)
+}
+
+/*
+GroupLag returns the lag of groupID on each partition of topic, the number of messages after its committed offset.
+The lag of a partition without a committed offset counts the messages from the log start offset.
+*/
+func (a *KafkaAdmin) GroupLag(ctx context.Context, transID string, groupID string, topicName string) ([]KafkaPartitionLag, error) {
+	topic, err := a.topicMetadata(topicName)
+
+	if err != nil {
+		a.kc.Logger.Error(transID, "KafkaAdmin.GroupLag Error: "+err.Error())
+		return nil, err
+	}
+
+	offsetMap, err := a.committedOffsets(ctx, groupID, topic)
+
+	if err != nil {
+		a.kc.Logger.Error(transID, "KafkaAdmin.ListConsumerGroupOffsets Error: "+err.Error())
+		return nil, err
+	}
+
+	lagList := make([]KafkaPartitionLag, 0, len(topic.Partitions))
+
+	for _, partition := range topic.Partitions {
+		low, high, err := a.offsets.QueryWatermarkOffsets(topicName, partition.ID, kafkaAdminTimeoutMs)
+
+		if err != nil {
+			a.kc.Logger.Error(transID, fmt.Sprintf("KafkaAdmin.QueryWatermarkOffsets of %s [%d] Error: %s",
+				topicName, partition.ID, err.Error()))
+			return nil, err
+		}
+
+		lag := KafkaPartitionLag{
+			Topic:           topicName,
+			Partition:       partition.ID,
+			CommittedOffset: offsetMap[partition.ID],
+			LogStartOffset:  low,
+			LogEndOffset:    high,
+		}
+
+		if lag.CommittedOffset == kafkaNoOffset {
+			lag.Lag = high - low
+		} else if lag.CommittedOffset < high {
+			lag.Lag = high - lag.CommittedOffset
+		}
+
+		lagList = append(lagList, lag)
+	}
+
+	return lagList, nil
+}
+
+// targetOffsets returns the new offset of each partition in lagList.
+func (a *KafkaAdmin) targetOffsets(topicName string, lagList []KafkaPartitionLag, reset KafkaOffsetReset) (map[int32]int64, error) {
+	targetMap := make(map[int32]int64)
+
+	switch reset.To {
+	case KafkaOffsetEarliest:
+		for _, lag := range lagList {
+			targetMap[lag.Partition] = lag.LogStartOffset
+		}
+	case KafkaOffsetLatest:
+		for _, lag := range lagList {
+			targetMap[lag.Partition] = lag.LogEndOffset
+		}
+	case KafkaOffsetSpecific:
+		for _, lag := range lagList {
+			offset := reset.Offset
+
+			if offset < lag.LogStartOffset {
+				offset = lag.LogStartOffset
+			} else if offset > lag.LogEndOffset {
+				offset = lag.LogEndOffset
+			}
+
+			targetMap[lag.Partition] = offset
+		}
+	case KafkaOffsetTimestamp:
+		timeList := make([]kafka.TopicPartition, 0, len(lagList))
+
+		for _, lag := range lagList {
+			timeList = append(timeList, kafka.TopicPartition{
+				Topic:     &topicName,
+				Partition: lag.Partition,
+				Offset:    kafka.Offset(reset.Timestamp.UnixMilli()),
+			})
+		}
+
+		offsetList, err := a.offsets.OffsetsForTimes(timeList, kafkaAdminTimeoutMs)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tp := range offsetList {
+			if tp.Error != nil {
+				return nil, fmt.Errorf("%s: %w", getTopicInfo(tp), tp.Error)
+			}
+
+			targetMap[tp.Partition] = int64(tp.Offset)
+		}
+
+		// There is no message at or after Timestamp.
+		for _, lag := range lagList {
+			if offset, found := targetMap[lag.Partition]; !found || offset < 0 {
+				targetMap[lag.Partition] = lag.LogEndOffset
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownOffsetReset, reset.To)
+	}
+
+	return targetMap, nil
+}
+
+/*
+ResetOffsets commits the offsets of groupID on each partition of topic to reset.To and returns the old and new offsets.
+The broker rejects the new offsets when a consumer of the group is running.
+*/
+func (a *KafkaAdmin) ResetOffsets(ctx context.Context, transID string, groupID string, topicName string,
+	reset KafkaOffsetReset) ([]KafkaPartitionOffset, error) {
+
+	lagList, err := a.GroupLag(ctx, transID, groupID, topicName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	targetMap, err := a.targetOffsets(topicName, lagList, reset)
+
+	if err != nil {
+		a.kc.Logger.Error(transID, "KafkaAdmin.ResetOffsets Error: "+err.Error())
+		return nil, err
+	}
+
+	offsetList := make([]KafkaPartitionOffset, 0, len(lagList))
+	partitionList := make([]kafka.TopicPartition, 0, len(lagList))
+
+	for _, lag := range lagList {
+		offsetList = append(offsetList, KafkaPartitionOffset{
+			Topic:     topicName,
+			Partition: lag.Partition,
+			OldOffset: lag.CommittedOffset,
+			NewOffset: targetMap[lag.Partition],
+		})
+
+		partitionList = append(partitionList, kafka.TopicPartition{
+			Topic:     &topicName,
+			Partition: lag.Partition,
+			Offset:    kafka.Offset(targetMap[lag.Partition]),
+		})
+	}
+
+	if reset.DryRun {
+		return offsetList, nil
+	}
+
+	result, err := a.admin.AlterConsumerGroupOffsets(ctx,
+		[]kafka.ConsumerGroupTopicPartitions{{Group: groupID, Partitions: partitionList}})
+
+	if err == nil {
+		var errList []string
+
+		for _, group := range result.ConsumerGroupsTopicPartitions {
+			for _, tp := range group.Partitions {
+				if tp.Error != nil {
+					errList = append(errList, getTopicInfo(tp)+": "+tp.Error.Error())
+				}
+			}
+		}
+
+		err = joinErrors(errList)
+	}
+
+	if err != nil {
+		a.kc.Logger.Error(transID, "KafkaAdmin.AlterConsumerGroupOffsets Error: "+err.Error())
+		return nil, err
+	}
+
+	a.kc.Logger.Info(transID, fmt.Sprintf("KafkaAdmin reset offsets of group %s on %s to %s: %+v",
+		groupID, topicName, reset.To, offsetList))
+
+	return offsetList, nil
+}
@@ -0,0 +1,400 @@
+package kafkautil
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+type memoryPartition struct {
+	low        int64
+	high       int64
+	timestamps []int64
+}
+
+// memoryAdminClient keeps the topics and the committed offsets of the groups in memory.
+type memoryAdminClient struct {
+	mu          sync.Mutex
+	topicMap    map[string][]memoryPartition
+	configMap   map[string]map[string]string
+	committed   map[string]map[int32]int64
+	activeGroup map[string]bool
+	groupList   []kafka.ConsumerGroupListing
+}
+
+func newMemoryAdminClient() *memoryAdminClient {
+	return &memoryAdminClient{
+		topicMap:    make(map[string][]memoryPartition),
+		configMap:   make(map[string]map[string]string),
+		committed:   make(map[string]map[int32]int64),
+		activeGroup: make(map[string]bool),
+	}
+}
+
+func (m *memoryAdminClient) CreateTopics(ctx context.Context, topics []kafka.TopicSpecification,
+	options ...kafka.CreateTopicsAdminOption) ([]kafka.TopicResult, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var resultList []kafka.TopicResult
+
+	for _, topic := range topics {
+		result := kafka.TopicResult{Topic: topic.Topic, Error: kafka.NewError(kafka.ErrNoError, "", false)}
+
+		if _, found := m.topicMap[topic.Topic]; found {
+			result.Error = kafka.NewError(kafka.ErrTopicAlreadyExists, "Topic '"+topic.Topic+"' already exists.", false)
+		} else {
+			m.topicMap[topic.Topic] = make([]memoryPartition, topic.NumPartitions)
+			m.configMap[topic.Topic] = topic.Config
+		}
+
+		resultList = append(resultList, result)
+	}
+
+	return resultList, nil
+}
+
+func (m *memoryAdminClient) DeleteTopics(ctx context.Context, topics []string,
+	options ...kafka.DeleteTopicsAdminOption) ([]kafka.TopicResult, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var resultList []kafka.TopicResult
+
+	for _, topic := range topics {
+		result := kafka.TopicResult{Topic: topic, Error: kafka.NewError(kafka.ErrNoError, "", false)}
+
+		if _, found := m.topicMap[topic]; !found {
+			result.Error = kafka.NewError(kafka.ErrUnknownTopicOrPart, "Broker: Unknown topic or partition", false)
+		}
+
+		delete(m.topicMap, topic)
+		resultList = append(resultList, result)
+	}
+
+	return resultList, nil
+}
+
+func (m *memoryAdminClient) DescribeConfigs(ctx context.Context, resources []kafka.ConfigResource,
+	options ...kafka.DescribeConfigsAdminOption) ([]kafka.ConfigResourceResult, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var resultList []kafka.ConfigResourceResult
+
+	// The results are in reverse order, they are matched by name.
+	for i := len(resources) - 1; i >= 0; i-- {
+		result := kafka.ConfigResourceResult{
+			Type:   resources[i].Type,
+			Name:   resources[i].Name,
+			Error:  kafka.NewError(kafka.ErrNoError, "", false),
+			Config: map[string]kafka.ConfigEntryResult{"cleanup.policy": {Name: "cleanup.policy", Value: "delete", IsDefault: true}},
+		}
+
+		for name, value := range m.configMap[resources[i].Name] {
+			result.Config[name] = kafka.ConfigEntryResult{Name: name, Value: value, Source: kafka.ConfigSourceDynamicTopic}
+		}
+
+		resultList = append(resultList, result)
+	}
+
+	return resultList, nil
+}
+
+func (m *memoryAdminClient) GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metadata := &kafka.Metadata{Topics: make(map[string]kafka.TopicMetadata)}
+
+	for topicName, partitionList := range m.topicMap {
+		if !allTopics && topicName != *topic {
+			continue
+		}
+
+		topicMetadata := kafka.TopicMetadata{Topic: topicName}
+
+		for i := len(partitionList) - 1; i >= 0; i-- {
+			topicMetadata.Partitions = append(topicMetadata.Partitions, kafka.PartitionMetadata{
+				ID: int32(i), Leader: 1, Replicas: []int32{1, 2}, Isrs: []int32{1, 2},
+			})
+		}
+
+		metadata.Topics[topicName] = topicMetadata
+	}
+
+	if !allTopics && len(metadata.Topics) == 0 {
+		metadata.Topics[*topic] = kafka.TopicMetadata{
+			Topic: *topic,
+			Error: kafka.NewError(kafka.ErrUnknownTopicOrPart, "Broker: Unknown topic or partition", false),
+		}
+	}
+
+	return metadata, nil
+}
+
+func (m *memoryAdminClient) ListConsumerGroups(ctx context.Context,
+	options ...kafka.ListConsumerGroupsAdminOption) (kafka.ListConsumerGroupsResult, error) {
+
+	return kafka.ListConsumerGroupsResult{Valid: m.groupList}, nil
+}
+
+func (m *memoryAdminClient) ListConsumerGroupOffsets(ctx context.Context, groupsPartitions []kafka.ConsumerGroupTopicPartitions,
+	options ...kafka.ListConsumerGroupOffsetsAdminOption) (kafka.ListConsumerGroupOffsetsResult, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group := groupsPartitions[0]
+	partitionList := make([]kafka.TopicPartition, 0, len(group.Partitions))
+
+	for _, tp := range group.Partitions {
+		tp.Offset = kafka.OffsetInvalid
+
+		if offset, found := m.committed[group.Group][tp.Partition]; found {
+			tp.Offset = kafka.Offset(offset)
+		}
+
+		partitionList = append(partitionList, tp)
+	}
+
+	return kafka.ListConsumerGroupOffsetsResult{
+		ConsumerGroupsTopicPartitions: []kafka.ConsumerGroupTopicPartitions{{Group: group.Group, Partitions: partitionList}},
+	}, nil
+}
+
+func (m *memoryAdminClient) AlterConsumerGroupOffsets(ctx context.Context, groupsPartitions []kafka.ConsumerGroupTopicPartitions,
+	options ...kafka.AlterConsumerGroupOffsetsAdminOption) (kafka.AlterConsumerGroupOffsetsResult, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group := groupsPartitions[0]
+	partitionList := make([]kafka.TopicPartition, 0, len(group.Partitions))
+
+	for _, tp := range group.Partitions {
+		if m.activeGroup[group.Group] {
+			tp.Error = kafka.NewError(kafka.ErrUnknownMemberID, "Broker: Unknown member", false)
+		} else {
+			if m.committed[group.Group] == nil {
+				m.committed[group.Group] = make(map[int32]int64)
+			}
+
+			m.committed[group.Group][tp.Partition] = int64(tp.Offset)
+		}
+
+		partitionList = append(partitionList, tp)
+	}
+
+	return kafka.AlterConsumerGroupOffsetsResult{
+		ConsumerGroupsTopicPartitions: []kafka.ConsumerGroupTopicPartitions{{Group: group.Group, Partitions: partitionList}},
+	}, nil
+}
+
+func (m *memoryAdminClient) Close() {
+}
+
+func (m *memoryAdminClient) QueryWatermarkOffsets(topic string, partition int32, timeoutMs int) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	partitionList, found := m.topicMap[topic]
+
+	if !found || int(partition) >= len(partitionList) {
+		return 0, 0, kafka.NewError(kafka.ErrUnknownTopicOrPart, "Broker: Unknown topic or partition", false)
+	}
+
+	return partitionList[partition].low, partitionList[partition].high, nil
+}
+
+// OffsetsForTimes returns the offset of the first timestamp at or after the time, or -1.
+func (m *memoryAdminClient) OffsetsForTimes(times []kafka.TopicPartition, timeoutMs int) ([]kafka.TopicPartition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var offsetList []kafka.TopicPartition
+
+	for _, tp := range times {
+		p := m.topicMap[*tp.Topic][tp.Partition]
+		timestamp := int64(tp.Offset)
+		tp.Offset = kafka.OffsetEnd
+
+		for i, messageTimestamp := range p.timestamps {
+			if messageTimestamp >= timestamp {
+				tp.Offset = kafka.Offset(p.low + int64(i))
+				break
+			}
+		}
+
+		offsetList = append(offsetList, tp)
+	}
+
+	return offsetList, nil
+}
+
+// memoryOffsetClient is the kafkaOffsetClient of memoryAdminClient.
+type memoryOffsetClient struct {
+	*memoryAdminClient
+}
+
+func (m memoryOffsetClient) Close() error {
+	return nil
+}
+
+func newTestKafkaAdmin() (*KafkaAdmin, *memoryAdminClient) {
+	client := newMemoryAdminClient()
+	return &KafkaAdmin{kc: *newTestKafkaConfig(), admin: client, offsets: memoryOffsetClient{client}}, client
+}
+
+func TestKafkaAdminTopics(t *testing.T) {
+	admin, _ := newTestKafkaAdmin()
+	ctx := context.Background()
+
+	err := admin.CreateTopics(ctx, "transID",
+		KafkaTopicSpec{Name: "order", Partitions: 3, ReplicationFactor: 2, Config: map[string]string{"retention.ms": "60000"}},
+		KafkaTopicSpec{Name: "customer", Partitions: 1, ReplicationFactor: 2})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = admin.CreateTopics(ctx, "transID", KafkaTopicSpec{Name: "order", Partitions: 3}, KafkaTopicSpec{Name: "invoice", Partitions: 1})
+
+	if err == nil || !strings.Contains(err.Error(), "order: Topic 'order' already exists") || strings.Contains(err.Error(), "invoice") {
+		t.Fatalf("expected the error of order only, got %v", err)
+	}
+
+	descriptionList, err := admin.DescribeTopics(ctx, "transID", "order", "customer")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := descriptionList[0]
+	if order.Name != "order" || len(order.Partitions) != 3 || order.Partitions[0].Partition != 0 || order.Partitions[2].Partition != 2 {
+		t.Fatalf("expected 3 partitions of order in order, got %+v", order)
+	}
+
+	if !reflect.DeepEqual(order.Config, map[string]string{"retention.ms": "60000"}) || len(descriptionList[1].Config) != 0 {
+		t.Fatalf("expected the configs which are not default, got %+v", descriptionList)
+	}
+
+	if descriptionList, err = admin.DescribeTopics(ctx, "transID"); err != nil || len(descriptionList) != 3 ||
+		descriptionList[0].Name != "customer" || descriptionList[2].Name != "order" {
+		t.Fatalf("expected all the topics sorted by name, got %+v, %v", descriptionList, err)
+	}
+
+	if _, err = admin.DescribeTopics(ctx, "transID", "unknown"); err == nil {
+		t.Fatal("expected the unknown topic to fail")
+	}
+
+	if err = admin.DeleteTopics(ctx, "transID", "customer", "invoice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = admin.DeleteTopics(ctx, "transID", "customer"); err == nil || !strings.Contains(err.Error(), "customer: Broker: Unknown topic") {
+		t.Fatalf("expected the deleted topic to fail, got %v", err)
+	}
+}
+
+func TestKafkaAdminListConsumerGroups(t *testing.T) {
+	admin, client := newTestKafkaAdmin()
+	client.groupList = []kafka.ConsumerGroupListing{
+		{GroupID: "crm-order", State: kafka.ConsumerGroupStateStable},
+		{GroupID: "crm-customer", State: kafka.ConsumerGroupStateEmpty, IsSimpleConsumerGroup: true},
+	}
+
+	groupList, err := admin.ListConsumerGroups(context.Background(), "transID")
+
+	expected := []KafkaConsumerGroup{
+		{GroupID: "crm-customer", State: "Empty", IsSimple: true},
+		{GroupID: "crm-order", State: "Stable"},
+	}
+
+	if err != nil || !reflect.DeepEqual(groupList, expected) {
+		t.Fatalf("expected %+v, got %+v, %v", expected, groupList, err)
+	}
+}
+
+func TestKafkaAdminGroupLagAndResetOffsets(t *testing.T) {
+	admin, client := newTestKafkaAdmin()
+	ctx := context.Background()
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	timestamps := func(count int) []int64 {
+		var timestampList []int64
+		for i := 0; i < count; i++ {
+			timestampList = append(timestampList, base.Add(time.Duration(i)*time.Minute).UnixMilli())
+		}
+		return timestampList
+	}
+
+	client.topicMap["order"] = []memoryPartition{
+		{low: 0, high: 10, timestamps: timestamps(10)},
+		{low: 5, high: 8, timestamps: timestamps(3)},
+		{low: 0, high: 0},
+	}
+	client.committed["crm-order"] = map[int32]int64{0: 4}
+
+	lagList, err := admin.GroupLag(ctx, "transID", "crm-order", "order")
+
+	expectedLag := []KafkaPartitionLag{
+		{Topic: "order", Partition: 0, CommittedOffset: 4, LogStartOffset: 0, LogEndOffset: 10, Lag: 6},
+		{Topic: "order", Partition: 1, CommittedOffset: -1, LogStartOffset: 5, LogEndOffset: 8, Lag: 3},
+		{Topic: "order", Partition: 2, CommittedOffset: -1, LogStartOffset: 0, LogEndOffset: 0, Lag: 0},
+	}
+
+	if err != nil || !reflect.DeepEqual(lagList, expectedLag) {
+		t.Fatalf("expected %+v, got %+v, %v", expectedLag, lagList, err)
+	}
+
+	testList := []struct {
+		reset    KafkaOffsetReset
+		expected []int64
+	}{
+		{KafkaOffsetReset{To: KafkaOffsetLatest}, []int64{10, 8, 0}},
+		{KafkaOffsetReset{To: KafkaOffsetEarliest}, []int64{0, 5, 0}},
+		{KafkaOffsetReset{To: KafkaOffsetSpecific, Offset: 7}, []int64{7, 7, 0}},
+		{KafkaOffsetReset{To: KafkaOffsetTimestamp, Timestamp: base.Add(150 * time.Second)}, []int64{3, 8, 0}},
+	}
+
+	for _, test := range testList {
+		offsetList, err := admin.ResetOffsets(ctx, "transID", "crm-order", "order", test.reset)
+
+		if err != nil {
+			t.Fatalf("%s: %v", test.reset.To, err)
+		}
+
+		for i, offset := range offsetList {
+			if offset.NewOffset != test.expected[i] || client.committed["crm-order"][offset.Partition] != test.expected[i] {
+				t.Fatalf("%s: expected %v, got %+v, committed %v", test.reset.To, test.expected, offsetList, client.committed)
+			}
+		}
+	}
+
+	offsetList, err := admin.ResetOffsets(ctx, "transID", "crm-order", "order", KafkaOffsetReset{To: KafkaOffsetEarliest, DryRun: true})
+
+	if err != nil || offsetList[0].OldOffset != 3 || offsetList[0].NewOffset != 0 || client.committed["crm-order"][0] != 3 {
+		t.Fatalf("expected DryRun not to commit, got %+v, %v, committed %v", offsetList, err, client.committed)
+	}
+
+	if _, err = admin.ResetOffsets(ctx, "transID", "crm-order", "order", KafkaOffsetReset{To: "now"}); !errors.Is(err, ErrUnknownOffsetReset) {
+		t.Fatalf("expected ErrUnknownOffsetReset, got %v", err)
+	}
+
+	client.activeGroup["crm-order"] = true
+
+	if _, err = admin.ResetOffsets(ctx, "transID", "crm-order", "order", KafkaOffsetReset{To: KafkaOffsetLatest}); err == nil ||
+		!strings.Contains(err.Error(), "Unknown member") {
+		t.Fatalf("expected the active group to fail, got %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package kafkautil
 import (
 	"crm-util-go/logging"
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"time"
 )
 
 /*
@@ -30,24 +31,51 @@ kerberosReloginMS: 180000
 SslCALocation:  "/etc/ssl/certs/ca-certificates.crt"
 SslCipherSuites: "DHE-DSS-AES256-GCM-SHA384"
 CompressionType: "lz4"
+AutoOffsetReset: "earliest" (default) or "latest"
+ShutdownTimeout: 30 * time.Second (default), waits the running messages, the final commit and the close of the consumer
+
+ConsumerConcurrent only:
+WorkerCount: 8 (default), the messages of a partition are processed in order by one worker
+OrderByKey: true processes the messages of different keys in a partition in parallel, the messages of a key are still in order
+CommitInterval: 5 * time.Second (default), commits the highest offset of each partition whose messages are all processed
+RetryBackoff: 5 * time.Second (default), waits before calling onMessage again with the failed message
+RebalanceDrainTimeout: 30 * time.Second (default), waits the running messages of the revoked partitions before commit
+
+RetryPolicy: nil (default) retries a failed message until it succeeds, see KafkaRetryPolicy
+
+Process only:
+TransactionalID: required, unique and stable for each instance of the application, e.g. "order-enrich-" + hostname
+TransactionMaxMessages: 100 (default), commits the transaction after the messages
+TransactionInterval: 1 * time.Second (default), commits the transaction after the interval
+RetryBackoff: 5 * time.Second (default), waits before processing the messages of an aborted transaction again
 */
 
 type KafkaConfig struct {
-	BootstrapServers      string
-	TopicName             []string
-	SecurityProtocol      string
-	SaslMechanism         string
-	KerberosPrincipalName string
-	KerberosServiceName   string
-	KerberosKeytab        string
-	KerberosReloginMS     int
-	SslCALocation         string
-	SslCipherSuites       string
-	CompressionType       string
-	GroupID               string
-	IsShutdown            bool
-	IsLineNotify          bool
-	Logger                *logging.PatternLogger
+	BootstrapServers       string
+	TopicName              []string
+	SecurityProtocol       string
+	SaslMechanism          string
+	KerberosPrincipalName  string
+	KerberosServiceName    string
+	KerberosKeytab         string
+	KerberosReloginMS      int
+	SslCALocation          string
+	SslCipherSuites        string
+	CompressionType        string
+	GroupID                string
+	AutoOffsetReset        string
+	WorkerCount            int
+	OrderByKey             bool
+	CommitInterval         time.Duration
+	RetryBackoff           time.Duration
+	RebalanceDrainTimeout  time.Duration
+	ShutdownTimeout        time.Duration
+	RetryPolicy            *KafkaRetryPolicy
+	TransactionalID        string
+	TransactionMaxMessages int
+	TransactionInterval    time.Duration
+	IsLineNotify           bool
+	Logger                 *logging.PatternLogger
 }
 
 type KafkaMessage struct {
@@ -55,3 +83,145 @@ type KafkaMessage struct {
 	Value   string
 	Headers []kafka.Header
 }
+
+/*
+KafkaProducerConfig tunes the shared Producer, the zero value keeps the librdkafka defaults.
+
+Idempotent: true publishes each message exactly once and in order per partition when the producer retries
+LingerMs: 5 (librdkafka default), waits to batch the messages, more throughput with more latency
+BatchSize: 1000000 bytes (librdkafka default), the maximum size of a batch of a partition
+BatchNumMessages: 10000 (librdkafka default), the maximum messages of a batch
+DeliveryTimeout: 5 * time.Minute (librdkafka default), fails the delivery of a message not acknowledged in time
+CloseTimeout: 10 * time.Second (default), waits the queued messages on Close, the messages left are failed
+*/
+type KafkaProducerConfig struct {
+	Idempotent       bool
+	LingerMs         int
+	BatchSize        int
+	BatchNumMessages int
+	DeliveryTimeout  time.Duration
+	CloseTimeout     time.Duration
+}
+
+/*
+SchemaRegistryConfig of a Confluent compatible schema registry, the schemas are cached by the client.
+The server certificate is verified against the system cert pool, or against CertPEMFileName when it is set.
+
+Example:
+URL: "https://schema-registry:8081"
+UserName, Password: basic authentication of the registry, optional
+CertPEMFileName: "/etc/ssl/certs/schema-registry-ca.pem", optional
+Timeout: 10 * time.Second (default)
+*/
+type SchemaRegistryConfig struct {
+	URL             string
+	UserName        string
+	Password        string
+	CertPEMFileName string
+	Timeout         time.Duration
+}
+
+/*
+KafkaRetryPolicy moves a failed message out of its partition so it does not block the messages after it.
+onMessage is called ImmediateRetries more times, waiting RetryBackoff doubled on each retry,
+then the message is published to the retry topics of RetryDelays in order and last to DLQTopic.
+The consumer also subscribes the retry topics, a message of a retry topic is processed after its delay.
+
+Example: topic "order" with RetryDelays []time.Duration{time.Minute, 10 * time.Minute}
+Retry topics: "order.retry.1m", "order.retry.10m"
+DLQTopic: "order.dlq" (default)
+
+The message keeps its key, value and headers, the KafkaHeader* headers record the source and the error.
+*/
+type KafkaRetryPolicy struct {
+	ImmediateRetries int
+	RetryBackoff     time.Duration
+	RetryDelays      []time.Duration
+	DLQTopic         string
+}
+
+const (
+	KafkaHeaderSourceTopic     = "x-source-topic"
+	KafkaHeaderSourcePartition = "x-source-partition"
+	KafkaHeaderSourceOffset    = "x-source-offset"
+	KafkaHeaderAttempt         = "x-retry-attempt"
+	KafkaHeaderError           = "x-retry-error"
+	KafkaHeaderFailedTopic     = "x-retry-failed-topic"
+	KafkaHeaderNotBefore       = "x-retry-not-before"
+	KafkaHeaderReplayedFrom    = "x-replayed-from"
+)
+
+/*
+KafkaTopicSpec of KafkaAdmin.CreateTopics.
+
+Example:
+Name: "order"
+Partitions: 6
+ReplicationFactor: 3, or -1 for default.replication.factor of the broker
+Config: map[string]string{"retention.ms": "604800000", "cleanup.policy": "delete"}
+*/
+type KafkaTopicSpec struct {
+	Name              string
+	Partitions        int
+	ReplicationFactor int
+	Config            map[string]string
+}
+
+// KafkaTopicDescription of KafkaAdmin.DescribeTopics, Config has the configs which are not the default of the broker.
+type KafkaTopicDescription struct {
+	Name       string
+	Partitions []KafkaPartitionDescription
+	Config     map[string]string
+}
+
+type KafkaPartitionDescription struct {
+	Partition int32
+	Leader    int32
+	Replicas  []int32
+	Isrs      []int32
+}
+
+type KafkaConsumerGroup struct {
+	GroupID  string
+	State    string
+	IsSimple bool
+}
+
+// KafkaPartitionLag of a consumer group, CommittedOffset is -1 when the group has not committed the partition.
+type KafkaPartitionLag struct {
+	Topic           string
+	Partition       int32
+	CommittedOffset int64
+	LogStartOffset  int64
+	LogEndOffset    int64
+	Lag             int64
+}
+
+const (
+	KafkaOffsetEarliest  = "earliest"
+	KafkaOffsetLatest    = "latest"
+	KafkaOffsetTimestamp = "timestamp"
+	KafkaOffsetSpecific  = "offset"
+)
+
+/*
+KafkaOffsetReset of KafkaAdmin.ResetOffsets, the consumers of the group must be stopped.
+
+To: KafkaOffsetEarliest, KafkaOffsetLatest, KafkaOffsetTimestamp or KafkaOffsetSpecific
+Timestamp: KafkaOffsetTimestamp only, the first message at or after Timestamp, or the log end offset
+Offset: KafkaOffsetSpecific only, limited to the log start and end offsets of each partition
+DryRun: true returns the new offsets without committing them
+*/
+type KafkaOffsetReset struct {
+	To        string
+	Timestamp time.Time
+	Offset    int64
+	DryRun    bool
+}
+
+type KafkaPartitionOffset struct {
+	Topic     string
+	Partition int32
+	OldOffset int64
+	NewOffset int64
+}
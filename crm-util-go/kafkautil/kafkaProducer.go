@@ -0,0 +1,312 @@
+package kafkautil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+const (
+	kafkaDefaultCloseTimeout   time.Duration = 10 * time.Second
+	kafkaPurgeFlushTimeoutMs   int           = 1000
+	kafkaIdempotentMaxInFlight int           = 5
+)
+
+var (
+	ErrProducerClosed = errors.New("kafka producer is closed")
+	ErrFlushTimeout   = errors.New("kafka producer flush timeout")
+	ErrNoTopic        = errors.New("kafka message has no topic")
+)
+
+// kafkaProducer is implemented by *kafka.Producer.
+type kafkaProducer interface {
+	messageProducer
+	Events() chan kafka.Event
+	Flush(timeoutMs int) int
+	Purge(flags int) error
+	Close()
+}
+
+// DeliveryFuture is completed by the delivery report of its message.
+type DeliveryFuture struct {
+	transID    string
+	done       chan struct{}
+	msg        *kafka.Message
+	err        error
+	onDelivery func(msg *kafka.Message, err error)
+}
+
+// Done is closed when the message is delivered or failed.
+func (f *DeliveryFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait returns the delivered message with its partition and offset, or ctx.Err() when ctx is done first.
+func (f *DeliveryFuture) Wait(ctx context.Context) (*kafka.Message, error) {
+	select {
+	case <-f.done:
+		return f.msg, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+/*
+Producer is created once and shared by the goroutines, it is safe for concurrent use.
+The delivery reports are handled by one goroutine which completes the DeliveryFuture of each message.
+Close flushes the queued messages within CloseTimeout.
+*/
+type Producer struct {
+	transID   string
+	kc        KafkaConfig
+	config    KafkaProducerConfig
+	producer  kafkaProducer
+	mu        sync.RWMutex
+	closed    bool
+	pendingMu sync.Mutex
+	pending   map[*DeliveryFuture]struct{}
+	wg        sync.WaitGroup
+}
+
+func (kc KafkaConfig) producerConfigMapOf(config KafkaProducerConfig) *kafka.ConfigMap {
+	configMap := kc.producerConfigMap()
+	configMap.SetKey("go.delivery.report.fields", "key,value,headers")
+
+	if config.Idempotent {
+		configMap.SetKey("enable.idempotence", true)
+		configMap.SetKey("max.in.flight.requests.per.connection", kafkaIdempotentMaxInFlight)
+	}
+
+	if config.LingerMs > 0 {
+		configMap.SetKey("linger.ms", config.LingerMs)
+	}
+
+	if config.BatchSize > 0 {
+		configMap.SetKey("batch.size", config.BatchSize)
+	}
+
+	if config.BatchNumMessages > 0 {
+		configMap.SetKey("batch.num.messages", config.BatchNumMessages)
+	}
+
+	if config.DeliveryTimeout > 0 {
+		configMap.SetKey("delivery.timeout.ms", int(config.DeliveryTimeout.Milliseconds()))
+	}
+
+	if kc.CompressionType != "" {
+		configMap.SetKey("compression.type", kc.CompressionType)
+	}
+
+	return configMap
+}
+
+func NewProducer(transID string, kafkaConfig KafkaConfig, config KafkaProducerConfig) (*Producer, error) {
+	kafkaConfig.Logger.Info(transID, fmt.Sprintf("Starting Producer. BootstrapServers: %s, KafkaProducerConfig: %+v",
+		kafkaConfig.BootstrapServers, config))
+
+	producer, err := kafka.NewProducer(kafkaConfig.producerConfigMapOf(config))
+
+	if err != nil {
+		kafkaConfig.Logger.Error(transID, "Producer can not connect to kafka server", err)
+		return nil, err
+	}
+
+	return newProducer(transID, kafkaConfig, config, producer), nil
+}
+
+func newProducer(transID string, kafkaConfig KafkaConfig, config KafkaProducerConfig, producer kafkaProducer) *Producer {
+	p := &Producer{
+		transID:  transID,
+		kc:       kafkaConfig,
+		config:   config,
+		producer: producer,
+		pending:  make(map[*DeliveryFuture]struct{}),
+	}
+
+	p.wg.Add(1)
+	go p.handleEvents()
+
+	return p
+}
+
+// NewMessage returns the message of topic to any partition, or TopicName[0] of KafkaConfig when topic is empty.
+func NewMessage(topic string, key string, value string, headers []kafka.Header) *kafka.Message {
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Partition: kafka.PartitionAny},
+		Value:          []byte(value),
+		Key:            []byte(key),
+		Timestamp:      time.Now(),
+		Headers:        headers,
+	}
+
+	if topic != "" {
+		msg.TopicPartition.Topic = &topic
+	}
+
+	return msg
+}
+
+/*
+Produce queues msg and returns its DeliveryFuture, the Opaque of msg is used by the Producer.
+msg.TopicPartition.Topic is TopicName[0] of KafkaConfig when it is nil, see NewMessage.
+kafka.ErrQueueFull is returned when the queue of librdkafka is full, retry after the queued messages are delivered.
+*/
+func (p *Producer) Produce(transID string, msg *kafka.Message) (*DeliveryFuture, error) {
+	return p.produce(transID, msg, nil)
+}
+
+// ProduceCallback is the same as Produce but onDelivery is called by the delivery goroutine, it must not block.
+func (p *Producer) ProduceCallback(transID string, msg *kafka.Message, onDelivery func(msg *kafka.Message, err error)) error {
+	_, err := p.produce(transID, msg, onDelivery)
+	return err
+}
+
+// Send produces the message to topic and waits for its delivery.
+func (p *Producer) Send(ctx context.Context, transID string, topic string, key string, value string,
+	headers []kafka.Header) (*kafka.Message, error) {
+
+	future, err := p.Produce(transID, NewMessage(topic, key, value, headers))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return future.Wait(ctx)
+}
+
+func (p *Producer) produce(transID string, msg *kafka.Message, onDelivery func(msg *kafka.Message, err error)) (*DeliveryFuture, error) {
+	if msg.TopicPartition.Topic == nil {
+		if len(p.kc.TopicName) == 0 {
+			return nil, ErrNoTopic
+		}
+
+		msg.TopicPartition.Topic = &p.kc.TopicName[0]
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return nil, ErrProducerClosed
+	}
+
+	future := &DeliveryFuture{transID: transID, done: make(chan struct{}), onDelivery: onDelivery}
+	msg.Opaque = future
+
+	p.pendingMu.Lock()
+	p.pending[future] = struct{}{}
+	p.pendingMu.Unlock()
+
+	if err := p.producer.Produce(msg, nil); err != nil {
+		p.pendingMu.Lock()
+		delete(p.pending, future)
+		p.pendingMu.Unlock()
+
+		p.kc.Logger.Error(transID, "Producer.Produce() failed because "+err.Error())
+		return nil, err
+	}
+
+	return future, nil
+}
+
+func (p *Producer) handleEvents() {
+	defer p.wg.Done()
+
+	for event := range p.producer.Events() {
+		switch e := event.(type) {
+		case *kafka.Message:
+			future, ok := e.Opaque.(*DeliveryFuture)
+
+			if !ok {
+				continue
+			}
+
+			if e.TopicPartition.Error != nil {
+				p.kc.Logger.Error(future.transID, "Delivery message failed because "+e.TopicPartition.Error.Error())
+				p.complete(future, e, e.TopicPartition.Error)
+			} else {
+				p.kc.Logger.Debug(future.transID, "Delivered message success to "+getTopicInfo(e.TopicPartition))
+				p.complete(future, e, nil)
+			}
+		case kafka.Error:
+			p.kc.Logger.Error(p.transID, fmt.Sprintf("Producer Error: %v", e))
+		}
+	}
+}
+
+// complete is called once for each future, by the delivery report or by Close.
+func (p *Producer) complete(future *DeliveryFuture, msg *kafka.Message, err error) {
+	p.pendingMu.Lock()
+	_, found := p.pending[future]
+	delete(p.pending, future)
+	p.pendingMu.Unlock()
+
+	if !found {
+		return
+	}
+
+	future.msg = msg
+	future.err = err
+	close(future.done)
+
+	if future.onDelivery != nil {
+		future.onDelivery(msg, err)
+	}
+}
+
+// Flush waits for the delivery of the queued messages and returns the number of messages still queued after timeout.
+func (p *Producer) Flush(timeout time.Duration) int {
+	return p.producer.Flush(int(timeout.Milliseconds()))
+}
+
+// Close returns ErrFlushTimeout when the queued messages are not delivered within CloseTimeout, they are failed.
+func (p *Producer) Close() error {
+	p.mu.Lock()
+
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+
+	p.closed = true
+	p.mu.Unlock()
+
+	closeTimeout := p.config.CloseTimeout
+	if closeTimeout <= 0 {
+		closeTimeout = kafkaDefaultCloseTimeout
+	}
+
+	var err error
+
+	if remaining := p.Flush(closeTimeout); remaining > 0 {
+		err = fmt.Errorf("%w: %d messages are not delivered after %s", ErrFlushTimeout, remaining, closeTimeout)
+		p.kc.Logger.Error(p.transID, "Producer.Close Error: "+err.Error())
+
+		// The purged messages are failed by their delivery reports.
+		if errPurge := p.producer.Purge(kafka.PurgeQueue | kafka.PurgeInFlight | kafka.PurgeNonBlocking); errPurge != nil {
+			p.kc.Logger.Error(p.transID, "Producer.Purge Error: "+errPurge.Error())
+		}
+
+		p.producer.Flush(kafkaPurgeFlushTimeoutMs)
+	}
+
+	p.producer.Close()
+	p.wg.Wait()
+
+	p.pendingMu.Lock()
+	futureList := make([]*DeliveryFuture, 0, len(p.pending))
+	for future := range p.pending {
+		futureList = append(futureList, future)
+	}
+	p.pendingMu.Unlock()
+
+	for _, future := range futureList {
+		p.complete(future, nil, ErrProducerClosed)
+	}
+
+	return err
+}
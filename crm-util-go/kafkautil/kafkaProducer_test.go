@@ -0,0 +1,187 @@
+package kafkautil
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// memoryEventProducer reports the delivery of each message on Events, or queues it when hold is set.
+type memoryEventProducer struct {
+	mu     sync.Mutex
+	events chan kafka.Event
+	hold   bool
+	queue  []*kafka.Message
+	offset kafka.Offset
+}
+
+func newMemoryEventProducer(hold bool) *memoryEventProducer {
+	return &memoryEventProducer{events: make(chan kafka.Event, 100), hold: hold}
+}
+
+func (m *memoryEventProducer) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.hold {
+		m.queue = append(m.queue, msg)
+		return nil
+	}
+
+	delivered := *msg
+	delivered.TopicPartition.Partition = 0
+	delivered.TopicPartition.Offset = m.offset
+	m.offset++
+
+	m.events <- &delivered
+	return nil
+}
+
+func (m *memoryEventProducer) Events() chan kafka.Event {
+	return m.events
+}
+
+func (m *memoryEventProducer) Flush(timeoutMs int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.queue)
+}
+
+func (m *memoryEventProducer) Purge(flags int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, msg := range m.queue {
+		msg.TopicPartition.Error = kafka.NewError(kafka.ErrPurgeQueue, "purged", false)
+		m.events <- msg
+	}
+
+	m.queue = nil
+	return nil
+}
+
+func (m *memoryEventProducer) Close() {
+	close(m.events)
+}
+
+func TestProducerDelivery(t *testing.T) {
+	kc := newTestKafkaConfig()
+	kc.TopicName = []string{"order"}
+	producer := newProducer("transID", *kc, KafkaProducerConfig{}, newMemoryEventProducer(false))
+
+	msg, err := producer.Send(context.Background(), "transID", "", "K1", "V1", nil)
+
+	if err != nil || *msg.TopicPartition.Topic != "order" || string(msg.Key) != "K1" {
+		t.Fatalf("expected delivery to the default topic, got %v, %v", msg, err)
+	}
+
+	delivered := make(chan *kafka.Message, 1)
+	err = producer.ProduceCallback("transID", NewMessage("customer", "K2", "V2", nil), func(msg *kafka.Message, err error) {
+		delivered <- msg
+	})
+
+	if err != nil {
+		t.Fatalf("ProduceCallback Error: %v", err)
+	}
+
+	select {
+	case msg = <-delivered:
+		if *msg.TopicPartition.Topic != "customer" || msg.TopicPartition.Offset != 1 {
+			t.Errorf("expected topic customer offset 1, got %v", msg.TopicPartition)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("onDelivery is not called")
+	}
+
+	if err = producer.Close(); err != nil {
+		t.Errorf("Close Error: %v", err)
+	}
+
+	if _, err = producer.Produce("transID", NewMessage("order", "K3", "V3", nil)); !errors.Is(err, ErrProducerClosed) {
+		t.Errorf("expected ErrProducerClosed, got %v", err)
+	}
+}
+
+func TestProducerCloseFailsQueued(t *testing.T) {
+	kc := newTestKafkaConfig()
+	producer := newProducer("transID", *kc, KafkaProducerConfig{}, newMemoryEventProducer(true))
+
+	if _, err := producer.Produce("transID", NewMessage("", "K1", "V1", nil)); !errors.Is(err, ErrNoTopic) {
+		t.Errorf("expected ErrNoTopic, got %v", err)
+	}
+
+	future, err := producer.Produce("transID", NewMessage("order", "K1", "V1", nil))
+
+	if err != nil {
+		t.Fatalf("Produce Error: %v", err)
+	}
+
+	if err = producer.Close(); !errors.Is(err, ErrFlushTimeout) {
+		t.Errorf("expected ErrFlushTimeout, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err = future.Wait(ctx); err == nil || err == context.DeadlineExceeded {
+		t.Errorf("queued message should be failed on Close, got %v", err)
+	}
+}
+
+func TestProducerConfigMap(t *testing.T) {
+	kc := newTestKafkaConfig()
+	kc.CompressionType = "lz4"
+
+	configMap := kc.producerConfigMapOf(KafkaProducerConfig{Idempotent: true, LingerMs: 20, DeliveryTimeout: time.Minute})
+
+	for key, expected := range map[string]kafka.ConfigValue{
+		"enable.idempotence":  true,
+		"linger.ms":           20,
+		"delivery.timeout.ms": 60000,
+		"compression.type":    "lz4",
+	} {
+		if value, _ := configMap.Get(key, nil); value != expected {
+			t.Errorf("%s expected %v, got %v", key, expected, value)
+		}
+	}
+
+	if value, _ := configMap.Get("batch.size", nil); value != nil {
+		t.Errorf("batch.size should keep the librdkafka default, got %v", value)
+	}
+}
+
+func TestNewProducerCloseTimeout(t *testing.T) {
+	kc := newTestKafkaConfig()
+	kc.BootstrapServers = "localhost:1"
+	kc.SecurityProtocol = "PLAINTEXT"
+	kc.SaslMechanism = "PLAIN"
+	kc.KerberosPrincipalName = "kafkaclient"
+	kc.KerberosServiceName = "kafka"
+
+	producer, err := NewProducer("transID", *kc, KafkaProducerConfig{Idempotent: true, CloseTimeout: 100 * time.Millisecond})
+
+	if err != nil {
+		t.Fatalf("NewProducer Error: %v", err)
+	}
+
+	future, err := producer.Produce("transID", NewMessage("order", "K1", "V1", nil))
+
+	if err != nil {
+		t.Fatalf("Produce Error: %v", err)
+	}
+
+	if err = producer.Close(); !errors.Is(err, ErrFlushTimeout) {
+		t.Errorf("expected ErrFlushTimeout without broker, got %v", err)
+	}
+
+	select {
+	case <-future.Done():
+	case <-time.After(time.Second):
+		t.Fatal("future should be completed by Close")
+	}
+}
@@ -18,19 +18,13 @@ const (
 	kafkaImmediatePublish   int = 0
 )
 
-func (kc KafkaConfig) Publish(transID string, key string, value string, headers []kafka.Header) (*kafka.Message, error) {
-	kc.Logger.Info(transID, "Starting Publish key: "+key+", value: "+value)
-
-	var kafkaMessage *kafka.Message
-
+func (kc *KafkaConfig) producerConfigMap() *kafka.ConfigMap {
 	// "compression.type":           kc.CompressionType,
 	// "enable.ssl.certificate.verification": "false",
-	producer, err := kafka.NewProducer(&kafka.ConfigMap{
+	return &kafka.ConfigMap{
 		"bootstrap.servers":                     kc.BootstrapServers,
 		"request.required.acks":                 kafkaAcksSyncReplicas,
 		"request.timeout.ms":                    kafkaRequestTimeoutMs,
-		"linger.ms":                             kafkaLingerMs,
-		"queue.buffering.max.ms":                kafkaImmediatePublish,
 		"security.protocol":                     kc.SecurityProtocol,
 		"sasl.mechanisms":                       kc.SaslMechanism,
 		"sasl.kerberos.principal":               kc.KerberosPrincipalName,
@@ -39,7 +33,20 @@ func (kc KafkaConfig) Publish(transID string, key string, value string, headers
 		"sasl.kerberos.min.time.before.relogin": kc.KerberosReloginMS,
 		"ssl.ca.location":                       kc.SslCALocation,
 		"ssl.cipher.suites":                     kc.SslCipherSuites,
-	})
+	}
+}
+
+// Publish creates a producer for each message, use Producer to publish many messages.
+func (kc KafkaConfig) Publish(transID string, key string, value string, headers []kafka.Header) (*kafka.Message, error) {
+	kc.Logger.Info(transID, "Starting Publish key: "+key+", value: "+value)
+
+	var kafkaMessage *kafka.Message
+
+	configMap := kc.producerConfigMap()
+	configMap.SetKey("linger.ms", kafkaLingerMs)
+	configMap.SetKey("queue.buffering.max.ms", kafkaImmediatePublish)
+
+	producer, err := kafka.NewProducer(configMap)
 
 	if err != nil {
 		kc.Logger.Error(transID, "Producer can not connect to kafka server", err)
@@ -91,21 +98,7 @@ func (kc KafkaConfig) PublishBulk(transID string, kafkaMessageList []KafkaMessag
 	onProducedMessage func(wg *sync.WaitGroup, transID string, key string, value string, err error)) error {
 	kc.Logger.Info(transID, "Starting PublishBulk size:", len(kafkaMessageList))
 
-	// "compression.type":           kc.CompressionType,
-	// "enable.ssl.certificate.verification": "false",
-	producer, err := kafka.NewProducer(&kafka.ConfigMap{
-		"bootstrap.servers":                     kc.BootstrapServers,
-		"request.required.acks":                 kafkaAcksSyncReplicas,
-		"request.timeout.ms":                    kafkaRequestTimeoutMs,
-		"security.protocol":                     kc.SecurityProtocol,
-		"sasl.mechanisms":                       kc.SaslMechanism,
-		"sasl.kerberos.principal":               kc.KerberosPrincipalName,
-		"sasl.kerberos.service.name":            kc.KerberosServiceName,
-		"sasl.kerberos.keytab":                  kc.KerberosKeytab,
-		"sasl.kerberos.min.time.before.relogin": kc.KerberosReloginMS,
-		"ssl.ca.location":                       kc.SslCALocation,
-		"ssl.cipher.suites":                     kc.SslCipherSuites,
-	})
+	producer, err := kafka.NewProducer(kc.producerConfigMap())
 
 	if err != nil {
 		kc.Logger.Error(transID, "Producer can not connect to kafka server", err)
@@ -0,0 +1,480 @@
+package kafkautil
+
+import (
+	"crm-util-go/pointer"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+const (
+	kafkaDefaultImmediateRetryBackoff time.Duration = time.Second
+	kafkaMaxErrorHeaderLength         int           = 1000
+	kafkaReplayIdleTimeout            time.Duration = 10 * time.Second
+)
+
+// messageProducer is implemented by *kafka.Producer.
+type messageProducer interface {
+	Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error
+}
+
+func retryTopicName(sourceTopic string, delay time.Duration) string {
+	return sourceTopic + ".retry." + formatRetryDelay(delay)
+}
+
+// formatRetryDelay formats 1m for time.Minute and 90s for 90 * time.Second.
+func formatRetryDelay(delay time.Duration) string {
+	switch {
+	case delay >= time.Hour && delay%time.Hour == 0:
+		return strconv.FormatInt(int64(delay/time.Hour), 10) + "h"
+	case delay >= time.Minute && delay%time.Minute == 0:
+		return strconv.FormatInt(int64(delay/time.Minute), 10) + "m"
+	case delay >= time.Second && delay%time.Second == 0:
+		return strconv.FormatInt(int64(delay/time.Second), 10) + "s"
+	default:
+		return strconv.FormatInt(int64(delay/time.Millisecond), 10) + "ms"
+	}
+}
+
+func (policy KafkaRetryPolicy) dlqTopicName(sourceTopic string) string {
+	if policy.DLQTopic != "" {
+		return policy.DLQTopic
+	}
+
+	return sourceTopic + ".dlq"
+}
+
+// immediateBackoff returns the wait before the immediate retry of attempt, attempt starts from 1.
+func (policy KafkaRetryPolicy) immediateBackoff(attempt int) time.Duration {
+	backoff := policy.RetryBackoff
+	if backoff <= 0 {
+		backoff = kafkaDefaultImmediateRetryBackoff
+	}
+
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+	}
+
+	return backoff
+}
+
+// nextTopic returns the topic of a failed message of topicName and the delay of that topic, zero delay for the DLQ.
+func (policy KafkaRetryPolicy) nextTopic(sourceTopic string, topicName string) (string, time.Duration) {
+	nextIndex := 0
+
+	for i, delay := range policy.RetryDelays {
+		if topicName == retryTopicName(sourceTopic, delay) {
+			nextIndex = i + 1
+			break
+		}
+	}
+
+	if nextIndex < len(policy.RetryDelays) {
+		delay := policy.RetryDelays[nextIndex]
+		return retryTopicName(sourceTopic, delay), delay
+	}
+
+	return policy.dlqTopicName(sourceTopic), 0
+}
+
+// subscribeTopicNames returns the source topics and their retry topics.
+func (kc *KafkaConfig) subscribeTopicNames() []string {
+	if kc.RetryPolicy == nil {
+		return kc.TopicName
+	}
+
+	topicNames := append([]string{}, kc.TopicName...)
+
+	for _, topicName := range kc.TopicName {
+		for _, delay := range kc.RetryPolicy.RetryDelays {
+			topicNames = append(topicNames, retryTopicName(topicName, delay))
+		}
+	}
+
+	return topicNames
+}
+
+func getHeaderValue(headers []kafka.Header, key string) (string, bool) {
+	for _, header := range headers {
+		if header.Key == key {
+			return string(header.Value), true
+		}
+	}
+
+	return "", false
+}
+
+// SourceTopic returns the topic which msg was first published to, it is the topic of msg when msg is not a retry.
+func SourceTopic(msg *kafka.Message) string {
+	if sourceTopic, found := getHeaderValue(msg.Headers, KafkaHeaderSourceTopic); found {
+		return sourceTopic
+	}
+
+	return pointer.GetStringValue(msg.TopicPartition.Topic)
+}
+
+// RetryAttempt returns the number of times msg has failed before, zero when msg is not a retry.
+func RetryAttempt(msg *kafka.Message) int {
+	attemptValue, _ := getHeaderValue(msg.Headers, KafkaHeaderAttempt)
+	attempt, _ := strconv.Atoi(attemptValue)
+
+	return attempt
+}
+
+// retryNotBefore returns the zero time when msg can be processed now.
+func retryNotBefore(msg *kafka.Message) time.Time {
+	notBeforeValue, found := getHeaderValue(msg.Headers, KafkaHeaderNotBefore)
+
+	if !found {
+		return time.Time{}
+	}
+
+	notBeforeMs, err := strconv.ParseInt(notBeforeValue, 10, 64)
+
+	if err != nil {
+		return time.Time{}
+	}
+
+	return time.UnixMilli(notBeforeMs)
+}
+
+func isRetryHeader(key string) bool {
+	switch key {
+	case KafkaHeaderSourceTopic, KafkaHeaderSourcePartition, KafkaHeaderSourceOffset, KafkaHeaderAttempt,
+		KafkaHeaderError, KafkaHeaderFailedTopic, KafkaHeaderNotBefore, KafkaHeaderReplayedFrom:
+		return true
+	default:
+		return false
+	}
+}
+
+// withoutRetryHeaders returns the original headers of msg.
+func withoutRetryHeaders(headers []kafka.Header) []kafka.Header {
+	var originalHeaders []kafka.Header
+
+	for _, header := range headers {
+		if !isRetryHeader(header.Key) {
+			originalHeaders = append(originalHeaders, header)
+		}
+	}
+
+	return originalHeaders
+}
+
+// failedMessage returns the message of the next retry topic or the DLQ, attempts is the number of failed calls of msg.
+func (policy KafkaRetryPolicy) failedMessage(msg *kafka.Message, attempts int, errMessage error, now time.Time) *kafka.Message {
+	sourceTopic := SourceTopic(msg)
+	topicName := pointer.GetStringValue(msg.TopicPartition.Topic)
+	nextTopic, delay := policy.nextTopic(sourceTopic, topicName)
+
+	sourcePartition, found := getHeaderValue(msg.Headers, KafkaHeaderSourcePartition)
+	if !found {
+		sourcePartition = strconv.FormatInt(int64(msg.TopicPartition.Partition), 10)
+	}
+
+	sourceOffset, found := getHeaderValue(msg.Headers, KafkaHeaderSourceOffset)
+	if !found {
+		sourceOffset = strconv.FormatInt(int64(msg.TopicPartition.Offset), 10)
+	}
+
+	errText := errMessage.Error()
+	if len(errText) > kafkaMaxErrorHeaderLength {
+		errText = errText[:kafkaMaxErrorHeaderLength]
+	}
+
+	headers := withoutRetryHeaders(msg.Headers)
+	headers = append(headers,
+		kafka.Header{Key: KafkaHeaderSourceTopic, Value: []byte(sourceTopic)},
+		kafka.Header{Key: KafkaHeaderSourcePartition, Value: []byte(sourcePartition)},
+		kafka.Header{Key: KafkaHeaderSourceOffset, Value: []byte(sourceOffset)},
+		kafka.Header{Key: KafkaHeaderAttempt, Value: []byte(strconv.Itoa(RetryAttempt(msg) + attempts))},
+		kafka.Header{Key: KafkaHeaderError, Value: []byte(errText)},
+		kafka.Header{Key: KafkaHeaderFailedTopic, Value: []byte(topicName)},
+	)
+
+	if delay > 0 {
+		headers = append(headers, kafka.Header{Key: KafkaHeaderNotBefore,
+			Value: []byte(strconv.FormatInt(now.Add(delay).UnixMilli(), 10))})
+	}
+
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &nextTopic, Partition: kafka.PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        headers,
+		Timestamp:      now,
+	}
+}
+
+// produceSync publishes msg and waits for its delivery report.
+func produceSync(producer messageProducer, msg *kafka.Message) (*kafka.Message, error) {
+	deliveryChan := make(chan kafka.Event, 1)
+
+	if err := producer.Produce(msg, deliveryChan); err != nil {
+		return nil, err
+	}
+
+	kafkaMessage, ok := (<-deliveryChan).(*kafka.Message)
+
+	if !ok {
+		return nil, errors.New("unexpected delivery report")
+	}
+
+	if kafkaMessage.TopicPartition.Error != nil {
+		return kafkaMessage, kafkaMessage.TopicPartition.Error
+	}
+
+	return kafkaMessage, nil
+}
+
+// forwardFailedMessage publishes msg to the next retry topic or the DLQ, then the offset of msg can be committed.
+func (kc *KafkaConfig) forwardFailedMessage(msgTransID string, producer messageProducer, msg *kafka.Message,
+	attempts int, errMessage error) error {
+
+	topicInfo := getTopicInfo(msg.TopicPartition)
+	failedMessage := kc.RetryPolicy.failedMessage(msg, attempts, errMessage, time.Now())
+	nextTopic := pointer.GetStringValue(failedMessage.TopicPartition.Topic)
+
+	deliveredMessage, err := produceSync(producer, failedMessage)
+
+	if err != nil {
+		kc.Logger.Error(msgTransID, topicInfo+" Forward failed message to "+nextTopic+" Error: "+err.Error())
+		return err
+	}
+
+	kc.Logger.Warn(msgTransID, fmt.Sprintf("%s Forwarded failed message to %s", topicInfo, getTopicInfo(deliveredMessage.TopicPartition)))
+
+	return nil
+}
+
+// processImmediateRetry calls onMessage once and ImmediateRetries more times while it fails,
+// it returns the number of calls, the last error and false when wait returns false.
+func (kc *KafkaConfig) processImmediateRetry(msgTransID string, msg *kafka.Message,
+	onMessage func(transID string, msg *kafka.Message) error, wait func(time.Duration) bool) (int, error) {
+
+	attempts := 0
+
+	for {
+		attempts++
+		err := onMessage(msgTransID, msg)
+
+		if err == nil || attempts > kc.RetryPolicy.ImmediateRetries {
+			return attempts, err
+		}
+
+		backoff := kc.RetryPolicy.immediateBackoff(attempts)
+		kc.Logger.Error(msgTransID, fmt.Sprintf("%s Retry message after %s because %s",
+			getTopicInfo(msg.TopicPartition), backoff.String(), err.Error()))
+
+		if !wait(backoff) {
+			return attempts, err
+		}
+	}
+}
+
+func (kc *KafkaConfig) newRetryProducer() (*kafka.Producer, error) {
+	configMap := kc.producerConfigMap()
+	configMap.SetKey("linger.ms", kafkaLingerMs)
+	configMap.SetKey("queue.buffering.max.ms", kafkaImmediatePublish)
+
+	return kafka.NewProducer(configMap)
+}
+
+/*
+ReplayDLQ publishes the messages of dlqTopic back to their source topic with the original key, value and headers,
+until there is no new message for 10 seconds or maxMessages messages are published, maxMessages <= 0 is no limit.
+The offsets are committed with the group GroupID + ".replay", so a message is replayed once.
+
+	count, err := kc.ReplayDLQ(transID, "order.dlq", 0)
+*/
+func (kc KafkaConfig) ReplayDLQ(transID string, dlqTopic string, maxMessages int) (int, error) {
+	kc.Logger.Info(transID, "Starting ReplayDLQ of "+dlqTopic)
+
+	replayConfig := kc
+	replayConfig.GroupID = kc.GroupID + ".replay"
+	replayConfig.AutoOffsetReset = "earliest"
+
+	consumer, err := kafka.NewConsumer(replayConfig.consumerConfigMap())
+
+	if err != nil {
+		kc.Logger.Error(transID, "ReplayDLQ.NewConsumer Error: "+err.Error())
+		return 0, err
+	}
+	defer consumer.Close()
+
+	producer, err := kafka.NewProducer(kc.producerConfigMap())
+
+	if err != nil {
+		kc.Logger.Error(transID, "ReplayDLQ.NewProducer Error: "+err.Error())
+		return 0, err
+	}
+	defer producer.Close()
+
+	if err = consumer.Subscribe(dlqTopic, nil); err != nil {
+		kc.Logger.Error(transID, "ReplayDLQ.Subscribe Error: "+err.Error())
+		return 0, err
+	}
+
+	count := 0
+	lastMessageAt := time.Now()
+	eofPartitions := make(map[int32]bool)
+
+	for maxMessages <= 0 || count < maxMessages {
+		event := consumer.Poll(kafkaConcurrentPollTimeoutMs)
+
+		switch e := event.(type) {
+		case *kafka.Message:
+			lastMessageAt = time.Now()
+			delete(eofPartitions, e.TopicPartition.Partition)
+
+			if err = kc.replayMessage(transID, producer, dlqTopic, e); err != nil {
+				return count, err
+			}
+
+			if _, err = consumer.CommitMessage(e); err != nil {
+				kc.Logger.Error(transID, getTopicInfo(e.TopicPartition)+" ReplayDLQ.CommitMessage Error: "+err.Error())
+				return count, err
+			}
+
+			count++
+		case kafka.PartitionEOF:
+			eofPartitions[e.Partition] = true
+		case kafka.Error:
+			kc.Logger.Error(transID, fmt.Sprintf("ReplayDLQ.Poll Error: %v", e))
+			return count, e
+		}
+
+		if assignment, _ := consumer.Assignment(); len(assignment) > 0 && len(eofPartitions) >= len(assignment) {
+			break
+		}
+
+		if time.Since(lastMessageAt) > kafkaReplayIdleTimeout {
+			break
+		}
+	}
+
+	producer.Flush(kafkaFlushTimeoutMs)
+	kc.Logger.Info(transID, fmt.Sprintf("Finished ReplayDLQ of %s, replayed %d messages", dlqTopic, count))
+
+	return count, nil
+}
+
+// replayMessage skips a message without the source topic header, it was not published by a retry policy.
+func (kc KafkaConfig) replayMessage(transID string, producer messageProducer, dlqTopic string, msg *kafka.Message) error {
+	topicInfo := getTopicInfo(msg.TopicPartition)
+	sourceTopic, found := getHeaderValue(msg.Headers, KafkaHeaderSourceTopic)
+
+	if !found {
+		kc.Logger.Warn(transID, topicInfo+" Skip message without header "+KafkaHeaderSourceTopic)
+		return nil
+	}
+
+	replayMessage := replayMessageOf(msg, sourceTopic, dlqTopic)
+
+	if _, err := produceSync(producer, replayMessage); err != nil {
+		kc.Logger.Error(transID, topicInfo+" Replay to "+sourceTopic+" Error: "+err.Error())
+		return err
+	}
+
+	kc.Logger.Info(transID, topicInfo+" Replayed to "+sourceTopic)
+
+	return nil
+}
+
+func replayMessageOf(msg *kafka.Message, sourceTopic string, dlqTopic string) *kafka.Message {
+	headers := append(withoutRetryHeaders(msg.Headers), kafka.Header{Key: KafkaHeaderReplayedFrom, Value: []byte(dlqTopic)})
+
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &sourceTopic, Partition: kafka.PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        headers,
+		Timestamp:      time.Now(),
+	}
+}
+
+// partitionPauser is implemented by *kafka.Consumer.
+type partitionPauser interface {
+	Pause(partitions []kafka.TopicPartition) error
+	Resume(partitions []kafka.TopicPartition) error
+	SeekPartitions(partitions []kafka.TopicPartition) ([]kafka.TopicPartition, error)
+}
+
+// pausedPartition is resumed at resumeAt, or later when drained is set and returns false at resumeAt.
+type pausedPartition struct {
+	tp       kafka.TopicPartition
+	resumeAt time.Time
+	drained  func() bool
+}
+
+// pausePartition pauses the partition of msg and seeks back to msg, the message is consumed again after Resume.
+func pausePartition(consumer partitionPauser, pausedMap map[topicPartition]pausedPartition, paused pausedPartition) error {
+	tpList := []kafka.TopicPartition{paused.tp}
+
+	if err := consumer.Pause(tpList); err != nil {
+		return err
+	}
+
+	if _, err := consumer.SeekPartitions(tpList); err != nil {
+		return err
+	}
+
+	pausedMap[toTopicPartition(paused.tp)] = paused
+
+	return nil
+}
+
+// pauseUntil pauses the partition of a retry message which is not due, the message is consumed again after Resume.
+func (kc *KafkaConfig) pauseUntil(transID string, consumer partitionPauser, pausedMap map[topicPartition]pausedPartition,
+	msg *kafka.Message, resumeAt time.Time) error {
+
+	if err := pausePartition(consumer, pausedMap, pausedPartition{tp: msg.TopicPartition, resumeAt: resumeAt}); err != nil {
+		return err
+	}
+
+	kc.Logger.Info(transID, getTopicInfo(msg.TopicPartition)+" Pause retry partition until "+resumeAt.Format(time.RFC3339))
+
+	return nil
+}
+
+func (kc *KafkaConfig) resumeDuePartitions(transID string, consumer partitionPauser,
+	pausedMap map[topicPartition]pausedPartition, now time.Time) {
+
+	for key, paused := range pausedMap {
+		if now.Before(paused.resumeAt) {
+			continue
+		}
+
+		if paused.drained != nil && !paused.drained() {
+			paused.resumeAt = now.Add(kafkaPausedCheckInterval)
+			pausedMap[key] = paused
+			continue
+		}
+
+		delete(pausedMap, key)
+
+		if err := consumer.Resume([]kafka.TopicPartition{paused.tp}); err != nil {
+			kc.Logger.Error(transID, getTopicInfo(paused.tp)+" Consumer.Resume Error: "+err.Error())
+		}
+	}
+}
+
+// pollTimeoutMs returns maxTimeoutMs or less, so a paused partition is resumed on time.
+func pollTimeoutMs(pausedMap map[topicPartition]pausedPartition, now time.Time, maxTimeoutMs int) int {
+	timeoutMs := maxTimeoutMs
+
+	for _, paused := range pausedMap {
+		if untilMs := int(paused.resumeAt.Sub(now) / time.Millisecond); untilMs < timeoutMs {
+			timeoutMs = untilMs
+		}
+	}
+
+	if timeoutMs < 0 {
+		return 0
+	}
+
+	return timeoutMs
+}
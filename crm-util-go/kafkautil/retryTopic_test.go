@@ -0,0 +1,409 @@
+package kafkautil
+
+import (
+	"crm-util-go/pointer"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// memoryProducer reports every message as delivered, or failed when err is set.
+type memoryProducer struct {
+	mu          sync.Mutex
+	messageList []*kafka.Message
+	err         error
+	nextOffset  kafka.Offset
+}
+
+func (p *memoryProducer) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delivered := *msg
+	delivered.TopicPartition.Partition = 0
+	delivered.TopicPartition.Offset = p.nextOffset
+	delivered.TopicPartition.Error = p.err
+	p.nextOffset++
+
+	if p.err == nil {
+		p.messageList = append(p.messageList, &delivered)
+	}
+
+	deliveryChan <- &delivered
+
+	return nil
+}
+
+func (p *memoryProducer) messages() []*kafka.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]*kafka.Message{}, p.messageList...)
+}
+
+type memoryPauser struct {
+	paused   map[int32]bool
+	seekList []kafka.TopicPartition
+}
+
+func (p *memoryPauser) Pause(partitions []kafka.TopicPartition) error {
+	for _, tp := range partitions {
+		p.paused[tp.Partition] = true
+	}
+	return nil
+}
+
+func (p *memoryPauser) Resume(partitions []kafka.TopicPartition) error {
+	for _, tp := range partitions {
+		delete(p.paused, tp.Partition)
+	}
+	return nil
+}
+
+func (p *memoryPauser) SeekPartitions(partitions []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+	p.seekList = append(p.seekList, partitions...)
+	return partitions, nil
+}
+
+func testRetryPolicy() *KafkaRetryPolicy {
+	return &KafkaRetryPolicy{
+		ImmediateRetries: 2,
+		RetryBackoff:     time.Millisecond,
+		RetryDelays:      []time.Duration{time.Minute, 10 * time.Minute},
+	}
+}
+
+func TestRetryTopicName(t *testing.T) {
+	testList := map[time.Duration]string{
+		time.Minute:             "order.retry.1m",
+		10 * time.Minute:        "order.retry.10m",
+		2 * time.Hour:           "order.retry.2h",
+		90 * time.Second:        "order.retry.90s",
+		1500 * time.Millisecond: "order.retry.1500ms",
+	}
+
+	for delay, expected := range testList {
+		if topicName := retryTopicName("order", delay); topicName != expected {
+			t.Errorf("retryTopicName(%s) = %s, expected %s", delay, topicName, expected)
+		}
+	}
+
+	kc := &KafkaConfig{TopicName: []string{"order"}, RetryPolicy: testRetryPolicy()}
+	topicNames := kc.subscribeTopicNames()
+
+	if len(topicNames) != 3 || topicNames[1] != "order.retry.1m" || topicNames[2] != "order.retry.10m" {
+		t.Errorf("unexpected subscribe topics %v", topicNames)
+	}
+}
+
+func TestRetryPolicyNextTopic(t *testing.T) {
+	policy := testRetryPolicy()
+
+	testList := [][3]string{
+		{"order", "order.retry.1m", "1m0s"},
+		{"order.retry.1m", "order.retry.10m", "10m0s"},
+		{"order.retry.10m", "order.dlq", "0s"},
+	}
+
+	for _, test := range testList {
+		nextTopic, delay := policy.nextTopic("order", test[0])
+
+		if nextTopic != test[1] || delay.String() != test[2] {
+			t.Errorf("nextTopic(%s) = %s %s, expected %s %s", test[0], nextTopic, delay, test[1], test[2])
+		}
+	}
+
+	policy.DLQTopic = "crm.dlq"
+	policy.RetryDelays = nil
+
+	if nextTopic, _ := policy.nextTopic("order", "order"); nextTopic != "crm.dlq" {
+		t.Errorf("expected the DLQTopic, got %s", nextTopic)
+	}
+}
+
+func TestRetryPolicyFailedMessage(t *testing.T) {
+	policy := testRetryPolicy()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	msg := newTestMessage("order", 3, 42, "K1")
+	msg.Value = []byte("payload")
+	msg.Headers = []kafka.Header{{Key: "correlation-id", Value: []byte("c-1")}}
+
+	retryMsg := policy.failedMessage(msg, 3, errors.New("downstream timeout"), now)
+
+	if pointer.GetStringValue(retryMsg.TopicPartition.Topic) != "order.retry.1m" {
+		t.Fatalf("unexpected topic %s", pointer.GetStringValue(retryMsg.TopicPartition.Topic))
+	}
+
+	if string(retryMsg.Key) != "K1" || string(retryMsg.Value) != "payload" {
+		t.Errorf("key and value should be kept")
+	}
+
+	expected := map[string]string{
+		"correlation-id":           "c-1",
+		KafkaHeaderSourceTopic:     "order",
+		KafkaHeaderSourcePartition: "3",
+		KafkaHeaderSourceOffset:    "42",
+		KafkaHeaderAttempt:         "3",
+		KafkaHeaderError:           "downstream timeout",
+		KafkaHeaderFailedTopic:     "order",
+		KafkaHeaderNotBefore:       strconv.FormatInt(now.Add(time.Minute).UnixMilli(), 10),
+	}
+
+	for key, value := range expected {
+		if headerValue, _ := getHeaderValue(retryMsg.Headers, key); headerValue != value {
+			t.Errorf("header %s = %q, expected %q", key, headerValue, value)
+		}
+	}
+
+	// the retry of the retry keeps the source and adds the attempts
+	retryMsg.TopicPartition.Partition = 0
+	retryMsg.TopicPartition.Offset = 7
+	dlqMsg := policy.failedMessage(retryMsg, 3, errors.New("still failing"), now)
+	dlqMsg = policy.failedMessage(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: dlqMsg.TopicPartition.Topic}, Headers: dlqMsg.Headers},
+		3, errors.New("last error"), now)
+
+	if pointer.GetStringValue(dlqMsg.TopicPartition.Topic) != "order.dlq" {
+		t.Fatalf("unexpected topic %s", pointer.GetStringValue(dlqMsg.TopicPartition.Topic))
+	}
+
+	if SourceTopic(dlqMsg) != "order" || RetryAttempt(dlqMsg) != 9 {
+		t.Errorf("unexpected source %s and attempt %d", SourceTopic(dlqMsg), RetryAttempt(dlqMsg))
+	}
+
+	if sourceOffset, _ := getHeaderValue(dlqMsg.Headers, KafkaHeaderSourceOffset); sourceOffset != "42" {
+		t.Errorf("source offset %s, expected 42", sourceOffset)
+	}
+
+	if _, found := getHeaderValue(dlqMsg.Headers, KafkaHeaderNotBefore); found {
+		t.Errorf("DLQ message should not have a delay")
+	}
+
+	headerCount := 0
+	for _, header := range dlqMsg.Headers {
+		if header.Key == KafkaHeaderAttempt {
+			headerCount++
+		}
+	}
+
+	if headerCount != 1 {
+		t.Errorf("retry headers should not be duplicated, got %d attempt headers", headerCount)
+	}
+
+	replayMsg := replayMessageOf(dlqMsg, "order", "order.dlq")
+
+	if pointer.GetStringValue(replayMsg.TopicPartition.Topic) != "order" || len(replayMsg.Headers) != 2 {
+		t.Errorf("unexpected replay message %v %v", pointer.GetStringValue(replayMsg.TopicPartition.Topic), replayMsg.Headers)
+	}
+
+	if replayedFrom, _ := getHeaderValue(replayMsg.Headers, KafkaHeaderReplayedFrom); replayedFrom != "order.dlq" {
+		t.Errorf("replayed from %s, expected order.dlq", replayedFrom)
+	}
+}
+
+func TestProcessImmediateRetry(t *testing.T) {
+	kc := newTestKafkaConfig()
+	kc.RetryPolicy = testRetryPolicy()
+
+	var waitList []time.Duration
+	wait := func(backoff time.Duration) bool {
+		waitList = append(waitList, backoff)
+		return true
+	}
+
+	calls := 0
+	attempts, err := kc.processImmediateRetry("T1", newTestMessage("order", 0, 0, ""), func(transID string, msg *kafka.Message) error {
+		calls++
+		return errors.New("fail")
+	}, wait)
+
+	if attempts != 3 || calls != 3 || err == nil {
+		t.Errorf("expected 3 failed attempts, got %d %d %v", attempts, calls, err)
+	}
+
+	if len(waitList) != 2 || waitList[0] != time.Millisecond || waitList[1] != 2*time.Millisecond {
+		t.Errorf("unexpected backoff %v", waitList)
+	}
+
+	calls = 0
+	attempts, err = kc.processImmediateRetry("T2", newTestMessage("order", 0, 1, ""), func(transID string, msg *kafka.Message) error {
+		calls++
+		if calls < 2 {
+			return errors.New("fail")
+		}
+		return nil
+	}, wait)
+
+	if attempts != 2 || err != nil {
+		t.Errorf("expected success on attempt 2, got %d %v", attempts, err)
+	}
+}
+
+func TestForwardFailedMessage(t *testing.T) {
+	kc := newTestKafkaConfig()
+	kc.RetryPolicy = testRetryPolicy()
+	producer := &memoryProducer{}
+
+	if err := kc.forwardFailedMessage("T1", producer, newTestMessage("order", 0, 5, "K"), 3, errors.New("fail")); err != nil {
+		t.Fatal(err)
+	}
+
+	messageList := producer.messages()
+
+	if len(messageList) != 1 || pointer.GetStringValue(messageList[0].TopicPartition.Topic) != "order.retry.1m" {
+		t.Fatalf("unexpected forwarded messages %v", messageList)
+	}
+
+	producer.err = kafka.NewError(kafka.ErrMsgTimedOut, "timeout", false)
+
+	if err := kc.forwardFailedMessage("T2", producer, newTestMessage("order", 0, 6, "K"), 3, errors.New("fail")); err == nil {
+		t.Errorf("expected the delivery error")
+	}
+}
+
+func TestPauseRetryPartition(t *testing.T) {
+	kc := newTestKafkaConfig()
+	pauser := &memoryPauser{paused: make(map[int32]bool)}
+	pausedMap := make(map[topicPartition]pausedPartition)
+	now := time.Now()
+
+	msg := newTestMessage("order.retry.1m", 2, 11, "")
+
+	if err := kc.pauseUntil("T1", pauser, pausedMap, msg, now.Add(3*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !pauser.paused[2] || len(pauser.seekList) != 1 || pauser.seekList[0].Offset != 11 {
+		t.Fatalf("partition should be paused and seek back to offset 11")
+	}
+
+	if timeoutMs := pollTimeoutMs(pausedMap, now, 10000); timeoutMs > 3000 || timeoutMs < 2900 {
+		t.Errorf("poll timeout %d, expected about 3000", timeoutMs)
+	}
+
+	kc.resumeDuePartitions("T1", pauser, pausedMap, now.Add(time.Second))
+
+	if !pauser.paused[2] {
+		t.Errorf("partition should not be resumed before its time")
+	}
+
+	kc.resumeDuePartitions("T1", pauser, pausedMap, now.Add(4*time.Second))
+
+	if pauser.paused[2] || len(pausedMap) != 0 {
+		t.Errorf("partition should be resumed")
+	}
+}
+
+func TestConsumerWorkerPoolRetryPolicy(t *testing.T) {
+	kc := newTestKafkaConfig()
+	kc.RetryPolicy = testRetryPolicy()
+	tracker := newOffsetTracker()
+	producer := &memoryProducer{}
+
+	var mu sync.Mutex
+	processed := 0
+
+	pool := kc.newConsumerWorkerPool(tracker, producer, func(transID string, msg *kafka.Message) error {
+		if msg.TopicPartition.Offset == 0 {
+			return errors.New("poison message")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		processed++
+
+		return nil
+	})
+
+	pool.dispatch(newTestMessage("order", 0, 0, ""))
+	pool.dispatch(newTestMessage("order", 0, 1, ""))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for commitList := tracker.commitList(); len(commitList) == 0 || commitList[0].Offset != 2; commitList = tracker.commitList() {
+		if time.Now().After(deadline) {
+			t.Fatalf("poison message should not block the partition, commit list %v", commitList)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	pool.shutdown(time.Second)
+
+	if processed != 1 {
+		t.Errorf("expected 1 processed message, got %d", processed)
+	}
+
+	messageList := producer.messages()
+
+	if len(messageList) != 1 || RetryAttempt(messageList[0]) != 3 {
+		t.Errorf("poison message should be forwarded after 3 attempts, got %v", messageList)
+	}
+}
+
+func TestConsumerWorkerPoolDelayedRetry(t *testing.T) {
+	kc := newTestKafkaConfig()
+	kc.RetryPolicy = testRetryPolicy()
+	tracker := newOffsetTracker()
+	pauser := &memoryPauser{paused: make(map[int32]bool)}
+	pausedMap := make(map[topicPartition]pausedPartition)
+
+	processed := make(chan *kafka.Message, 2)
+	pool := kc.newConsumerWorkerPool(tracker, &memoryProducer{}, func(transID string, msg *kafka.Message) error {
+		processed <- msg
+		return nil
+	})
+	defer pool.shutdown(time.Second)
+
+	notBefore := time.Now().Add(time.Hour)
+	retryMsg := newTestMessage("order.retry.1m", 0, 7, "")
+	retryMsg.Headers = []kafka.Header{{Key: KafkaHeaderNotBefore, Value: []byte(strconv.FormatInt(notBefore.UnixMilli(), 10))}}
+
+	// The source message uses the worker of the retry message
+	var sourceMsg *kafka.Message
+	for partition := int32(0); sourceMsg == nil; partition++ {
+		if msg := newTestMessage("order", partition, 0, ""); pool.workerIndex(msg) == pool.workerIndex(retryMsg) {
+			sourceMsg = msg
+		}
+	}
+
+	if err := pool.receive(pauser, pausedMap, retryMsg); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pool.receive(pauser, pausedMap, sourceMsg); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-processed:
+		if msg != sourceMsg {
+			t.Fatalf("expected the source message but got %v", msg.TopicPartition)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the delayed retry message should not hold back the worker")
+	}
+
+	if !pauser.paused[0] || len(pauser.seekList) != 1 || pauser.seekList[0].Offset != 7 {
+		t.Fatalf("the retry partition should be paused and seek back to offset 7")
+	}
+
+	// After resume the retry message is consumed again, it is due now
+	kc.resumeDuePartitions("T1", pauser, pausedMap, notBefore)
+	retryMsg.Headers = nil
+
+	if err := pool.receive(pauser, pausedMap, retryMsg); err != nil || pauser.paused[0] {
+		t.Fatalf("the retry partition should be resumed, %v", err)
+	}
+
+	select {
+	case msg := <-processed:
+		if msg != retryMsg {
+			t.Fatalf("expected the retry message but got %v", msg.TopicPartition)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the due retry message should be processed")
+	}
+}
@@ -0,0 +1,44 @@
+package kafkautil
+
+import (
+	"errors"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
+)
+
+const (
+	schemaRegistryMagicByte  byte = 0
+	schemaRegistryHeaderSize int  = 5
+)
+
+var ErrInvalidWireFormat = errors.New("kafka value is not in the schema registry wire format")
+
+/*
+NewSchemaRegistryClient returns the Confluent schema registry client of config.
+TLS verifies the registry against the system cert pool, CertPEMFileName replaces the pool with its CA certificates.
+*/
+func NewSchemaRegistryClient(config SchemaRegistryConfig) (schemaregistry.Client, error) {
+	registryConfig := schemaregistry.NewConfig(config.URL)
+
+	if config.UserName != "" {
+		registryConfig = schemaregistry.NewConfigWithAuthentication(config.URL, config.UserName, config.Password)
+	}
+
+	registryConfig.SslCaLocation = config.CertPEMFileName
+
+	if config.Timeout > 0 {
+		registryConfig.ConnectionTimeoutMs = int(config.Timeout.Milliseconds())
+		registryConfig.RequestTimeoutMs = int(config.Timeout.Milliseconds())
+	}
+
+	return schemaregistry.NewClient(registryConfig)
+}
+
+// checkWireFormat rejects data without the magic byte and the schema id, the serde of confluent expects both.
+func checkWireFormat(data []byte) error {
+	if len(data) < schemaRegistryHeaderSize || data[0] != schemaRegistryMagicByte {
+		return ErrInvalidWireFormat
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package kafkautil
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
+)
+
+// stubSchemaRegistry implements the register and get schema APIs of the schema registry in memory.
+type stubSchemaRegistry struct {
+	mu           sync.Mutex
+	schemaList   []schemaregistry.SchemaInfo
+	subjectMap   map[string][]int
+	requestCount int
+	userName     string
+}
+
+func newStubSchemaRegistry(t *testing.T) (*stubSchemaRegistry, schemaregistry.Client) {
+	stub := &stubSchemaRegistry{subjectMap: make(map[string][]int)}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	client, err := NewSchemaRegistryClient(SchemaRegistryConfig{URL: server.URL})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return stub, client
+}
+
+func (s *stubSchemaRegistry) requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requestCount
+}
+
+func (s *stubSchemaRegistry) writeError(w http.ResponseWriter, httpStatusCode int, errorCode int, message string) {
+	w.WriteHeader(httpStatusCode)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error_code": errorCode, "message": message})
+}
+
+func (s *stubSchemaRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requestCount++
+	s.userName, _, _ = r.BasicAuth()
+	w.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+
+	switch {
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/subjects/") && strings.HasSuffix(r.URL.Path, "/versions"):
+		subject := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/subjects/"), "/versions")
+
+		var schema schemaregistry.SchemaInfo
+		if err := json.NewDecoder(r.Body).Decode(&schema); err != nil || schema.Schema == "" {
+			s.writeError(w, http.StatusUnprocessableEntity, 42201, "Invalid schema")
+			return
+		}
+
+		id := 0
+		for i, registered := range s.schemaList {
+			if registered.Schema == schema.Schema && registered.SchemaType == schema.SchemaType {
+				id = i + 1
+			}
+		}
+
+		if id == 0 {
+			s.schemaList = append(s.schemaList, schema)
+			id = len(s.schemaList)
+			s.subjectMap[subject] = append(s.subjectMap[subject], id)
+		}
+
+		json.NewEncoder(w).Encode(map[string]int{"id": id})
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/schemas/ids/"):
+		id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/schemas/ids/"))
+
+		if id < 1 || id > len(s.schemaList) {
+			s.writeError(w, http.StatusNotFound, 40403, "Schema "+strconv.Itoa(id)+" not found")
+			return
+		}
+
+		json.NewEncoder(w).Encode(s.schemaList[id-1])
+	default:
+		s.writeError(w, http.StatusNotFound, 404, "HTTP 404 Not Found")
+	}
+}
+
+func TestSchemaRegistryRegisterAndGetSchema(t *testing.T) {
+	stub, client := newStubSchemaRegistry(t)
+	order := schemaregistry.SchemaInfo{Schema: `{"type":"string"}`}
+	customer := schemaregistry.SchemaInfo{Schema: `{"type":"object"}`, SchemaType: "JSON"}
+
+	avroID, err := client.Register("order-value", order, false)
+	if err != nil || avroID != 1 {
+		t.Fatalf("expected id 1, got %d, %v", avroID, err)
+	}
+
+	jsonID, err := client.Register("customer-value", customer, false)
+	if err != nil || jsonID != 2 {
+		t.Fatalf("expected id 2, got %d, %v", jsonID, err)
+	}
+
+	if id, err := client.Register("order-value", order, false); err != nil || id != avroID {
+		t.Fatalf("expected cached id %d, got %d, %v", avroID, id, err)
+	}
+
+	if stub.requests() != 2 {
+		t.Fatalf("expected Register to be cached, got %d requests", stub.requests())
+	}
+
+	for i := 0; i < 2; i++ {
+		schema, err := client.GetBySubjectAndID("customer-value", jsonID)
+		if err != nil || schema.Schema != customer.Schema || schema.SchemaType != customer.SchemaType {
+			t.Fatalf("expected %+v, got %+v, %v", customer, schema, err)
+		}
+	}
+
+	if stub.requests() != 3 {
+		t.Fatalf("expected GetBySubjectAndID to be cached, got %d requests", stub.requests())
+	}
+}
+
+func TestSchemaRegistryError(t *testing.T) {
+	_, client := newStubSchemaRegistry(t)
+
+	_, err := client.GetBySubjectAndID("order-value", 99)
+
+	var registryErr *schemaregistry.RestError
+	if !errors.As(err, &registryErr) || registryErr.Code != 40403 {
+		t.Fatalf("expected error code 40403, got %v", err)
+	}
+
+	if _, err = client.Register("order-value", schemaregistry.SchemaInfo{}, false); !errors.As(err, &registryErr) || registryErr.Code != 42201 {
+		t.Fatalf("expected error code 42201, got %v", err)
+	}
+}
+
+func TestSchemaRegistryClientTLS(t *testing.T) {
+	stub := &stubSchemaRegistry{subjectMap: make(map[string][]int)}
+	server := httptest.NewTLSServer(stub)
+	defer server.Close()
+
+	schema := schemaregistry.SchemaInfo{Schema: `{"type":"string"}`}
+
+	// The certificate of the test server is not in the system cert pool.
+	client, err := NewSchemaRegistryClient(SchemaRegistryConfig{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = client.Register("order-value", schema, false); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("expected the unknown certificate to fail, got %v", err)
+	}
+
+	certPEMFileName := filepath.Join(t.TempDir(), "schema-registry-ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	if err = os.WriteFile(certPEMFileName, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err = NewSchemaRegistryClient(SchemaRegistryConfig{URL: server.URL, UserName: "crm", Password: "secret",
+		CertPEMFileName: certPEMFileName})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err := client.Register("order-value", schema, false); err != nil || id != 1 || stub.userName != "crm" {
+		t.Fatalf("expected id 1 registered by crm, got %d, %q, %v", id, stub.userName, err)
+	}
+}
+
+func TestCheckWireFormat(t *testing.T) {
+	if err := checkWireFormat([]byte("\x00\x00\x00\x01\x02payload")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, invalid := range [][]byte{nil, []byte("\x00\x00\x01"), []byte("\x01\x00\x00\x00\x01{}")} {
+		if err := checkWireFormat(invalid); !errors.Is(err, ErrInvalidWireFormat) {
+			t.Fatalf("expected ErrInvalidWireFormat for %v, got %v", invalid, err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package kafkautil
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde/avro"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde/jsonschema"
+)
+
+/*
+NewJSONSchemaSerde returns the serializer and deserializer of the kafka value in JSON.
+The JSON schema is generated from the Go type and registered under the subject <topic>-value,
+the value is validated against it before it is written and after it is read.
+*/
+func NewJSONSchemaSerde(client schemaregistry.Client) (serde.Serializer, serde.Deserializer, error) {
+	serializerConfig := jsonschema.NewSerializerConfig()
+	serializerConfig.EnableValidation = true
+
+	serializer, err := jsonschema.NewSerializer(client, serde.ValueSerde, serializerConfig)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deserializerConfig := jsonschema.NewDeserializerConfig()
+	deserializerConfig.EnableValidation = true
+
+	deserializer, err := jsonschema.NewDeserializer(client, serde.ValueSerde, deserializerConfig)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return serializer, deserializer, nil
+}
+
+/*
+NewAvroSerde returns the serializer and deserializer of the kafka value in Avro.
+The Avro schema is generated from the Go type, the json tags are the field names, and registered under the subject <topic>-value.
+The value is read with its writer schema, the fields which are not in the Go type are ignored.
+*/
+func NewAvroSerde(client schemaregistry.Client) (serde.Serializer, serde.Deserializer, error) {
+	serializer, err := avro.NewGenericSerializer(client, serde.ValueSerde, avro.NewSerializerConfig())
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deserializer, err := avro.NewGenericDeserializer(client, serde.ValueSerde, avro.NewDeserializerConfig())
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return serializer, deserializer, nil
+}
+
+// PublishValue serializes value for topic, or TopicName[0] of the producer when topic is empty, and waits for its delivery.
+func PublishValue[T any](ctx context.Context, producer *Producer, serializer serde.Serializer, transID string,
+	topic string, key string, value T, headers []kafka.Header) (*kafka.Message, error) {
+
+	if topic == "" {
+		if len(producer.kc.TopicName) == 0 {
+			return nil, ErrNoTopic
+		}
+
+		topic = producer.kc.TopicName[0]
+	}
+
+	data, err := serializer.Serialize(topic, value)
+
+	if err != nil {
+		producer.kc.Logger.Error(transID, "PublishValue serialize failed because "+err.Error())
+		return nil, err
+	}
+
+	msg := NewMessage(topic, key, "", headers)
+	msg.Value = data
+
+	future, err := producer.Produce(transID, msg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return future.Wait(ctx)
+}
+
+/*
+ConsumeValue returns the onMessage of ConsumerMessage and ConsumerConcurrent which deserializes the kafka value into T.
+The subject is of the SourceTopic, so a message of a retry topic is read like the message of its source topic.
+The deserialize error is returned as the error of onMessage, so the message is retried or forwarded by RetryPolicy.
+*/
+func ConsumeValue[T any](deserializer serde.Deserializer,
+	onMessage func(transID string, msg *kafka.Message, value T) error) func(transID string, msg *kafka.Message) error {
+
+	return func(transID string, msg *kafka.Message) error {
+		var value T
+
+		err := checkWireFormat(msg.Value)
+
+		if err == nil {
+			err = deserializer.DeserializeInto(SourceTopic(msg), msg.Value, &value)
+		}
+
+		if err != nil {
+			return fmt.Errorf("deserialize message of %s failed: %w", getTopicInfo(msg.TopicPartition), err)
+		}
+
+		return onMessage(transID, msg, value)
+	}
+}
@@ -0,0 +1,169 @@
+package kafkautil
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
+)
+
+type testOrder struct {
+	OrderID  string            `json:"orderId"`
+	Quantity int               `json:"quantity"`
+	Price    float64           `json:"price"`
+	Status   string            `json:"status"`
+	Note     *string           `json:"note"`
+	Items    []string          `json:"items"`
+	Attrs    map[string]string `json:"attrs"`
+	Payload  []byte            `json:"payload"`
+}
+
+func newTestOrder() testOrder {
+	note := "urgent"
+
+	return testOrder{
+		OrderID:  "ORD-1",
+		Quantity: 2,
+		Price:    99.5,
+		Status:   "PAID",
+		Note:     &note,
+		Items:    []string{"A", "B"},
+		Attrs:    map[string]string{"channel": "web"},
+		Payload:  []byte{0, 1, 2},
+	}
+}
+
+func encodeTestWireFormat(schemaID int, payload string) []byte {
+	data := make([]byte, schemaRegistryHeaderSize)
+	binary.BigEndian.PutUint32(data[1:], uint32(schemaID))
+
+	return append(data, payload...)
+}
+
+func TestJSONSchemaSerde(t *testing.T) {
+	stub, client := newStubSchemaRegistry(t)
+	serializer, deserializer, err := NewJSONSchemaSerde(client)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := newTestOrder()
+	data, err := serializer.Serialize("order", order)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if binary.BigEndian.Uint32(data[1:5]) != 1 || !strings.HasPrefix(string(data[5:]), `{"orderId":"ORD-1"`) {
+		t.Fatalf("unexpected wire format %v", data)
+	}
+
+	if len(stub.subjectMap["order-value"]) != 1 || stub.schemaList[0].SchemaType != "JSON" {
+		t.Fatalf("expected the JSON schema under order-value, got %v, %+v", stub.subjectMap, stub.schemaList)
+	}
+
+	var result testOrder
+	if err = deserializer.DeserializeInto("order", data, &result); err != nil || !reflect.DeepEqual(result, order) {
+		t.Fatalf("expected %+v, got %+v, %v", order, result, err)
+	}
+
+	// The value written by another producer is validated with its writer schema.
+	if err = deserializer.DeserializeInto("order", encodeTestWireFormat(1, `{"orderId":2}`), &result); err == nil ||
+		!strings.Contains(err.Error(), "does not validate") {
+		t.Fatalf("expected the invalid value to fail the validation, got %v", err)
+	}
+}
+
+func TestAvroSerde(t *testing.T) {
+	stub, client := newStubSchemaRegistry(t)
+	serializer, deserializer, err := NewAvroSerde(client)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := newTestOrder()
+	data, err := serializer.Serialize("order", order)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// orderId "ORD-1" is the length 5 as zigzag varint 10 and the bytes of the string.
+	if binary.BigEndian.Uint32(data[1:5]) != 1 || string(data[5:11]) != "\x0aORD-1" {
+		t.Fatalf("unexpected wire format %v", data)
+	}
+
+	if stub.schemaList[0].SchemaType != "" {
+		t.Fatalf("expected schemaType to be omitted for AVRO, got %+v", stub.schemaList[0])
+	}
+
+	var result testOrder
+	if err = deserializer.DeserializeInto("order", data, &result); err != nil || !reflect.DeepEqual(result, order) {
+		t.Fatalf("expected %+v, got %+v, %v", order, result, err)
+	}
+
+	order.Note = nil
+	order.Items = []string{}
+	data, _ = serializer.Serialize("order", order)
+
+	result = testOrder{}
+	if err = deserializer.DeserializeInto("order", data, &result); err != nil || result.Note != nil || len(result.Items) != 0 {
+		t.Fatalf("expected null note and no items, got %+v, %v", result, err)
+	}
+
+	if err = deserializer.DeserializeInto("order", data[:len(data)-2], &result); err == nil {
+		t.Fatal("expected the truncated data to fail")
+	}
+
+	// The writer schema with fewer fields is read into the Go type.
+	writerID, _ := client.Register("order-value", schemaregistry.SchemaInfo{
+		Schema: `{"type":"record","name":"testOrder","fields":[{"name":"orderId","type":"string"}]}`}, false)
+
+	result = testOrder{}
+	if err = deserializer.DeserializeInto("order", encodeTestWireFormat(writerID, "\x0aORD-2"), &result); err != nil || result.OrderID != "ORD-2" {
+		t.Fatalf("expected ORD-2, got %+v, %v", result, err)
+	}
+}
+
+func TestPublishAndConsumeValue(t *testing.T) {
+	_, client := newStubSchemaRegistry(t)
+	serializer, deserializer, _ := NewAvroSerde(client)
+
+	kc := newTestKafkaConfig()
+	kc.TopicName = []string{"order"}
+	producer := newProducer("transID", *kc, KafkaProducerConfig{}, newMemoryEventProducer(false))
+	defer producer.Close()
+
+	order := newTestOrder()
+	msg, err := PublishValue(context.Background(), producer, serializer, "transID", "", "ORD-1", order, nil)
+
+	if err != nil || *msg.TopicPartition.Topic != "order" || msg.Value[0] != schemaRegistryMagicByte {
+		t.Fatalf("expected the serialized value delivered to order, got %v, %v", msg, err)
+	}
+
+	var consumed testOrder
+	onMessage := ConsumeValue(deserializer, func(transID string, msg *kafka.Message, value testOrder) error {
+		consumed = value
+		return nil
+	})
+
+	if err = onMessage("transID", msg); err != nil || !reflect.DeepEqual(consumed, order) {
+		t.Fatalf("expected %+v, got %+v, %v", order, consumed, err)
+	}
+
+	msg.Value = []byte("plain")
+	if err = onMessage("transID", msg); !errors.Is(err, ErrInvalidWireFormat) {
+		t.Fatalf("expected ErrInvalidWireFormat, got %v", err)
+	}
+
+	if _, err = PublishValue(context.Background(), producer, serializer, "transID", "order", "ORD-1", make(chan int), nil); err == nil {
+		t.Fatal("expected the value without an avro type not to be published")
+	}
+}
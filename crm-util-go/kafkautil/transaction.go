@@ -0,0 +1,393 @@
+package kafkautil
+
+import (
+	"context"
+	"crm-util-go/common"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+const (
+	kafkaDefaultTransactionMaxMessages int           = 100
+	kafkaDefaultTransactionInterval    time.Duration = time.Second
+	kafkaTransactionCallTimeout        time.Duration = 30 * time.Second
+	kafkaTransactionPollTimeoutMs      int           = 100
+)
+
+var ErrNoTransactionalID = errors.New("kafka TransactionalID is required for transactions")
+
+// transactionProducer is implemented by *kafka.Producer.
+type transactionProducer interface {
+	messageProducer
+	BeginTransaction() error
+	SendOffsetsToTransaction(ctx context.Context, offsets []kafka.TopicPartition, consumerMetadata *kafka.ConsumerGroupMetadata) error
+	CommitTransaction(ctx context.Context) error
+	AbortTransaction(ctx context.Context) error
+}
+
+// transactionConsumer is implemented by *kafka.Consumer.
+type transactionConsumer interface {
+	GetConsumerGroupMetadata() (*kafka.ConsumerGroupMetadata, error)
+	SeekPartitions(partitions []kafka.TopicPartition) ([]kafka.TopicPartition, error)
+}
+
+// transactionBatch keeps the offsets of the messages consumed in the open transaction.
+type transactionBatch struct {
+	first map[topicPartition]kafka.Offset
+	next  map[topicPartition]kafka.Offset
+	count int
+	start time.Time
+}
+
+func newTransactionBatch(now time.Time) *transactionBatch {
+	return &transactionBatch{
+		first: make(map[topicPartition]kafka.Offset),
+		next:  make(map[topicPartition]kafka.Offset),
+		start: now,
+	}
+}
+
+func (batch *transactionBatch) add(tp kafka.TopicPartition) {
+	key := toTopicPartition(tp)
+
+	if _, found := batch.first[key]; !found {
+		batch.first[key] = tp.Offset
+	}
+
+	batch.next[key] = tp.Offset + 1
+	batch.count++
+}
+
+func offsetList(offsetMap map[topicPartition]kafka.Offset) []kafka.TopicPartition {
+	var partitionList []kafka.TopicPartition
+
+	for key, offset := range offsetMap {
+		topic := key.topic
+		partitionList = append(partitionList, kafka.TopicPartition{Topic: &topic, Partition: key.partition, Offset: offset})
+	}
+
+	return partitionList
+}
+
+// commitList returns the offsets committed with the transaction.
+func (batch *transactionBatch) commitList() []kafka.TopicPartition {
+	return offsetList(batch.next)
+}
+
+// rewindList returns the offsets of the first messages, they are consumed again after the transaction is aborted.
+func (batch *transactionBatch) rewindList() []kafka.TopicPartition {
+	return offsetList(batch.first)
+}
+
+// transactionProcessor runs one transaction at a time, the transaction begins with its first message.
+type transactionProcessor struct {
+	kc        *KafkaConfig
+	transID   string
+	out       string
+	producer  transactionProducer
+	onMessage func(transID string, msg *kafka.Message) ([]KafkaMessage, error)
+	batch     *transactionBatch
+}
+
+// process produces the messages returned by onMessage in the transaction, the transaction must be aborted on error.
+func (tp *transactionProcessor) process(msg *kafka.Message) error {
+	if tp.batch == nil {
+		if err := tp.producer.BeginTransaction(); err != nil {
+			tp.kc.Logger.Error(tp.transID, "Producer.BeginTransaction Error: "+err.Error())
+			return err
+		}
+
+		tp.batch = newTransactionBatch(time.Now())
+	}
+
+	tp.batch.add(msg.TopicPartition)
+
+	msgTransID := common.NewUUID()
+	topicInfo := getTopicInfo(msg.TopicPartition)
+
+	tp.kc.Logger.Info(msgTransID, fmt.Sprintf("Start message on %s, Key: %s, Value: %s",
+		topicInfo, string(msg.Key), string(msg.Value)))
+
+	tp.kc.logKafkaHeaders(msgTransID, msg.Headers)
+
+	outList, err := tp.onMessage(msgTransID, msg)
+
+	if err != nil {
+		tp.kc.Logger.Error(msgTransID, topicInfo+" Process Error: "+err.Error())
+		return err
+	}
+
+	for _, out := range outList {
+		err = tp.producer.Produce(&kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &tp.out, Partition: kafka.PartitionAny},
+			Value:          []byte(out.Value),
+			Key:            []byte(out.Key),
+			Timestamp:      time.Now(),
+			Headers:        out.Headers,
+		}, nil)
+
+		if err != nil {
+			tp.kc.Logger.Error(msgTransID, topicInfo+" Producer.Produce() failed because "+err.Error())
+			return err
+		}
+	}
+
+	tp.kc.Logger.Info(msgTransID, fmt.Sprintf("%s produced %d messages to %s", topicInfo, len(outList), tp.out))
+
+	return nil
+}
+
+func (tp *transactionProcessor) isDue(now time.Time) bool {
+	if tp.batch == nil {
+		return false
+	}
+
+	maxMessages := tp.kc.TransactionMaxMessages
+	if maxMessages <= 0 {
+		maxMessages = kafkaDefaultTransactionMaxMessages
+	}
+
+	interval := tp.kc.TransactionInterval
+	if interval <= 0 {
+		interval = kafkaDefaultTransactionInterval
+	}
+
+	return tp.batch.count >= maxMessages || now.Sub(tp.batch.start) >= interval
+}
+
+/*
+commit commits the produced messages and the consumed offsets together.
+The transaction is aborted and its messages are consumed again when the error requires abort,
+the other errors are fatal and returned.
+*/
+func (tp *transactionProcessor) commit(consumer transactionConsumer) error {
+	if tp.batch == nil {
+		return nil
+	}
+
+	err := tp.commitTransaction(consumer)
+
+	if err == nil {
+		tp.kc.Logger.Info(tp.transID, fmt.Sprintf("Producer.CommitTransaction Success %d messages %v",
+			tp.batch.count, tp.batch.commitList()))
+
+		tp.batch = nil
+		return nil
+	}
+
+	tp.kc.Logger.Error(tp.transID, "Producer.CommitTransaction Error: "+err.Error())
+
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) && kafkaErr.TxnRequiresAbort() {
+		return tp.abort(consumer)
+	}
+
+	return err
+}
+
+func (tp *transactionProcessor) commitTransaction(consumer transactionConsumer) error {
+	consumerMetadata, err := consumer.GetConsumerGroupMetadata()
+
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaTransactionCallTimeout)
+	defer cancel()
+
+	if err = tp.producer.SendOffsetsToTransaction(ctx, tp.batch.commitList(), consumerMetadata); err != nil {
+		return err
+	}
+
+	for {
+		err = tp.producer.CommitTransaction(ctx)
+
+		var kafkaErr kafka.Error
+		if err == nil || ctx.Err() != nil || !errors.As(err, &kafkaErr) || !kafkaErr.IsRetriable() {
+			return err
+		}
+
+		tp.kc.Logger.Warn(tp.transID, "Producer.CommitTransaction Retry: "+err.Error())
+	}
+}
+
+// abort discards the produced messages and seeks to the first message of each partition of the transaction.
+func (tp *transactionProcessor) abort(consumer transactionConsumer) error {
+	if tp.batch == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaTransactionCallTimeout)
+	defer cancel()
+
+	if err := tp.producer.AbortTransaction(ctx); err != nil {
+		tp.kc.Logger.Error(tp.transID, "Producer.AbortTransaction Error: "+err.Error())
+		return err
+	}
+
+	rewindList := tp.batch.rewindList()
+	tp.batch = nil
+
+	tp.kc.Logger.Info(tp.transID, fmt.Sprintf("Producer.AbortTransaction Success, rewind %v", rewindList))
+
+	if _, err := consumer.SeekPartitions(rewindList); err != nil {
+		tp.kc.Logger.Error(tp.transID, "Consumer.SeekPartitions Error: "+err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (kc *KafkaConfig) transactionalConfigMap() *kafka.ConfigMap {
+	configMap := kc.producerConfigMap()
+	configMap.SetKey("transactional.id", kc.TransactionalID)
+	configMap.SetKey("enable.idempotence", true)
+	// The delivery of the messages is checked by CommitTransaction.
+	configMap.SetKey("go.delivery.reports", false)
+
+	if kc.CompressionType != "" {
+		configMap.SetKey("compression.type", kc.CompressionType)
+	}
+
+	return configMap
+}
+
+/*
+Process consumes topic in, produces the messages returned by onMessage to topic out
+and commits the consumed offsets in the same Kafka transaction, so a message is processed exactly once
+by the consumers of out with isolation.level read_committed, even when the application crashes before the commit.
+
+A transaction is committed after TransactionMaxMessages or TransactionInterval.
+When onMessage returns an error the transaction is aborted, then its messages are processed again after RetryBackoff.
+Process stops when ctx is cancelled or ShutdownConsumer is called, the open transaction is committed.
+It returns nil when it is stopped, or the error which stops it.
+*/
+func (kc *KafkaConfig) Process(ctx context.Context, transID string, in string, out string,
+	onMessage func(transID string, msg *kafka.Message) ([]KafkaMessage, error)) error {
+
+	// The context of the call stops what it started, it does not stop the other consumers of kc
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	kc.Logger.Info(transID, "Starting Process "+in+" to "+out+". TransactionalID: "+kc.TransactionalID+", "+kc.consumerInfo())
+
+	if kc.TransactionalID == "" {
+		kc.Logger.Error(transID, ErrNoTransactionalID.Error())
+		return ErrNoTransactionalID
+	}
+
+	producer, err := kafka.NewProducer(kc.transactionalConfigMap())
+
+	if err != nil {
+		kc.Logger.Error(transID, "Producer can not connect to kafka server", err)
+		return err
+	}
+
+	defer producer.Close()
+
+	go func() {
+		for event := range producer.Events() {
+			if e, ok := event.(kafka.Error); ok {
+				kc.Logger.Error(transID, fmt.Sprintf("Producer Error: %v", e))
+			}
+		}
+	}()
+
+	initCtx, cancel := context.WithTimeout(ctx, kafkaTransactionCallTimeout)
+	err = producer.InitTransactions(initCtx)
+	cancel()
+
+	if err != nil {
+		kc.Logger.Error(transID, "Producer.InitTransactions Error: "+err.Error())
+		return err
+	}
+
+	configMap := kc.consumerConfigMap()
+	configMap.SetKey("isolation.level", "read_committed")
+
+	consumer, err := kafka.NewConsumer(configMap)
+
+	if err != nil {
+		kc.Logger.Error(transID, fmt.Sprintf("Consumer.NewConsumer Error: %v", err))
+		return err
+	}
+
+	processor := &transactionProcessor{kc: kc, transID: transID, out: out, producer: producer, onMessage: onMessage}
+
+	// The open transaction is committed before its partitions are assigned to another consumer.
+	err = consumer.SubscribeTopics([]string{in}, func(c *kafka.Consumer, event kafka.Event) error {
+		if e, ok := event.(kafka.RevokedPartitions); ok {
+			kc.Logger.Info(transID, fmt.Sprintf("Revoked partitions %v", e.Partitions))
+
+			if errCommit := processor.commit(c); errCommit != nil {
+				kc.Logger.Error(transID, "Revoked partitions are not committed: "+errCommit.Error())
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		consumer.Close()
+		kc.Logger.Error(transID, fmt.Sprintf("Consumer.SubscribeTopics Error: %v", err))
+		return err
+	}
+
+	retryBackoff := kc.RetryBackoff
+	if retryBackoff <= 0 {
+		retryBackoff = kafkaDefaultRetryBackoff
+	}
+
+	var runErr error
+
+	for runErr == nil && !kc.isStopped(ctx) {
+		event := consumer.Poll(kafkaTransactionPollTimeoutMs)
+
+		switch e := event.(type) {
+		case *kafka.Message:
+			if err = processor.process(e); err != nil {
+				notifyMessage := "Error Kafka Process. App: " + kc.Logger.ApplicationName + ", " +
+					getTopicInfo(e.TopicPartition) + ", Error: " + err.Error()
+
+				kc.postLineNotify(transID, notifyMessage)
+
+				if runErr = processor.abort(consumer); runErr == nil {
+					kc.Logger.Info(transID, "Process Retry Transaction after "+retryBackoff.String())
+					kc.sleep(ctx, retryBackoff)
+				}
+			}
+		case kafka.PartitionEOF:
+			kc.Logger.Info(transID, fmt.Sprintf("Reached the end of a partition. %v", e))
+		case kafka.Error:
+			// librdkafka recovers from the errors which are not fatal, e.g. all brokers down
+			if e.IsFatal() {
+				runErr = e
+			}
+			kc.Logger.Error(transID, fmt.Sprintf("Consumer.Poll Error: %v", e))
+		case nil:
+		default:
+			kc.Logger.Info(transID, fmt.Sprintf("Ignored %v", e))
+		}
+
+		if runErr == nil && processor.isDue(time.Now()) {
+			runErr = processor.commit(consumer)
+		}
+	}
+
+	kc.Logger.Info(transID, "Stopping Process")
+
+	if runErr == nil {
+		runErr = processor.commit(consumer)
+	} else if processor.batch != nil {
+		processor.abort(consumer)
+	}
+
+	if err = kc.closeConsumer(transID, consumer, time.Now().Add(kc.shutdownTimeout())); err != nil && runErr == nil {
+		runErr = err
+	}
+
+	return runErr
+}
@@ -0,0 +1,203 @@
+package kafkautil
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// memoryTransactionProducer records the messages of the open transaction and of the committed transactions.
+type memoryTransactionProducer struct {
+	open      []*kafka.Message
+	committed []*kafka.Message
+	offsets   []kafka.TopicPartition
+	inTxn     bool
+	aborted   int
+	commitErr error
+}
+
+func (m *memoryTransactionProducer) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	m.open = append(m.open, msg)
+	return nil
+}
+
+func (m *memoryTransactionProducer) BeginTransaction() error {
+	if m.inTxn {
+		return errors.New("transaction is already open")
+	}
+
+	m.inTxn = true
+	return nil
+}
+
+func (m *memoryTransactionProducer) SendOffsetsToTransaction(ctx context.Context, offsets []kafka.TopicPartition,
+	consumerMetadata *kafka.ConsumerGroupMetadata) error {
+
+	m.offsets = offsets
+	return nil
+}
+
+func (m *memoryTransactionProducer) CommitTransaction(ctx context.Context) error {
+	if m.commitErr != nil {
+		return m.commitErr
+	}
+
+	m.committed = append(m.committed, m.open...)
+	m.open = nil
+	m.inTxn = false
+	return nil
+}
+
+func (m *memoryTransactionProducer) AbortTransaction(ctx context.Context) error {
+	m.open = nil
+	m.inTxn = false
+	m.aborted++
+	return nil
+}
+
+type memoryTransactionConsumer struct {
+	seekList []kafka.TopicPartition
+}
+
+func (m *memoryTransactionConsumer) GetConsumerGroupMetadata() (*kafka.ConsumerGroupMetadata, error) {
+	return &kafka.ConsumerGroupMetadata{}, nil
+}
+
+func (m *memoryTransactionConsumer) SeekPartitions(partitions []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+	m.seekList = append(m.seekList, partitions...)
+	return partitions, nil
+}
+
+func newTestTransactionProcessor(producer *memoryTransactionProducer) *transactionProcessor {
+	kc := newTestKafkaConfig()
+	kc.TransactionMaxMessages = 3
+
+	return &transactionProcessor{
+		kc:       kc,
+		transID:  "transID",
+		out:      "order-enriched",
+		producer: producer,
+		onMessage: func(transID string, msg *kafka.Message) ([]KafkaMessage, error) {
+			if string(msg.Key) == "fail" {
+				return nil, errors.New("enrich failed")
+			}
+
+			return []KafkaMessage{{Key: string(msg.Key), Value: "enriched"}, {Key: string(msg.Key), Value: "audit"}}, nil
+		},
+	}
+}
+
+func sortPartitionList(partitionList []kafka.TopicPartition) []kafka.TopicPartition {
+	sort.Slice(partitionList, func(i, j int) bool {
+		return partitionList[i].Partition < partitionList[j].Partition
+	})
+
+	return partitionList
+}
+
+func TestTransactionProcessorCommit(t *testing.T) {
+	producer := &memoryTransactionProducer{}
+	consumer := &memoryTransactionConsumer{}
+	processor := newTestTransactionProcessor(producer)
+
+	for _, msg := range []*kafka.Message{
+		newTestMessage("order", 0, 10, "A"),
+		newTestMessage("order", 1, 20, "B"),
+	} {
+		if err := processor.process(msg); err != nil {
+			t.Fatalf("process Error: %v", err)
+		}
+	}
+
+	if processor.isDue(time.Now()) {
+		t.Errorf("transaction of 2 messages should not be due before TransactionInterval")
+	}
+
+	if !processor.isDue(time.Now().Add(kafkaDefaultTransactionInterval)) {
+		t.Errorf("transaction should be due after TransactionInterval")
+	}
+
+	processor.process(newTestMessage("order", 0, 11, "C"))
+
+	if !processor.isDue(time.Now()) {
+		t.Errorf("transaction should be due after TransactionMaxMessages")
+	}
+
+	if err := processor.commit(consumer); err != nil {
+		t.Fatalf("commit Error: %v", err)
+	}
+
+	if len(producer.committed) != 6 || *producer.committed[0].TopicPartition.Topic != "order-enriched" {
+		t.Errorf("expected 6 committed messages to order-enriched, got %d", len(producer.committed))
+	}
+
+	offsets := sortPartitionList(producer.offsets)
+
+	if len(offsets) != 2 || offsets[0].Offset != 12 || offsets[1].Offset != 21 {
+		t.Errorf("expected commit offsets 12 and 21, got %v", offsets)
+	}
+
+	if processor.batch != nil || processor.isDue(time.Now()) {
+		t.Errorf("transaction should be closed after commit")
+	}
+}
+
+func TestTransactionProcessorAbort(t *testing.T) {
+	producer := &memoryTransactionProducer{}
+	consumer := &memoryTransactionConsumer{}
+	processor := newTestTransactionProcessor(producer)
+
+	processor.process(newTestMessage("order", 0, 10, "A"))
+	processor.process(newTestMessage("order", 0, 11, "B"))
+
+	if err := processor.process(newTestMessage("order", 1, 20, "fail")); err == nil {
+		t.Fatalf("process should return the error of onMessage")
+	}
+
+	if err := processor.abort(consumer); err != nil {
+		t.Fatalf("abort Error: %v", err)
+	}
+
+	seekList := sortPartitionList(consumer.seekList)
+
+	if producer.aborted != 1 || len(producer.open) != 0 || len(producer.committed) != 0 {
+		t.Errorf("produced messages should be aborted, got aborted %d, open %d", producer.aborted, len(producer.open))
+	}
+
+	if len(seekList) != 2 || seekList[0].Offset != 10 || seekList[1].Offset != 20 {
+		t.Errorf("expected rewind to offsets 10 and 20, got %v", seekList)
+	}
+
+	if err := processor.process(newTestMessage("order", 0, 10, "A")); err != nil {
+		t.Errorf("new transaction should begin after abort, got %v", err)
+	}
+}
+
+func TestTransactionProcessorCommitFatal(t *testing.T) {
+	fatalErr := kafka.NewError(kafka.ErrFenced, "producer is fenced", true)
+	producer := &memoryTransactionProducer{commitErr: fatalErr}
+	processor := newTestTransactionProcessor(producer)
+
+	processor.process(newTestMessage("order", 0, 10, "A"))
+
+	if err := processor.commit(&memoryTransactionConsumer{}); !errors.Is(err, fatalErr) {
+		t.Errorf("expected fatal commit error, got %v", err)
+	}
+}
+
+func TestProcessRequiresTransactionalID(t *testing.T) {
+	kc := newTestKafkaConfig()
+
+	err := kc.Process(context.Background(), "transID", "order", "order-enriched",
+		func(transID string, msg *kafka.Message) ([]KafkaMessage, error) {
+			return nil, nil
+		})
+
+	if !errors.Is(err, ErrNoTransactionalID) {
+		t.Errorf("expected ErrNoTransactionalID, got %v", err)
+	}
+}
@@ -213,3 +213,17 @@ func TestLoggerLevel(t *testing.T) {
 	logger.Error(transID, "Error Level")
 	logger.Fatal(transID, "Fatal Level")
 }
+
+func TestGetCallerStackTrace(t *testing.T) {
+	stacktrace := GetCallerStackTrace()
+
+	if stacktrace.Function != "crm-util-go/logging.TestGetCallerStackTrace" || stacktrace.Line == 0 {
+		t.Errorf("unexpected caller %+v", stacktrace)
+	}
+
+	stacktrace = GetCallerStackTrace("crm-util-go/logging.")
+
+	if stacktrace.Function != "testing.tRunner" {
+		t.Errorf("expected testing.tRunner but got %+v", stacktrace)
+	}
+}
@@ -94,6 +94,36 @@ func GetStackTrace() (stacktrace StackTrace) {
 	return stacktrace
 }
 
+// GetCallerStackTrace returns the first caller whose function does not start with one of skipFunctionPrefix.
+func GetCallerStackTrace(skipFunctionPrefix ...string) (stacktrace StackTrace) {
+	frames := getCallersFrames(3)
+
+	for {
+		frame, more := frames.Next()
+		if !strings.Contains(frame.File, "runtime/") && !hasAnyPrefix(frame.Function, skipFunctionPrefix) {
+			stacktrace.Function = frame.Function
+			stacktrace.File = frame.File
+			stacktrace.Line = frame.Line
+			break
+		}
+		if !more {
+			break
+		}
+	}
+
+	return stacktrace
+}
+
+func hasAnyPrefix(s string, prefixList []string) bool {
+	for _, prefix := range prefixList {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func getCallerInfoBean(stacktrace StackTrace) (callerInfoBean CallerInfoBean) {
 	runes := []rune(stacktrace.Function)
 	indexFirst := strings.Index(stacktrace.Function, ".")
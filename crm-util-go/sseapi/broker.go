@@ -0,0 +1,202 @@
+package sseapi
+
+import (
+	"context"
+	"crm-util-go/common"
+	"crm-util-go/kafkautil"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+const (
+	kafkaBrokerRestartBackoff    time.Duration = time.Second
+	kafkaBrokerMaxRestartBackoff time.Duration = 30 * time.Second
+)
+
+type BrokerMessage struct {
+	NodeID   string   `json:"nodeID"`
+	StreamID string   `json:"streamID"`
+	Event    SseEvent `json:"event"`
+}
+
+// Broker fans out the published events to every node of the cluster, including the publishing node.
+type Broker interface {
+	Publish(msg BrokerMessage) error
+	Subscribe(onMessage func(msg BrokerMessage)) error
+	Close() error
+}
+
+// MemoryBroker connects the hubs of one process, it is used by a single node or in tests.
+type MemoryBroker struct {
+	mu          sync.RWMutex
+	subscribers []chan BrokerMessage
+	wg          sync.WaitGroup
+	closed      bool
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (b *MemoryBroker) Publish(msg BrokerMessage) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return ErrHubClosed
+	}
+
+	for _, subscriber := range b.subscribers {
+		subscriber <- msg
+	}
+
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(onMessage func(msg BrokerMessage)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrHubClosed
+	}
+
+	subscriber := make(chan BrokerMessage, DefaultSubscriberBufferSize)
+	b.subscribers = append(b.subscribers, subscriber)
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+
+		for msg := range subscriber {
+			onMessage(msg)
+		}
+	}()
+
+	return nil
+}
+
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+
+		for _, subscriber := range b.subscribers {
+			close(subscriber)
+		}
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+
+	return nil
+}
+
+/*
+KafkaBroker publishes BrokerMessage as JSON with the stream ID as key, so the events of a stream keep their order.
+The messages are published by one idempotent producer of the broker, it is closed by Close.
+Every node consumes the whole topic with its own consumer group "<GroupID>-<nodeID>" from the latest offset.
+The consumer is started again when it stops before Close, waiting 1 second doubled on each restart up to 30 seconds.
+*/
+type KafkaBroker struct {
+	kafkaConfig    kafkautil.KafkaConfig
+	producer       *kafkautil.Producer
+	cancel         context.CancelFunc
+	wg             sync.WaitGroup
+	restartBackoff time.Duration
+	consume        func(ctx context.Context, transID string, onMessage func(transID string, msg *kafka.Message) error) error
+}
+
+func NewKafkaBroker(kafkaConfig kafkautil.KafkaConfig, nodeID string) (*KafkaBroker, error) {
+	kafkaConfig.GroupID = kafkaConfig.GroupID + "-" + nodeID
+	kafkaConfig.AutoOffsetReset = "latest"
+
+	producer, err := kafkautil.NewProducer(common.NewUUID(), kafkaConfig, kafkautil.KafkaProducerConfig{Idempotent: true})
+
+	if err != nil {
+		return nil, err
+	}
+
+	broker := &KafkaBroker{kafkaConfig: kafkaConfig, producer: producer, restartBackoff: kafkaBrokerRestartBackoff}
+	broker.consume = broker.kafkaConfig.ConsumerMessage
+
+	return broker, nil
+}
+
+func (b *KafkaBroker) Publish(msg BrokerMessage) error {
+	value, err := json.Marshal(msg)
+
+	if err != nil {
+		return err
+	}
+
+	headers := []kafka.Header{{Key: "nodeID", Value: []byte(msg.NodeID)}}
+	_, err = b.producer.Send(context.Background(), common.NewUUID(), "", msg.StreamID, string(value), headers)
+
+	return err
+}
+
+func (b *KafkaBroker) Subscribe(onMessage func(msg BrokerMessage)) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+
+		handler := func(transID string, kafkaMsg *kafka.Message) error {
+			var msg BrokerMessage
+
+			if err := json.Unmarshal(kafkaMsg.Value, &msg); err != nil {
+				b.kafkaConfig.Logger.Error(transID, "KafkaBroker skip invalid message "+KafkaEventID(kafkaMsg), err)
+				return nil
+			}
+
+			onMessage(msg)
+			return nil
+		}
+
+		backoff := b.restartBackoff
+
+		for {
+			transID := common.NewUUID()
+			err := b.consume(ctx, transID, handler)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			// The hub would stop receiving the events of the other nodes, so the consumer is started again
+			if err != nil {
+				b.kafkaConfig.Logger.Error(transID, "KafkaBroker consumer is stopped, restart after "+backoff.String(), err)
+			} else {
+				b.kafkaConfig.Logger.Error(transID, "KafkaBroker consumer is stopped, restart after "+backoff.String())
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			if backoff *= 2; backoff > kafkaBrokerMaxRestartBackoff {
+				backoff = kafkaBrokerMaxRestartBackoff
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (b *KafkaBroker) Close() error {
+	if b.cancel != nil {
+		b.cancel()
+	}
+
+	b.wg.Wait()
+
+	return b.producer.Close()
+}
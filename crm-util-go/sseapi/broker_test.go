@@ -0,0 +1,178 @@
+package sseapi
+
+import (
+	"context"
+	"crm-util-go/kafkautil"
+	"crm-util-go/logging"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+func newTestClusterHub(t *testing.T, nodeID string, broker Broker) *SSEHub {
+	logger := logging.InitUtilLogger("crm-util-go", logging.CrmUtil)
+	logger.Level = logging.LEVEL_OFF
+
+	hub, err := NewSSEHubWithBroker(SSEHubConfig{NodeID: nodeID, AllowAnonymous: true, Logger: logger}, broker)
+	if err != nil {
+		t.Fatalf("NewSSEHubWithBroker Error: %v", err)
+	}
+
+	return hub
+}
+
+func receiveSseEvent(t *testing.T, sub *sseSubscriber) SseEvent {
+	select {
+	case event := <-sub.events:
+		return event
+	case <-time.After(3 * time.Second):
+		t.Fatalf("receive event timeout")
+	}
+
+	return SseEvent{}
+}
+
+func TestMemoryBrokerFanOut(t *testing.T) {
+	broker := NewMemoryBroker()
+	podA := newTestClusterHub(t, "podA", broker)
+	podB := newTestClusterHub(t, "podB", broker)
+	defer broker.Close()
+
+	podA.CreateStream("m1")
+	podB.CreateStream("m1")
+	subA, _, _ := podA.subscribe("m1", "")
+	subB, _, _ := podB.subscribe("m1", "")
+
+	event, err := podA.Publish("m1", SseEvent{Data: "hello"})
+	if err != nil {
+		t.Fatalf("Publish Error: %v", err)
+	}
+
+	if !strings.HasPrefix(event.ID, "podA-") {
+		t.Errorf("event id expected prefix podA- but got %s", event.ID)
+	}
+
+	if received := receiveSseEvent(t, subA); received.ID != event.ID {
+		t.Errorf("podA expected event %s but got %+v", event.ID, received)
+	}
+
+	if received := receiveSseEvent(t, subB); received.ID != event.ID || received.Data != "hello" {
+		t.Errorf("podB expected event %s but got %+v", event.ID, received)
+	}
+
+	// Publishing to a stream which exists only on the other node is not an error
+	podB.CreateStream("m2")
+	if _, err = podA.Publish("m2", SseEvent{Data: "only podB"}); err != nil {
+		t.Errorf("Publish m2 Error: %v", err)
+	}
+}
+
+func TestBrokerDeduplicateEventID(t *testing.T) {
+	broker := NewMemoryBroker()
+	podA := newTestClusterHub(t, "podA", broker)
+	podB := newTestClusterHub(t, "podB", broker)
+	defer broker.Close()
+
+	podB.CreateStream("m1")
+	subB, _, _ := podB.subscribe("m1", "")
+
+	podA.Publish("m1", SseEvent{ID: "crm.notify-0-10", Data: "first"})
+	podB.Publish("m1", SseEvent{ID: "crm.notify-0-10", Data: "redelivered"})
+	podA.Publish("m1", SseEvent{ID: "crm.notify-0-11", Data: "second"})
+
+	if received := receiveSseEvent(t, subB); received.Data != "first" {
+		t.Errorf("expected first but got %+v", received)
+	}
+
+	if received := receiveSseEvent(t, subB); received.Data != "second" {
+		t.Errorf("expected second but got %+v", received)
+	}
+}
+
+func TestDeduplicateWindow(t *testing.T) {
+	hub := newTestHub(SSEHubConfig{DedupWindowSize: 2})
+	hub.CreateStream("m1")
+
+	hub.Publish("m1", SseEvent{ID: "a"})
+	hub.Publish("m1", SseEvent{ID: "b"})
+	hub.Publish("m1", SseEvent{ID: "a"})
+	hub.Publish("m1", SseEvent{ID: "c"})
+	hub.Publish("m1", SseEvent{ID: "a"})
+
+	if info := hub.StreamInfo(); info[0].Buffered != 4 {
+		t.Errorf("expected 4 buffered events but got %d", info[0].Buffered)
+	}
+}
+
+func TestKafkaBrokerOwnsProducer(t *testing.T) {
+	logger := logging.InitUtilLogger("crm-util-go", logging.CrmUtil)
+	logger.Level = logging.LEVEL_OFF
+
+	// librdkafka connects lazily, so the broker is created and closed without a kafka server.
+	broker, err := NewKafkaBroker(kafkautil.KafkaConfig{
+		BootstrapServers:      "localhost:1",
+		TopicName:             []string{"crm.sse"},
+		SecurityProtocol:      "PLAINTEXT",
+		SaslMechanism:         "PLAIN",
+		KerberosPrincipalName: "kafkaclient",
+		KerberosServiceName:   "kafka",
+		GroupID:               "crm-sse",
+		Logger:                logger,
+	}, "podA")
+
+	if err != nil {
+		t.Fatalf("NewKafkaBroker Error: %v", err)
+	}
+
+	if err = broker.Close(); err != nil {
+		t.Fatalf("Close Error: %v", err)
+	}
+
+	if err = broker.Publish(BrokerMessage{NodeID: "podA", StreamID: "m1"}); !errors.Is(err, kafkautil.ErrProducerClosed) {
+		t.Errorf("Publish after Close expected ErrProducerClosed but got %v", err)
+	}
+}
+
+func TestKafkaBrokerRestartConsumer(t *testing.T) {
+	logger := logging.InitUtilLogger("crm-util-go", logging.CrmUtil)
+	logger.Level = logging.LEVEL_OFF
+
+	var consumeCount int32
+	broker := &KafkaBroker{kafkaConfig: kafkautil.KafkaConfig{Logger: logger}, restartBackoff: time.Millisecond}
+	broker.consume = func(ctx context.Context, transID string, onMessage func(transID string, msg *kafka.Message) error) error {
+		// The first consumer fails, the next one delivers a message and runs until Close
+		if atomic.AddInt32(&consumeCount, 1) == 1 {
+			return errors.New("Local: Fatal error")
+		}
+
+		onMessage(transID, &kafka.Message{Value: []byte(`{"nodeID":"podB","streamID":"m1"}`)})
+		<-ctx.Done()
+
+		return nil
+	}
+
+	received := make(chan BrokerMessage, 1)
+	if err := broker.Subscribe(func(msg BrokerMessage) { received <- msg }); err != nil {
+		t.Fatalf("Subscribe Error: %v", err)
+	}
+
+	select {
+	case msg := <-received:
+		if msg.NodeID != "podB" || msg.StreamID != "m1" {
+			t.Errorf("unexpected message %+v", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected the consumer to be restarted")
+	}
+
+	broker.cancel()
+	broker.wg.Wait()
+
+	if count := atomic.LoadInt32(&consumeCount); count != 2 {
+		t.Errorf("expected 2 consumers but got %d", count)
+	}
+}
@@ -0,0 +1,195 @@
+package sseapi
+
+import (
+	"bytes"
+	"context"
+	"crm-util-go/logging"
+	"crm-util-go/pointer"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+type KafkaSSEBridge struct {
+	config    KafkaSSEBridgeConfig
+	source    KafkaMessageSource
+	publisher SseStreamPublisher
+}
+
+func NewKafkaSSEBridge(source KafkaMessageSource, publisher SseStreamPublisher, config KafkaSSEBridgeConfig) *KafkaSSEBridge {
+	if config.StreamIDSource == "" {
+		config.StreamIDSource = StreamIDFromKey
+	}
+
+	if config.Logger == nil {
+		config.Logger = logging.InitUtilLogger("crm-util-go", logging.CrmUtil)
+	}
+
+	return &KafkaSSEBridge{
+		config:    config,
+		source:    source,
+		publisher: publisher,
+	}
+}
+
+// Start consumes the topics of the source until ctx is cancelled or Stop is called.
+// Ex. go func() { err := bridge.Start(ctx, transID) }()
+func (b *KafkaSSEBridge) Start(ctx context.Context, transID string) error {
+	b.config.Logger.Info(transID, fmt.Sprintf("Starting KafkaSSEBridge StreamIDSource: %s, StreamIDField: %s",
+		b.config.StreamIDSource, b.config.StreamIDField))
+
+	return b.source.ConsumerMessage(ctx, transID, b.OnMessage)
+}
+
+func (b *KafkaSSEBridge) Stop() {
+	b.source.ShutdownConsumer()
+}
+
+// OnMessage publishes the kafka message to the resolved stream.
+// Messages without stream id or with unknown stream are skipped so they do not block the partition.
+func (b *KafkaSSEBridge) OnMessage(transID string, msg *kafka.Message) error {
+	streamID, err := b.ResolveStreamID(msg)
+
+	if err != nil {
+		b.config.Logger.Warn(transID, "KafkaSSEBridge skip message "+KafkaEventID(msg), err)
+		return nil
+	}
+
+	if b.config.AutoCreateStream {
+		if err = b.publisher.CreateStream(streamID); err != nil {
+			return err
+		}
+	}
+
+	event, err := b.publisher.Publish(streamID, b.ToSseEvent(msg))
+
+	if err == ErrStreamNotFound {
+		b.config.Logger.Warn(transID, "KafkaSSEBridge skip message "+KafkaEventID(msg)+", stream not found: "+streamID)
+		return nil
+	}
+
+	if err == ErrInvalidEventField {
+		b.config.Logger.Warn(transID, "KafkaSSEBridge skip message "+KafkaEventID(msg)+", invalid event type", err)
+		return nil
+	}
+
+	if err != nil {
+		b.config.Logger.Error(transID, "KafkaSSEBridge publish stream "+streamID+" error", err)
+		return err
+	}
+
+	b.config.Logger.Info(transID, "KafkaSSEBridge published. stream="+streamID+", eventID="+event.ID+
+		", event="+event.Event)
+
+	return nil
+}
+
+func (b *KafkaSSEBridge) ResolveStreamID(msg *kafka.Message) (string, error) {
+	var streamID string
+
+	switch b.config.StreamIDSource {
+	case StreamIDFromKey:
+		streamID = string(msg.Key)
+	case StreamIDFromHeader:
+		streamID = getKafkaHeader(msg.Headers, b.config.StreamIDField)
+	case StreamIDFromJSONPath:
+		value, err := GetJSONPathValue(msg.Value, b.config.StreamIDField)
+		if err != nil {
+			return "", err
+		}
+		streamID = value
+	default:
+		return "", fmt.Errorf("unknown StreamIDSource: %s", b.config.StreamIDSource)
+	}
+
+	if streamID == "" {
+		return "", ErrStreamIDNotResolved
+	}
+
+	return streamID, nil
+}
+
+// ToSseEvent uses "<topic>-<partition>-<offset>" as event id and the event type header or topic name as event type.
+func (b *KafkaSSEBridge) ToSseEvent(msg *kafka.Message) SseEvent {
+	eventType := ""
+
+	if b.config.EventTypeHeader != "" {
+		eventType = getKafkaHeader(msg.Headers, b.config.EventTypeHeader)
+	}
+
+	if eventType == "" {
+		eventType = pointer.GetStringValue(msg.TopicPartition.Topic)
+	}
+
+	return SseEvent{
+		ID:    KafkaEventID(msg),
+		Event: eventType,
+		Data:  string(msg.Value),
+	}
+}
+
+func KafkaEventID(msg *kafka.Message) string {
+	tp := msg.TopicPartition
+	return pointer.GetStringValue(tp.Topic) + "-" + strconv.FormatInt(int64(tp.Partition), 10) + "-" +
+		strconv.FormatInt(int64(tp.Offset), 10)
+}
+
+func getKafkaHeader(headers []kafka.Header, key string) string {
+	for _, header := range headers {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+
+	return ""
+}
+
+// GetJSONPathValue returns the value of a dot separated path, array elements are selected by index.
+// Ex. GetJSONPathValue(`{"customer":{"ids":["c1","c2"]}}`, "customer.ids.1") returns "c2"
+func GetJSONPathValue(data []byte, path string) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var node interface{}
+	if err := decoder.Decode(&node); err != nil {
+		return "", err
+	}
+
+	if path != "" {
+		for _, field := range strings.Split(path, ".") {
+			switch v := node.(type) {
+			case map[string]interface{}:
+				child, found := v[field]
+				if !found {
+					return "", fmt.Errorf("json path %s: field %s not found", path, field)
+				}
+				node = child
+			case []interface{}:
+				index, err := strconv.Atoi(field)
+				if err != nil || index < 0 || index >= len(v) {
+					return "", fmt.Errorf("json path %s: invalid index %s", path, field)
+				}
+				node = v[index]
+			default:
+				return "", fmt.Errorf("json path %s: field %s not found", path, field)
+			}
+		}
+	}
+
+	switch v := node.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		str, err := json.Marshal(v)
+		return string(str), err
+	}
+}
@@ -0,0 +1,48 @@
+package sseapi
+
+import (
+	"context"
+	"crm-util-go/logging"
+	"errors"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+var ErrStreamIDNotResolved = errors.New("stream id not resolved from kafka message")
+
+type StreamIDSource string
+
+const (
+	StreamIDFromHeader   = StreamIDSource("header")
+	StreamIDFromKey      = StreamIDSource("key")
+	StreamIDFromJSONPath = StreamIDSource("jsonPath")
+)
+
+// KafkaMessageSource is implemented by *kafkautil.KafkaConfig.
+type KafkaMessageSource interface {
+	ConsumerMessage(ctx context.Context, transID string, onMessage func(transID string, msg *kafka.Message) error) error
+	ShutdownConsumer()
+}
+
+// SseStreamPublisher is implemented by *SSEHub.
+type SseStreamPublisher interface {
+	CreateStream(streamID string) error
+	Publish(streamID string, event SseEvent) (SseEvent, error)
+}
+
+/*
+KafkaSSEBridgeConfig
+
+StreamIDSource: header, key or jsonPath
+StreamIDField: the header name for header, or a dot separated path into the JSON value for jsonPath
+Example: "customer.id", "items.0.streamID"
+EventTypeHeader: the header used as SSE event type, the topic name is used when it is empty or not found.
+AutoCreateStream: create the stream on the hub when it does not exist, otherwise the message is skipped.
+*/
+type KafkaSSEBridgeConfig struct {
+	StreamIDSource   StreamIDSource
+	StreamIDField    string
+	EventTypeHeader  string
+	AutoCreateStream bool
+	Logger           *logging.PatternLogger
+}
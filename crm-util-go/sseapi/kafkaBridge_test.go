@@ -0,0 +1,144 @@
+package sseapi
+
+import (
+	"context"
+	"crm-util-go/common"
+	"crm-util-go/kafkautil"
+	"crm-util-go/logging"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+var _ KafkaMessageSource = &kafkautil.KafkaConfig{}
+
+// memoryKafkaSource is an in-memory stand-in of kafkautil.KafkaConfig
+type memoryKafkaSource struct {
+	messages   []*kafka.Message
+	errList    []error
+	isShutdown bool
+}
+
+func (m *memoryKafkaSource) ConsumerMessage(ctx context.Context, transID string,
+	onMessage func(transID string, msg *kafka.Message) error) error {
+
+	for _, msg := range m.messages {
+		if m.isShutdown || ctx.Err() != nil {
+			break
+		}
+
+		if err := onMessage(common.NewUUID(), msg); err != nil {
+			m.errList = append(m.errList, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryKafkaSource) ShutdownConsumer() {
+	m.isShutdown = true
+}
+
+func newTestKafkaMessage(topic string, offset int64, key string, value string, headers ...kafka.Header) *kafka.Message {
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 2, Offset: kafka.Offset(offset)},
+		Key:            []byte(key),
+		Value:          []byte(value),
+		Headers:        headers,
+	}
+}
+
+func runTestBridge(t *testing.T, hub *SSEHub, config KafkaSSEBridgeConfig, messages ...*kafka.Message) *memoryKafkaSource {
+	source := &memoryKafkaSource{messages: messages}
+	config.Logger = logging.InitUtilLogger("crm-util-go", logging.CrmUtil)
+	config.Logger.Level = logging.LEVEL_OFF
+
+	if err := NewKafkaSSEBridge(source, hub, config).Start(context.Background(), common.NewUUID()); err != nil {
+		t.Fatalf("Start Error: %v", err)
+	}
+
+	if len(source.errList) > 0 {
+		t.Errorf("onMessage Error: %v", source.errList)
+	}
+
+	return source
+}
+
+func TestKafkaSSEBridgeStreamIDFromKey(t *testing.T) {
+	hub := newTestHub(SSEHubConfig{})
+	hub.CreateStream("m1")
+	sub, _, _ := hub.subscribe("m1", "")
+	defer hub.unsubscribe(sub)
+
+	runTestBridge(t, hub, KafkaSSEBridgeConfig{StreamIDSource: StreamIDFromKey},
+		newTestKafkaMessage("crm.notify", 10, "m1", `{"msg":"hello"}`),
+		newTestKafkaMessage("crm.notify", 11, "unknown", `{"msg":"skip"}`))
+
+	if len(sub.events) != 1 {
+		t.Fatalf("expected 1 event but got %d", len(sub.events))
+	}
+
+	event := <-sub.events
+	if event.ID != "crm.notify-2-10" || event.Event != "crm.notify" || event.Data != `{"msg":"hello"}` {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
+
+func TestKafkaSSEBridgeStreamIDFromHeader(t *testing.T) {
+	hub := newTestHub(SSEHubConfig{})
+
+	runTestBridge(t, hub, KafkaSSEBridgeConfig{
+		StreamIDSource:   StreamIDFromHeader,
+		StreamIDField:    "streamID",
+		EventTypeHeader:  "eventType",
+		AutoCreateStream: true,
+	}, newTestKafkaMessage("crm.notify", 5, "k1", "data",
+		kafka.Header{Key: "streamID", Value: []byte("agent-01")},
+		kafka.Header{Key: "eventType", Value: []byte("caseAssigned")}))
+
+	infoList := hub.StreamInfo()
+	if len(infoList) != 1 || infoList[0].StreamID != "agent-01" || infoList[0].LastEventID != "crm.notify-2-5" {
+		t.Errorf("unexpected stream info %+v", infoList)
+	}
+
+	_, replayList, _ := hub.subscribe("agent-01", "unknown")
+	if len(replayList) != 1 || replayList[0].Event != "caseAssigned" {
+		t.Errorf("unexpected replay %+v", replayList)
+	}
+}
+
+func TestKafkaSSEBridgeStreamIDFromJSONPath(t *testing.T) {
+	hub := newTestHub(SSEHubConfig{})
+	hub.CreateStream("1001")
+	sub, _, _ := hub.subscribe("1001", "")
+
+	runTestBridge(t, hub, KafkaSSEBridgeConfig{StreamIDSource: StreamIDFromJSONPath, StreamIDField: "customer.ids.1"},
+		newTestKafkaMessage("crm.case", 1, "", `{"customer":{"ids":[1000,1001]}}`),
+		newTestKafkaMessage("crm.case", 2, "", `not json`))
+
+	if len(sub.events) != 1 {
+		t.Errorf("expected 1 event but got %d", len(sub.events))
+	}
+}
+
+func TestGetJSONPathValue(t *testing.T) {
+	data := []byte(`{"a":{"b":[{"c":"x"},{"c":true}],"n":12345678901234}}`)
+
+	testCases := map[string]string{
+		"a.b.0.c": "x",
+		"a.b.1.c": "true",
+		"a.n":     "12345678901234",
+		"a.b.0":   `{"c":"x"}`,
+	}
+
+	for path, expected := range testCases {
+		value, err := GetJSONPathValue(data, path)
+		if err != nil || value != expected {
+			t.Errorf("GetJSONPathValue(%s) expected %s but got %s, %v", path, expected, value, err)
+		}
+	}
+
+	if _, err := GetJSONPathValue(data, "a.b.5.c"); err == nil {
+		t.Errorf("GetJSONPathValue expected index error")
+	}
+}
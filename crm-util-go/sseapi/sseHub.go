@@ -0,0 +1,669 @@
+package sseapi
+
+import (
+	"crm-util-go/common"
+	"crm-util-go/cryptography"
+	"crm-util-go/logging"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type sseSubscriber struct {
+	streamID  string
+	events    chan SseEvent
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+func (sub *sseSubscriber) close(err error) {
+	sub.closeOnce.Do(func() {
+		sub.err = err
+		close(sub.done)
+	})
+}
+
+type sseStream struct {
+	id          string
+	mu          sync.Mutex
+	seq         uint64
+	buffer      []SseEvent
+	seenIDs     map[string]struct{}
+	seenQueue   []string
+	subscribers map[*sseSubscriber]struct{}
+}
+
+type SSEHub struct {
+	config  SSEHubConfig
+	broker  Broker
+	seq     uint64
+	mu      sync.RWMutex
+	streams map[string]*sseStream
+	closed  bool
+}
+
+func NewSSEHub(config SSEHubConfig) *SSEHub {
+	if config.ReplayBufferSize <= 0 {
+		config.ReplayBufferSize = DefaultReplayBufferSize
+	}
+
+	if config.SubscriberBufferSize <= 0 {
+		config.SubscriberBufferSize = DefaultSubscriberBufferSize
+	}
+
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+
+	if config.MaxSubscribersPerStream <= 0 {
+		config.MaxSubscribersPerStream = DefaultMaxSubscribersByStream
+	}
+
+	if config.WsWriteTimeout <= 0 {
+		config.WsWriteTimeout = DefaultWsWriteTimeout
+	}
+
+	if config.DedupWindowSize <= 0 {
+		config.DedupWindowSize = DefaultDedupWindowSize
+	}
+
+	if config.NodeID == "" {
+		config.NodeID = common.NewUUID()
+	}
+
+	if config.Logger == nil {
+		config.Logger = logging.InitUtilLogger("crm-util-go", logging.CrmUtil)
+	}
+
+	if config.Authorize == nil {
+		config.Authorize = AuthorizeByAudience
+	}
+
+	if config.JwtSecret == "" && config.AllowAnonymous {
+		config.Logger.Warn("", "SSEHub AllowAnonymous is set, subscribe and publish are not authorized")
+	} else if config.JwtSecret == "" {
+		config.Logger.Error("", "SSEHub JwtSecret is empty, every subscribe and publish is rejected")
+	}
+
+	return &SSEHub{
+		config:  config,
+		streams: make(map[string]*sseStream),
+	}
+}
+
+// NewSSEHubWithBroker creates a hub of a cluster, events published on any node are delivered to the
+// subscribers of every node through the broker.
+func NewSSEHubWithBroker(config SSEHubConfig, broker Broker) (*SSEHub, error) {
+	h := NewSSEHub(config)
+
+	if err := broker.Subscribe(h.onBrokerMessage); err != nil {
+		h.config.Logger.Error("", "SSEHub broker subscribe error", err)
+		return nil, err
+	}
+
+	h.broker = broker
+	h.config.Logger.Info("", "SSEHub joined broker. node="+h.config.NodeID)
+
+	return h, nil
+}
+
+// AuthorizeByAudience allows the action when the JWT audience contains "<action>:<streamID>" or "<action>:*".
+func AuthorizeByAudience(claims cryptography.JwtClaims, streamID string, action SseAction) bool {
+	grants := strings.FieldsFunc(claims.Audience, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '[' || r == ']'
+	})
+
+	for _, grant := range grants {
+		if grant == string(action)+":"+streamID || grant == string(action)+":*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *SSEHub) CreateStream(streamID string) error {
+	if streamID == "" {
+		return ErrInvalidStreamID
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return ErrHubClosed
+	}
+
+	if h.streams[streamID] == nil {
+		h.streams[streamID] = &sseStream{
+			id:          streamID,
+			seenIDs:     make(map[string]struct{}),
+			subscribers: make(map[*sseSubscriber]struct{}),
+		}
+	}
+
+	return nil
+}
+
+func (h *SSEHub) RemoveStream(streamID string) {
+	h.mu.Lock()
+	stream := h.streams[streamID]
+	delete(h.streams, streamID)
+	h.mu.Unlock()
+
+	if stream != nil {
+		stream.closeSubscribers(ErrStreamNotFound)
+	}
+}
+
+func (h *SSEHub) StreamExists(streamID string) bool {
+	return h.getStream(streamID) != nil
+}
+
+func (h *SSEHub) StreamInfo() []SseStreamInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	infoList := make([]SseStreamInfo, 0, len(h.streams))
+
+	for _, stream := range h.streams {
+		stream.mu.Lock()
+		info := SseStreamInfo{
+			StreamID:    stream.id,
+			Subscribers: len(stream.subscribers),
+			Buffered:    len(stream.buffer),
+		}
+
+		if len(stream.buffer) > 0 {
+			info.LastEventID = stream.buffer[len(stream.buffer)-1].ID
+		}
+		stream.mu.Unlock()
+
+		infoList = append(infoList, info)
+	}
+
+	return infoList
+}
+
+func (h *SSEHub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	streams := h.streams
+	h.streams = make(map[string]*sseStream)
+	h.mu.Unlock()
+
+	for _, stream := range streams {
+		stream.closeSubscribers(ErrHubClosed)
+	}
+
+	if h.broker != nil {
+		if err := h.broker.Close(); err != nil {
+			h.config.Logger.Error("", "SSEHub broker close error", err)
+		}
+	}
+}
+
+func (h *SSEHub) getStream(streamID string) *sseStream {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.streams[streamID]
+}
+
+// Publish stores the event in the replay buffer of the stream and sends it to every subscriber.
+// The event ID is generated from a sequence of the stream when it is empty.
+// With a broker the event is published to the broker and every node delivers it to its local subscribers.
+func (h *SSEHub) Publish(streamID string, event SseEvent) (SseEvent, error) {
+	if err := validateEvent(event); err != nil {
+		return event, err
+	}
+
+	if h.broker == nil {
+		return h.deliver(streamID, event)
+	}
+
+	if streamID == "" {
+		return event, ErrInvalidStreamID
+	}
+
+	if event.ID == "" {
+		event.ID = h.config.NodeID + "-" + strconv.FormatUint(atomic.AddUint64(&h.seq, 1), 10)
+	}
+
+	err := h.broker.Publish(BrokerMessage{NodeID: h.config.NodeID, StreamID: streamID, Event: event})
+
+	return event, err
+}
+
+// validateEvent rejects an ID or Event with a line break, it would inject fields or events into the event stream.
+func validateEvent(event SseEvent) error {
+	if strings.ContainsAny(event.ID, "\r\n") || strings.ContainsAny(event.Event, "\r\n") {
+		return ErrInvalidEventField
+	}
+
+	return nil
+}
+
+func (h *SSEHub) onBrokerMessage(msg BrokerMessage) {
+	_, err := h.deliver(msg.StreamID, msg.Event)
+
+	if err != nil && err != ErrStreamNotFound {
+		h.config.Logger.Error("", "SSEHub deliver broker message error. stream="+msg.StreamID+
+			", eventID="+msg.Event.ID+", node="+msg.NodeID, err)
+	}
+}
+
+// deliver sends the event to the local subscribers, an event ID already delivered to the stream is skipped.
+func (h *SSEHub) deliver(streamID string, event SseEvent) (SseEvent, error) {
+	stream := h.getStream(streamID)
+
+	if stream == nil {
+		return event, ErrStreamNotFound
+	}
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	stream.seq++
+	if event.ID == "" {
+		event.ID = strconv.FormatUint(stream.seq, 10)
+	}
+
+	if _, found := stream.seenIDs[event.ID]; found {
+		h.config.Logger.Debug("", "SSEHub skip duplicate event. stream="+streamID+", eventID="+event.ID)
+		return event, nil
+	}
+
+	stream.seenIDs[event.ID] = struct{}{}
+	stream.seenQueue = append(stream.seenQueue, event.ID)
+	if len(stream.seenQueue) > h.config.DedupWindowSize {
+		delete(stream.seenIDs, stream.seenQueue[0])
+		stream.seenQueue = stream.seenQueue[1:]
+	}
+
+	stream.buffer = append(stream.buffer, event)
+	if len(stream.buffer) > h.config.ReplayBufferSize {
+		stream.buffer = stream.buffer[len(stream.buffer)-h.config.ReplayBufferSize:]
+	}
+
+	for sub := range stream.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			// The subscriber reconnects with Last-Event-ID and receives the missing events from the buffer.
+			delete(stream.subscribers, sub)
+			sub.close(ErrSubscriberTooSlow)
+		}
+	}
+
+	return event, nil
+}
+
+// subscribe registers a subscriber and returns the buffered events after lastEventID.
+// When lastEventID is not in the buffer anymore the whole buffer is replayed.
+func (h *SSEHub) subscribe(streamID string, lastEventID string) (*sseSubscriber, []SseEvent, error) {
+	stream := h.getStream(streamID)
+
+	if stream == nil {
+		if !h.config.AutoCreateStream {
+			return nil, nil, ErrStreamNotFound
+		}
+
+		if err := h.CreateStream(streamID); err != nil {
+			return nil, nil, err
+		}
+
+		stream = h.getStream(streamID)
+		if stream == nil {
+			return nil, nil, ErrStreamNotFound
+		}
+	}
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	if len(stream.subscribers) >= h.config.MaxSubscribersPerStream {
+		return nil, nil, ErrStreamFull
+	}
+
+	var replayList []SseEvent
+
+	if lastEventID != "" {
+		replayList = stream.buffer
+
+		for i := len(stream.buffer) - 1; i >= 0; i-- {
+			if stream.buffer[i].ID == lastEventID {
+				replayList = stream.buffer[i+1:]
+				break
+			}
+		}
+
+		replayList = append([]SseEvent(nil), replayList...)
+	}
+
+	sub := &sseSubscriber{
+		streamID: streamID,
+		events:   make(chan SseEvent, h.config.SubscriberBufferSize),
+		done:     make(chan struct{}),
+	}
+	stream.subscribers[sub] = struct{}{}
+
+	return sub, replayList, nil
+}
+
+func (h *SSEHub) unsubscribe(sub *sseSubscriber) {
+	if stream := h.getStream(sub.streamID); stream != nil {
+		stream.mu.Lock()
+		delete(stream.subscribers, sub)
+		stream.mu.Unlock()
+	}
+
+	sub.close(nil)
+}
+
+func (stream *sseStream) closeSubscribers(err error) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	for sub := range stream.subscribers {
+		delete(stream.subscribers, sub)
+		sub.close(err)
+	}
+}
+
+func getBearerToken(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	}
+
+	return r.URL.Query().Get("token")
+}
+
+// Authenticate verifies the bearer token of the request and checks the grant of the action on the stream.
+func (h *SSEHub) Authenticate(r *http.Request, streamID string, action SseAction) (cryptography.JwtClaims, error) {
+	claims, err := h.verifyToken(r)
+
+	if err != nil {
+		return claims, err
+	}
+
+	return claims, h.authorize(claims, streamID, action)
+}
+
+func (h *SSEHub) verifyToken(r *http.Request) (cryptography.JwtClaims, error) {
+	var claims cryptography.JwtClaims
+
+	if h.config.JwtSecret == "" {
+		if h.config.AllowAnonymous {
+			return claims, nil
+		}
+
+		return claims, ErrUnauthorized
+	}
+
+	token := getBearerToken(r)
+
+	if token == "" {
+		return claims, ErrMissingBearerToken
+	}
+
+	return cryptography.VerifyJWToken(h.config.JwtSecret, token)
+}
+
+func (h *SSEHub) authorize(claims cryptography.JwtClaims, streamID string, action SseAction) error {
+	if h.config.JwtSecret == "" {
+		if h.config.AllowAnonymous {
+			return nil
+		}
+
+		return ErrUnauthorized
+	}
+
+	if !h.config.Authorize(claims, streamID, action) {
+		return ErrUnauthorized
+	}
+
+	return nil
+}
+
+// allowOrigin returns the value of Access-Control-Allow-Origin or empty when the origin is not allowed.
+func (h *SSEHub) allowOrigin(origin string) string {
+	for _, allow := range h.config.AllowOrigins {
+		if allow == "*" && !h.config.AllowCredentials {
+			return "*"
+		}
+
+		if allow == "*" || strings.EqualFold(allow, origin) {
+			return origin
+		}
+	}
+
+	return ""
+}
+
+// setCorsHeader sets the CORS response headers and returns false when the origin is not allowed.
+func (h *SSEHub) setCorsHeader(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+
+	if origin == "" || len(h.config.AllowOrigins) == 0 {
+		return true
+	}
+
+	allowOrigin := h.allowOrigin(origin)
+
+	if allowOrigin == "" {
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+	w.Header().Add("Vary", "Origin")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Last-Event-ID")
+
+	if h.config.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	return true
+}
+
+func writeSseEvent(w http.ResponseWriter, event SseEvent) error {
+	var eventBuilder strings.Builder
+
+	if event.ID != "" {
+		eventBuilder.WriteString("id: " + event.ID + "\n")
+	}
+
+	if event.Event != "" {
+		eventBuilder.WriteString("event: " + event.Event + "\n")
+	}
+
+	if event.Retry > 0 {
+		eventBuilder.WriteString("retry: " + strconv.Itoa(event.Retry) + "\n")
+	}
+
+	// CR and CRLF are line breaks of the event stream too.
+	data := strings.ReplaceAll(strings.ReplaceAll(event.Data, "\r\n", "\n"), "\r", "\n")
+
+	for _, line := range strings.Split(data, "\n") {
+		eventBuilder.WriteString("data: " + line + "\n")
+	}
+
+	eventBuilder.WriteString("\n")
+
+	_, err := fmt.Fprint(w, eventBuilder.String())
+	return err
+}
+
+func writeSseResponse(w http.ResponseWriter, httpStatus int, code string, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(SseResponse{Code: code, Msg: msg})
+}
+
+// ServeEvents handles GET /events?stream=<streamID>
+func (h *SSEHub) ServeEvents(w http.ResponseWriter, r *http.Request) {
+	transID := common.NewUUID()
+
+	if !h.setCorsHeader(w, r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	streamID := r.URL.Query().Get("stream")
+	if streamID == "" {
+		http.Error(w, "Please specify a stream", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := h.Authenticate(r, streamID, SseActionSubscribe)
+	if err != nil {
+		h.config.Logger.Warn(transID, "SSEHub subscribe unauthorized. stream="+streamID, err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+
+	sub, replayList, err := h.subscribe(streamID, lastEventID)
+	switch err {
+	case nil:
+	case ErrStreamNotFound:
+		http.Error(w, "Stream not found", http.StatusNotFound)
+		return
+	case ErrStreamFull:
+		h.config.Logger.Warn(transID, "SSEHub max subscribers reached. stream="+streamID)
+		http.Error(w, "Too many subscribers", http.StatusTooManyRequests)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer h.unsubscribe(sub)
+
+	h.config.Logger.Info(transID, "SSEHub client connected. stream="+streamID+", userID="+claims.UserID+
+		", lastEventID="+lastEventID+", replay="+strconv.Itoa(len(replayList)))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range replayList {
+		if err = writeSseEvent(w, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(h.config.HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			h.config.Logger.Info(transID, "SSEHub client disconnected. stream="+streamID)
+			return
+		case <-sub.done:
+			if sub.err != nil {
+				h.config.Logger.Warn(transID, "SSEHub subscriber closed. stream="+streamID, sub.err)
+			}
+			return
+		case event := <-sub.events:
+			if err = writeSseEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err = fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// ServeNotifyMsg handles POST /notifyMsg with SseNotifyMsg body.
+func (h *SSEHub) ServeNotifyMsg(w http.ResponseWriter, r *http.Request) {
+	transID := common.NewUUID()
+
+	if !h.setCorsHeader(w, r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var msg SseNotifyMsg
+	err := json.NewDecoder(r.Body).Decode(&msg)
+
+	if err != nil {
+		h.config.Logger.Error(transID, "SSEHub decode http body error", err)
+		writeSseResponse(w, http.StatusBadRequest, RespCodeInvalidRequest, "Decode http body error")
+		return
+	}
+
+	_, err = h.Authenticate(r, msg.StreamID, SseActionPublish)
+	if err != nil {
+		h.config.Logger.Warn(transID, "SSEHub publish unauthorized. stream="+msg.StreamID, err)
+		writeSseResponse(w, http.StatusUnauthorized, RespCodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	event, err := h.Publish(msg.StreamID, SseEvent{ID: msg.ID, Event: msg.Event, Data: msg.Data})
+	if err == ErrInvalidEventField {
+		writeSseResponse(w, http.StatusBadRequest, RespCodeInvalidRequest, err.Error())
+		return
+	} else if err == ErrStreamNotFound || err == ErrInvalidStreamID {
+		writeSseResponse(w, http.StatusOK, RespCodeNotFound, "StreamID "+msg.StreamID+" not found")
+		return
+	} else if err != nil {
+		h.config.Logger.Error(transID, "SSEHub publish error. stream="+msg.StreamID, err)
+		writeSseResponse(w, http.StatusInternalServerError, RespCodeSystemError, err.Error())
+		return
+	}
+
+	h.config.Logger.Info(transID, "SSEHub published. stream="+msg.StreamID+", eventID="+event.ID)
+	writeSseResponse(w, http.StatusOK, RespCodeSuccess, "Success")
+}
+
+func (h *SSEHub) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", h.ServeEvents)
+	mux.HandleFunc("/notifyMsg", h.ServeNotifyMsg)
+	mux.Handle("/ws", h.WebSocketHandler())
+	return mux
+}
+
+func (h *SSEHub) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, h.Handler())
+}
@@ -0,0 +1,92 @@
+package sseapi
+
+import (
+	"crm-util-go/cryptography"
+	"crm-util-go/logging"
+	"errors"
+	"time"
+)
+
+const (
+	DefaultReplayBufferSize       int           = 100
+	DefaultSubscriberBufferSize   int           = 64
+	DefaultHeartbeatInterval      time.Duration = 15 * time.Second
+	DefaultMaxSubscribersByStream int           = 1000
+	DefaultWsWriteTimeout         time.Duration = 10 * time.Second
+	DefaultDedupWindowSize        int           = 1000
+
+	RespCodeSuccess        string = "0"
+	RespCodeNotFound       string = "1"
+	RespCodeUnauthorized   string = "2"
+	RespCodeInvalidRequest string = "3"
+	RespCodeSystemError    string = "9"
+)
+
+var (
+	ErrStreamNotFound     = errors.New("stream not found")
+	ErrStreamFull         = errors.New("stream reached max subscribers")
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrInvalidStreamID    = errors.New("invalid stream id")
+	ErrSubscriberTooSlow  = errors.New("subscriber too slow")
+	ErrHubClosed          = errors.New("sse hub closed")
+	ErrMissingBearerToken = errors.New("missing bearer token")
+	ErrInvalidEventField  = errors.New("event id and event must not contain CR or LF")
+)
+
+type SseAction string
+
+const (
+	SseActionSubscribe = SseAction("subscribe")
+	SseActionPublish   = SseAction("publish")
+)
+
+/*
+SSEHubConfig
+
+JwtSecret: HMAC secret used to verify the bearer token of subscribers and publishers.
+The token is read from "Authorization: Bearer <token>" or the "token" query parameter
+because a browser EventSource or WebSocket can not set request headers.
+
+Without JwtSecret every subscribe and publish is rejected, unless AllowAnonymous is set explicitly
+to run the hub without authentication, e.g. behind a gateway which authenticates the clients.
+
+By default the JWT audience ("aud") holds space separated grants, for example
+"subscribe:m1 subscribe:m2 publish:m1" or "subscribe:*". Set Authorize to replace this rule.
+
+HeartbeatInterval is also the ping interval of WebSocket connections, a connection without any
+message from the client within two intervals is closed.
+
+NodeID: prefix of the event IDs generated on this node when the hub has a broker, default is a random UUID.
+DedupWindowSize: number of recent event IDs per stream used to skip duplicate deliveries.
+*/
+type SSEHubConfig struct {
+	JwtSecret               string
+	AllowAnonymous          bool
+	Authorize               func(claims cryptography.JwtClaims, streamID string, action SseAction) bool
+	ReplayBufferSize        int
+	SubscriberBufferSize    int
+	HeartbeatInterval       time.Duration
+	MaxSubscribersPerStream int
+	AllowOrigins            []string
+	AllowCredentials        bool
+	AutoCreateStream        bool
+	WsWriteTimeout          time.Duration
+	OnWsAck                 func(claims cryptography.JwtClaims, streamID string, eventID string)
+	NodeID                  string
+	DedupWindowSize         int
+	Logger                  *logging.PatternLogger
+}
+
+type SseEvent struct {
+	ID    string `json:"id,omitempty"`
+	Event string `json:"event,omitempty"`
+	Data  string `json:"data,omitempty"`
+	Retry int    `json:"retry,omitempty"`
+}
+
+type SseStreamInfo struct {
+	StreamID    string `json:"streamID"`
+	Subscribers int    `json:"subscribers"`
+	Buffered    int    `json:"buffered"`
+	LastEventID string `json:"lastEventID,omitempty"`
+}
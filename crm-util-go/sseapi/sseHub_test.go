@@ -0,0 +1,287 @@
+package sseapi
+
+import (
+	"bufio"
+	"crm-util-go/cryptography"
+	"crm-util-go/logging"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testJwtSecret = "sse-secret"
+
+func newTestHub(config SSEHubConfig) *SSEHub {
+	config.Logger = logging.InitUtilLogger("crm-util-go", logging.CrmUtil)
+	config.Logger.Level = logging.LEVEL_OFF
+
+	if config.JwtSecret == "" {
+		config.AllowAnonymous = true
+	}
+
+	return NewSSEHub(config)
+}
+
+func createTestToken(t *testing.T, audience string) string {
+	var jwtClaims cryptography.JwtClaims
+	jwtClaims.UserID = "12345"
+	jwtClaims.Audience = audience
+	jwtClaims.ExpirationTime = time.Now().Add(time.Minute)
+
+	token, err := cryptography.CreateJWTokenHS256(testJwtSecret, jwtClaims)
+	if err != nil {
+		t.Fatalf("CreateJWTokenHS256 Error: %v", err)
+	}
+
+	return token
+}
+
+func readSseLines(t *testing.T, reader *bufio.Reader, prefix string, count int) []string {
+	var lines []string
+	deadline := time.Now().Add(3 * time.Second)
+
+	for len(lines) < count && time.Now().Before(deadline) {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event stream error: %v", err)
+		}
+
+		if strings.HasPrefix(line, prefix) {
+			lines = append(lines, strings.TrimSpace(strings.TrimPrefix(line, prefix)))
+		}
+	}
+
+	return lines
+}
+
+func TestSSEHubReplayLastEventID(t *testing.T) {
+	hub := newTestHub(SSEHubConfig{ReplayBufferSize: 3})
+	defer hub.Close()
+	hub.CreateStream("m1")
+
+	for _, data := range []string{"a", "b", "c", "d"} {
+		if _, err := hub.Publish("m1", SseEvent{Data: data}); err != nil {
+			t.Fatalf("Publish Error: %v", err)
+		}
+	}
+
+	server := httptest.NewServer(hub.Handler())
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/events?stream=m1", nil)
+	req.Header.Set("Last-Event-ID", "2")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events Error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	replay := readSseLines(t, reader, "data: ", 2)
+	if strings.Join(replay, ",") != "c,d" {
+		t.Errorf("replay expected c,d but got %v", replay)
+	}
+
+	hub.Publish("m1", SseEvent{Event: "notify", Data: "e"})
+	live := readSseLines(t, reader, "id: ", 1)
+	if len(live) != 1 || live[0] != "5" {
+		t.Errorf("live event id expected 5 but got %v", live)
+	}
+}
+
+func TestSSEHubUnknownLastEventIDReplaysBuffer(t *testing.T) {
+	hub := newTestHub(SSEHubConfig{ReplayBufferSize: 2})
+	hub.CreateStream("m1")
+	hub.Publish("m1", SseEvent{Data: "a"})
+	hub.Publish("m1", SseEvent{Data: "b"})
+	hub.Publish("m1", SseEvent{Data: "c"})
+
+	sub, replayList, err := hub.subscribe("m1", "1")
+	if err != nil {
+		t.Fatalf("subscribe Error: %v", err)
+	}
+	defer hub.unsubscribe(sub)
+
+	if len(replayList) != 2 || replayList[0].Data != "b" {
+		t.Errorf("replay expected [b c] but got %v", replayList)
+	}
+}
+
+func TestSSEHubAuthorization(t *testing.T) {
+	hub := newTestHub(SSEHubConfig{JwtSecret: testJwtSecret})
+	defer hub.Close()
+	hub.CreateStream("m1")
+
+	server := httptest.NewServer(hub.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events?stream=m1")
+	if err != nil {
+		t.Fatalf("GET /events Error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("subscribe without token expected 401 but got %d", resp.StatusCode)
+	}
+
+	token := createTestToken(t, "subscribe:m2")
+	resp, _ = http.Get(server.URL + "/events?stream=m1&token=" + token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("subscribe other stream expected 401 but got %d", resp.StatusCode)
+	}
+
+	body := `{"streamID":"m1","data":"hello"}`
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/notifyMsg", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+createTestToken(t, "subscribe:*"))
+	resp, _ = http.DefaultClient.Do(req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("publish with subscribe grant expected 401 but got %d", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"/notifyMsg", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+createTestToken(t, "publish:m1"))
+	resp, _ = http.DefaultClient.Do(req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("publish with publish grant expected 200 but got %d", resp.StatusCode)
+	}
+}
+
+func TestSSEHubWithoutJwtSecretRejects(t *testing.T) {
+	logger := logging.InitUtilLogger("crm-util-go", logging.CrmUtil)
+	logger.Level = logging.LEVEL_OFF
+	hub := NewSSEHub(SSEHubConfig{Logger: logger})
+	defer hub.Close()
+	hub.CreateStream("m1")
+
+	server := httptest.NewServer(hub.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events?stream=m1")
+	if err != nil {
+		t.Fatalf("GET /events Error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("subscribe without JwtSecret expected 401 but got %d", resp.StatusCode)
+	}
+
+	resp, _ = http.Post(server.URL+"/notifyMsg", "application/json", strings.NewReader(`{"streamID":"m1","data":"x"}`))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("publish without JwtSecret expected 401 but got %d", resp.StatusCode)
+	}
+}
+
+func TestSSEHubRejectsLineBreakInEventFields(t *testing.T) {
+	hub := newTestHub(SSEHubConfig{})
+	defer hub.Close()
+	hub.CreateStream("m1")
+
+	server := httptest.NewServer(hub.Handler())
+	defer server.Close()
+
+	for _, body := range []string{
+		`{"streamID":"m1","id":"1\ndata: forged","data":"x"}`,
+		`{"streamID":"m1","event":"notify\r\n\nevent: forged","data":"x"}`,
+	} {
+		resp, err := http.Post(server.URL+"/notifyMsg", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST /notifyMsg Error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s expected 400 but got %d", body, resp.StatusCode)
+		}
+	}
+
+	if info := hub.StreamInfo(); info[0].Buffered != 0 {
+		t.Errorf("expected no event published but got %d", info[0].Buffered)
+	}
+
+	recorder := httptest.NewRecorder()
+	writeSseEvent(recorder, SseEvent{ID: "1", Data: "a\rid: forged\r\nb"})
+	if recorder.Body.String() != "id: 1\ndata: a\ndata: id: forged\ndata: b\n\n" {
+		t.Errorf("CR in data expected as data lines but got %q", recorder.Body.String())
+	}
+}
+
+func TestSSEHubMaxSubscribers(t *testing.T) {
+	hub := newTestHub(SSEHubConfig{MaxSubscribersPerStream: 1})
+	hub.CreateStream("m1")
+
+	sub, _, err := hub.subscribe("m1", "")
+	if err != nil {
+		t.Fatalf("subscribe Error: %v", err)
+	}
+
+	if _, _, err = hub.subscribe("m1", ""); err != ErrStreamFull {
+		t.Errorf("second subscribe expected ErrStreamFull but got %v", err)
+	}
+
+	hub.unsubscribe(sub)
+
+	if _, _, err = hub.subscribe("m1", ""); err != nil {
+		t.Errorf("subscribe after unsubscribe Error: %v", err)
+	}
+}
+
+func TestSSEHubSlowSubscriber(t *testing.T) {
+	hub := newTestHub(SSEHubConfig{SubscriberBufferSize: 1})
+	hub.CreateStream("m1")
+
+	sub, _, _ := hub.subscribe("m1", "")
+	hub.Publish("m1", SseEvent{Data: "a"})
+	hub.Publish("m1", SseEvent{Data: "b"})
+
+	select {
+	case <-sub.done:
+		if sub.err != ErrSubscriberTooSlow {
+			t.Errorf("expected ErrSubscriberTooSlow but got %v", sub.err)
+		}
+	default:
+		t.Errorf("slow subscriber was not closed")
+	}
+}
+
+func TestSSEHubHeartbeatAndCors(t *testing.T) {
+	hub := newTestHub(SSEHubConfig{
+		HeartbeatInterval: 50 * time.Millisecond,
+		AllowOrigins:      []string{"https://crm.true.th"},
+	})
+	defer hub.Close()
+	hub.CreateStream("m1")
+
+	server := httptest.NewServer(hub.Handler())
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/events?stream=m1", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	resp, _ := http.DefaultClient.Do(req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("not allowed origin expected 403 but got %d", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/events?stream=m1", nil)
+	req.Header.Set("Origin", "https://crm.true.th")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events Error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Access-Control-Allow-Origin") != "https://crm.true.th" {
+		t.Errorf("Access-Control-Allow-Origin expected https://crm.true.th but got %s",
+			resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+
+	heartbeat := readSseLines(t, bufio.NewReader(resp.Body), ": ", 1)
+	if len(heartbeat) != 1 || heartbeat[0] != "heartbeat" {
+		t.Errorf("heartbeat comment expected but got %v", heartbeat)
+	}
+}
@@ -8,6 +8,8 @@ import (
 
 type SseNotifyMsg struct {
 	StreamID string `json:"streamID,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Event    string `json:"event,omitempty"`
 	Data     string `json:"data,omitempty"`
 }
 
@@ -0,0 +1,301 @@
+package sseapi
+
+import (
+	"crm-util-go/common"
+	"crm-util-go/cryptography"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+type wsConnection struct {
+	hub           *SSEHub
+	ws            *websocket.Conn
+	transID       string
+	claims        cryptography.JwtClaims
+	out           chan WsMessage
+	done          chan struct{}
+	closeOnce     sync.Once
+	mu            sync.Mutex
+	subscriptions map[string]*sseSubscriber
+}
+
+// WebSocketHandler serves the WebSocket endpoint on the same streams as ServeEvents and ServeNotifyMsg.
+// The bearer token is verified before the upgrade, the grant of each stream is checked on subscribe and publish.
+func (h *SSEHub) WebSocketHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := h.verifyToken(r)
+
+		if err != nil {
+			h.config.Logger.Warn("", "SSEHub websocket unauthorized", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		server := websocket.Server{
+			Handshake: func(config *websocket.Config, r *http.Request) error {
+				origin := r.Header.Get("Origin")
+
+				if origin != "" && len(h.config.AllowOrigins) > 0 && h.allowOrigin(origin) == "" {
+					return errors.New("origin not allowed: " + origin)
+				}
+
+				return nil
+			},
+			Handler: func(ws *websocket.Conn) {
+				h.serveWebSocket(ws, claims)
+			},
+		}
+
+		server.ServeHTTP(w, r)
+	})
+}
+
+func (h *SSEHub) serveWebSocket(ws *websocket.Conn, claims cryptography.JwtClaims) {
+	conn := &wsConnection{
+		hub:           h,
+		ws:            ws,
+		transID:       common.NewUUID(),
+		claims:        claims,
+		out:           make(chan WsMessage, h.config.SubscriberBufferSize),
+		done:          make(chan struct{}),
+		subscriptions: make(map[string]*sseSubscriber),
+	}
+
+	h.config.Logger.Info(conn.transID, "SSEHub websocket connected. userID="+claims.UserID)
+
+	go conn.writeLoop()
+	conn.readLoop()
+	conn.close()
+
+	h.config.Logger.Info(conn.transID, "SSEHub websocket disconnected. userID="+claims.UserID)
+}
+
+func (c *wsConnection) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.ws.Close()
+
+		c.mu.Lock()
+		for streamID, sub := range c.subscriptions {
+			delete(c.subscriptions, streamID)
+			c.hub.unsubscribe(sub)
+		}
+		c.mu.Unlock()
+	})
+}
+
+// send blocks while the outbound queue is full, so a slow client also fills its stream subscribers
+// and the hub closes them with ErrSubscriberTooSlow.
+func (c *wsConnection) send(msg WsMessage) bool {
+	select {
+	case c.out <- msg:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+func (c *wsConnection) respond(request WsMessage, code string, msg string) {
+	c.send(WsMessage{
+		Type:         WsResponse,
+		SseNotifyMsg: SseNotifyMsg{StreamID: request.StreamID, ID: request.ID},
+		Request:      request.Type,
+		Code:         code,
+		Msg:          msg,
+	})
+}
+
+func (c *wsConnection) writeLoop() {
+	ping := time.NewTicker(c.hub.config.HeartbeatInterval)
+	defer ping.Stop()
+
+	for {
+		var msg WsMessage
+
+		select {
+		case <-c.done:
+			return
+		case msg = <-c.out:
+		case <-ping.C:
+			msg = WsMessage{Type: WsPing}
+		}
+
+		c.ws.SetWriteDeadline(time.Now().Add(c.hub.config.WsWriteTimeout))
+
+		if err := websocket.JSON.Send(c.ws, msg); err != nil {
+			c.hub.config.Logger.Warn(c.transID, "SSEHub websocket send error", err)
+			c.close()
+			return
+		}
+	}
+}
+
+func (c *wsConnection) readLoop() {
+	for {
+		c.ws.SetReadDeadline(time.Now().Add(2 * c.hub.config.HeartbeatInterval))
+
+		var data []byte
+		if err := websocket.Message.Receive(c.ws, &data); err != nil {
+			if err != io.EOF {
+				c.hub.config.Logger.Info(c.transID, "SSEHub websocket receive: "+err.Error())
+			}
+			return
+		}
+
+		var msg WsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.respond(msg, RespCodeInvalidRequest, "Invalid message")
+			continue
+		}
+
+		c.handleMessage(msg)
+	}
+}
+
+func (c *wsConnection) handleMessage(msg WsMessage) {
+	switch msg.Type {
+	case WsPing:
+		c.send(WsMessage{Type: WsPong})
+	case WsPong:
+	case WsSubscribe:
+		c.subscribe(msg)
+	case WsUnsubscribe:
+		c.unsubscribe(msg)
+	case WsPublish:
+		c.publish(msg)
+	case WsAck:
+		c.hub.config.Logger.Debug(c.transID, "SSEHub websocket ack. stream="+msg.StreamID+", eventID="+msg.ID)
+
+		if c.hub.config.OnWsAck != nil {
+			c.hub.config.OnWsAck(c.claims, msg.StreamID, msg.ID)
+		}
+	default:
+		c.respond(msg, RespCodeInvalidRequest, "Unknown message type: "+string(msg.Type))
+	}
+}
+
+func (c *wsConnection) subscribe(msg WsMessage) {
+	if err := c.hub.authorize(c.claims, msg.StreamID, SseActionSubscribe); err != nil {
+		c.respond(msg, RespCodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	c.mu.Lock()
+	_, found := c.subscriptions[msg.StreamID]
+	c.mu.Unlock()
+
+	if found {
+		c.respond(msg, RespCodeSuccess, "Success")
+		return
+	}
+
+	sub, replayList, err := c.hub.subscribe(msg.StreamID, msg.LastEventID)
+
+	if err == ErrStreamNotFound || err == ErrInvalidStreamID {
+		c.respond(msg, RespCodeNotFound, "StreamID "+msg.StreamID+" not found")
+		return
+	} else if err != nil {
+		c.respond(msg, RespCodeInvalidRequest, err.Error())
+		return
+	}
+
+	c.mu.Lock()
+	c.subscriptions[msg.StreamID] = sub
+	c.mu.Unlock()
+
+	c.respond(msg, RespCodeSuccess, "Success")
+
+	go c.forward(sub, replayList)
+}
+
+func (c *wsConnection) unsubscribe(msg WsMessage) {
+	c.mu.Lock()
+	sub := c.subscriptions[msg.StreamID]
+	delete(c.subscriptions, msg.StreamID)
+	c.mu.Unlock()
+
+	if sub != nil {
+		c.hub.unsubscribe(sub)
+	}
+
+	c.respond(msg, RespCodeSuccess, "Success")
+}
+
+func (c *wsConnection) publish(msg WsMessage) {
+	if err := c.hub.authorize(c.claims, msg.StreamID, SseActionPublish); err != nil {
+		c.respond(msg, RespCodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	event, err := c.hub.Publish(msg.StreamID, SseEvent{ID: msg.ID, Event: msg.Event, Data: msg.Data})
+
+	if err == ErrInvalidEventField {
+		c.respond(msg, RespCodeInvalidRequest, err.Error())
+		return
+	} else if err == ErrStreamNotFound || err == ErrInvalidStreamID {
+		c.respond(msg, RespCodeNotFound, "StreamID "+msg.StreamID+" not found")
+		return
+	} else if err != nil {
+		c.respond(msg, RespCodeSystemError, err.Error())
+		return
+	}
+
+	msg.ID = event.ID
+	c.respond(msg, RespCodeSuccess, "Success")
+}
+
+func (c *wsConnection) forward(sub *sseSubscriber, replayList []SseEvent) {
+	toWsMessage := func(event SseEvent) WsMessage {
+		return WsMessage{
+			Type:         WsEvent,
+			SseNotifyMsg: SseNotifyMsg{StreamID: sub.streamID, ID: event.ID, Event: event.Event, Data: event.Data},
+		}
+	}
+
+	for _, event := range replayList {
+		if !c.send(toWsMessage(event)) {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case event := <-sub.events:
+			if !c.send(toWsMessage(event)) {
+				return
+			}
+		case <-sub.done:
+			if sub.err == ErrSubscriberTooSlow {
+				// The client reconnects and subscribes with lastEventID to receive the missing events.
+				c.hub.config.Logger.Warn(c.transID, "SSEHub websocket too slow. stream="+sub.streamID)
+				c.close()
+				return
+			}
+
+			c.mu.Lock()
+			if c.subscriptions[sub.streamID] == sub {
+				delete(c.subscriptions, sub.streamID)
+			}
+			c.mu.Unlock()
+
+			if sub.err != nil {
+				c.send(WsMessage{
+					Type:         WsResponse,
+					SseNotifyMsg: SseNotifyMsg{StreamID: sub.streamID},
+					Request:      WsSubscribe,
+					Code:         RespCodeNotFound,
+					Msg:          sub.err.Error(),
+				})
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package sseapi
+
+type WsMessageType string
+
+const (
+	WsSubscribe   = WsMessageType("subscribe")
+	WsUnsubscribe = WsMessageType("unsubscribe")
+	WsPublish     = WsMessageType("publish")
+	WsAck         = WsMessageType("ack")
+	WsPing        = WsMessageType("ping")
+	WsPong        = WsMessageType("pong")
+	WsEvent       = WsMessageType("event")
+	WsResponse    = WsMessageType("response")
+)
+
+/*
+WsMessage is the JSON message on the WebSocket connection. The publish fields are the same as SseNotifyMsg.
+
+Client to server:
+{"type":"subscribe","streamID":"m1","lastEventID":"15"}
+{"type":"unsubscribe","streamID":"m1"}
+{"type":"publish","streamID":"m1","event":"typing","data":"agent-01"}
+{"type":"ack","streamID":"m1","id":"16"}
+{"type":"ping"} or {"type":"pong"}
+
+Server to client:
+{"type":"event","streamID":"m1","id":"16","event":"presence","data":"online"}
+{"type":"response","request":"subscribe","streamID":"m1","code":"0","msg":"Success"}
+{"type":"ping"} or {"type":"pong"}
+*/
+type WsMessage struct {
+	Type WsMessageType `json:"type"`
+	SseNotifyMsg
+	LastEventID string        `json:"lastEventID,omitempty"`
+	Request     WsMessageType `json:"request,omitempty"`
+	Code        string        `json:"code,omitempty"`
+	Msg         string        `json:"msg,omitempty"`
+}
@@ -0,0 +1,158 @@
+package sseapi
+
+import (
+	"crm-util-go/cryptography"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func dialTestWebSocket(t *testing.T, server *httptest.Server, token string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	if token != "" {
+		url = url + "?token=" + token
+	}
+
+	ws, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatalf("websocket.Dial Error: %v", err)
+	}
+
+	return ws
+}
+
+func receiveWsMessage(t *testing.T, ws *websocket.Conn, msgType WsMessageType) WsMessage {
+	ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+
+	for {
+		var msg WsMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			t.Fatalf("receive %s Error: %v", msgType, err)
+		}
+
+		if msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
+func TestWebSocketSubscribePublish(t *testing.T) {
+	hub := newTestHub(SSEHubConfig{})
+	defer hub.Close()
+	hub.CreateStream("m1")
+	hub.Publish("m1", SseEvent{Data: "a"})
+	hub.Publish("m1", SseEvent{Data: "b"})
+
+	server := httptest.NewServer(hub.Handler())
+	defer server.Close()
+
+	ws := dialTestWebSocket(t, server, "")
+	defer ws.Close()
+
+	websocket.JSON.Send(ws, WsMessage{Type: WsSubscribe, SseNotifyMsg: SseNotifyMsg{StreamID: "m1"}, LastEventID: "1"})
+	if resp := receiveWsMessage(t, ws, WsResponse); resp.Code != RespCodeSuccess {
+		t.Fatalf("subscribe expected success but got %+v", resp)
+	}
+
+	if event := receiveWsMessage(t, ws, WsEvent); event.Data != "b" {
+		t.Errorf("replay expected b but got %+v", event)
+	}
+
+	// The SSE publish API and the websocket publish message share the same stream
+	hub.Publish("m1", SseEvent{Event: "presence", Data: "online"})
+	if event := receiveWsMessage(t, ws, WsEvent); event.Event != "presence" || event.StreamID != "m1" {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	websocket.JSON.Send(ws, WsMessage{Type: WsPublish, SseNotifyMsg: SseNotifyMsg{StreamID: "m1", Event: "typing", Data: "agent-01"}})
+	if event := receiveWsMessage(t, ws, WsEvent); event.Event != "typing" || event.ID != "4" {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	websocket.JSON.Send(ws, WsMessage{Type: WsPublish, SseNotifyMsg: SseNotifyMsg{StreamID: "m1", Event: "typing\nevent: forged"}})
+	if resp := receiveWsMessage(t, ws, WsResponse); resp.Code != RespCodeInvalidRequest {
+		t.Errorf("publish with line break expected invalid request but got %+v", resp)
+	}
+
+	websocket.JSON.Send(ws, WsMessage{Type: WsPing})
+	receiveWsMessage(t, ws, WsPong)
+
+	websocket.JSON.Send(ws, WsMessage{Type: WsUnsubscribe, SseNotifyMsg: SseNotifyMsg{StreamID: "m1"}})
+	receiveWsMessage(t, ws, WsResponse)
+
+	if info := hub.StreamInfo(); info[0].Subscribers != 0 {
+		t.Errorf("expected 0 subscribers after unsubscribe but got %d", info[0].Subscribers)
+	}
+}
+
+func TestWebSocketAuthorization(t *testing.T) {
+	var ackList []string
+	hub := newTestHub(SSEHubConfig{
+		JwtSecret: testJwtSecret,
+		OnWsAck: func(claims cryptography.JwtClaims, streamID string, eventID string) {
+			ackList = append(ackList, claims.UserID+":"+streamID+":"+eventID)
+		},
+	})
+	defer hub.Close()
+	hub.CreateStream("m1")
+	hub.CreateStream("m2")
+
+	server := httptest.NewServer(hub.Handler())
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	if _, err := websocket.Dial(url, "", server.URL); err == nil {
+		t.Errorf("dial without token expected error")
+	}
+
+	ws := dialTestWebSocket(t, server, createTestToken(t, "subscribe:m1"))
+	defer ws.Close()
+
+	websocket.JSON.Send(ws, WsMessage{Type: WsSubscribe, SseNotifyMsg: SseNotifyMsg{StreamID: "m2"}})
+	if resp := receiveWsMessage(t, ws, WsResponse); resp.Code != RespCodeUnauthorized {
+		t.Errorf("subscribe m2 expected unauthorized but got %+v", resp)
+	}
+
+	websocket.JSON.Send(ws, WsMessage{Type: WsPublish, SseNotifyMsg: SseNotifyMsg{StreamID: "m1", Data: "x"}})
+	if resp := receiveWsMessage(t, ws, WsResponse); resp.Code != RespCodeUnauthorized {
+		t.Errorf("publish m1 expected unauthorized but got %+v", resp)
+	}
+
+	websocket.JSON.Send(ws, WsMessage{Type: WsAck, SseNotifyMsg: SseNotifyMsg{StreamID: "m1", ID: "7"}})
+	websocket.JSON.Send(ws, WsMessage{Type: WsPing})
+	receiveWsMessage(t, ws, WsPong)
+
+	if len(ackList) != 1 || ackList[0] != "12345:m1:7" {
+		t.Errorf("unexpected ack %v", ackList)
+	}
+}
+
+func TestWebSocketKeepalive(t *testing.T) {
+	hub := newTestHub(SSEHubConfig{HeartbeatInterval: 50 * time.Millisecond})
+	defer hub.Close()
+
+	server := httptest.NewServer(hub.Handler())
+	defer server.Close()
+
+	ws := dialTestWebSocket(t, server, "")
+	defer ws.Close()
+
+	receiveWsMessage(t, ws, WsPing)
+
+	// No message from the client within two intervals closes the connection
+	start := time.Now()
+	ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		var msg WsMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			break
+		}
+	}
+
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("idle connection was not closed by the server")
+	}
+}
@@ -2,6 +2,7 @@ package test
 
 /*
 import (
+	"context"
 	"crm-util-go/common"
 	"crm-util-go/kafkautil"
 	"crm-util-go/logging"
@@ -40,17 +41,25 @@ func ConsumerController() {
 
 	schLogger.Info(transID, "Initial KafkaConfig success")
 
+	// SIGTERM stops the consumers, they finish the running messages and close before exit
+	ctx, stop := kafkautil.SignalContext(context.Background())
+	defer stop()
+
 	var noOfConsumer int = 10
 	var wg sync.WaitGroup
 
-	kc.StartConsumer()
-
 	for i := 0; i < noOfConsumer; i++ {
 		wg.Add(1)
-		go kc.Consumer(&wg, transID, onMessage)
+		go func() {
+			defer wg.Done()
+
+			if err := kc.Consumer(ctx, transID, onMessage); err != nil {
+				schLogger.Error(transID, "Consumer Error: "+err.Error())
+			}
+		}()
 	}
 
 	wg.Wait()
 	schLogger.Info(transID, "End ConsumerController")
 }
-*/
\ No newline at end of file
+*/
\ No newline at end of file